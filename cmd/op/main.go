@@ -1,24 +1,29 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	log "github.com/charmbracelet/log"
 	_ "github.com/lib/pq"
 	"github.com/vinimdocarmo/quackfs/db/sqlc"
+	"github.com/vinimdocarmo/quackfs/db/types"
 	"github.com/vinimdocarmo/quackfs/internal/storage"
 	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
 	"github.com/vinimdocarmo/quackfs/pkg/logger"
@@ -28,57 +33,83 @@ func main() {
 	// Initialize logger first thing
 	log := logger.New(os.Stderr)
 
+	// S3 flags go before the subcommand, e.g. op -s3-bucket my-bucket log -file db.duckdb
+	var s3Config objectstore.S3Config
+	s3Config.RegisterFlags(flag.CommandLine)
+	var logConfig logger.Config
+	logConfig.RegisterFlags(flag.CommandLine)
+	opTimeout := flag.Duration("op-timeout", storage.DefaultOperationTimeout, "Maximum duration of each storage operation (0 for no limit)")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	logOpts, err := logConfig.Opts()
+	if err != nil {
+		log.Fatal("Invalid logging flags", "error", err)
+	}
+	log = logger.New(os.Stderr, logOpts...)
+
 	// Check if a subcommand was provided
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		printUsage()
 		os.Exit(1)
 	}
 
 	// Extract the subcommand
-	command := os.Args[1]
+	command := flag.Arg(0)
 
-	// Remove the subcommand from os.Args to make flag parsing work correctly
-	os.Args = slices.Delete(os.Args, 1, 2)
+	// Leave only the subcommand's flags in os.Args to make flag parsing work correctly
+	os.Args = append(os.Args[:1], flag.Args()[1:]...)
 
 	// Connect to the database
 	db := newDB(log)
 	defer db.Close()
 
 	// Set up S3 client
-	s3Endpoint := getEnvOrDefault("AWS_ENDPOINT_URL", "http://localhost:4566")
-	s3Region := getEnvOrDefault("AWS_REGION", "us-east-1")
-	s3BucketName := getEnvOrDefault("S3_BUCKET_NAME", "quackfs-bucket")
-
-	// Load AWS SDK configuration
-	cfgOptions := []func(*config.LoadOptions) error{
-		config.WithRegion(s3Region),
-	}
-
-	log.Debug("Using static credentials for LocalStack")
-	cfgOptions = append(cfgOptions,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			"test", "test", "test")))
-
-	cfg, err := config.LoadDefaultConfig(context.Background(), cfgOptions...)
+	log.Debug("Using S3 settings", "endpoint", s3Config.Endpoint, "region", s3Config.Region, "bucket", s3Config.Bucket, "pathStyle", s3Config.PathStyle)
+	s3Client, err := s3Config.NewClient(context.Background())
 	if err != nil {
-		log.Fatal("Failed to configure AWS client", "error", err)
+		log.Fatal("Failed to create S3 client", "error", err)
 	}
 
-	// Create an S3 client with custom endpoint for LocalStack
-	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(s3Endpoint)
-		o.UsePathStyle = true // Required for LocalStack
-	})
-
-	objectStore := objectstore.NewS3(s3Client, s3BucketName)
+	objectStore := objectstore.NewS3(s3Client, s3Config.Bucket)
 
 	// Create a storage manager
-	sm := storage.NewManager(db, objectStore, log)
+	sm := storage.NewManager(db, objectStore, log, storage.WithOperationTimeout(*opTimeout))
 
 	// Execute the appropriate command
 	switch command {
 	case "log":
 		executeLogCommand(sm, log)
+	case "read":
+		executeReadCommand(sm, log)
+	case "write":
+		executeWriteCommand(sm, log)
+	case "checkpoint":
+		executeCheckpointCommand(sm, log)
+	case "ls":
+		executeLsCommand(sm, log)
+	case "versions":
+		executeVersionsCommand(sm, log)
+	case "stats":
+		executeStatsCommand(sm, log)
+	case "chunks":
+		executeChunksCommand(sm, log)
+	case "diff":
+		executeDiffCommand(sm, log)
+	case "export":
+		executeExportCommand(sm, log)
+	case "import":
+		executeImportCommand(sm, log)
+	case "mv":
+		executeMvCommand(sm, log)
+	case "set-head":
+		executeSetHeadCommand(sm, log)
+	case "delete-head":
+		executeDeleteHeadCommand(sm, log)
+	case "health":
+		executeHealthCommand(sm, log)
+	case "verify":
+		executeVerifyCommand(sm, log)
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
@@ -88,47 +119,990 @@ func main() {
 
 // printUsage prints the usage information for the CLI tool
 func printUsage() {
-	fmt.Println("Usage: op <command> [options]")
+	fmt.Println("Usage: op [s3 options] [log options] [other options] <command> [options]")
+	fmt.Println("S3 options (each defaults to the env var in parentheses, then to LocalStack's settings):")
+	fmt.Println("  -s3-endpoint   - S3 endpoint URL, empty to use AWS with the default credential chain (AWS_ENDPOINT_URL)")
+	fmt.Println("  -s3-region     - S3 region (AWS_REGION)")
+	fmt.Println("  -s3-bucket     - S3 bucket (S3_BUCKET_NAME)")
+	fmt.Println("  -s3-path-style - Use path-style bucket addressing with a custom endpoint (S3_PATH_STYLE)")
+	fmt.Println("Log options:")
+	fmt.Println("  -log-level     - debug, info, warn, error or fatal (LOG_LEVEL, default info)")
+	fmt.Println("  -log-format    - text or json, one object per line (LOG_FORMAT, default text)")
+	fmt.Println("Other options:")
+	fmt.Println("  -op-timeout    - Maximum duration of each storage operation, e.g. a read or a checkpoint (default 1m, 0 for no limit)")
 	fmt.Println("Commands:")
-	fmt.Println("  log        - List all versions for a specific file and indicate head pointer")
+	fmt.Println("  ls          - List the files with their size, number of versions and head")
+	fmt.Println("  log         - List all versions for a specific file and indicate head pointer")
+	fmt.Println("  read        - Write the content of a file (optionally at a given version) to stdout")
+	fmt.Println("  write       - Write data to a file at an offset and checkpoint it as a new version")
+	fmt.Println("  checkpoint  - Checkpoint the writes made by op itself (write already does)")
+	fmt.Println("  set-head    - Point the head of a file to a version, making it read-only")
+	fmt.Println("  delete-head - Remove the head of a file, going back to its latest version")
+	fmt.Println("  versions    - List the versions of all files, oldest first")
+	fmt.Println("  diff        - List the byte ranges of a file that changed between two versions")
+	fmt.Println("  chunks      - List the chunks of a file in the order reads apply them, for debugging")
+	fmt.Println("  export      - Copy a version of a file to a local file")
+	fmt.Println("  import      - Create a file from a local file and checkpoint it as a new version")
+	fmt.Println("  mv          - Rename a file, keeping its versions, unless a file already has the new name")
+	fmt.Println("  stats       - Print the number of files, versions and layers, and the bytes stored, as JSON")
+	fmt.Println("  health      - Check that PostgreSQL and the object store are reachable, exiting non-zero if not")
+	fmt.Println("  verify      - Check that the object of every layer exists with the right size, exiting non-zero if not")
 	fmt.Println("")
 	fmt.Println("For detailed command usage:")
+	fmt.Println("  op ls -h")
 	fmt.Println("  op log -h")
+	fmt.Println("  op read -h")
+	fmt.Println("  op write -h")
+	fmt.Println("  op checkpoint -h")
+	fmt.Println("  op set-head -h")
+	fmt.Println("  op delete-head -h")
+	fmt.Println("  op versions -h")
+	fmt.Println("  op diff -h")
+	fmt.Println("  op chunks -h")
+	fmt.Println("  op export -h")
+	fmt.Println("  op import -h")
+	fmt.Println("  op mv -h")
+	fmt.Println("  op stats -h")
+	fmt.Println("  op health -h")
+	fmt.Println("  op verify -h")
 	fmt.Println("")
 	fmt.Println("Examples:")
+	fmt.Println("  op ls")
 	fmt.Println("  op log -file myfile.txt")
+	fmt.Println("  op read -file mydb.duckdb -version v1 > mydb-v1.duckdb")
+	fmt.Println("  op write -file data.duckdb -offset 4096 -data hello -version patched")
+	fmt.Println("  op set-head -file mydb.duckdb -version v1")
+	fmt.Println("  op delete-head -file mydb.duckdb")
+	fmt.Println("  op versions")
+	fmt.Println("  op diff -file mydb.duckdb -from v1 -to v2 -hex")
+	fmt.Println("  op chunks -file mydb.duckdb -version v2")
+	fmt.Println("  op checkpoint -file mydb.duckdb -dry-run")
+	fmt.Println("  op export -file mydb.duckdb -version v2 -out /tmp/mydb_v2.duckdb")
+	fmt.Println("  op import -file mydb.duckdb -in ./local.duckdb -version v1")
+	fmt.Println("  op mv -from mydb.duckdb -to archive.duckdb")
+	fmt.Println("  op stats")
+	fmt.Println("  op health -timeout 2s")
+	fmt.Println("  op verify -json")
+	fmt.Println("  op -s3-endpoint= -s3-region eu-west-1 -s3-bucket my-bucket versions")
 }
 
 func executeLogCommand(sm *storage.Manager, log *log.Logger) {
-	logCmd := flag.NewFlagSet("log", flag.ExitOnError)
+	if err := runLog(context.Background(), sm, os.Args[1:], os.Stdout, isTerminal(os.Stdout)); err != nil {
+		exitWithError(log, "Failed to show version history", err)
+	}
+}
+
+// logEntry is a version as printed by op log -json
+type logEntry struct {
+	Tag       string    `json:"tag"`
+	Timestamp time.Time `json:"timestamp"`
+	IsHead    bool      `json:"isHead"`
+	Origin    string    `json:"origin"`
+	Author    string    `json:"author,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// runLog shows the versions of a file, newest first. Unless -json or -no-tui is given, the
+// interactive UI is used when interactive is set (i.e. stdout is a terminal).
+func runLog(ctx context.Context, sm *storage.Manager, args []string, w io.Writer, interactive bool) error {
+	logCmd := flag.NewFlagSet("log", flag.ContinueOnError)
 	fileName := logCmd.String("file", "", "Target file to show version history for")
+	asJSON := logCmd.Bool("json", false, "Print the versions as JSON instead of showing the interactive UI")
+	noTUI := logCmd.Bool("no-tui", false, "Print the versions as text instead of showing the interactive UI")
 
-	logCmd.Parse(os.Args[1:])
+	if err := logCmd.Parse(args); err != nil {
+		return err
+	}
 
 	if *fileName == "" {
-		log.Error("Missing required flag: -file")
-		fmt.Println("Usage: op log -file <filename>")
-		os.Exit(1)
+		return usageError("missing required flag -file", "op log -file <filename> [-json] [-no-tui]")
+	}
+
+	versions, err := sm.GetFileVersions(ctx, *fileName)
+	if err != nil {
+		return fmt.Errorf("failed to get file versions: %w", err)
+	}
+
+	headVersion, err := sm.GetHead(ctx, *fileName)
+	if err != nil {
+		return fmt.Errorf("failed to get head version: %w", err)
+	}
+
+	if *asJSON {
+		entries := make([]logEntry, len(versions))
+		for i, v := range versions {
+			entries[i] = logEntry{
+				Tag:       v.Tag,
+				Timestamp: v.CreatedAt.Time,
+				IsHead:    v.Tag == headVersion,
+				Origin:    v.Origin,
+				Author:    v.Author,
+				Message:   v.Message,
+			}
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(versions) == 0 {
+		_, err := fmt.Fprintf(w, "No versions found for file: %s\n", *fileName)
+		return err
+	}
+
+	if *noTUI || !interactive {
+		printVersions(w, versions, headVersion, *fileName)
+		return nil
+	}
+
+	runBubbleteaUI(versions, headVersion, *fileName, sm)
+	return nil
+}
+
+// isTerminal reports whether f is a terminal rather than e.g. a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func executeReadCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runRead(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to read file", err)
+	}
+}
+
+// runRead writes the content of a file (at a version, if given) to w
+func runRead(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	readCmd := flag.NewFlagSet("read", flag.ContinueOnError)
+	fileName := readCmd.String("file", "", "Target file to read")
+	version := readCmd.String("version", "", "Version to read (defaults to the head version, or the latest one)")
+	offset := readCmd.Uint64("offset", 0, "Offset to start reading at")
+	size := readCmd.Uint64("size", 0, "Number of bytes to read (defaults to the rest of the file)")
+
+	if err := readCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if *fileName == "" {
+		return usageError("missing required flag -file", "op read -file <filename> [-version <tag>] [-offset <offset>] [-size <size>]")
+	}
+
+	// Without -version, read whatever the file system would: the head version if set, else the latest
+	if *version == "" {
+		head, err := sm.GetHead(ctx, *fileName)
+		if err != nil {
+			return fmt.Errorf("failed to get head version: %w", err)
+		}
+		*version = head
+	}
+
+	var readOpts []storage.ReadOpt
+	var fileSize uint64
+	var err error
+
+	if *version != "" {
+		readOpts = append(readOpts, storage.WithVersion(*version))
+		fileSize, err = sm.SizeOfVersion(ctx, *fileName, *version)
+		if errors.Is(err, types.ErrNotFound) {
+			return fmt.Errorf("version %s of %s does not exist", *version, *fileName)
+		}
+	} else {
+		fileSize, err = sm.SizeOf(ctx, *fileName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get file size: %w", err)
+	}
+
+	if *offset >= fileSize {
+		return nil
+	}
+
+	if *size == 0 || *size > fileSize-*offset {
+		*size = fileSize - *offset
+	}
+
+	data, err := sm.ReadFile(ctx, *fileName, *offset, *size, readOpts...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	return nil
+}
+
+func executeWriteCommand(sm *storage.Manager, log *log.Logger) {
+	version, err := runWrite(context.Background(), sm, os.Args[1:])
+	if err != nil {
+		exitWithError(log, "Failed to write file", err)
+	}
+	fmt.Printf("Wrote and checkpointed version %s\n", version)
+}
+
+// runWrite writes data to a file, creating it if needed, and checkpoints the write right away:
+// writes that aren't checkpointed only live in memory, so they would be lost when op exits.
+// It returns the tag of the new version.
+func runWrite(ctx context.Context, sm *storage.Manager, args []string) (string, error) {
+	writeCmd := flag.NewFlagSet("write", flag.ContinueOnError)
+	fileName := writeCmd.String("file", "", "Target file to write to")
+	offset := writeCmd.Uint64("offset", 0, "Offset to write at")
+	data := writeCmd.String("data", "", "Data to write")
+	allowBeyondSize := writeCmd.Bool("allow-beyond-size", false, "Allow writing past the end of the file, filling the gap with zeroes")
+	version := writeCmd.String("version", "", "Tag of the version created for the write (defaults to the next vN)")
+
+	if err := writeCmd.Parse(args); err != nil {
+		return "", err
+	}
+
+	usage := "op write -file <filename> -data <data> [-offset <offset>] [-allow-beyond-size] [-version <tag>]"
+	if *fileName == "" {
+		return "", usageError("missing required flag -file", usage)
+	}
+	if *data == "" {
+		return "", usageError("missing required flag -data", usage)
+	}
+
+	if _, err := ensureFile(ctx, sm, *fileName); err != nil {
+		return "", err
+	}
+
+	err := sm.WriteFile(ctx, *fileName, []byte(*data), *offset, storage.WithZeroFill(*allowBeyondSize))
+	if err != nil {
+		return "", err
+	}
+
+	tag, err := sm.Checkpoint(ctx, *fileName, *version)
+	if err != nil {
+		return "", fmt.Errorf("failed to checkpoint write: %w", err)
+	}
+
+	return tag, nil
+}
+
+// ensureFile creates the file if it doesn't exist yet, reporting whether it did
+func ensureFile(ctx context.Context, sm *storage.Manager, fileName string) (bool, error) {
+	if _, err := sm.InsertFile(ctx, fileName); err != nil {
+		if errors.Is(err, types.ErrFileExists) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create file: %w", err)
+	}
+	return true, nil
+}
+
+func executeImportCommand(sm *storage.Manager, log *log.Logger) {
+	n, version, err := runImport(context.Background(), sm, os.Args[1:])
+	if err != nil {
+		exitWithError(log, "Failed to import file", err)
+	}
+	fmt.Printf("Imported %d bytes as version %s\n", n, version)
+}
+
+// runImport copies a local file into a new (or empty) file a block at a time and checkpoints
+// it, since writes that aren't checkpointed would be lost when op exits. The written data is
+// held in memory until the checkpoint, like any other write. It returns the number of bytes
+// imported and the tag of the new version.
+func runImport(ctx context.Context, sm *storage.Manager, args []string) (uint64, string, error) {
+	importCmd := flag.NewFlagSet("import", flag.ContinueOnError)
+	fileName := importCmd.String("file", "", "Target file to import into")
+	inPath := importCmd.String("in", "", "Path of the local file to import")
+	version := importCmd.String("version", "", "Tag of the version created for the import (defaults to the next vN)")
+	blockSize := importCmd.Int("block-size", 4<<20, "Number of bytes written to storage at a time")
+
+	if err := importCmd.Parse(args); err != nil {
+		return 0, "", err
+	}
+
+	usage := "op import -file <filename> -in <path> [-version <tag>] [-block-size <bytes>]"
+	switch {
+	case *fileName == "":
+		return 0, "", usageError("missing required flag -file", usage)
+	case *inPath == "":
+		return 0, "", usageError("missing required flag -in", usage)
+	case *blockSize <= 0:
+		return 0, "", usageError("-block-size must be positive", usage)
 	}
 
+	in, err := os.Open(*inPath)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open %s: %w", *inPath, err)
+	}
+	defer in.Close()
+
+	created, err := ensureFile(ctx, sm, *fileName)
+	if err != nil {
+		return 0, "", err
+	}
+	if !created {
+		// Importing over existing data would leave its tail behind if the local file is smaller
+		size, err := sm.SizeOf(ctx, *fileName)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to get size of %s: %w", *fileName, err)
+		}
+		if size > 0 {
+			return 0, "", fmt.Errorf("cannot import into %s: it already has %d bytes", *fileName, size)
+		}
+	}
+
+	var offset uint64
+	block := make([]byte, *blockSize)
+	for {
+		n, err := io.ReadFull(in, block)
+		if n > 0 {
+			// WriteFile keeps its own copy of the data, so the block can be reused
+			if err := sm.WriteFile(ctx, *fileName, block[:n], offset); err != nil {
+				return 0, "", fmt.Errorf("failed to write %s at offset %d: %w", *fileName, offset, err)
+			}
+			offset += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return 0, "", fmt.Errorf("failed to read %s: %w", *inPath, err)
+		}
+	}
+
+	tag, err := sm.Checkpoint(ctx, *fileName, *version)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to checkpoint import: %w", err)
+	}
+
+	return offset, tag, nil
+}
+
+func executeCheckpointCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runCheckpoint(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to checkpoint file", err)
+	}
+}
+
+// runCheckpoint checkpoints the writes to a file made by this process, or with -dry-run only
+// prints what the checkpoint would persist. Uncommitted writes made through the file system
+// live in the memory of the quackfs process, so they can't be checkpointed from here.
+func runCheckpoint(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	checkpointCmd := flag.NewFlagSet("checkpoint", flag.ContinueOnError)
+	fileName := checkpointCmd.String("file", "", "Target file to checkpoint")
+	version := checkpointCmd.String("version", "", "Tag of the new version (defaults to the next vN)")
+	dryRun := checkpointCmd.Bool("dry-run", false, "Print what would be checkpointed without persisting anything")
+
+	if err := checkpointCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if *fileName == "" {
+		return usageError("missing required flag -file", "op checkpoint -file <filename> [-version <tag>] [-dry-run]")
+	}
+
+	if *dryRun {
+		plan, err := sm.CheckpointPlan(ctx, *fileName)
+		if err != nil {
+			return err
+		}
+		if plan.Version == "" {
+			_, err = fmt.Fprintln(w, "Nothing to checkpoint")
+			return err
+		}
+		if *version != "" {
+			plan.Version = *version
+		}
+		_, err = fmt.Fprintf(w, "Would checkpoint version %s: %d bytes in %d chunks, stored under %s\n",
+			plan.Version, plan.Bytes, plan.Chunks, plan.ObjectKeyPrefix)
+		return err
+	}
+
+	tag, err := sm.Checkpoint(ctx, *fileName, *version)
+	if err != nil {
+		return err
+	}
+	if tag == "" {
+		_, err = fmt.Fprintln(w, "Nothing to checkpoint")
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Checkpointed version %s\n", tag)
+	return err
+}
+
+// usageError is returned by commands given invalid flags
+func usageError(msg string, usage string) error {
+	return fmt.Errorf("%s\nUsage: %s", msg, usage)
+}
+
+// exitWithError logs err and exits, successfully if help was requested
+func exitWithError(log *log.Logger, msg string, err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	log.Fatal(msg, "error", err)
+}
+
+func executeMvCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runMv(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to rename file", err)
+	}
+}
+
+// runMv renames a file. Unlike a rename through the mount, it fails if a file already has
+// the new name instead of replacing it.
+func runMv(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	mvCmd := flag.NewFlagSet("mv", flag.ContinueOnError)
+	from := mvCmd.String("from", "", "File to rename")
+	to := mvCmd.String("to", "", "New name of the file")
+
+	if err := mvCmd.Parse(args); err != nil {
+		return err
+	}
+
+	usage := "op mv -from <filename> -to <filename>"
+	switch {
+	case *from == "":
+		return usageError("missing required flag -from", usage)
+	case *to == "":
+		return usageError("missing required flag -to", usage)
+	}
+
+	err := sm.RenameFile(ctx, *from, *to, storage.WithNoReplace())
+	if errors.Is(err, types.ErrNotFound) {
+		return fmt.Errorf("file %s does not exist", *from)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Renamed %s to %s\n", *from, *to)
+	return err
+}
+
+func executeSetHeadCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runSetHead(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to set head", err)
+	}
+}
+
+// runSetHead points the head of a file to a version, like pressing enter in op log
+func runSetHead(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	setHeadCmd := flag.NewFlagSet("set-head", flag.ContinueOnError)
+	fileName := setHeadCmd.String("file", "", "Target file to set the head of")
+	version := setHeadCmd.String("version", "", "Version the head should point to")
+
+	if err := setHeadCmd.Parse(args); err != nil {
+		return err
+	}
+
+	usage := "op set-head -file <filename> -version <tag>"
+	if *fileName == "" {
+		return usageError("missing required flag -file", usage)
+	}
+	if *version == "" {
+		return usageError("missing required flag -version", usage)
+	}
+
+	err := sm.SetHead(ctx, *fileName, *version)
+	if errors.Is(err, types.ErrNotFound) {
+		return fmt.Errorf("version %s of %s does not exist", *version, *fileName)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Head of %s set to %s\n", *fileName, *version)
+	return err
+}
+
+func executeDeleteHeadCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runDeleteHead(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to delete head", err)
+	}
+}
+
+// runDeleteHead removes the head of a file, so it reads (and writes) its latest version again.
+// It succeeds if the file has no head.
+func runDeleteHead(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	deleteHeadCmd := flag.NewFlagSet("delete-head", flag.ContinueOnError)
+	fileName := deleteHeadCmd.String("file", "", "Target file to delete the head of")
+
+	if err := deleteHeadCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if *fileName == "" {
+		return usageError("missing required flag -file", "op delete-head -file <filename>")
+	}
+
+	head, err := sm.GetHead(ctx, *fileName)
+	if err != nil {
+		return fmt.Errorf("failed to get head version: %w", err)
+	}
+	if head == "" {
+		_, err := fmt.Fprintf(w, "%s has no head, nothing to delete\n", *fileName)
+		return err
+	}
+
+	if err := sm.DeleteHead(ctx, *fileName); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Head of %s deleted, it was %s\n", *fileName, head)
+	return err
+}
+
+func executeLsCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runLs(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to list files", err)
+	}
+}
+
+// lsEntry is a row of op ls
+type lsEntry struct {
+	Name     string `json:"name"`
+	Size     uint64 `json:"size"`
+	Versions int    `json:"versions"`
+	Head     string `json:"head,omitempty"`
+}
+
+// runLs writes the files with their latest size, number of versions and head to w
+func runLs(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	lsCmd := flag.NewFlagSet("ls", flag.ContinueOnError)
+	asJSON := lsCmd.Bool("json", false, "Print the files as JSON")
+
+	if err := lsCmd.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := sm.GetAllFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get files: %w", err)
+	}
+
+	entries := make([]lsEntry, 0, len(files))
+	for _, f := range files {
+		size, err := sm.SizeOf(ctx, f.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get size of %s: %w", f.Name, err)
+		}
+
+		head, err := sm.GetHead(ctx, f.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get head of %s: %w", f.Name, err)
+		}
+
+		versions, err := sm.GetFileVersions(ctx, f.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get versions of %s: %w", f.Name, err)
+		}
+
+		entries = append(entries, lsEntry{Name: f.Name, Size: size, Versions: len(versions), Head: head})
+	}
+
+	slices.SortFunc(entries, func(a, b lsEntry) int { return strings.Compare(a.Name, b.Name) })
+
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "No files found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tVERSIONS\tHEAD")
+	for _, e := range entries {
+		head := e.Head
+		if head == "" {
+			head = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", e.Name, e.Size, e.Versions, head)
+	}
+	return tw.Flush()
+}
+
+func executeVersionsCommand(sm *storage.Manager, log *log.Logger) {
+	versionsCmd := flag.NewFlagSet("versions", flag.ExitOnError)
+	versionsCmd.Parse(os.Args[1:])
+
 	ctx := context.Background()
 
+	// Rows are printed as they are fetched, so columns have a fixed width rather than
+	// being sized to the longest value
+	const rowFormat = "%-19s  %-30s  %-20s  %-6s  %s\n"
+	fmt.Printf(rowFormat, "CREATED AT", "FILE", "VERSION", "ORIGIN", "OBJECT KEY")
+
+	count := 0
+	err := sm.WalkVersions(ctx, func(v storage.VersionInfo) error {
+		count++
+		_, err := fmt.Printf(rowFormat, v.CreatedAt.Format("2006-01-02 15:04:05"), v.FileName, v.Tag, v.Origin, v.ObjectKey)
+		return err
+	})
+	if err != nil {
+		log.Fatal("Failed to list versions", "error", err)
+	}
+
+	if count == 0 {
+		fmt.Println("No versions found")
+	}
+}
+
+func executeStatsCommand(sm *storage.Manager, log *log.Logger) {
+	statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	statsCmd.Parse(os.Args[1:])
+
+	stats, err := sm.Stats(context.Background())
+	if err != nil {
+		log.Fatal("Failed to get stats", "error", err)
+	}
+
+	// Uncommitted writes live in the memory of the quackfs process, so memtable bytes are always 0 here
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(stats); err != nil {
+		log.Fatal("Failed to print stats", "error", err)
+	}
+}
+
+func executeHealthCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runHealth(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Health check failed", err)
+	}
+}
+
+// runHealth checks that the metadata store and the object store are reachable
+func runHealth(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	healthCmd := flag.NewFlagSet("health", flag.ContinueOnError)
+	timeout := healthCmd.Duration("timeout", 5*time.Second, "How long to wait for the checks to complete")
+
+	if err := healthCmd.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	if err := sm.Health(ctx); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, "OK: metadata store and object store are reachable")
+	return err
+}
+
+func executeVerifyCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runVerify(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Verification failed", err)
+	}
+}
+
+// runVerify prints the layers whose object is missing from the object store or has the
+// wrong size, and fails if there are any
+func runVerify(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	verifyCmd := flag.NewFlagSet("verify", flag.ContinueOnError)
+	asJSON := verifyCmd.Bool("json", false, "Print the issues as JSON")
+
+	if err := verifyCmd.Parse(args); err != nil {
+		return err
+	}
+
+	issues, err := sm.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to verify layers: %w", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			return err
+		}
+	} else if len(issues) == 0 {
+		_, err := fmt.Fprintln(w, "OK: every layer object exists with the expected size")
+		return err
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "LAYER\tFILE\tVERSION\tOBJECT KEY\tPROBLEM")
+		for _, issue := range issues {
+			problem := issue.Problem
+			if issue.Problem == storage.IssueSizeMismatch {
+				problem = fmt.Sprintf("%s: %d bytes, expected %d", problem, issue.ActualSize, issue.ExpectedSize)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", issue.LayerID, issue.FileName, issue.Version, issue.ObjectKey, problem)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("%d layers failed verification", len(issues))
+	}
+	return nil
+}
+
+func executeDiffCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runDiff(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to diff versions", err)
+	}
+}
+
+// diffOutput is what op diff -json prints
+type diffOutput struct {
+	File   string      `json:"file"`
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Ranges []diffRange `json:"ranges"`
+}
+
+type diffRange struct {
+	Start   uint64 `json:"start"`
+	End     uint64 `json:"end"` // exclusive
+	Version string `json:"version"`
+	FromHex string `json:"from_hex,omitempty"` // first bytes of the range in -from, if -hex is set
+	ToHex   string `json:"to_hex,omitempty"`   // first bytes of the range in -to, if -hex is set
+}
+
+// runDiff writes the byte ranges of a file that changed between two versions to w
+func runDiff(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	diffCmd := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fileName := diffCmd.String("file", "", "Target file to diff")
+	from := diffCmd.String("from", "", "Version to diff from")
+	to := diffCmd.String("to", "", "Version to diff to (defaults to the head version, or the latest one)")
+	showHex := diffCmd.Bool("hex", false, "Show the first bytes of each range in both versions, in hex")
+	hexBytes := diffCmd.Uint64("hex-bytes", 16, "Number of bytes shown per range with -hex")
+	asJSON := diffCmd.Bool("json", false, "Print the ranges as JSON")
+
+	if err := diffCmd.Parse(args); err != nil {
+		return err
+	}
+
+	usage := "op diff -file <filename> -from <tag> [-to <tag>] [-hex] [-hex-bytes <n>] [-json]"
+	if *fileName == "" {
+		return usageError("missing required flag -file", usage)
+	}
+	if *from == "" {
+		return usageError("missing required flag -from", usage)
+	}
+
 	versions, err := sm.GetFileVersions(ctx, *fileName)
 	if err != nil {
-		log.Fatal("Failed to get file versions", "error", err)
+		return fmt.Errorf("failed to get versions of %s: %w", *fileName, err)
 	}
 
-	if len(versions) == 0 {
-		fmt.Printf("No versions found for file: %s\n", *fileName)
-		return
+	if *to == "" {
+		head, err := sm.GetHead(ctx, *fileName)
+		if err != nil {
+			return fmt.Errorf("failed to get head version: %w", err)
+		}
+		*to = head
+		if *to == "" && len(versions) > 0 {
+			*to = versions[0].Tag // newest first
+		}
 	}
 
-	headVersion, err := sm.GetHead(ctx, *fileName)
+	for _, tag := range []string{*from, *to} {
+		if !slices.ContainsFunc(versions, func(v sqlc.Version) bool { return v.Tag == tag }) {
+			return fmt.Errorf("version %s of %s does not exist", tag, *fileName)
+		}
+	}
+
+	ranges, err := sm.Diff(ctx, *fileName, *from, *to)
 	if err != nil {
-		log.Fatal("Failed to get head version", "error", err)
+		return err
 	}
 
-	runBubbleteaUI(versions, headVersion, *fileName, sm)
+	out := diffOutput{File: *fileName, From: *from, To: *to, Ranges: make([]diffRange, len(ranges))}
+	for i, r := range ranges {
+		out.Ranges[i] = diffRange{Start: r.FileRange[0], End: r.FileRange[1], Version: r.Version}
+
+		if *showHex {
+			n := min(*hexBytes, r.FileRange[1]-r.FileRange[0])
+			if out.Ranges[i].FromHex, err = readHex(ctx, sm, *fileName, *from, r.FileRange[0], n); err != nil {
+				return err
+			}
+			if out.Ranges[i].ToHex, err = readHex(ctx, sm, *fileName, *to, r.FileRange[0], n); err != nil {
+				return err
+			}
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	if len(out.Ranges) == 0 {
+		_, err := fmt.Fprintf(w, "No changes between %s and %s of %s\n", *from, *to, *fileName)
+		return err
+	}
+
+	const rowFormat = "%-12s  %-12s  %-10s  %s\n"
+	fmt.Fprintf(w, rowFormat, "START", "END", "SIZE", "VERSION")
+	for _, r := range out.Ranges {
+		fmt.Fprintf(w, rowFormat, strconv.FormatUint(r.Start, 10), strconv.FormatUint(r.End, 10), strconv.FormatUint(r.End-r.Start, 10), r.Version)
+		if *showHex {
+			fmt.Fprintf(w, "  %-6s %s\n  %-6s %s\n", *from+":", r.FromHex, *to+":", r.ToHex)
+		}
+	}
+
+	return nil
+}
+
+// readHex returns up to n bytes of a version of a file at offset, hex encoded. Bytes past the
+// end of the version (e.g. when the file grew) are left out.
+func readHex(ctx context.Context, sm *storage.Manager, fileName string, version string, offset uint64, n uint64) (string, error) {
+	size, err := sm.SizeOfVersion(ctx, fileName, version)
+	if err != nil {
+		return "", fmt.Errorf("failed to get size of version %s: %w", version, err)
+	}
+	if offset >= size {
+		return "", nil
+	}
+
+	data, err := sm.ReadFile(ctx, fileName, offset, min(n, size-offset), storage.WithVersion(version))
+	if err != nil {
+		return "", fmt.Errorf("failed to read version %s: %w", version, err)
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+func executeChunksCommand(sm *storage.Manager, log *log.Logger) {
+	if err := runChunks(context.Background(), sm, os.Args[1:], os.Stdout); err != nil {
+		exitWithError(log, "Failed to list chunks", err)
+	}
+}
+
+// runChunks prints the chunks of a file in the order reads apply them, later ones overriding
+// earlier ones, to debug overlapping writes
+func runChunks(ctx context.Context, sm *storage.Manager, args []string, w io.Writer) error {
+	chunksCmd := flag.NewFlagSet("chunks", flag.ContinueOnError)
+	fileName := chunksCmd.String("file", "", "Target file to list the chunks of")
+	version := chunksCmd.String("version", "", "Version to list the chunks of (defaults to the head version, or the latest one)")
+	asJSON := chunksCmd.Bool("json", false, "Print the chunks as JSON")
+
+	if err := chunksCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if *fileName == "" {
+		return usageError("missing required flag -file", "op chunks -file <filename> [-version <tag>] [-json]")
+	}
+
+	var opts []storage.ReadOpt
+	if *version != "" {
+		opts = append(opts, storage.WithVersion(*version))
+	}
+
+	chunks, err := sm.ListChunks(ctx, *fileName, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to list chunks of %s: %w", *fileName, err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(chunks)
+	}
+
+	if len(chunks) == 0 {
+		_, err := fmt.Fprintf(w, "No chunks found for %s\n", *fileName)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LAYER\tVERSION\tFILE RANGE\tLAYER RANGE\tOBJECT KEY")
+	for _, c := range chunks {
+		layer, version, objectKey := strconv.FormatUint(c.LayerID, 10), c.Version, c.ObjectKey
+		if c.Active {
+			layer, version, objectKey = "active", "-", "-"
+		}
+		if c.Dedup {
+			objectKey += " (dedup)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t[%d, %d)\t[%d, %d)\t%s\n", layer, version,
+			c.FileRange[0], c.FileRange[1], c.LayerRange[0], c.LayerRange[1], objectKey)
+	}
+	return tw.Flush()
+}
+
+func executeExportCommand(sm *storage.Manager, log *log.Logger) {
+	n, err := runExport(context.Background(), sm, os.Args[1:])
+	if err != nil {
+		exitWithError(log, "Failed to export version", err)
+	}
+	fmt.Printf("Exported %d bytes\n", n)
+}
+
+// runExport copies a version of a file to a local file a chunk at a time, so the version is
+// never held in memory as a whole. It returns the number of bytes exported.
+func runExport(ctx context.Context, sm *storage.Manager, args []string) (uint64, error) {
+	exportCmd := flag.NewFlagSet("export", flag.ContinueOnError)
+	fileName := exportCmd.String("file", "", "Target file to export")
+	version := exportCmd.String("version", "", "Version to export")
+	outPath := exportCmd.String("out", "", "Path of the local file to write the version to")
+	chunkSize := exportCmd.Uint64("chunk-size", 4<<20, "Number of bytes read from storage at a time")
+
+	if err := exportCmd.Parse(args); err != nil {
+		return 0, err
+	}
+
+	usage := "op export -file <filename> -version <tag> -out <path> [-chunk-size <bytes>]"
+	switch {
+	case *fileName == "":
+		return 0, usageError("missing required flag -file", usage)
+	case *version == "":
+		return 0, usageError("missing required flag -version", usage)
+	case *outPath == "":
+		return 0, usageError("missing required flag -out", usage)
+	case *chunkSize == 0:
+		return 0, usageError("-chunk-size must be positive", usage)
+	}
+
+	size, err := sm.SizeOfVersion(ctx, *fileName, *version)
+	if errors.Is(err, types.ErrNotFound) {
+		return 0, fmt.Errorf("version %s of %s does not exist", *version, *fileName)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get size of version %s: %w", *version, err)
+	}
+
+	out, err := os.Create(*outPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", *outPath, err)
+	}
+
+	if err := exportVersion(ctx, sm, *fileName, *version, size, *chunkSize, out); err != nil {
+		out.Close()
+		os.Remove(*outPath) // Don't leave a truncated copy behind
+		return 0, err
+	}
+
+	if err := out.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close %s: %w", *outPath, err)
+	}
+
+	return size, nil
+}
+
+func exportVersion(ctx context.Context, sm *storage.Manager, fileName string, version string, size uint64, chunkSize uint64, out *os.File) error {
+	w := bufio.NewWriterSize(out, int(min(chunkSize, 1<<20)))
+
+	for offset := uint64(0); offset < size; offset += chunkSize {
+		data, err := sm.ReadFile(ctx, fileName, offset, min(chunkSize, size-offset), storage.WithVersion(version))
+		if err != nil {
+			return fmt.Errorf("failed to read version %s at offset %d: %w", version, offset, err)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", out.Name(), err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out.Name(), err)
+	}
+
+	return out.Sync()
 }
 
 // Model represents the UI state
@@ -211,6 +1185,9 @@ func (m Model) refreshTableModel() table.Model {
 	columns := []table.Column{
 		{Title: "VERSION", Width: 20},
 		{Title: "TIMESTAMP", Width: 30},
+		{Title: "ORIGIN", Width: 8},
+		{Title: "AUTHOR", Width: 20},
+		{Title: "MESSAGE", Width: 40},
 		{Title: "HEAD", Width: 5},
 	}
 
@@ -226,7 +1203,7 @@ func (m Model) refreshTableModel() table.Model {
 			timestamp = v.CreatedAt.Time.Format("2006-01-02 15:04:05.000")
 		}
 
-		rows[i] = table.Row{v.Tag, timestamp, headIndicator}
+		rows[i] = table.Row{v.Tag, timestamp, v.Origin, v.Author, v.Message, headIndicator}
 	}
 
 	t := table.New(
@@ -278,21 +1255,26 @@ func runBubbleteaUI(versions []sqlc.Version, headVersion string, fileName string
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running UI: %v\n", err)
 
-		fmt.Printf("Version history for file: %s\n", fileName)
-		fmt.Printf("%-20s %-30s %s\n", "VERSION", "TIMESTAMP", "HEAD")
-		fmt.Println(strings.Repeat("-", 60))
+		printVersions(os.Stdout, versions, headVersion, fileName)
+	}
+}
 
-		for _, version := range versions {
-			headIndicator := ""
-			if version.Tag == headVersion {
-				headIndicator = "<---"
-			}
-			timestamp := "N/A"
-			if version.CreatedAt.Valid {
-				timestamp = version.CreatedAt.Time.Format("2006-01-02 15:04:05.000")
-			}
-			fmt.Printf("%-20s %-30s %s\n", version.Tag, timestamp, headIndicator)
+// printVersions prints the versions as a plain text table
+func printVersions(w io.Writer, versions []sqlc.Version, headVersion string, fileName string) {
+	fmt.Fprintf(w, "Version history for file: %s\n", fileName)
+	fmt.Fprintf(w, "%-20s %-30s %-8s %-20s %-40s %s\n", "VERSION", "TIMESTAMP", "ORIGIN", "AUTHOR", "MESSAGE", "HEAD")
+	fmt.Fprintln(w, strings.Repeat("-", 129))
+
+	for _, version := range versions {
+		headIndicator := ""
+		if version.Tag == headVersion {
+			headIndicator = "<---"
+		}
+		timestamp := "N/A"
+		if version.CreatedAt.Valid {
+			timestamp = version.CreatedAt.Time.Format("2006-01-02 15:04:05.000")
 		}
+		fmt.Fprintf(w, "%-20s %-30s %-8s %-20s %-40s %s\n", version.Tag, timestamp, version.Origin, version.Author, version.Message, headIndicator)
 	}
 }
 
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/quackfstest"
+	"github.com/vinimdocarmo/quackfs/internal/storage"
+)
+
+func TestWriteCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_write_%d.duckdb", time.Now().UnixNano())
+
+	// The file is created on the first write
+	version, err := runWrite(ctx, sm, []string{"-file", fileName, "-data", "hello world"})
+	require.NoError(t, err)
+	assert.Equal(t, "v1", version)
+
+	version, err = runWrite(ctx, sm, []string{"-file", fileName, "-offset", "6", "-data", "quack", "-version", "patched"})
+	require.NoError(t, err)
+	assert.Equal(t, "patched", version)
+
+	data, err := sm.ReadFile(ctx, fileName, 0, 11)
+	require.NoError(t, err)
+	assert.Equal(t, "hello quack", string(data))
+
+	_, err = runWrite(ctx, sm, []string{"-file", fileName, "-offset", "20", "-data", "gap"})
+	assert.ErrorIs(t, err, types.ErrBeyondFileSize, "Writes past the end should need -allow-beyond-size")
+
+	_, err = runWrite(ctx, sm, []string{"-file", fileName, "-offset", "20", "-data", "gap", "-allow-beyond-size"})
+	require.NoError(t, err)
+
+	size, err := sm.SizeOf(ctx, fileName)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(23), size)
+
+	_, err = runWrite(ctx, sm, []string{"-data", "no file"})
+	assert.ErrorContains(t, err, "-file")
+}
+
+func TestReadCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_read_%d.duckdb", time.Now().UnixNano())
+
+	_, err := runWrite(ctx, sm, []string{"-file", fileName, "-data", "first"})
+	require.NoError(t, err)
+	_, err = runWrite(ctx, sm, []string{"-file", fileName, "-data", "FIRST and second"})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, runRead(ctx, sm, []string{"-file", fileName}, &out))
+	assert.Equal(t, "FIRST and second", out.String())
+
+	out.Reset()
+	require.NoError(t, runRead(ctx, sm, []string{"-file", fileName, "-version", "v1"}, &out))
+	assert.Equal(t, "first", out.String())
+
+	out.Reset()
+	require.NoError(t, runRead(ctx, sm, []string{"-file", fileName, "-offset", "6", "-size", "3"}, &out))
+	assert.Equal(t, "and", out.String())
+
+	out.Reset()
+	err = runRead(ctx, sm, []string{"-file", fileName, "-version", "v9"}, &out)
+	assert.EqualError(t, err, fmt.Sprintf("version v9 of %s does not exist", fileName))
+	assert.Empty(t, out.String())
+}
+
+func TestCheckpointCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_checkpoint_%d.duckdb", time.Now().UnixNano())
+
+	_, err := sm.InsertFile(ctx, fileName)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, runCheckpoint(ctx, sm, []string{"-file", fileName}, &out))
+	assert.Equal(t, "Nothing to checkpoint\n", out.String())
+
+	out.Reset()
+	require.NoError(t, runCheckpoint(ctx, sm, []string{"-file", fileName, "-dry-run"}, &out))
+	assert.Equal(t, "Nothing to checkpoint\n", out.String())
+
+	require.NoError(t, sm.WriteFile(ctx, fileName, []byte("pending"), 0))
+
+	out.Reset()
+	require.NoError(t, runCheckpoint(ctx, sm, []string{"-file", fileName, "-version", "manual", "-dry-run"}, &out))
+	assert.Regexp(t, `^Would checkpoint version manual: 7 bytes in 1 chunks, stored under layers/\d+/\n$`, out.String())
+
+	versions, err := sm.GetFileVersions(ctx, fileName)
+	require.NoError(t, err)
+	assert.Empty(t, versions, "A dry run should not create a version")
+
+	out.Reset()
+	require.NoError(t, runCheckpoint(ctx, sm, []string{"-file", fileName, "-version", "manual"}, &out))
+	assert.Equal(t, "Checkpointed version manual\n", out.String())
+
+	versions, err = sm.GetFileVersions(ctx, fileName)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, "manual", versions[0].Tag)
+
+	err = runCheckpoint(ctx, sm, nil, &out)
+	assert.ErrorContains(t, err, "-file")
+}
+
+func TestDiffCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_diff_%d.duckdb", time.Now().UnixNano())
+
+	// v1 writes the whole file, v2 overwrites a range, v3 overwrites the middle of it and v4 another one
+	for _, write := range [][]string{
+		{"-data", "aaaaaaaaaa"},
+		{"-offset", "2", "-data", "bbbb"},
+		{"-offset", "3", "-data", "dd"},
+	} {
+		_, err := runWrite(ctx, sm, append([]string{"-file", fileName}, write...))
+		require.NoError(t, err)
+	}
+	require.NoError(t, sm.WriteFile(ctx, fileName, []byte("cc"), 8))
+	_, err := sm.Checkpoint(ctx, fileName, "v4")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, runDiff(ctx, sm, []string{"-file", fileName, "-from", "v1", "-to", "v3", "-json", "-hex"}, &out))
+
+	var got diffOutput
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, "v1", got.From)
+	assert.Equal(t, "v3", got.To)
+	assert.Equal(t, []diffRange{
+		{Start: 2, End: 3, Version: "v2", FromHex: "61", ToHex: "62"},
+		{Start: 3, End: 5, Version: "v3", FromHex: "6161", ToHex: "6464"},
+		{Start: 5, End: 6, Version: "v2", FromHex: "61", ToHex: "62"},
+	}, got.Ranges)
+
+	// Without -to, diff against the latest version
+	out.Reset()
+	require.NoError(t, runDiff(ctx, sm, []string{"-file", fileName, "-from", "v3"}, &out))
+	assert.Equal(t, "START         END           SIZE        VERSION\n8             10            2           v4\n", out.String())
+
+	out.Reset()
+	require.NoError(t, runDiff(ctx, sm, []string{"-file", fileName, "-from", "v4"}, &out))
+	assert.Contains(t, out.String(), "No changes")
+
+	err = runDiff(ctx, sm, []string{"-file", fileName, "-from", "v1", "-to", "v9"}, &out)
+	assert.EqualError(t, err, fmt.Sprintf("version v9 of %s does not exist", fileName))
+
+	err = runDiff(ctx, sm, []string{"-file", fileName, "-from", "v0"}, &out)
+	assert.EqualError(t, err, fmt.Sprintf("version v0 of %s does not exist", fileName))
+}
+
+func TestExportCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_export_%d.duckdb", time.Now().UnixNano())
+
+	v1 := bytes.Repeat([]byte("0123456789"), 100)
+	_, err := sm.InsertFile(ctx, fileName)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, fileName, v1, 0))
+	_, err = sm.Checkpoint(ctx, fileName, "v1")
+	require.NoError(t, err)
+
+	require.NoError(t, sm.WriteFile(ctx, fileName, []byte("patched"), 995))
+	_, err = sm.Checkpoint(ctx, fileName, "v2")
+	require.NoError(t, err)
+
+	v2 := append(bytes.Clone(v1[:995]), "patched"...)
+
+	dir := t.TempDir()
+
+	// A chunk size that doesn't divide the file, so the last chunk is partial
+	outPath := filepath.Join(dir, "v1.duckdb")
+	n, err := runExport(ctx, sm, []string{"-file", fileName, "-version", "v1", "-out", outPath, "-chunk-size", "64"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(v1)), n)
+
+	exported, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Equal(t, v1, exported)
+
+	outPath = filepath.Join(dir, "v2.duckdb")
+	n, err = runExport(ctx, sm, []string{"-file", fileName, "-version", "v2", "-out", outPath})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(v2)), n)
+
+	exported, err = os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Equal(t, v2, exported)
+
+	outPath = filepath.Join(dir, "v9.duckdb")
+	_, err = runExport(ctx, sm, []string{"-file", fileName, "-version", "v9", "-out", outPath})
+	assert.EqualError(t, err, fmt.Sprintf("version v9 of %s does not exist", fileName))
+	assert.NoFileExists(t, outPath)
+}
+
+func TestImportCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_import_%d.duckdb", time.Now().UnixNano())
+
+	// A few megabytes that don't fill the last block
+	data := make([]byte, 3<<20+12345)
+	for i := range data {
+		data[i] = byte(i * 31 % 251)
+	}
+	inPath := filepath.Join(t.TempDir(), "local.duckdb")
+	require.NoError(t, os.WriteFile(inPath, data, 0644))
+
+	n, version, err := runImport(ctx, sm, []string{"-file", fileName, "-in", inPath, "-version", "seed", "-block-size", "1048576"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), n)
+	assert.Equal(t, "seed", version)
+
+	size, err := sm.SizeOfVersion(ctx, fileName, "seed")
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), size)
+
+	got, err := sm.ReadFile(ctx, fileName, 0, size, storage.WithVersion("seed"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got), "The imported file should read back identical")
+
+	_, _, err = runImport(ctx, sm, []string{"-file", fileName, "-in", inPath})
+	assert.ErrorContains(t, err, "already has")
+
+	_, _, err = runImport(ctx, sm, []string{"-file", "other.duckdb", "-in", filepath.Join(t.TempDir(), "missing.duckdb")})
+	assert.ErrorContains(t, err, "failed to open")
+}
+
+func TestHeadCommands(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_head_%d.duckdb", time.Now().UnixNano())
+
+	_, err := runWrite(ctx, sm, []string{"-file", fileName, "-data", "first"})
+	require.NoError(t, err)
+	_, err = runWrite(ctx, sm, []string{"-file", fileName, "-data", "second"})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, runSetHead(ctx, sm, []string{"-file", fileName, "-version", "v1"}, &out))
+	assert.Equal(t, fmt.Sprintf("Head of %s set to v1\n", fileName), out.String())
+
+	head, err := sm.GetHead(ctx, fileName)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", head)
+
+	out.Reset()
+	err = runSetHead(ctx, sm, []string{"-file", fileName, "-version", "v9"}, &out)
+	assert.EqualError(t, err, fmt.Sprintf("version v9 of %s does not exist", fileName))
+	assert.Empty(t, out.String())
+
+	head, err = sm.GetHead(ctx, fileName)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", head, "A failed set-head should leave the head alone")
+
+	out.Reset()
+	require.NoError(t, runDeleteHead(ctx, sm, []string{"-file", fileName}, &out))
+	assert.Equal(t, fmt.Sprintf("Head of %s deleted, it was v1\n", fileName), out.String())
+
+	head, err = sm.GetHead(ctx, fileName)
+	require.NoError(t, err)
+	assert.Empty(t, head)
+
+	// Deleting a head that isn't set succeeds
+	out.Reset()
+	require.NoError(t, runDeleteHead(ctx, sm, []string{"-file", fileName}, &out))
+	assert.Contains(t, out.String(), "has no head")
+
+	assert.ErrorContains(t, runSetHead(ctx, sm, []string{"-file", fileName}, &out), "-version")
+}
+
+func TestMvCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	from := fmt.Sprintf("op_mv_from_%d.duckdb", time.Now().UnixNano())
+	to := fmt.Sprintf("op_mv_to_%d.duckdb", time.Now().UnixNano())
+	taken := fmt.Sprintf("op_mv_taken_%d.duckdb", time.Now().UnixNano())
+
+	_, err := runWrite(ctx, sm, []string{"-file", from, "-data", "moved"})
+	require.NoError(t, err)
+	_, err = runWrite(ctx, sm, []string{"-file", taken, "-data", "taken"})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	err = runMv(ctx, sm, []string{"-from", from, "-to", taken}, &out)
+	assert.ErrorIs(t, err, types.ErrFileExists)
+	assert.Empty(t, out.String())
+
+	require.NoError(t, runMv(ctx, sm, []string{"-from", from, "-to", to}, &out))
+	assert.Equal(t, fmt.Sprintf("Renamed %s to %s\n", from, to), out.String())
+
+	data, err := sm.ReadFile(ctx, to, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "moved", string(data))
+
+	err = runMv(ctx, sm, []string{"-from", from, "-to", to}, &out)
+	assert.EqualError(t, err, fmt.Sprintf("file %s does not exist", from))
+
+	assert.ErrorContains(t, runMv(ctx, sm, []string{"-from", from}, &out), "-to")
+}
+
+func TestLsCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	require.NoError(t, runLs(ctx, sm, nil, &out))
+	assert.Equal(t, "No files found\n", out.String())
+
+	_, err := runWrite(ctx, sm, []string{"-file", "b.duckdb", "-data", "first"})
+	require.NoError(t, err)
+	_, err = runWrite(ctx, sm, []string{"-file", "b.duckdb", "-offset", "5", "-data", " and second"})
+	require.NoError(t, err)
+	require.NoError(t, sm.SetHead(ctx, "b.duckdb", "v1"))
+	defer sm.DeleteHead(ctx, "b.duckdb")
+
+	_, err = runWrite(ctx, sm, []string{"-file", "a-much-longer-name.duckdb", "-data", "data"})
+	require.NoError(t, err)
+
+	_, err = sm.InsertFile(ctx, "empty.duckdb")
+	require.NoError(t, err)
+
+	out.Reset()
+	require.NoError(t, runLs(ctx, sm, nil, &out))
+	assert.Equal(t, ""+
+		"NAME                       SIZE  VERSIONS  HEAD\n"+
+		"a-much-longer-name.duckdb  4     1         -\n"+
+		"b.duckdb                   16    2         v1\n"+
+		"empty.duckdb               0     0         -\n", out.String())
+
+	out.Reset()
+	require.NoError(t, runLs(ctx, sm, []string{"-json"}, &out))
+
+	var entries []lsEntry
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	assert.Equal(t, []lsEntry{
+		{Name: "a-much-longer-name.duckdb", Size: 4, Versions: 1},
+		{Name: "b.duckdb", Size: 16, Versions: 2, Head: "v1"},
+		{Name: "empty.duckdb", Size: 0, Versions: 0},
+	}, entries)
+}
+
+func TestLogCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := "log.duckdb"
+
+	_, err := runWrite(ctx, sm, []string{"-file", fileName, "-data", "first", "-version", "v1"})
+	require.NoError(t, err)
+	_, err = runWrite(ctx, sm, []string{"-file", fileName, "-offset", "5", "-data", "second", "-version", "v2"})
+	require.NoError(t, err)
+	require.NoError(t, sm.SetHead(ctx, fileName, "v1"))
+	defer sm.DeleteHead(ctx, fileName)
+
+	var out bytes.Buffer
+	require.NoError(t, runLog(ctx, sm, []string{"-file", fileName, "-json"}, &out, true))
+
+	var entries []logEntry
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	require.Len(t, entries, 2)
+	assert.Equal(t, "v2", entries[0].Tag, "newest version should come first")
+	assert.False(t, entries[0].IsHead)
+	assert.Equal(t, "v1", entries[1].Tag)
+	assert.True(t, entries[1].IsHead)
+	assert.False(t, entries[1].Timestamp.IsZero())
+	assert.False(t, entries[0].Timestamp.Before(entries[1].Timestamp))
+
+	// Not a terminal, so plain text instead of the UI
+	out.Reset()
+	require.NoError(t, runLog(ctx, sm, []string{"-file", fileName}, &out, false))
+	assert.Contains(t, out.String(), "Version history for file: "+fileName)
+	assert.Regexp(t, `(?m)^v1 .*<---$`, out.String())
+
+	out.Reset()
+	require.NoError(t, runLog(ctx, sm, []string{"-file", "unknown.duckdb", "-no-tui"}, &out, true))
+	assert.Equal(t, "No versions found for file: unknown.duckdb\n", out.String())
+}
+
+func TestHealthCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	require.NoError(t, runHealth(ctx, sm, nil, &out))
+	assert.Equal(t, "OK: metadata store and object store are reachable\n", out.String())
+
+	// The context is already done, so both checks fail
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	out.Reset()
+	err := runHealth(ctx, sm, nil, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "metadata store is unreachable")
+	assert.Empty(t, out.String())
+}
+
+func TestVerifyCommand(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_verify_%d.duckdb", time.Now().UnixNano())
+
+	_, err := runWrite(ctx, sm, []string{"-file", fileName, "-data", "data", "-version", "v1"})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, runVerify(ctx, sm, nil, &out))
+	assert.Equal(t, "OK: every layer object exists with the expected size\n", out.String())
+
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NoError(t, store.DeleteObject(ctx, keys[0]))
+
+	out.Reset()
+	err = runVerify(ctx, sm, nil, &out)
+	assert.ErrorContains(t, err, "1 layers failed verification")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, `^LAYER\s+FILE\s+VERSION\s+OBJECT KEY\s+PROBLEM$`, lines[0])
+	assert.Regexp(t, `^\d+\s+`+regexp.QuoteMeta(fileName)+`\s+v1\s+`+regexp.QuoteMeta(keys[0])+`\s+missing$`, lines[1])
+
+	out.Reset()
+	err = runVerify(ctx, sm, []string{"-json"}, &out)
+	assert.Error(t, err)
+	var issues []storage.VerifyIssue
+	require.NoError(t, json.Unmarshal(out.Bytes(), &issues))
+	require.Len(t, issues, 1)
+	assert.Equal(t, storage.IssueMissing, issues[0].Problem)
+}
+
+func TestChunksCommand(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fileName := fmt.Sprintf("op_chunks_%d.duckdb", time.Now().UnixNano())
+
+	for _, write := range [][]string{
+		{"-data", "aaaa", "-version", "v1"},
+		{"-offset", "2", "-data", "bb", "-version", "v2"},
+	} {
+		_, err := runWrite(ctx, sm, append([]string{"-file", fileName}, write...))
+		require.NoError(t, err)
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, runChunks(ctx, sm, []string{"-file", fileName, "-json"}, &out))
+
+	var chunks []storage.ChunkInfo
+	require.NoError(t, json.Unmarshal(out.Bytes(), &chunks))
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "v1", chunks[0].Version)
+	assert.Equal(t, [2]uint64{0, 4}, chunks[0].FileRange)
+	assert.Equal(t, "v2", chunks[1].Version)
+	assert.Equal(t, [2]uint64{2, 4}, chunks[1].FileRange)
+
+	out.Reset()
+	require.NoError(t, runChunks(ctx, sm, []string{"-file", fileName, "-version", "v1"}, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, `^LAYER\s+VERSION\s+FILE RANGE\s+LAYER RANGE\s+OBJECT KEY$`, lines[0])
+	assert.Regexp(t, `^\d+\s+v1\s+\[0, 4\)\s+\[0, 4\)\s+layers/`, lines[1])
+
+	err := runChunks(ctx, sm, nil, &out)
+	assert.ErrorContains(t, err, "missing required flag -file")
+}
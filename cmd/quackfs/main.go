@@ -3,21 +3,28 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	gcs "cloud.google.com/go/storage"
+	"github.com/charmbracelet/log"
 	_ "github.com/lib/pq"
 	"github.com/vinimdocarmo/quackfs/internal/fsx"
 	"github.com/vinimdocarmo/quackfs/internal/storage"
 	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
 	"github.com/vinimdocarmo/quackfs/pkg/logger"
+	"github.com/vinimdocarmo/quackfs/pkg/metrics"
 )
 
 func main() {
@@ -25,8 +32,24 @@ func main() {
 	log := logger.New(os.Stderr)
 
 	mountpoint := flag.String("mount", "", "Mount point for the FUSE filesystem")
+	readOnly := flag.Bool("read-only", false, "Mount the filesystem read-only")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics (e.g. :9090), disabled if empty")
+	dbMaxOpenConns := flag.Int("db-max-open-conns", storage.DefaultMaxOpenConns, "Maximum number of PostgreSQL connections, which bounds the number of concurrent reads (0 for no limit)")
+	dbMaxIdleConns := flag.Int("db-max-idle-conns", storage.DefaultMaxIdleConns, "Maximum number of idle PostgreSQL connections kept open")
+	dbConnMaxLifetime := flag.Duration("db-conn-max-lifetime", storage.DefaultConnMaxLifetime, "Maximum age of a PostgreSQL connection before it's reopened (0 to keep them forever)")
+	opTimeout := flag.Duration("op-timeout", storage.DefaultOperationTimeout, "Maximum duration of a file operation, PostgreSQL queries and object store requests included (0 for no limit)")
+	var s3Config objectstore.S3Config
+	s3Config.RegisterFlags(flag.CommandLine)
+	var logConfig logger.Config
+	logConfig.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	logOpts, err := logConfig.Opts()
+	if err != nil {
+		log.Fatal("Invalid logging flags", "error", err)
+	}
+	log = logger.New(os.Stderr, logOpts...)
+
 	if *mountpoint == "" {
 		log.Info("Usage: ./quackfs -mount <mountpoint>")
 		os.Exit(1)
@@ -64,57 +87,264 @@ Differential Storage System for DuckDB
 	if err != nil {
 		log.Fatal("Failed to create database connection", "error", err)
 	}
-	defer db.Close()
 
-	// Initialize AWS S3 client (using LocalStack)
-	s3Endpoint := getEnvOrDefault("AWS_ENDPOINT_URL", "http://localhost:4566")
-	s3Region := getEnvOrDefault("AWS_REGION", "us-east-1")
-	s3BucketName := getEnvOrDefault("S3_BUCKET_NAME", "quackfs-bucket")
+	objectStore, storeInfo := newObjectStore(log, homeDir, s3Config)
 
-	log.Debug("Using S3 settings", "endpoint", s3Endpoint, "region", s3Region, "bucket", s3BucketName)
+	log.Debug("Using connection pool", "maxOpenConns", *dbMaxOpenConns, "maxIdleConns", *dbMaxIdleConns, "connMaxLifetime", *dbConnMaxLifetime)
+	log.Debug("Using operation timeout", "timeout", *opTimeout)
+	managerOpts := []storage.ManagerOpt{
+		storage.WithConnPool(*dbMaxOpenConns, *dbMaxIdleConns, *dbConnMaxLifetime),
+		storage.WithOperationTimeout(*opTimeout),
+	}
 
-	// Load AWS SDK configuration
-	cfgOptions := []func(*config.LoadOptions) error{
-		config.WithRegion(s3Region),
+	switch compression := storage.Compression(getEnvOrDefault("LAYER_COMPRESSION", string(storage.CompressionNone))); compression {
+	case storage.CompressionNone, storage.CompressionGzip:
+		log.Debug("Using layer compression", "compression", compression)
+		managerOpts = append(managerOpts, storage.WithCompression(compression))
+	default:
+		log.Fatal("Unknown layer compression, expected none or gzip", "LAYER_COMPRESSION", compression)
 	}
 
-	// Add static credentials for LocalStack
-	cfgOptions = append(cfgOptions,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			"test", "test", "test")))
+	// Hex encoded AES key (32, 48 or 64 hex characters)
+	if keyHex := os.Getenv("LAYER_ENCRYPTION_KEY"); keyHex != "" {
+		key, err := hex.DecodeString(keyHex)
+		if err != nil {
+			log.Fatal("Failed to decode LAYER_ENCRYPTION_KEY, expected a hex encoded key", "error", err)
+		}
+		log.Debug("Using layer encryption")
+		managerOpts = append(managerOpts, storage.WithEncryptionKey(key))
+	}
 
-	cfg, err := config.LoadDefaultConfig(context.Background(), cfgOptions...)
-	if err != nil {
-		log.Fatal("Failed to configure AWS client", "error", err)
+	// Reference identical chunks of earlier versions instead of uploading them again
+	if getEnvOrDefault("LAYER_DEDUP", "false") == "true" {
+		log.Debug("Using chunk deduplication")
+		managerOpts = append(managerOpts, storage.WithChunkDedup())
 	}
 
-	// Create an S3 client with custom endpoint for LocalStack
-	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(s3Endpoint)
-		o.UsePathStyle = true // Required for LocalStack
-		o.DisableLogOutputChecksumValidationSkipped = true
-	})
+	// Keep uncommitted writes in a local journal, so they survive a crash of the process
+	if journalDir := os.Getenv("WRITE_JOURNAL_DIR"); journalDir != "" {
+		log.Debug("Using write journal", "dir", journalDir)
+		managerOpts = append(managerOpts, storage.WithJournal(journalDir))
+	}
+
+	// Record which FUSE request produced each write, for debugging
+	if getEnvOrDefault("TRACE_WRITES", "false") == "true" {
+		log.Debug("Using write tracing")
+		managerOpts = append(managerOpts, storage.WithWriteTracing())
+	}
+
+	// Keep up to this many bytes of chunk data fetched from the object store in memory
+	if cacheBytes := os.Getenv("READ_CACHE_BYTES"); cacheBytes != "" {
+		bytes, err := strconv.ParseUint(cacheBytes, 10, 64)
+		if err != nil {
+			log.Fatal("Failed to parse READ_CACHE_BYTES, expected a number of bytes", "error", err)
+		}
+		log.Debug("Using read cache", "bytes", bytes)
+		managerOpts = append(managerOpts, storage.WithReadCache(bytes))
+	}
+
+	// Keep up to this many bytes of the layers just checkpointed in memory
+	if sealedBytes := os.Getenv("SEALED_LAYER_CACHE_BYTES"); sealedBytes != "" {
+		bytes, err := strconv.ParseUint(sealedBytes, 10, 64)
+		if err != nil {
+			log.Fatal("Failed to parse SEALED_LAYER_CACHE_BYTES, expected a number of bytes", "error", err)
+		}
+		log.Debug("Using sealed layer cache", "bytes", bytes)
+		managerOpts = append(managerOpts, storage.WithSealedLayerCache(bytes))
+	}
+
+	var registry *metrics.Registry
+	if *metricsAddr != "" {
+		registry = metrics.NewRegistry()
+		managerOpts = append(managerOpts, storage.WithMetrics(registry))
+	}
+
+	sm := storage.NewManager(db, objectStore, log, managerOpts...)
+
+	// Create the schema of an empty database, or upgrade one created by an older version
+	if err := sm.Migrate(context.Background()); err != nil {
+		log.Fatal("Failed to migrate database", "error", err)
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry.Handler())
+		mux.Handle("/healthz", healthHandler(sm))
+
+		go func() {
+			log.Info("Serving metrics and health checks", "addr", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Error("Failed to serve metrics", "error", err)
+			}
+		}()
+	}
+
+	var fsOpts []fsx.FSOpt
+
+	// Size of the file system reported to df and the like, in bytes
+	if capacity := os.Getenv("FS_CAPACITY"); capacity != "" {
+		bytes, err := strconv.ParseUint(capacity, 10, 64)
+		if err != nil {
+			log.Fatal("Failed to parse FS_CAPACITY, expected a number of bytes", "error", err)
+		}
+		fsOpts = append(fsOpts, fsx.WithCapacity(bytes))
+	}
+
+	// Maximum size of each WAL file, in bytes, writes past it fail with ENOSPC
+	if maxWALSize := os.Getenv("MAX_WAL_SIZE"); maxWALSize != "" {
+		bytes, err := strconv.ParseUint(maxWALSize, 10, 64)
+		if err != nil {
+			log.Fatal("Failed to parse MAX_WAL_SIZE, expected a number of bytes", "error", err)
+		}
+		fsOpts = append(fsOpts, fsx.WithMaxWALSize(bytes))
+	}
+
+	// Comma separated names of the files that can be created, e.g. ".sqlite,.sqlite-wal"
+	if extensions := os.Getenv("FS_EXTENSIONS"); extensions != "" {
+		fsOpts = append(fsOpts, fsx.WithExtensions(strings.Split(extensions, ",")...))
+	}
 
-	objectStore := objectstore.NewS3(s3Client, s3BucketName)
+	// Store files with DuckDB WAL names like any other file
+	if getEnvOrDefault("FS_DISABLE_WAL", "false") == "true" {
+		fsOpts = append(fsOpts, fsx.WithoutWAL())
+	}
+
+	// What closing a database file does with its uncommitted writes, "none" or "checkpoint"
+	switch policy := fsx.FlushPolicy(getEnvOrDefault("FS_FLUSH_POLICY", string(fsx.FlushNone))); policy {
+	case fsx.FlushNone, fsx.FlushCheckpoint:
+		fsOpts = append(fsOpts, fsx.WithFlushPolicy(policy))
+	default:
+		log.Fatal("Unknown flush policy, expected none or checkpoint", "FS_FLUSH_POLICY", policy)
+	}
+
+	mountOpts := []fuse.MountOption{fuse.FSName("quackfs")}
 
-	sm := storage.NewManager(db, objectStore, log)
+	if *readOnly {
+		fsOpts = append(fsOpts, fsx.WithReadOnly())
+		mountOpts = append(mountOpts, fuse.ReadOnly())
+	}
 
 	// Mount the FUSE filesystem.
-	c, err := fuse.Mount(*mountpoint, fuse.FSName("quackfs"))
+	c, err := fuse.Mount(*mountpoint, mountOpts...)
 	if err != nil {
 		log.Fatal("Failed to mount FUSE", "error", err)
 	}
-	defer c.Close()
 
-	log.Info("FUSE filesystem mounted", "mountpoint", *mountpoint)
+	log.Info("FUSE filesystem mounted", "mountpoint", *mountpoint, "readOnly", *readOnly)
 	log.Info("Storing WAL file in", "path", *walPath)
 	log.Info("Using PostgreSQL for metadata", "host", os.Getenv("POSTGRES_HOST"))
-	log.Info("Using S3 for data storage", "endpoint", s3Endpoint, "bucket", s3BucketName, "region", s3Region)
+	log.Info("Using object store for data storage", storeInfo...)
 
-	// Serve the filesystem. fs.Serve blocks until the filesystem is unmounted.
-	if err := fs.Serve(c, fsx.NewFS(sm, log, *walPath)); err != nil {
+	if err := serve(log, c, fsx.NewFS(sm, log, *walPath, fsOpts...), *mountpoint); err != nil {
 		log.Fatal("Failed to serve FUSE FS", "error", err)
 	}
+
+	log.Info("Closing connections")
+	if err := c.Close(); err != nil {
+		log.Error("Failed to close FUSE connection", "error", err)
+	}
+	if err := sm.Close(); err != nil {
+		log.Error("Failed to close metadata store", "error", err)
+	}
+	log.Info("Shutdown complete")
+}
+
+// serve serves the filesystem until it's unmounted, either externally or by serve itself on
+// SIGINT or SIGTERM. Requests in flight (e.g. a checkpoint) are done by the time it returns.
+// If the unmount fails, e.g. because the mount is busy, it's tried again on the next signal.
+func serve(log *log.Logger, c *fuse.Conn, fsys fs.FS, mountpoint string) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() {
+		// fs.Serve blocks until the filesystem is unmounted and its requests are done
+		served <- fs.Serve(c, fsys)
+	}()
+
+	for {
+		select {
+		case err := <-served:
+			if err != nil {
+				return err
+			}
+			log.Info("FUSE filesystem unmounted", "mountpoint", mountpoint)
+			return nil
+		case sig := <-signals:
+			log.Info("Received signal, unmounting", "signal", sig, "mountpoint", mountpoint)
+			if err := fuse.Unmount(mountpoint); err != nil {
+				log.Error("Failed to unmount, close the files in use and send the signal again", "mountpoint", mountpoint, "error", err)
+				continue
+			}
+			log.Info("Waiting for in-flight requests to finish")
+		}
+	}
+}
+
+// healthHandler responds 200 if the metadata store and the object store are reachable, 503
+// with the failed checks otherwise.
+func healthHandler(sm *storage.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := sm.Health(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// newObjectStore creates the object store selected by the OBJECT_STORE env var ("s3", "gcs" or "localfs").
+// It also returns key/value pairs describing the store for logging.
+func newObjectStore(log *log.Logger, homeDir string, s3Config objectstore.S3Config) (objectstore.ObjectStore, []any) {
+	switch kind := getEnvOrDefault("OBJECT_STORE", "s3"); kind {
+	case "s3":
+		log.Debug("Using S3 settings", "endpoint", s3Config.Endpoint, "region", s3Config.Region, "bucket", s3Config.Bucket, "pathStyle", s3Config.PathStyle)
+
+		s3Client, err := s3Config.NewClient(context.Background())
+		if err != nil {
+			log.Fatal("Failed to create S3 client", "error", err)
+		}
+
+		// Retry puts and gets failing on timeouts, throttling or 5xx responses
+		retryOpts := objectstore.DefaultRetryOptions()
+		if attempts := os.Getenv("S3_MAX_ATTEMPTS"); attempts != "" {
+			n, err := strconv.Atoi(attempts)
+			if err != nil {
+				log.Fatal("Failed to parse S3_MAX_ATTEMPTS, expected a number of attempts", "error", err)
+			}
+			retryOpts.MaxAttempts = n
+		}
+
+		return objectstore.NewRetrying(objectstore.NewS3(s3Client, s3Config.Bucket), retryOpts),
+			[]any{"type", kind, "endpoint", s3Config.Endpoint, "bucket", s3Config.Bucket, "region", s3Config.Region, "maxAttempts", retryOpts.MaxAttempts}
+	case "gcs":
+		bucket := getEnvOrDefault("GCS_BUCKET_NAME", "quackfs-bucket")
+
+		// Credentials come from the default chain (GOOGLE_APPLICATION_CREDENTIALS, gcloud, metadata
+		// server), and STORAGE_EMULATOR_HOST points the client to an emulator
+		gcsClient, err := gcs.NewClient(context.Background())
+		if err != nil {
+			log.Fatal("Failed to create GCS client", "error", err)
+		}
+
+		log.Debug("Using GCS object store", "bucket", bucket)
+
+		return objectstore.NewGCS(gcsClient, bucket), []any{"type", kind, "bucket", bucket}
+	case "localfs":
+		rootDir := getEnvOrDefault("OBJECT_STORE_PATH", filepath.Join(homeDir, ".quackfs", "objects"))
+
+		log.Debug("Using local filesystem object store", "path", rootDir)
+
+		return objectstore.NewLocalFS(rootDir), []any{"type", kind, "path", rootDir}
+	default:
+		log.Fatal("Unknown object store type, expected s3, gcs or localfs", "OBJECT_STORE", kind)
+		return nil, nil
+	}
 }
 
 // getEnvOrDefault returns the environment variable value or a default if not set
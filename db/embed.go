@@ -0,0 +1,15 @@
+// Package db holds the schema of the metadata store and its migrations.
+package db
+
+import "embed"
+
+// Schema creates the current schema in an empty database.
+//
+//go:embed schema.sql
+var Schema string
+
+// Migrations upgrade a database created from an older schema.sql. They are applied in the
+// order of their file names and must be idempotent, as the Makefile applies all of them.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
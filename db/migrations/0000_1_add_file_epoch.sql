@@ -0,0 +1,3 @@
+-- Add a fencing epoch to each file, bumped every time a node takes ownership of it.
+-- Existing files start at epoch 0.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS epoch BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- Record how each layer's chunks are compressed, and where each chunk's data is stored in the
+-- layer object. Existing layers are uncompressed, so their chunks are stored at their layer range.
+ALTER TABLE snapshot_layers ADD COLUMN IF NOT EXISTS compression TEXT NOT NULL DEFAULT 'none';
+
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS object_range INT8RANGE;
+
+UPDATE chunks SET object_range = layer_range WHERE object_range IS NULL;
+
+ALTER TABLE chunks ALTER COLUMN object_range SET NOT NULL;
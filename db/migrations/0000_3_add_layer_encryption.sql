@@ -0,0 +1,5 @@
+-- Record whether each layer's chunks are encrypted, and the nonce each chunk was encrypted with.
+-- Existing layers aren't encrypted.
+ALTER TABLE snapshot_layers ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS nonce BYTEA;
@@ -0,0 +1,3 @@
+-- Add a checksum of each chunk's data so corrupted objects are detected on read.
+-- Existing chunks have no checksum and are read without verification.
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS checksum BIGINT;
@@ -0,0 +1,3 @@
+-- Record which key each chunk was encrypted with, so encryption keys can be rotated.
+-- Chunks encrypted before this column existed used the default key.
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS key_id TEXT;
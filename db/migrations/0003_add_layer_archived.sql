@@ -0,0 +1,3 @@
+-- Record which layer objects were moved to cold storage, so reads needing them fail fast
+-- instead of waiting on a slow (or failing) fetch.
+ALTER TABLE snapshot_layers ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+-- Debug log of the writes that produced each layer's data, filled when write tracing is enabled.
+CREATE TABLE IF NOT EXISTS write_origins (
+    id BIGSERIAL PRIMARY KEY,
+    snapshot_layer_id INTEGER NOT NULL REFERENCES snapshot_layers(id) ON DELETE CASCADE,
+    layer_range INT8RANGE NOT NULL,
+    request_id BIGINT NOT NULL,
+    pid BIGINT NOT NULL,
+    write_offset BIGINT NOT NULL,
+    write_size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_write_origins_layer ON write_origins(snapshot_layer_id);
@@ -0,0 +1,3 @@
+-- Commit-style metadata recorded at checkpoint. Existing versions get empty values.
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS message TEXT NOT NULL DEFAULT '';
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT '';
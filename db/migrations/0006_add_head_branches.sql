@@ -0,0 +1,12 @@
+-- Named branches: a file can have one head per branch, the existing heads become the main branch.
+ALTER TABLE heads ADD COLUMN IF NOT EXISTS branch TEXT NOT NULL DEFAULT 'main';
+ALTER TABLE heads DROP CONSTRAINT IF EXISTS heads_file_id_key;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'heads_file_id_branch_key' AND conrelid = 'heads'::regclass) THEN
+        ALTER TABLE heads ADD CONSTRAINT heads_file_id_branch_key UNIQUE (file_id, branch);
+    END IF;
+END $$;
+
+ALTER TABLE files ADD COLUMN IF NOT EXISTS current_branch TEXT NOT NULL DEFAULT 'main';
@@ -0,0 +1,4 @@
+-- Persist file attributes across remounts. Existing files get 0644 and the time of the migration.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS mode INTEGER NOT NULL DEFAULT 420;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS modified_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
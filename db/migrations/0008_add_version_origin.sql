@@ -0,0 +1,2 @@
+-- Record what triggered each checkpoint. Existing versions are assumed to be manual.
+ALTER TABLE versions ADD COLUMN IF NOT EXISTS origin TEXT NOT NULL DEFAULT 'manual';
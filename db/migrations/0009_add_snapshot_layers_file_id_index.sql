@@ -0,0 +1,2 @@
+-- Scan the layers of a file in creation order without sorting them.
+CREATE INDEX IF NOT EXISTS idx_snapshot_layers_file_id ON snapshot_layers(file_id, id);
@@ -0,0 +1,7 @@
+-- Record a hash of each chunk's data so checkpoints can reference identical chunks of
+-- earlier layers instead of uploading them again, and the layer holding the data of such
+-- deduplicated chunks. Existing chunks have no hash and are never deduplicated against.
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS chunk_hash BYTEA;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS source_layer_id INTEGER REFERENCES snapshot_layers(id);
+
+CREATE INDEX IF NOT EXISTS idx_chunks_hash ON chunks(chunk_hash) WHERE chunk_hash IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Cache the size of each file, so that it isn't computed from its chunks on every stat.
+-- Existing files get the size of their committed chunks.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS current_size BIGINT NOT NULL DEFAULT 0;
+
+UPDATE files f SET current_size = COALESCE((
+    SELECT MAX(UPPER(c.file_range))
+    FROM chunks c
+    INNER JOIN snapshot_layers l ON c.snapshot_layer_id = l.id
+    WHERE l.file_id = f.id
+), 0);
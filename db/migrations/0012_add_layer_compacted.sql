@@ -0,0 +1,3 @@
+-- Mark the layers written by compaction, which hold the whole content of their file. Reads
+-- of their version and later ones don't need the chunks of the layers before them.
+ALTER TABLE snapshot_layers ADD COLUMN IF NOT EXISTS compacted BOOLEAN NOT NULL DEFAULT FALSE;
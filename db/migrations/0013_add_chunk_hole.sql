@@ -0,0 +1,3 @@
+-- Mark the chunks punched as holes: their file range reads as zeroes, and they have no data,
+-- so their layer and object ranges are empty.
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS hole BOOLEAN NOT NULL DEFAULT FALSE;
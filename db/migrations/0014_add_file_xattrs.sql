@@ -0,0 +1,7 @@
+-- Extended attributes of files, deleted along with their file.
+CREATE TABLE IF NOT EXISTS file_xattrs (
+    file_id BIGINT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    value BYTEA NOT NULL,
+    PRIMARY KEY (file_id, name)
+);
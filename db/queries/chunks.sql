@@ -11,16 +11,69 @@ ORDER BY
     UPPER(e.file_range) DESC
 LIMIT 1;
 
+-- name: CalcFileSizeAtLayer :one
+-- Size of the file as of a layer, i.e. considering only that layer and the ones before it
+SELECT 
+    COALESCE(MAX(UPPER(e.file_range)), 0)::BIGINT as file_size
+FROM 
+    chunks e
+INNER JOIN 
+    snapshot_layers l ON e.snapshot_layer_id = l.id
+WHERE 
+    l.file_id = sqlc.arg('fileID') AND l.id <= sqlc.arg('layerID');
+
 -- name: InsertChunk :exec
 INSERT INTO 
-    chunks (snapshot_layer_id, layer_range, file_range) 
+    chunks (snapshot_layer_id, layer_range, file_range, object_range, nonce, key_id, checksum) 
 VALUES 
-    ($1, $2, $3);
+    ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: InsertChunks :exec
+-- Inserts all the chunks of a layer in a single round-trip. Chunks are inserted (and so
+-- get their ids) in array order, which reads rely on to apply them in write order.
+INSERT INTO 
+    chunks (snapshot_layer_id, layer_range, file_range, object_range, nonce, key_id, checksum, chunk_hash, source_layer_id, hole) 
+SELECT 
+    sqlc.arg('snapshotLayerID')::BIGINT,
+    int8range(c.layer_start, c.layer_end),
+    int8range(c.file_start, c.file_end),
+    int8range(c.object_start, c.object_end),
+    NULLIF(c.nonce, ''::BYTEA),
+    NULLIF(c.key_id, ''),
+    CASE WHEN c.has_checksum THEN c.checksum END,
+    NULLIF(c.chunk_hash, ''::BYTEA),
+    NULLIF(c.source_layer_id, 0),
+    c.hole
+FROM 
+    ROWS FROM (
+        unnest(sqlc.arg('layerStarts')::BIGINT[]),
+        unnest(sqlc.arg('layerEnds')::BIGINT[]),
+        unnest(sqlc.arg('fileStarts')::BIGINT[]),
+        unnest(sqlc.arg('fileEnds')::BIGINT[]),
+        unnest(sqlc.arg('objectStarts')::BIGINT[]),
+        unnest(sqlc.arg('objectEnds')::BIGINT[]),
+        unnest(sqlc.arg('nonces')::BYTEA[]),
+        unnest(sqlc.arg('keyIDs')::TEXT[]),
+        unnest(sqlc.arg('checksums')::BIGINT[]),
+        unnest(sqlc.arg('hasChecksums')::BOOLEAN[]),
+        unnest(sqlc.arg('chunkHashes')::BYTEA[]),
+        unnest(sqlc.arg('sourceLayerIDs')::BIGINT[]),
+        unnest(sqlc.arg('holes')::BOOLEAN[])
+    ) WITH ORDINALITY AS c(layer_start, layer_end, file_start, file_end, object_start, object_end, nonce, key_id, checksum, has_checksum, chunk_hash, source_layer_id, hole, ord)
+ORDER BY 
+    c.ord;
 
 -- name: GetLayerChunks :many
 SELECT 
     layer_range, 
-    file_range
+    file_range,
+    object_range,
+    nonce,
+    key_id,
+    checksum,
+    chunk_hash,
+    source_layer_id,
+    hole
 FROM 
     chunks
 WHERE 
@@ -32,7 +85,14 @@ ORDER BY
 SELECT 
     c.snapshot_layer_id, 
     c.layer_range, 
-    c.file_range
+    c.file_range,
+    c.object_range,
+    c.nonce,
+    c.key_id,
+    c.checksum,
+    c.chunk_hash,
+    c.source_layer_id,
+    c.hole
 FROM 
     chunks c
 INNER JOIN 
@@ -40,6 +100,37 @@ INNER JOIN
 WHERE
     -- if versionedLayerID is 0, then we don't filter by layer ID
     (sqlc.arg('versionedLayerID') = 0 OR l.id <= sqlc.arg('versionedLayerID')) AND
-    l.file_id = sqlc.arg('fileID') AND c.file_range && sqlc.arg('range')::INT8RANGE
+    l.file_id = sqlc.arg('fileID') AND c.file_range && sqlc.arg('range')::INT8RANGE AND
+    -- the last compacted layer holds the whole file, the layers before it are overridden
+    l.id >= COALESCE((
+        SELECT MAX(b.id) FROM snapshot_layers b
+        WHERE b.file_id = sqlc.arg('fileID') AND b.compacted AND
+            (sqlc.arg('versionedLayerID') = 0 OR b.id <= sqlc.arg('versionedLayerID'))
+    ), 0)
 ORDER BY 
-    l.id ASC, c.id ASC; 
\ No newline at end of file
+    l.id ASC, c.id ASC; 
+-- name: FindChunksByHash :many
+-- Finds where the data of chunks with the given hashes is already stored for a file: the
+-- first stored copy of each, in a versioned layer whose object is readable (not archived).
+SELECT DISTINCT ON (c.chunk_hash)
+    c.chunk_hash,
+    l.id AS data_layer_id,
+    c.object_range,
+    c.nonce,
+    c.key_id
+FROM 
+    chunks c
+INNER JOIN 
+    snapshot_layers l ON l.id = COALESCE(c.source_layer_id, c.snapshot_layer_id)
+WHERE 
+    c.chunk_hash = ANY(sqlc.arg('hashes')::BYTEA[]) AND
+    l.file_id = sqlc.arg('fileID') AND
+    l.version_id IS NOT NULL AND
+    NOT l.archived AND
+    l.encrypted = sqlc.arg('encrypted')
+ORDER BY 
+    c.chunk_hash, c.id;
+
+-- name: DeleteFileChunks :exec
+DELETE FROM chunks
+WHERE snapshot_layer_id IN (SELECT id FROM snapshot_layers WHERE file_id = $1);
@@ -5,4 +5,55 @@ SELECT id FROM files WHERE name = $1;
 INSERT INTO files (name) VALUES ($1) RETURNING id;
 
 -- name: GetAllFiles :many
-SELECT id, name FROM files; 
\ No newline at end of file
+SELECT id, name, epoch, current_branch, mode, created_at, modified_at, current_size FROM files;
+
+-- name: AcquireFileEpoch :one
+UPDATE files SET epoch = epoch + 1 WHERE id = $1 RETURNING epoch;
+
+-- name: GetFileEpoch :one
+-- FOR SHARE blocks other nodes from acquiring the file until the transaction ends
+SELECT epoch FROM files WHERE id = $1 FOR SHARE;
+
+-- name: RenameFile :exec
+UPDATE files SET name = sqlc.arg('name') WHERE id = sqlc.arg('id');
+
+-- name: DeleteFile :exec
+DELETE FROM files WHERE id = $1;
+
+-- name: GetFileAttr :one
+SELECT mode, created_at, modified_at FROM files WHERE id = $1;
+
+-- name: SetFileMode :exec
+UPDATE files SET mode = $2 WHERE id = $1;
+
+-- name: SetFileModifiedAt :exec
+UPDATE files SET modified_at = $2 WHERE id = $1;
+
+-- name: GetFileCurrentSize :one
+SELECT current_size FROM files WHERE id = $1;
+
+-- name: GrowFileCurrentSize :exec
+-- Chunks are never removed from a file, so its size only grows
+UPDATE files SET current_size = GREATEST(current_size, sqlc.arg('size')) WHERE id = sqlc.arg('id');
+
+-- name: GetFileXattr :one
+SELECT value FROM file_xattrs WHERE file_id = $1 AND name = $2;
+
+-- name: ListFileXattrs :many
+SELECT name FROM file_xattrs WHERE file_id = $1 ORDER BY name;
+
+-- name: SetFileXattr :exec
+INSERT INTO file_xattrs (file_id, name, value) VALUES ($1, $2, $3)
+ON CONFLICT (file_id, name) DO UPDATE SET value = EXCLUDED.value;
+
+-- name: InsertFileXattr :execrows
+-- Inserts the attribute only if the file doesn't have it yet
+INSERT INTO file_xattrs (file_id, name, value) VALUES ($1, $2, $3)
+ON CONFLICT (file_id, name) DO NOTHING;
+
+-- name: ReplaceFileXattr :execrows
+-- Replaces the value of the attribute only if the file has it already
+UPDATE file_xattrs SET value = $3 WHERE file_id = $1 AND name = $2;
+
+-- name: DeleteFileXattr :execrows
+DELETE FROM file_xattrs WHERE file_id = $1 AND name = $2;
@@ -1,21 +1,42 @@
 -- name: SetHead :exec
-INSERT INTO heads (file_id, version_id)
-VALUES ($1, $2)
-ON CONFLICT (file_id)
+INSERT INTO heads (file_id, version_id, branch)
+VALUES ($1, $2, $3)
+ON CONFLICT (file_id, branch)
 DO UPDATE SET version_id = $2, created_at = CURRENT_TIMESTAMP;
 
+-- name: CreateBranch :exec
+INSERT INTO heads (file_id, version_id, branch)
+VALUES ($1, $2, $3);
+
 -- name: GetHeadVersion :one
+-- Head of the branch the file is currently on
 SELECT v.id as version_id, v.tag as version_tag
 FROM heads h
+JOIN files f ON h.file_id = f.id AND h.branch = f.current_branch
 JOIN versions v ON h.version_id = v.id
 WHERE h.file_id = $1;
 
+-- name: GetBranchVersion :one
+SELECT v.id as version_id, v.tag as version_tag
+FROM heads h
+JOIN versions v ON h.version_id = v.id
+WHERE h.file_id = $1 AND h.branch = $2;
+
 -- name: DeleteHead :exec
 DELETE FROM heads
-WHERE file_id = $1;
+WHERE file_id = $1 AND branch = $2;
 
 -- name: GetAllHeads :many
-SELECT h.file_id, f.name as file_name, v.id as version_id, v.tag as version_tag
+SELECT h.file_id, f.name as file_name, h.branch, v.id as version_id, v.tag as version_tag
 FROM heads h
 JOIN files f ON h.file_id = f.id
-JOIN versions v ON h.version_id = v.id; 
\ No newline at end of file
+JOIN versions v ON h.version_id = v.id; 
+
+-- name: GetCurrentBranch :one
+SELECT current_branch FROM files WHERE id = $1;
+
+-- name: SetCurrentBranch :exec
+UPDATE files SET current_branch = $2 WHERE id = $1;
+
+-- name: DeleteFileHeads :exec
+DELETE FROM heads WHERE file_id = $1;
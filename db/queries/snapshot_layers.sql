@@ -4,7 +4,10 @@ SELECT
     snapshot_layers.file_id, 
     snapshot_layers.version_id, 
     versions.tag, 
-    snapshot_layers.object_key
+    snapshot_layers.object_key,
+    snapshot_layers.compression,
+    snapshot_layers.encrypted,
+    snapshot_layers.archived
 FROM 
     snapshot_layers
 LEFT JOIN 
@@ -16,29 +19,122 @@ ORDER BY
 
 -- name: InsertLayer :one
 INSERT INTO 
-    snapshot_layers (file_id, version_id, object_key) 
+    snapshot_layers (file_id, version_id, object_key, compression, encrypted) 
 VALUES 
-    ($1, $2, $3) 
+    ($1, $2, $3, $4, $5) 
 RETURNING id;
 
--- name: GetObjectKey :one
+-- name: SetLayerCompacted :exec
+UPDATE snapshot_layers SET compacted = TRUE WHERE id = $1;
+
+-- name: GetLayerObject :one
 SELECT 
-    object_key
+    object_key,
+    compression,
+    encrypted,
+    archived
 FROM 
     snapshot_layers
 WHERE 
     id = $1;
 
+-- name: SetLayerArchived :exec
+UPDATE 
+    snapshot_layers 
+SET 
+    archived = $2 
+WHERE 
+    id = $1;
+
 -- name: GetLayerByVersion :one
 SELECT 
     snapshot_layers.id, 
     snapshot_layers.file_id, 
     snapshot_layers.version_id, 
     versions.tag, 
-    snapshot_layers.object_key
+    snapshot_layers.object_key,
+    snapshot_layers.compression,
+    snapshot_layers.encrypted,
+    snapshot_layers.archived
 FROM 
     snapshot_layers
 INNER JOIN 
     versions ON versions.id = snapshot_layers.version_id
 WHERE 
-    snapshot_layers.file_id = $1 AND versions.tag = $2; 
\ No newline at end of file
+    snapshot_layers.file_id = $1 AND versions.tag = $2; 
+-- name: GetAllObjectKeys :many
+SELECT 
+    object_key
+FROM 
+    snapshot_layers;
+
+-- name: LockObjectsShared :exec
+-- Held by checkpoints while they upload and reference a new object, so that
+-- garbage collection never sees an uploaded object that isn't referenced yet
+SELECT pg_advisory_xact_lock_shared(sqlc.arg('lockID')::BIGINT);
+
+-- name: LockObjectsExclusive :exec
+-- Held by garbage collection while it looks for and deletes unreferenced objects
+SELECT pg_advisory_xact_lock(sqlc.arg('lockID')::BIGINT);
+
+-- name: DeleteFileLayers :exec
+-- Deletes the layers of a file along with their versions (and write origins)
+WITH deleted_layers AS (
+    DELETE FROM snapshot_layers WHERE file_id = $1 RETURNING version_id
+)
+DELETE FROM versions WHERE id IN (SELECT version_id FROM deleted_layers);
+
+-- name: GetFileStats :many
+-- Each layer has its own object, as big as the end of its last chunk stored in it (deduplicated
+-- chunks point into the object of an earlier layer)
+SELECT
+    files.id,
+    files.name,
+    COUNT(snapshot_layers.version_id)::BIGINT AS versions,
+    COUNT(snapshot_layers.id)::BIGINT AS layers,
+    COALESCE(SUM(layer_objects.size), 0)::BIGINT AS object_bytes
+FROM
+    files
+LEFT JOIN
+    snapshot_layers ON snapshot_layers.file_id = files.id
+LEFT JOIN (
+    SELECT
+        snapshot_layer_id,
+        MAX(upper(object_range)) AS size
+    FROM
+        chunks
+    WHERE
+        source_layer_id IS NULL
+    GROUP BY
+        snapshot_layer_id
+) AS layer_objects ON layer_objects.snapshot_layer_id = snapshot_layers.id
+GROUP BY
+    files.id, files.name
+ORDER BY
+    files.name;
+
+-- name: GetLayerObjectsPage :many
+-- Versioned layers of every file in id order, a page at a time (pass the id of the last layer of
+-- the previous page, or 0 for the first page), with the size their object should have: the end
+-- of the last of their own chunks, as deduplicated chunks are stored in the object of another layer
+SELECT
+    sl.id,
+    f.name AS file_name,
+    v.tag,
+    sl.object_key,
+    COALESCE(MAX(UPPER(c.object_range)) FILTER (WHERE c.source_layer_id IS NULL), 0)::BIGINT AS object_size
+FROM
+    snapshot_layers sl
+JOIN
+    files f ON f.id = sl.file_id
+JOIN
+    versions v ON v.id = sl.version_id
+LEFT JOIN
+    chunks c ON c.snapshot_layer_id = sl.id
+WHERE
+    sl.id > sqlc.arg('afterID')::BIGINT
+GROUP BY
+    sl.id, f.name, v.tag, sl.object_key
+ORDER BY
+    sl.id
+LIMIT sqlc.arg('pageSize')::INT;
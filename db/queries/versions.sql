@@ -1,5 +1,5 @@
 -- name: InsertVersion :one
-INSERT INTO versions (tag) VALUES ($1) RETURNING id;
+INSERT INTO versions (tag, message, author, origin) VALUES ($1, $2, $3, $4) RETURNING id;
 
 -- name: GetVersionIDByTag :one
 SELECT id FROM versions WHERE tag = $1;
@@ -8,7 +8,10 @@ SELECT id FROM versions WHERE tag = $1;
 SELECT
     v.id,
     v.tag,
-    v.created_at
+    v.created_at,
+    v.message,
+    v.author,
+    v.origin
 FROM
     versions v
 JOIN
@@ -16,4 +19,83 @@ JOIN
 WHERE
     sl.file_id = $1
 ORDER BY
-    v.created_at DESC; 
\ No newline at end of file
+    v.created_at DESC; 
+-- name: VersionTagExists :one
+-- Tags are unique per file, versions only become part of a file through its layers
+SELECT EXISTS (
+    SELECT 1
+    FROM
+        versions v
+    INNER JOIN
+        snapshot_layers sl ON sl.version_id = v.id
+    WHERE
+        sl.file_id = sqlc.arg('fileID') AND v.tag = sqlc.arg('tag')
+)::BOOLEAN AS version_exists;
+
+-- name: GetMaxNumericVersionTag :one
+-- Highest n among the file's tags of the form vn, 0 if there are none
+SELECT
+    COALESCE(MAX(SUBSTRING(v.tag FROM 2)::BIGINT), 0)::BIGINT AS max_tag
+FROM
+    versions v
+INNER JOIN
+    snapshot_layers sl ON sl.version_id = v.id
+WHERE
+    sl.file_id = sqlc.arg('fileID') AND v.tag ~ '^v[0-9]{1,18}$';
+
+-- name: GetAllVersionsPage :many
+-- Versions of every file in creation order, a page at a time: pass the created_at and id
+-- of the last row of the previous page (or the zero time and 0 for the first page)
+SELECT
+    v.id,
+    f.name AS file_name,
+    v.tag,
+    COALESCE(v.created_at, 'epoch'::TIMESTAMP)::TIMESTAMP AS created_at,
+    v.message,
+    v.author,
+    v.origin,
+    sl.object_key
+FROM
+    versions v
+JOIN
+    snapshot_layers sl ON v.id = sl.version_id
+JOIN
+    files f ON f.id = sl.file_id
+WHERE
+    (COALESCE(v.created_at, 'epoch'::TIMESTAMP), v.id) > (sqlc.arg('afterCreatedAt')::TIMESTAMP, sqlc.arg('afterID')::BIGINT)
+ORDER BY
+    COALESCE(v.created_at, 'epoch'::TIMESTAMP), v.id
+LIMIT sqlc.arg('pageSize')::INT;
+
+-- name: GetLatestVersion :one
+-- Most recent version of a file and its layer
+SELECT
+    v.id AS version_id,
+    v.tag,
+    sl.id AS layer_id
+FROM
+    versions v
+JOIN
+    snapshot_layers sl ON sl.version_id = v.id
+WHERE
+    sl.file_id = $1
+ORDER BY
+    v.created_at DESC, v.id DESC
+LIMIT 1;
+
+-- name: GetVersionAsOf :one
+-- Most recent version of a file created at or before asOf. created_at holds the session's
+-- local time, so asOf is converted to it
+SELECT
+    v.id AS version_id,
+    v.tag,
+    sl.id AS layer_id
+FROM
+    versions v
+JOIN
+    snapshot_layers sl ON sl.version_id = v.id
+WHERE
+    sl.file_id = sqlc.arg('fileID') AND v.created_at <= (sqlc.arg('asOf')::TIMESTAMPTZ)::TIMESTAMP
+ORDER BY
+    v.created_at DESC, v.id DESC
+LIMIT 1;
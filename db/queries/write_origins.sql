@@ -0,0 +1,35 @@
+-- name: InsertWriteOrigins :exec
+INSERT INTO 
+    write_origins (snapshot_layer_id, layer_range, request_id, pid, write_offset, write_size) 
+SELECT 
+    sqlc.arg('snapshotLayerID')::BIGINT,
+    int8range(o.layer_start, o.layer_end),
+    o.request_id,
+    o.pid,
+    o.write_offset,
+    o.write_size
+FROM 
+    ROWS FROM (
+        unnest(sqlc.arg('layerStarts')::BIGINT[]),
+        unnest(sqlc.arg('layerEnds')::BIGINT[]),
+        unnest(sqlc.arg('requestIDs')::BIGINT[]),
+        unnest(sqlc.arg('pids')::BIGINT[]),
+        unnest(sqlc.arg('writeOffsets')::BIGINT[]),
+        unnest(sqlc.arg('writeSizes')::BIGINT[])
+    ) WITH ORDINALITY AS o(layer_start, layer_end, request_id, pid, write_offset, write_size, ord)
+ORDER BY 
+    o.ord;
+
+-- name: GetWriteOrigins :many
+SELECT 
+    layer_range, 
+    request_id, 
+    pid, 
+    write_offset, 
+    write_size
+FROM 
+    write_origins
+WHERE 
+    snapshot_layer_id = $1
+ORDER BY 
+    id ASC;
@@ -1,16 +1,25 @@
-CREATE EXTENSION btree_gist;
+CREATE EXTENSION IF NOT EXISTS btree_gist;
 
 -- Create files table
 CREATE TABLE IF NOT EXISTS files (
     id BIGSERIAL PRIMARY KEY,
-    name TEXT UNIQUE NOT NULL
+    name TEXT UNIQUE NOT NULL,
+    epoch BIGINT NOT NULL DEFAULT 0, -- fencing token, bumped every time a node takes ownership of the file
+    current_branch TEXT NOT NULL DEFAULT 'main', -- branch (see heads) reads and writes resolve against
+    mode INTEGER NOT NULL DEFAULT 420, -- permission bits (0644)
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    modified_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- set explicitly (e.g. touch) or by checkpoints
+    current_size BIGINT NOT NULL DEFAULT 0 -- highest end offset of the committed chunks, grown by checkpoints
 );
 
 -- Create versions table
 CREATE TABLE IF NOT EXISTS versions (
     id BIGSERIAL PRIMARY KEY,
     tag TEXT NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    message TEXT NOT NULL DEFAULT '', -- commit-style description given at checkpoint, empty if none
+    author TEXT NOT NULL DEFAULT '', -- who created the version, empty if unknown
+    origin TEXT NOT NULL DEFAULT 'manual' -- what triggered the checkpoint: 'manual', 'wal' (DuckDB removing its WAL), 'flush' (Manager.Flush) or 'compact' (Manager.Compact)
 );
 
 -- Create snapshot_layers table
@@ -21,6 +30,10 @@ CREATE TABLE IF NOT EXISTS snapshot_layers (
     active INTEGER DEFAULT 0,
     version_id INTEGER DEFAULT NULL REFERENCES versions(id),
     object_key VARCHAR(255) NOT NULL,
+    compression TEXT NOT NULL DEFAULT 'none', -- how each chunk's data is compressed in the layer object
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE, -- whether each chunk's data is encrypted in the layer object
+    archived BOOLEAN NOT NULL DEFAULT FALSE, -- whether the layer object was moved to cold storage and must be restored before it can be read
+    compacted BOOLEAN NOT NULL DEFAULT FALSE, -- whether the layer holds the whole file, so reads of it and later versions skip the layers before it
     CHECK ((active = 1 AND version_id IS NULL) OR (active = 0 AND version_id IS NOT NULL)), -- version_id is NULL for the active snapshot layer
     UNIQUE (file_id, version_id)
 );
@@ -31,21 +44,54 @@ CREATE TABLE IF NOT EXISTS chunks (
     snapshot_layer_id INTEGER REFERENCES snapshot_layers(id),
     layer_range INT8RANGE NOT NULL,
     file_range INT8RANGE NOT NULL,
+    object_range INT8RANGE NOT NULL, -- where the chunk's (possibly compressed) data is stored in the layer object
+    nonce BYTEA, -- nonce the chunk's data was encrypted with, NULL if the layer isn't encrypted
+    key_id TEXT, -- id of the key the chunk's data was encrypted with, NULL if the layer isn't encrypted
+    checksum BIGINT, -- CRC32C of the chunk's (decoded) data, NULL for chunks written before checksums existed
+    chunk_hash BYTEA, -- SHA-256 of the chunk's (decoded) data, to find identical chunks; NULL for chunks written before deduplication existed
+    source_layer_id INTEGER REFERENCES snapshot_layers(id), -- layer whose object holds the chunk's data when it's deduplicated, NULL if it's the chunk's own layer
+    hole BOOLEAN NOT NULL DEFAULT FALSE, -- whether the chunk is a punched hole, reading as zeroes, with no data (empty layer and object ranges)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     -- for any given snapshot_layer_id, there should be no overlapping layer_ranges
     EXCLUDE USING GIST (snapshot_layer_id WITH =, layer_range WITH &&)
 ); 
 
--- Create heads table to track which version a file is currently pointing to
+-- Create write_origins table, a debug log of the writes (e.g. FUSE requests) that produced each
+-- layer's data. Only filled when write tracing is enabled.
+CREATE TABLE IF NOT EXISTS write_origins (
+    id BIGSERIAL PRIMARY KEY,
+    snapshot_layer_id INTEGER NOT NULL REFERENCES snapshot_layers(id) ON DELETE CASCADE,
+    layer_range INT8RANGE NOT NULL, -- bytes of the layer the write added, overlapping the chunk(s) holding them
+    request_id BIGINT NOT NULL,
+    pid BIGINT NOT NULL,
+    write_offset BIGINT NOT NULL,
+    write_size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create heads table to track which version each branch of a file is pointing to.
+-- A file without a main head reads (and writes) its latest version.
 CREATE TABLE IF NOT EXISTS heads (
     id BIGSERIAL PRIMARY KEY,
     file_id BIGINT NOT NULL REFERENCES files(id),
     version_id BIGINT NOT NULL REFERENCES versions(id),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE (file_id)
+    branch TEXT NOT NULL DEFAULT 'main',
+    UNIQUE (file_id, branch)
 ); 
 
+-- Create file_xattrs table to persist the extended attributes of files (e.g. set with setfattr)
+CREATE TABLE IF NOT EXISTS file_xattrs (
+    file_id BIGINT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    value BYTEA NOT NULL,
+    PRIMARY KEY (file_id, name)
+);
+
 CREATE INDEX IF NOT EXISTS idx_files_name ON files(name);
 CREATE INDEX IF NOT EXISTS idx_versions_tag ON versions(tag);
 CREATE INDEX IF NOT EXISTS idx_snapshot_layers_file_version ON snapshot_layers(file_id, version_id);
+CREATE INDEX IF NOT EXISTS idx_snapshot_layers_file_id ON snapshot_layers(file_id, id); -- layers of a file in creation order
 CREATE INDEX IF NOT EXISTS idx_chunks_layer_range ON chunks USING GIST(snapshot_layer_id, file_range);
+CREATE INDEX IF NOT EXISTS idx_chunks_hash ON chunks(chunk_hash) WHERE chunk_hash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_write_origins_layer ON write_origins(snapshot_layer_id);
@@ -7,7 +7,9 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
 
+	"github.com/lib/pq"
 	"github.com/vinimdocarmo/quackfs/db/types"
 )
 
@@ -32,10 +34,117 @@ func (q *Queries) CalcFileSize(ctx context.Context, fileID uint64) (int64, error
 	return file_size, err
 }
 
+const calcFileSizeAtLayer = `-- name: CalcFileSizeAtLayer :one
+SELECT 
+    COALESCE(MAX(UPPER(e.file_range)), 0)::BIGINT as file_size
+FROM 
+    chunks e
+INNER JOIN 
+    snapshot_layers l ON e.snapshot_layer_id = l.id
+WHERE 
+    l.file_id = $1 AND l.id <= $2
+`
+
+type CalcFileSizeAtLayerParams struct {
+	FileID  uint64 `json:"fileID"`
+	LayerID uint64 `json:"layerID"`
+}
+
+// Size of the file as of a layer, i.e. considering only that layer and the ones before it
+func (q *Queries) CalcFileSizeAtLayer(ctx context.Context, arg CalcFileSizeAtLayerParams) (int64, error) {
+	row := q.queryRow(ctx, q.calcFileSizeAtLayerStmt, calcFileSizeAtLayer, arg.FileID, arg.LayerID)
+	var file_size int64
+	err := row.Scan(&file_size)
+	return file_size, err
+}
+
+const deleteFileChunks = `-- name: DeleteFileChunks :exec
+DELETE FROM chunks
+WHERE snapshot_layer_id IN (SELECT id FROM snapshot_layers WHERE file_id = $1)
+`
+
+func (q *Queries) DeleteFileChunks(ctx context.Context, fileID uint64) error {
+	_, err := q.exec(ctx, q.deleteFileChunksStmt, deleteFileChunks, fileID)
+	return err
+}
+
+const findChunksByHash = `-- name: FindChunksByHash :many
+SELECT DISTINCT ON (c.chunk_hash)
+    c.chunk_hash,
+    l.id AS data_layer_id,
+    c.object_range,
+    c.nonce,
+    c.key_id
+FROM 
+    chunks c
+INNER JOIN 
+    snapshot_layers l ON l.id = COALESCE(c.source_layer_id, c.snapshot_layer_id)
+WHERE 
+    c.chunk_hash = ANY($1::BYTEA[]) AND
+    l.file_id = $2 AND
+    l.version_id IS NOT NULL AND
+    NOT l.archived AND
+    l.encrypted = $3
+ORDER BY 
+    c.chunk_hash, c.id
+`
+
+type FindChunksByHashParams struct {
+	Hashes    [][]byte `json:"hashes"`
+	FileID    uint64   `json:"fileID"`
+	Encrypted bool     `json:"encrypted"`
+}
+
+type FindChunksByHashRow struct {
+	ChunkHash   []byte         `json:"chunkHash"`
+	DataLayerID uint64         `json:"dataLayerId"`
+	ObjectRange types.Range    `json:"objectRange"`
+	Nonce       []byte         `json:"nonce"`
+	KeyID       sql.NullString `json:"keyId"`
+}
+
+// Finds where the data of chunks with the given hashes is already stored for a file: the
+// first stored copy of each, in a versioned layer whose object is readable (not archived).
+func (q *Queries) FindChunksByHash(ctx context.Context, arg FindChunksByHashParams) ([]FindChunksByHashRow, error) {
+	rows, err := q.query(ctx, q.findChunksByHashStmt, findChunksByHash, pq.Array(arg.Hashes), arg.FileID, arg.Encrypted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []FindChunksByHashRow{}
+	for rows.Next() {
+		var i FindChunksByHashRow
+		if err := rows.Scan(
+			&i.ChunkHash,
+			&i.DataLayerID,
+			&i.ObjectRange,
+			&i.Nonce,
+			&i.KeyID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getLayerChunks = `-- name: GetLayerChunks :many
 SELECT 
     layer_range, 
-    file_range
+    file_range,
+    object_range,
+    nonce,
+    key_id,
+    checksum,
+    chunk_hash,
+    source_layer_id,
+    hole
 FROM 
     chunks
 WHERE 
@@ -45,8 +154,15 @@ ORDER BY
 `
 
 type GetLayerChunksRow struct {
-	LayerRange types.Range `json:"layerRange"`
-	FileRange  types.Range `json:"fileRange"`
+	LayerRange    types.Range    `json:"layerRange"`
+	FileRange     types.Range    `json:"fileRange"`
+	ObjectRange   types.Range    `json:"objectRange"`
+	Nonce         []byte         `json:"nonce"`
+	KeyID         sql.NullString `json:"keyId"`
+	Checksum      sql.NullInt64  `json:"checksum"`
+	ChunkHash     []byte         `json:"chunkHash"`
+	SourceLayerID sql.NullInt32  `json:"sourceLayerId"`
+	Hole          bool           `json:"hole"`
 }
 
 func (q *Queries) GetLayerChunks(ctx context.Context, snapshotLayerID uint64) ([]GetLayerChunksRow, error) {
@@ -58,7 +174,17 @@ func (q *Queries) GetLayerChunks(ctx context.Context, snapshotLayerID uint64) ([
 	items := []GetLayerChunksRow{}
 	for rows.Next() {
 		var i GetLayerChunksRow
-		if err := rows.Scan(&i.LayerRange, &i.FileRange); err != nil {
+		if err := rows.Scan(
+			&i.LayerRange,
+			&i.FileRange,
+			&i.ObjectRange,
+			&i.Nonce,
+			&i.KeyID,
+			&i.Checksum,
+			&i.ChunkHash,
+			&i.SourceLayerID,
+			&i.Hole,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -76,7 +202,14 @@ const getOverlappingChunksWithVersion = `-- name: GetOverlappingChunksWithVersio
 SELECT 
     c.snapshot_layer_id, 
     c.layer_range, 
-    c.file_range
+    c.file_range,
+    c.object_range,
+    c.nonce,
+    c.key_id,
+    c.checksum,
+    c.chunk_hash,
+    c.source_layer_id,
+    c.hole
 FROM 
     chunks c
 INNER JOIN 
@@ -84,7 +217,13 @@ INNER JOIN
 WHERE
     -- if versionedLayerID is 0, then we don't filter by layer ID
     ($1 = 0 OR l.id <= $1) AND
-    l.file_id = $2 AND c.file_range && $3::INT8RANGE
+    l.file_id = $2 AND c.file_range && $3::INT8RANGE AND
+    -- the last compacted layer holds the whole file, the layers before it are overridden
+    l.id >= COALESCE((
+        SELECT MAX(b.id) FROM snapshot_layers b
+        WHERE b.file_id = $2 AND b.compacted AND
+            ($1 = 0 OR b.id <= $1)
+    ), 0)
 ORDER BY 
     l.id ASC, c.id ASC
 `
@@ -96,9 +235,16 @@ type GetOverlappingChunksWithVersionParams struct {
 }
 
 type GetOverlappingChunksWithVersionRow struct {
-	SnapshotLayerID uint64      `json:"snapshotLayerId"`
-	LayerRange      types.Range `json:"layerRange"`
-	FileRange       types.Range `json:"fileRange"`
+	SnapshotLayerID uint64         `json:"snapshotLayerId"`
+	LayerRange      types.Range    `json:"layerRange"`
+	FileRange       types.Range    `json:"fileRange"`
+	ObjectRange     types.Range    `json:"objectRange"`
+	Nonce           []byte         `json:"nonce"`
+	KeyID           sql.NullString `json:"keyId"`
+	Checksum        sql.NullInt64  `json:"checksum"`
+	ChunkHash       []byte         `json:"chunkHash"`
+	SourceLayerID   sql.NullInt32  `json:"sourceLayerId"`
+	Hole            bool           `json:"hole"`
 }
 
 func (q *Queries) GetOverlappingChunksWithVersion(ctx context.Context, arg GetOverlappingChunksWithVersionParams) ([]GetOverlappingChunksWithVersionRow, error) {
@@ -110,7 +256,18 @@ func (q *Queries) GetOverlappingChunksWithVersion(ctx context.Context, arg GetOv
 	items := []GetOverlappingChunksWithVersionRow{}
 	for rows.Next() {
 		var i GetOverlappingChunksWithVersionRow
-		if err := rows.Scan(&i.SnapshotLayerID, &i.LayerRange, &i.FileRange); err != nil {
+		if err := rows.Scan(
+			&i.SnapshotLayerID,
+			&i.LayerRange,
+			&i.FileRange,
+			&i.ObjectRange,
+			&i.Nonce,
+			&i.KeyID,
+			&i.Checksum,
+			&i.ChunkHash,
+			&i.SourceLayerID,
+			&i.Hole,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -126,18 +283,103 @@ func (q *Queries) GetOverlappingChunksWithVersion(ctx context.Context, arg GetOv
 
 const insertChunk = `-- name: InsertChunk :exec
 INSERT INTO 
-    chunks (snapshot_layer_id, layer_range, file_range) 
+    chunks (snapshot_layer_id, layer_range, file_range, object_range, nonce, key_id, checksum) 
 VALUES 
-    ($1, $2, $3)
+    ($1, $2, $3, $4, $5, $6, $7)
 `
 
 type InsertChunkParams struct {
-	SnapshotLayerID uint64      `json:"snapshotLayerId"`
-	LayerRange      types.Range `json:"layerRange"`
-	FileRange       types.Range `json:"fileRange"`
+	SnapshotLayerID uint64         `json:"snapshotLayerId"`
+	LayerRange      types.Range    `json:"layerRange"`
+	FileRange       types.Range    `json:"fileRange"`
+	ObjectRange     types.Range    `json:"objectRange"`
+	Nonce           []byte         `json:"nonce"`
+	KeyID           sql.NullString `json:"keyId"`
+	Checksum        sql.NullInt64  `json:"checksum"`
 }
 
 func (q *Queries) InsertChunk(ctx context.Context, arg InsertChunkParams) error {
-	_, err := q.exec(ctx, q.insertChunkStmt, insertChunk, arg.SnapshotLayerID, arg.LayerRange, arg.FileRange)
+	_, err := q.exec(ctx, q.insertChunkStmt, insertChunk,
+		arg.SnapshotLayerID,
+		arg.LayerRange,
+		arg.FileRange,
+		arg.ObjectRange,
+		arg.Nonce,
+		arg.KeyID,
+		arg.Checksum,
+	)
+	return err
+}
+
+const insertChunks = `-- name: InsertChunks :exec
+INSERT INTO 
+    chunks (snapshot_layer_id, layer_range, file_range, object_range, nonce, key_id, checksum, chunk_hash, source_layer_id, hole) 
+SELECT 
+    $1::BIGINT,
+    int8range(c.layer_start, c.layer_end),
+    int8range(c.file_start, c.file_end),
+    int8range(c.object_start, c.object_end),
+    NULLIF(c.nonce, ''::BYTEA),
+    NULLIF(c.key_id, ''),
+    CASE WHEN c.has_checksum THEN c.checksum END,
+    NULLIF(c.chunk_hash, ''::BYTEA),
+    NULLIF(c.source_layer_id, 0),
+    c.hole
+FROM 
+    ROWS FROM (
+        unnest($2::BIGINT[]),
+        unnest($3::BIGINT[]),
+        unnest($4::BIGINT[]),
+        unnest($5::BIGINT[]),
+        unnest($6::BIGINT[]),
+        unnest($7::BIGINT[]),
+        unnest($8::BYTEA[]),
+        unnest($9::TEXT[]),
+        unnest($10::BIGINT[]),
+        unnest($11::BOOLEAN[]),
+        unnest($12::BYTEA[]),
+        unnest($13::BIGINT[]),
+        unnest($14::BOOLEAN[])
+    ) WITH ORDINALITY AS c(layer_start, layer_end, file_start, file_end, object_start, object_end, nonce, key_id, checksum, has_checksum, chunk_hash, source_layer_id, hole, ord)
+ORDER BY 
+    c.ord
+`
+
+type InsertChunksParams struct {
+	SnapshotLayerID int64    `json:"snapshotLayerID"`
+	LayerStarts     []int64  `json:"layerStarts"`
+	LayerEnds       []int64  `json:"layerEnds"`
+	FileStarts      []int64  `json:"fileStarts"`
+	FileEnds        []int64  `json:"fileEnds"`
+	ObjectStarts    []int64  `json:"objectStarts"`
+	ObjectEnds      []int64  `json:"objectEnds"`
+	Nonces          [][]byte `json:"nonces"`
+	KeyIDs          []string `json:"keyIDs"`
+	Checksums       []int64  `json:"checksums"`
+	HasChecksums    []bool   `json:"hasChecksums"`
+	ChunkHashes     [][]byte `json:"chunkHashes"`
+	SourceLayerIDs  []int64  `json:"sourceLayerIDs"`
+	Holes           []bool   `json:"holes"`
+}
+
+// Inserts all the chunks of a layer in a single round-trip. Chunks are inserted (and so
+// get their ids) in array order, which reads rely on to apply them in write order.
+func (q *Queries) InsertChunks(ctx context.Context, arg InsertChunksParams) error {
+	_, err := q.exec(ctx, q.insertChunksStmt, insertChunks,
+		arg.SnapshotLayerID,
+		pq.Array(arg.LayerStarts),
+		pq.Array(arg.LayerEnds),
+		pq.Array(arg.FileStarts),
+		pq.Array(arg.FileEnds),
+		pq.Array(arg.ObjectStarts),
+		pq.Array(arg.ObjectEnds),
+		pq.Array(arg.Nonces),
+		pq.Array(arg.KeyIDs),
+		pq.Array(arg.Checksums),
+		pq.Array(arg.HasChecksums),
+		pq.Array(arg.ChunkHashes),
+		pq.Array(arg.SourceLayerIDs),
+		pq.Array(arg.Holes),
+	)
 	return err
 }
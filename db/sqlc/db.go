@@ -24,75 +24,237 @@ func New(db DBTX) *Queries {
 func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	q := Queries{db: db}
 	var err error
+	if q.acquireFileEpochStmt, err = db.PrepareContext(ctx, acquireFileEpoch); err != nil {
+		return nil, fmt.Errorf("error preparing query AcquireFileEpoch: %w", err)
+	}
 	if q.calcFileSizeStmt, err = db.PrepareContext(ctx, calcFileSize); err != nil {
 		return nil, fmt.Errorf("error preparing query CalcFileSize: %w", err)
 	}
+	if q.calcFileSizeAtLayerStmt, err = db.PrepareContext(ctx, calcFileSizeAtLayer); err != nil {
+		return nil, fmt.Errorf("error preparing query CalcFileSizeAtLayer: %w", err)
+	}
+	if q.createBranchStmt, err = db.PrepareContext(ctx, createBranch); err != nil {
+		return nil, fmt.Errorf("error preparing query CreateBranch: %w", err)
+	}
+	if q.deleteFileStmt, err = db.PrepareContext(ctx, deleteFile); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteFile: %w", err)
+	}
+	if q.deleteFileChunksStmt, err = db.PrepareContext(ctx, deleteFileChunks); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteFileChunks: %w", err)
+	}
+	if q.deleteFileHeadsStmt, err = db.PrepareContext(ctx, deleteFileHeads); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteFileHeads: %w", err)
+	}
+	if q.deleteFileLayersStmt, err = db.PrepareContext(ctx, deleteFileLayers); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteFileLayers: %w", err)
+	}
+	if q.deleteFileXattrStmt, err = db.PrepareContext(ctx, deleteFileXattr); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteFileXattr: %w", err)
+	}
 	if q.deleteHeadStmt, err = db.PrepareContext(ctx, deleteHead); err != nil {
 		return nil, fmt.Errorf("error preparing query DeleteHead: %w", err)
 	}
+	if q.findChunksByHashStmt, err = db.PrepareContext(ctx, findChunksByHash); err != nil {
+		return nil, fmt.Errorf("error preparing query FindChunksByHash: %w", err)
+	}
 	if q.getAllFilesStmt, err = db.PrepareContext(ctx, getAllFiles); err != nil {
 		return nil, fmt.Errorf("error preparing query GetAllFiles: %w", err)
 	}
 	if q.getAllHeadsStmt, err = db.PrepareContext(ctx, getAllHeads); err != nil {
 		return nil, fmt.Errorf("error preparing query GetAllHeads: %w", err)
 	}
+	if q.getAllObjectKeysStmt, err = db.PrepareContext(ctx, getAllObjectKeys); err != nil {
+		return nil, fmt.Errorf("error preparing query GetAllObjectKeys: %w", err)
+	}
+	if q.getAllVersionsPageStmt, err = db.PrepareContext(ctx, getAllVersionsPage); err != nil {
+		return nil, fmt.Errorf("error preparing query GetAllVersionsPage: %w", err)
+	}
+	if q.getBranchVersionStmt, err = db.PrepareContext(ctx, getBranchVersion); err != nil {
+		return nil, fmt.Errorf("error preparing query GetBranchVersion: %w", err)
+	}
+	if q.getCurrentBranchStmt, err = db.PrepareContext(ctx, getCurrentBranch); err != nil {
+		return nil, fmt.Errorf("error preparing query GetCurrentBranch: %w", err)
+	}
+	if q.getFileAttrStmt, err = db.PrepareContext(ctx, getFileAttr); err != nil {
+		return nil, fmt.Errorf("error preparing query GetFileAttr: %w", err)
+	}
+	if q.getFileCurrentSizeStmt, err = db.PrepareContext(ctx, getFileCurrentSize); err != nil {
+		return nil, fmt.Errorf("error preparing query GetFileCurrentSize: %w", err)
+	}
+	if q.getFileEpochStmt, err = db.PrepareContext(ctx, getFileEpoch); err != nil {
+		return nil, fmt.Errorf("error preparing query GetFileEpoch: %w", err)
+	}
 	if q.getFileIDByNameStmt, err = db.PrepareContext(ctx, getFileIDByName); err != nil {
 		return nil, fmt.Errorf("error preparing query GetFileIDByName: %w", err)
 	}
+	if q.getFileStatsStmt, err = db.PrepareContext(ctx, getFileStats); err != nil {
+		return nil, fmt.Errorf("error preparing query GetFileStats: %w", err)
+	}
 	if q.getFileVersionsStmt, err = db.PrepareContext(ctx, getFileVersions); err != nil {
 		return nil, fmt.Errorf("error preparing query GetFileVersions: %w", err)
 	}
+	if q.getFileXattrStmt, err = db.PrepareContext(ctx, getFileXattr); err != nil {
+		return nil, fmt.Errorf("error preparing query GetFileXattr: %w", err)
+	}
 	if q.getHeadVersionStmt, err = db.PrepareContext(ctx, getHeadVersion); err != nil {
 		return nil, fmt.Errorf("error preparing query GetHeadVersion: %w", err)
 	}
+	if q.getLatestVersionStmt, err = db.PrepareContext(ctx, getLatestVersion); err != nil {
+		return nil, fmt.Errorf("error preparing query GetLatestVersion: %w", err)
+	}
 	if q.getLayerByVersionStmt, err = db.PrepareContext(ctx, getLayerByVersion); err != nil {
 		return nil, fmt.Errorf("error preparing query GetLayerByVersion: %w", err)
 	}
 	if q.getLayerChunksStmt, err = db.PrepareContext(ctx, getLayerChunks); err != nil {
 		return nil, fmt.Errorf("error preparing query GetLayerChunks: %w", err)
 	}
+	if q.getLayerObjectStmt, err = db.PrepareContext(ctx, getLayerObject); err != nil {
+		return nil, fmt.Errorf("error preparing query GetLayerObject: %w", err)
+	}
+	if q.getLayerObjectsPageStmt, err = db.PrepareContext(ctx, getLayerObjectsPage); err != nil {
+		return nil, fmt.Errorf("error preparing query GetLayerObjectsPage: %w", err)
+	}
 	if q.getLayersByFileIDStmt, err = db.PrepareContext(ctx, getLayersByFileID); err != nil {
 		return nil, fmt.Errorf("error preparing query GetLayersByFileID: %w", err)
 	}
-	if q.getObjectKeyStmt, err = db.PrepareContext(ctx, getObjectKey); err != nil {
-		return nil, fmt.Errorf("error preparing query GetObjectKey: %w", err)
+	if q.getMaxNumericVersionTagStmt, err = db.PrepareContext(ctx, getMaxNumericVersionTag); err != nil {
+		return nil, fmt.Errorf("error preparing query GetMaxNumericVersionTag: %w", err)
 	}
 	if q.getOverlappingChunksWithVersionStmt, err = db.PrepareContext(ctx, getOverlappingChunksWithVersion); err != nil {
 		return nil, fmt.Errorf("error preparing query GetOverlappingChunksWithVersion: %w", err)
 	}
+	if q.getVersionAsOfStmt, err = db.PrepareContext(ctx, getVersionAsOf); err != nil {
+		return nil, fmt.Errorf("error preparing query GetVersionAsOf: %w", err)
+	}
 	if q.getVersionIDByTagStmt, err = db.PrepareContext(ctx, getVersionIDByTag); err != nil {
 		return nil, fmt.Errorf("error preparing query GetVersionIDByTag: %w", err)
 	}
+	if q.getWriteOriginsStmt, err = db.PrepareContext(ctx, getWriteOrigins); err != nil {
+		return nil, fmt.Errorf("error preparing query GetWriteOrigins: %w", err)
+	}
+	if q.growFileCurrentSizeStmt, err = db.PrepareContext(ctx, growFileCurrentSize); err != nil {
+		return nil, fmt.Errorf("error preparing query GrowFileCurrentSize: %w", err)
+	}
 	if q.insertChunkStmt, err = db.PrepareContext(ctx, insertChunk); err != nil {
 		return nil, fmt.Errorf("error preparing query InsertChunk: %w", err)
 	}
+	if q.insertChunksStmt, err = db.PrepareContext(ctx, insertChunks); err != nil {
+		return nil, fmt.Errorf("error preparing query InsertChunks: %w", err)
+	}
 	if q.insertFileStmt, err = db.PrepareContext(ctx, insertFile); err != nil {
 		return nil, fmt.Errorf("error preparing query InsertFile: %w", err)
 	}
+	if q.insertFileXattrStmt, err = db.PrepareContext(ctx, insertFileXattr); err != nil {
+		return nil, fmt.Errorf("error preparing query InsertFileXattr: %w", err)
+	}
 	if q.insertLayerStmt, err = db.PrepareContext(ctx, insertLayer); err != nil {
 		return nil, fmt.Errorf("error preparing query InsertLayer: %w", err)
 	}
 	if q.insertVersionStmt, err = db.PrepareContext(ctx, insertVersion); err != nil {
 		return nil, fmt.Errorf("error preparing query InsertVersion: %w", err)
 	}
+	if q.insertWriteOriginsStmt, err = db.PrepareContext(ctx, insertWriteOrigins); err != nil {
+		return nil, fmt.Errorf("error preparing query InsertWriteOrigins: %w", err)
+	}
+	if q.listFileXattrsStmt, err = db.PrepareContext(ctx, listFileXattrs); err != nil {
+		return nil, fmt.Errorf("error preparing query ListFileXattrs: %w", err)
+	}
+	if q.lockObjectsExclusiveStmt, err = db.PrepareContext(ctx, lockObjectsExclusive); err != nil {
+		return nil, fmt.Errorf("error preparing query LockObjectsExclusive: %w", err)
+	}
+	if q.lockObjectsSharedStmt, err = db.PrepareContext(ctx, lockObjectsShared); err != nil {
+		return nil, fmt.Errorf("error preparing query LockObjectsShared: %w", err)
+	}
+	if q.renameFileStmt, err = db.PrepareContext(ctx, renameFile); err != nil {
+		return nil, fmt.Errorf("error preparing query RenameFile: %w", err)
+	}
+	if q.replaceFileXattrStmt, err = db.PrepareContext(ctx, replaceFileXattr); err != nil {
+		return nil, fmt.Errorf("error preparing query ReplaceFileXattr: %w", err)
+	}
+	if q.setCurrentBranchStmt, err = db.PrepareContext(ctx, setCurrentBranch); err != nil {
+		return nil, fmt.Errorf("error preparing query SetCurrentBranch: %w", err)
+	}
+	if q.setFileModeStmt, err = db.PrepareContext(ctx, setFileMode); err != nil {
+		return nil, fmt.Errorf("error preparing query SetFileMode: %w", err)
+	}
+	if q.setFileModifiedAtStmt, err = db.PrepareContext(ctx, setFileModifiedAt); err != nil {
+		return nil, fmt.Errorf("error preparing query SetFileModifiedAt: %w", err)
+	}
+	if q.setFileXattrStmt, err = db.PrepareContext(ctx, setFileXattr); err != nil {
+		return nil, fmt.Errorf("error preparing query SetFileXattr: %w", err)
+	}
 	if q.setHeadStmt, err = db.PrepareContext(ctx, setHead); err != nil {
 		return nil, fmt.Errorf("error preparing query SetHead: %w", err)
 	}
+	if q.setLayerArchivedStmt, err = db.PrepareContext(ctx, setLayerArchived); err != nil {
+		return nil, fmt.Errorf("error preparing query SetLayerArchived: %w", err)
+	}
+	if q.setLayerCompactedStmt, err = db.PrepareContext(ctx, setLayerCompacted); err != nil {
+		return nil, fmt.Errorf("error preparing query SetLayerCompacted: %w", err)
+	}
+	if q.versionTagExistsStmt, err = db.PrepareContext(ctx, versionTagExists); err != nil {
+		return nil, fmt.Errorf("error preparing query VersionTagExists: %w", err)
+	}
 	return &q, nil
 }
 
 func (q *Queries) Close() error {
 	var err error
+	if q.acquireFileEpochStmt != nil {
+		if cerr := q.acquireFileEpochStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing acquireFileEpochStmt: %w", cerr)
+		}
+	}
 	if q.calcFileSizeStmt != nil {
 		if cerr := q.calcFileSizeStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing calcFileSizeStmt: %w", cerr)
 		}
 	}
+	if q.calcFileSizeAtLayerStmt != nil {
+		if cerr := q.calcFileSizeAtLayerStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing calcFileSizeAtLayerStmt: %w", cerr)
+		}
+	}
+	if q.createBranchStmt != nil {
+		if cerr := q.createBranchStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing createBranchStmt: %w", cerr)
+		}
+	}
+	if q.deleteFileStmt != nil {
+		if cerr := q.deleteFileStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteFileStmt: %w", cerr)
+		}
+	}
+	if q.deleteFileChunksStmt != nil {
+		if cerr := q.deleteFileChunksStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteFileChunksStmt: %w", cerr)
+		}
+	}
+	if q.deleteFileHeadsStmt != nil {
+		if cerr := q.deleteFileHeadsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteFileHeadsStmt: %w", cerr)
+		}
+	}
+	if q.deleteFileLayersStmt != nil {
+		if cerr := q.deleteFileLayersStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteFileLayersStmt: %w", cerr)
+		}
+	}
+	if q.deleteFileXattrStmt != nil {
+		if cerr := q.deleteFileXattrStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteFileXattrStmt: %w", cerr)
+		}
+	}
 	if q.deleteHeadStmt != nil {
 		if cerr := q.deleteHeadStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing deleteHeadStmt: %w", cerr)
 		}
 	}
+	if q.findChunksByHashStmt != nil {
+		if cerr := q.findChunksByHashStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing findChunksByHashStmt: %w", cerr)
+		}
+	}
 	if q.getAllFilesStmt != nil {
 		if cerr := q.getAllFilesStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getAllFilesStmt: %w", cerr)
@@ -103,21 +265,71 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing getAllHeadsStmt: %w", cerr)
 		}
 	}
+	if q.getAllObjectKeysStmt != nil {
+		if cerr := q.getAllObjectKeysStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getAllObjectKeysStmt: %w", cerr)
+		}
+	}
+	if q.getAllVersionsPageStmt != nil {
+		if cerr := q.getAllVersionsPageStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getAllVersionsPageStmt: %w", cerr)
+		}
+	}
+	if q.getBranchVersionStmt != nil {
+		if cerr := q.getBranchVersionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getBranchVersionStmt: %w", cerr)
+		}
+	}
+	if q.getCurrentBranchStmt != nil {
+		if cerr := q.getCurrentBranchStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getCurrentBranchStmt: %w", cerr)
+		}
+	}
+	if q.getFileAttrStmt != nil {
+		if cerr := q.getFileAttrStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getFileAttrStmt: %w", cerr)
+		}
+	}
+	if q.getFileCurrentSizeStmt != nil {
+		if cerr := q.getFileCurrentSizeStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getFileCurrentSizeStmt: %w", cerr)
+		}
+	}
+	if q.getFileEpochStmt != nil {
+		if cerr := q.getFileEpochStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getFileEpochStmt: %w", cerr)
+		}
+	}
 	if q.getFileIDByNameStmt != nil {
 		if cerr := q.getFileIDByNameStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getFileIDByNameStmt: %w", cerr)
 		}
 	}
+	if q.getFileStatsStmt != nil {
+		if cerr := q.getFileStatsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getFileStatsStmt: %w", cerr)
+		}
+	}
 	if q.getFileVersionsStmt != nil {
 		if cerr := q.getFileVersionsStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getFileVersionsStmt: %w", cerr)
 		}
 	}
+	if q.getFileXattrStmt != nil {
+		if cerr := q.getFileXattrStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getFileXattrStmt: %w", cerr)
+		}
+	}
 	if q.getHeadVersionStmt != nil {
 		if cerr := q.getHeadVersionStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getHeadVersionStmt: %w", cerr)
 		}
 	}
+	if q.getLatestVersionStmt != nil {
+		if cerr := q.getLatestVersionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getLatestVersionStmt: %w", cerr)
+		}
+	}
 	if q.getLayerByVersionStmt != nil {
 		if cerr := q.getLayerByVersionStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getLayerByVersionStmt: %w", cerr)
@@ -128,14 +340,24 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing getLayerChunksStmt: %w", cerr)
 		}
 	}
+	if q.getLayerObjectStmt != nil {
+		if cerr := q.getLayerObjectStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getLayerObjectStmt: %w", cerr)
+		}
+	}
+	if q.getLayerObjectsPageStmt != nil {
+		if cerr := q.getLayerObjectsPageStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getLayerObjectsPageStmt: %w", cerr)
+		}
+	}
 	if q.getLayersByFileIDStmt != nil {
 		if cerr := q.getLayersByFileIDStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getLayersByFileIDStmt: %w", cerr)
 		}
 	}
-	if q.getObjectKeyStmt != nil {
-		if cerr := q.getObjectKeyStmt.Close(); cerr != nil {
-			err = fmt.Errorf("error closing getObjectKeyStmt: %w", cerr)
+	if q.getMaxNumericVersionTagStmt != nil {
+		if cerr := q.getMaxNumericVersionTagStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getMaxNumericVersionTagStmt: %w", cerr)
 		}
 	}
 	if q.getOverlappingChunksWithVersionStmt != nil {
@@ -143,21 +365,46 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing getOverlappingChunksWithVersionStmt: %w", cerr)
 		}
 	}
+	if q.getVersionAsOfStmt != nil {
+		if cerr := q.getVersionAsOfStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getVersionAsOfStmt: %w", cerr)
+		}
+	}
 	if q.getVersionIDByTagStmt != nil {
 		if cerr := q.getVersionIDByTagStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getVersionIDByTagStmt: %w", cerr)
 		}
 	}
+	if q.getWriteOriginsStmt != nil {
+		if cerr := q.getWriteOriginsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getWriteOriginsStmt: %w", cerr)
+		}
+	}
+	if q.growFileCurrentSizeStmt != nil {
+		if cerr := q.growFileCurrentSizeStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing growFileCurrentSizeStmt: %w", cerr)
+		}
+	}
 	if q.insertChunkStmt != nil {
 		if cerr := q.insertChunkStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing insertChunkStmt: %w", cerr)
 		}
 	}
+	if q.insertChunksStmt != nil {
+		if cerr := q.insertChunksStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing insertChunksStmt: %w", cerr)
+		}
+	}
 	if q.insertFileStmt != nil {
 		if cerr := q.insertFileStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing insertFileStmt: %w", cerr)
 		}
 	}
+	if q.insertFileXattrStmt != nil {
+		if cerr := q.insertFileXattrStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing insertFileXattrStmt: %w", cerr)
+		}
+	}
 	if q.insertLayerStmt != nil {
 		if cerr := q.insertLayerStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing insertLayerStmt: %w", cerr)
@@ -168,11 +415,76 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing insertVersionStmt: %w", cerr)
 		}
 	}
+	if q.insertWriteOriginsStmt != nil {
+		if cerr := q.insertWriteOriginsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing insertWriteOriginsStmt: %w", cerr)
+		}
+	}
+	if q.listFileXattrsStmt != nil {
+		if cerr := q.listFileXattrsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listFileXattrsStmt: %w", cerr)
+		}
+	}
+	if q.lockObjectsExclusiveStmt != nil {
+		if cerr := q.lockObjectsExclusiveStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing lockObjectsExclusiveStmt: %w", cerr)
+		}
+	}
+	if q.lockObjectsSharedStmt != nil {
+		if cerr := q.lockObjectsSharedStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing lockObjectsSharedStmt: %w", cerr)
+		}
+	}
+	if q.renameFileStmt != nil {
+		if cerr := q.renameFileStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing renameFileStmt: %w", cerr)
+		}
+	}
+	if q.replaceFileXattrStmt != nil {
+		if cerr := q.replaceFileXattrStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing replaceFileXattrStmt: %w", cerr)
+		}
+	}
+	if q.setCurrentBranchStmt != nil {
+		if cerr := q.setCurrentBranchStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing setCurrentBranchStmt: %w", cerr)
+		}
+	}
+	if q.setFileModeStmt != nil {
+		if cerr := q.setFileModeStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing setFileModeStmt: %w", cerr)
+		}
+	}
+	if q.setFileModifiedAtStmt != nil {
+		if cerr := q.setFileModifiedAtStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing setFileModifiedAtStmt: %w", cerr)
+		}
+	}
+	if q.setFileXattrStmt != nil {
+		if cerr := q.setFileXattrStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing setFileXattrStmt: %w", cerr)
+		}
+	}
 	if q.setHeadStmt != nil {
 		if cerr := q.setHeadStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing setHeadStmt: %w", cerr)
 		}
 	}
+	if q.setLayerArchivedStmt != nil {
+		if cerr := q.setLayerArchivedStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing setLayerArchivedStmt: %w", cerr)
+		}
+	}
+	if q.setLayerCompactedStmt != nil {
+		if cerr := q.setLayerCompactedStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing setLayerCompactedStmt: %w", cerr)
+		}
+	}
+	if q.versionTagExistsStmt != nil {
+		if cerr := q.versionTagExistsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing versionTagExistsStmt: %w", cerr)
+		}
+	}
 	return err
 }
 
@@ -212,47 +524,125 @@ func (q *Queries) queryRow(ctx context.Context, stmt *sql.Stmt, query string, ar
 type Queries struct {
 	db                                  DBTX
 	tx                                  *sql.Tx
+	acquireFileEpochStmt                *sql.Stmt
 	calcFileSizeStmt                    *sql.Stmt
+	calcFileSizeAtLayerStmt             *sql.Stmt
+	createBranchStmt                    *sql.Stmt
+	deleteFileStmt                      *sql.Stmt
+	deleteFileChunksStmt                *sql.Stmt
+	deleteFileHeadsStmt                 *sql.Stmt
+	deleteFileLayersStmt                *sql.Stmt
+	deleteFileXattrStmt                 *sql.Stmt
 	deleteHeadStmt                      *sql.Stmt
+	findChunksByHashStmt                *sql.Stmt
 	getAllFilesStmt                     *sql.Stmt
 	getAllHeadsStmt                     *sql.Stmt
+	getAllObjectKeysStmt                *sql.Stmt
+	getAllVersionsPageStmt              *sql.Stmt
+	getBranchVersionStmt                *sql.Stmt
+	getCurrentBranchStmt                *sql.Stmt
+	getFileAttrStmt                     *sql.Stmt
+	getFileCurrentSizeStmt              *sql.Stmt
+	getFileEpochStmt                    *sql.Stmt
 	getFileIDByNameStmt                 *sql.Stmt
+	getFileStatsStmt                    *sql.Stmt
 	getFileVersionsStmt                 *sql.Stmt
+	getFileXattrStmt                    *sql.Stmt
 	getHeadVersionStmt                  *sql.Stmt
+	getLatestVersionStmt                *sql.Stmt
 	getLayerByVersionStmt               *sql.Stmt
 	getLayerChunksStmt                  *sql.Stmt
+	getLayerObjectStmt                  *sql.Stmt
+	getLayerObjectsPageStmt             *sql.Stmt
 	getLayersByFileIDStmt               *sql.Stmt
-	getObjectKeyStmt                    *sql.Stmt
+	getMaxNumericVersionTagStmt         *sql.Stmt
 	getOverlappingChunksWithVersionStmt *sql.Stmt
+	getVersionAsOfStmt                  *sql.Stmt
 	getVersionIDByTagStmt               *sql.Stmt
+	getWriteOriginsStmt                 *sql.Stmt
+	growFileCurrentSizeStmt             *sql.Stmt
 	insertChunkStmt                     *sql.Stmt
+	insertChunksStmt                    *sql.Stmt
 	insertFileStmt                      *sql.Stmt
+	insertFileXattrStmt                 *sql.Stmt
 	insertLayerStmt                     *sql.Stmt
 	insertVersionStmt                   *sql.Stmt
+	insertWriteOriginsStmt              *sql.Stmt
+	listFileXattrsStmt                  *sql.Stmt
+	lockObjectsExclusiveStmt            *sql.Stmt
+	lockObjectsSharedStmt               *sql.Stmt
+	renameFileStmt                      *sql.Stmt
+	replaceFileXattrStmt                *sql.Stmt
+	setCurrentBranchStmt                *sql.Stmt
+	setFileModeStmt                     *sql.Stmt
+	setFileModifiedAtStmt               *sql.Stmt
+	setFileXattrStmt                    *sql.Stmt
 	setHeadStmt                         *sql.Stmt
+	setLayerArchivedStmt                *sql.Stmt
+	setLayerCompactedStmt               *sql.Stmt
+	versionTagExistsStmt                *sql.Stmt
 }
 
 func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 	return &Queries{
 		db:                                  tx,
 		tx:                                  tx,
+		acquireFileEpochStmt:                q.acquireFileEpochStmt,
 		calcFileSizeStmt:                    q.calcFileSizeStmt,
+		calcFileSizeAtLayerStmt:             q.calcFileSizeAtLayerStmt,
+		createBranchStmt:                    q.createBranchStmt,
+		deleteFileStmt:                      q.deleteFileStmt,
+		deleteFileChunksStmt:                q.deleteFileChunksStmt,
+		deleteFileHeadsStmt:                 q.deleteFileHeadsStmt,
+		deleteFileLayersStmt:                q.deleteFileLayersStmt,
+		deleteFileXattrStmt:                 q.deleteFileXattrStmt,
 		deleteHeadStmt:                      q.deleteHeadStmt,
+		findChunksByHashStmt:                q.findChunksByHashStmt,
 		getAllFilesStmt:                     q.getAllFilesStmt,
 		getAllHeadsStmt:                     q.getAllHeadsStmt,
+		getAllObjectKeysStmt:                q.getAllObjectKeysStmt,
+		getAllVersionsPageStmt:              q.getAllVersionsPageStmt,
+		getBranchVersionStmt:                q.getBranchVersionStmt,
+		getCurrentBranchStmt:                q.getCurrentBranchStmt,
+		getFileAttrStmt:                     q.getFileAttrStmt,
+		getFileCurrentSizeStmt:              q.getFileCurrentSizeStmt,
+		getFileEpochStmt:                    q.getFileEpochStmt,
 		getFileIDByNameStmt:                 q.getFileIDByNameStmt,
+		getFileStatsStmt:                    q.getFileStatsStmt,
 		getFileVersionsStmt:                 q.getFileVersionsStmt,
+		getFileXattrStmt:                    q.getFileXattrStmt,
 		getHeadVersionStmt:                  q.getHeadVersionStmt,
+		getLatestVersionStmt:                q.getLatestVersionStmt,
 		getLayerByVersionStmt:               q.getLayerByVersionStmt,
 		getLayerChunksStmt:                  q.getLayerChunksStmt,
+		getLayerObjectStmt:                  q.getLayerObjectStmt,
+		getLayerObjectsPageStmt:             q.getLayerObjectsPageStmt,
 		getLayersByFileIDStmt:               q.getLayersByFileIDStmt,
-		getObjectKeyStmt:                    q.getObjectKeyStmt,
+		getMaxNumericVersionTagStmt:         q.getMaxNumericVersionTagStmt,
 		getOverlappingChunksWithVersionStmt: q.getOverlappingChunksWithVersionStmt,
+		getVersionAsOfStmt:                  q.getVersionAsOfStmt,
 		getVersionIDByTagStmt:               q.getVersionIDByTagStmt,
+		getWriteOriginsStmt:                 q.getWriteOriginsStmt,
+		growFileCurrentSizeStmt:             q.growFileCurrentSizeStmt,
 		insertChunkStmt:                     q.insertChunkStmt,
+		insertChunksStmt:                    q.insertChunksStmt,
 		insertFileStmt:                      q.insertFileStmt,
+		insertFileXattrStmt:                 q.insertFileXattrStmt,
 		insertLayerStmt:                     q.insertLayerStmt,
 		insertVersionStmt:                   q.insertVersionStmt,
+		insertWriteOriginsStmt:              q.insertWriteOriginsStmt,
+		listFileXattrsStmt:                  q.listFileXattrsStmt,
+		lockObjectsExclusiveStmt:            q.lockObjectsExclusiveStmt,
+		lockObjectsSharedStmt:               q.lockObjectsSharedStmt,
+		renameFileStmt:                      q.renameFileStmt,
+		replaceFileXattrStmt:                q.replaceFileXattrStmt,
+		setCurrentBranchStmt:                q.setCurrentBranchStmt,
+		setFileModeStmt:                     q.setFileModeStmt,
+		setFileModifiedAtStmt:               q.setFileModifiedAtStmt,
+		setFileXattrStmt:                    q.setFileXattrStmt,
 		setHeadStmt:                         q.setHeadStmt,
+		setLayerArchivedStmt:                q.setLayerArchivedStmt,
+		setLayerCompactedStmt:               q.setLayerCompactedStmt,
+		versionTagExistsStmt:                q.versionTagExistsStmt,
 	}
 }
@@ -7,10 +7,48 @@ package sqlc
 
 import (
 	"context"
+	"time"
 )
 
+const acquireFileEpoch = `-- name: AcquireFileEpoch :one
+UPDATE files SET epoch = epoch + 1 WHERE id = $1 RETURNING epoch
+`
+
+func (q *Queries) AcquireFileEpoch(ctx context.Context, id uint64) (int64, error) {
+	row := q.queryRow(ctx, q.acquireFileEpochStmt, acquireFileEpoch, id)
+	var epoch int64
+	err := row.Scan(&epoch)
+	return epoch, err
+}
+
+const deleteFile = `-- name: DeleteFile :exec
+DELETE FROM files WHERE id = $1
+`
+
+func (q *Queries) DeleteFile(ctx context.Context, id uint64) error {
+	_, err := q.exec(ctx, q.deleteFileStmt, deleteFile, id)
+	return err
+}
+
+const deleteFileXattr = `-- name: DeleteFileXattr :execrows
+DELETE FROM file_xattrs WHERE file_id = $1 AND name = $2
+`
+
+type DeleteFileXattrParams struct {
+	FileID uint64 `json:"fileId"`
+	Name   string `json:"name"`
+}
+
+func (q *Queries) DeleteFileXattr(ctx context.Context, arg DeleteFileXattrParams) (int64, error) {
+	result, err := q.exec(ctx, q.deleteFileXattrStmt, deleteFileXattr, arg.FileID, arg.Name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getAllFiles = `-- name: GetAllFiles :many
-SELECT id, name FROM files
+SELECT id, name, epoch, current_branch, mode, created_at, modified_at, current_size FROM files
 `
 
 func (q *Queries) GetAllFiles(ctx context.Context) ([]File, error) {
@@ -22,7 +60,16 @@ func (q *Queries) GetAllFiles(ctx context.Context) ([]File, error) {
 	items := []File{}
 	for rows.Next() {
 		var i File
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Epoch,
+			&i.CurrentBranch,
+			&i.Mode,
+			&i.CreatedAt,
+			&i.ModifiedAt,
+			&i.CurrentSize,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -36,6 +83,46 @@ func (q *Queries) GetAllFiles(ctx context.Context) ([]File, error) {
 	return items, nil
 }
 
+const getFileAttr = `-- name: GetFileAttr :one
+SELECT mode, created_at, modified_at FROM files WHERE id = $1
+`
+
+type GetFileAttrRow struct {
+	Mode       int32     `json:"mode"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+func (q *Queries) GetFileAttr(ctx context.Context, id uint64) (GetFileAttrRow, error) {
+	row := q.queryRow(ctx, q.getFileAttrStmt, getFileAttr, id)
+	var i GetFileAttrRow
+	err := row.Scan(&i.Mode, &i.CreatedAt, &i.ModifiedAt)
+	return i, err
+}
+
+const getFileCurrentSize = `-- name: GetFileCurrentSize :one
+SELECT current_size FROM files WHERE id = $1
+`
+
+func (q *Queries) GetFileCurrentSize(ctx context.Context, id uint64) (int64, error) {
+	row := q.queryRow(ctx, q.getFileCurrentSizeStmt, getFileCurrentSize, id)
+	var current_size int64
+	err := row.Scan(&current_size)
+	return current_size, err
+}
+
+const getFileEpoch = `-- name: GetFileEpoch :one
+SELECT epoch FROM files WHERE id = $1 FOR SHARE
+`
+
+// FOR SHARE blocks other nodes from acquiring the file until the transaction ends
+func (q *Queries) GetFileEpoch(ctx context.Context, id uint64) (int64, error) {
+	row := q.queryRow(ctx, q.getFileEpochStmt, getFileEpoch, id)
+	var epoch int64
+	err := row.Scan(&epoch)
+	return epoch, err
+}
+
 const getFileIDByName = `-- name: GetFileIDByName :one
 SELECT id FROM files WHERE name = $1
 `
@@ -47,6 +134,37 @@ func (q *Queries) GetFileIDByName(ctx context.Context, name string) (uint64, err
 	return id, err
 }
 
+const getFileXattr = `-- name: GetFileXattr :one
+SELECT value FROM file_xattrs WHERE file_id = $1 AND name = $2
+`
+
+type GetFileXattrParams struct {
+	FileID uint64 `json:"fileId"`
+	Name   string `json:"name"`
+}
+
+func (q *Queries) GetFileXattr(ctx context.Context, arg GetFileXattrParams) ([]byte, error) {
+	row := q.queryRow(ctx, q.getFileXattrStmt, getFileXattr, arg.FileID, arg.Name)
+	var value []byte
+	err := row.Scan(&value)
+	return value, err
+}
+
+const growFileCurrentSize = `-- name: GrowFileCurrentSize :exec
+UPDATE files SET current_size = GREATEST(current_size, $1) WHERE id = $2
+`
+
+type GrowFileCurrentSizeParams struct {
+	Size int64  `json:"size"`
+	ID   uint64 `json:"id"`
+}
+
+// Chunks are never removed from a file, so its size only grows
+func (q *Queries) GrowFileCurrentSize(ctx context.Context, arg GrowFileCurrentSizeParams) error {
+	_, err := q.exec(ctx, q.growFileCurrentSizeStmt, growFileCurrentSize, arg.Size, arg.ID)
+	return err
+}
+
 const insertFile = `-- name: InsertFile :one
 INSERT INTO files (name) VALUES ($1) RETURNING id
 `
@@ -57,3 +175,127 @@ func (q *Queries) InsertFile(ctx context.Context, name string) (uint64, error) {
 	err := row.Scan(&id)
 	return id, err
 }
+
+const insertFileXattr = `-- name: InsertFileXattr :execrows
+INSERT INTO file_xattrs (file_id, name, value) VALUES ($1, $2, $3)
+ON CONFLICT (file_id, name) DO NOTHING
+`
+
+type InsertFileXattrParams struct {
+	FileID uint64 `json:"fileId"`
+	Name   string `json:"name"`
+	Value  []byte `json:"value"`
+}
+
+// Inserts the attribute only if the file doesn't have it yet
+func (q *Queries) InsertFileXattr(ctx context.Context, arg InsertFileXattrParams) (int64, error) {
+	result, err := q.exec(ctx, q.insertFileXattrStmt, insertFileXattr, arg.FileID, arg.Name, arg.Value)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listFileXattrs = `-- name: ListFileXattrs :many
+SELECT name FROM file_xattrs WHERE file_id = $1 ORDER BY name
+`
+
+func (q *Queries) ListFileXattrs(ctx context.Context, fileID uint64) ([]string, error) {
+	rows, err := q.query(ctx, q.listFileXattrsStmt, listFileXattrs, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const renameFile = `-- name: RenameFile :exec
+UPDATE files SET name = $1 WHERE id = $2
+`
+
+type RenameFileParams struct {
+	Name string `json:"name"`
+	ID   uint64 `json:"id"`
+}
+
+func (q *Queries) RenameFile(ctx context.Context, arg RenameFileParams) error {
+	_, err := q.exec(ctx, q.renameFileStmt, renameFile, arg.Name, arg.ID)
+	return err
+}
+
+const replaceFileXattr = `-- name: ReplaceFileXattr :execrows
+UPDATE file_xattrs SET value = $3 WHERE file_id = $1 AND name = $2
+`
+
+type ReplaceFileXattrParams struct {
+	FileID uint64 `json:"fileId"`
+	Name   string `json:"name"`
+	Value  []byte `json:"value"`
+}
+
+// Replaces the value of the attribute only if the file has it already
+func (q *Queries) ReplaceFileXattr(ctx context.Context, arg ReplaceFileXattrParams) (int64, error) {
+	result, err := q.exec(ctx, q.replaceFileXattrStmt, replaceFileXattr, arg.FileID, arg.Name, arg.Value)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setFileMode = `-- name: SetFileMode :exec
+UPDATE files SET mode = $2 WHERE id = $1
+`
+
+type SetFileModeParams struct {
+	ID   uint64 `json:"id"`
+	Mode int32  `json:"mode"`
+}
+
+func (q *Queries) SetFileMode(ctx context.Context, arg SetFileModeParams) error {
+	_, err := q.exec(ctx, q.setFileModeStmt, setFileMode, arg.ID, arg.Mode)
+	return err
+}
+
+const setFileModifiedAt = `-- name: SetFileModifiedAt :exec
+UPDATE files SET modified_at = $2 WHERE id = $1
+`
+
+type SetFileModifiedAtParams struct {
+	ID         uint64    `json:"id"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+func (q *Queries) SetFileModifiedAt(ctx context.Context, arg SetFileModifiedAtParams) error {
+	_, err := q.exec(ctx, q.setFileModifiedAtStmt, setFileModifiedAt, arg.ID, arg.ModifiedAt)
+	return err
+}
+
+const setFileXattr = `-- name: SetFileXattr :exec
+INSERT INTO file_xattrs (file_id, name, value) VALUES ($1, $2, $3)
+ON CONFLICT (file_id, name) DO UPDATE SET value = EXCLUDED.value
+`
+
+type SetFileXattrParams struct {
+	FileID uint64 `json:"fileId"`
+	Name   string `json:"name"`
+	Value  []byte `json:"value"`
+}
+
+func (q *Queries) SetFileXattr(ctx context.Context, arg SetFileXattrParams) error {
+	_, err := q.exec(ctx, q.setFileXattrStmt, setFileXattr, arg.FileID, arg.Name, arg.Value)
+	return err
+}
@@ -9,18 +9,48 @@ import (
 	"context"
 )
 
+const createBranch = `-- name: CreateBranch :exec
+INSERT INTO heads (file_id, version_id, branch)
+VALUES ($1, $2, $3)
+`
+
+type CreateBranchParams struct {
+	FileID    uint64 `json:"fileId"`
+	VersionID uint64 `json:"versionId"`
+	Branch    string `json:"branch"`
+}
+
+func (q *Queries) CreateBranch(ctx context.Context, arg CreateBranchParams) error {
+	_, err := q.exec(ctx, q.createBranchStmt, createBranch, arg.FileID, arg.VersionID, arg.Branch)
+	return err
+}
+
+const deleteFileHeads = `-- name: DeleteFileHeads :exec
+DELETE FROM heads WHERE file_id = $1
+`
+
+func (q *Queries) DeleteFileHeads(ctx context.Context, fileID uint64) error {
+	_, err := q.exec(ctx, q.deleteFileHeadsStmt, deleteFileHeads, fileID)
+	return err
+}
+
 const deleteHead = `-- name: DeleteHead :exec
 DELETE FROM heads
-WHERE file_id = $1
+WHERE file_id = $1 AND branch = $2
 `
 
-func (q *Queries) DeleteHead(ctx context.Context, fileID uint64) error {
-	_, err := q.exec(ctx, q.deleteHeadStmt, deleteHead, fileID)
+type DeleteHeadParams struct {
+	FileID uint64 `json:"fileId"`
+	Branch string `json:"branch"`
+}
+
+func (q *Queries) DeleteHead(ctx context.Context, arg DeleteHeadParams) error {
+	_, err := q.exec(ctx, q.deleteHeadStmt, deleteHead, arg.FileID, arg.Branch)
 	return err
 }
 
 const getAllHeads = `-- name: GetAllHeads :many
-SELECT h.file_id, f.name as file_name, v.id as version_id, v.tag as version_tag
+SELECT h.file_id, f.name as file_name, h.branch, v.id as version_id, v.tag as version_tag
 FROM heads h
 JOIN files f ON h.file_id = f.id
 JOIN versions v ON h.version_id = v.id
@@ -29,6 +59,7 @@ JOIN versions v ON h.version_id = v.id
 type GetAllHeadsRow struct {
 	FileID     uint64 `json:"fileId"`
 	FileName   string `json:"fileName"`
+	Branch     string `json:"branch"`
 	VersionID  uint64 `json:"versionId"`
 	VersionTag string `json:"versionTag"`
 }
@@ -45,6 +76,7 @@ func (q *Queries) GetAllHeads(ctx context.Context) ([]GetAllHeadsRow, error) {
 		if err := rows.Scan(
 			&i.FileID,
 			&i.FileName,
+			&i.Branch,
 			&i.VersionID,
 			&i.VersionTag,
 		); err != nil {
@@ -61,9 +93,45 @@ func (q *Queries) GetAllHeads(ctx context.Context) ([]GetAllHeadsRow, error) {
 	return items, nil
 }
 
+const getBranchVersion = `-- name: GetBranchVersion :one
+SELECT v.id as version_id, v.tag as version_tag
+FROM heads h
+JOIN versions v ON h.version_id = v.id
+WHERE h.file_id = $1 AND h.branch = $2
+`
+
+type GetBranchVersionParams struct {
+	FileID uint64 `json:"fileId"`
+	Branch string `json:"branch"`
+}
+
+type GetBranchVersionRow struct {
+	VersionID  uint64 `json:"versionId"`
+	VersionTag string `json:"versionTag"`
+}
+
+func (q *Queries) GetBranchVersion(ctx context.Context, arg GetBranchVersionParams) (GetBranchVersionRow, error) {
+	row := q.queryRow(ctx, q.getBranchVersionStmt, getBranchVersion, arg.FileID, arg.Branch)
+	var i GetBranchVersionRow
+	err := row.Scan(&i.VersionID, &i.VersionTag)
+	return i, err
+}
+
+const getCurrentBranch = `-- name: GetCurrentBranch :one
+SELECT current_branch FROM files WHERE id = $1
+`
+
+func (q *Queries) GetCurrentBranch(ctx context.Context, id uint64) (string, error) {
+	row := q.queryRow(ctx, q.getCurrentBranchStmt, getCurrentBranch, id)
+	var current_branch string
+	err := row.Scan(&current_branch)
+	return current_branch, err
+}
+
 const getHeadVersion = `-- name: GetHeadVersion :one
 SELECT v.id as version_id, v.tag as version_tag
 FROM heads h
+JOIN files f ON h.file_id = f.id AND h.branch = f.current_branch
 JOIN versions v ON h.version_id = v.id
 WHERE h.file_id = $1
 `
@@ -73,6 +141,7 @@ type GetHeadVersionRow struct {
 	VersionTag string `json:"versionTag"`
 }
 
+// Head of the branch the file is currently on
 func (q *Queries) GetHeadVersion(ctx context.Context, fileID uint64) (GetHeadVersionRow, error) {
 	row := q.queryRow(ctx, q.getHeadVersionStmt, getHeadVersion, fileID)
 	var i GetHeadVersionRow
@@ -80,19 +149,34 @@ func (q *Queries) GetHeadVersion(ctx context.Context, fileID uint64) (GetHeadVer
 	return i, err
 }
 
+const setCurrentBranch = `-- name: SetCurrentBranch :exec
+UPDATE files SET current_branch = $2 WHERE id = $1
+`
+
+type SetCurrentBranchParams struct {
+	ID            uint64 `json:"id"`
+	CurrentBranch string `json:"currentBranch"`
+}
+
+func (q *Queries) SetCurrentBranch(ctx context.Context, arg SetCurrentBranchParams) error {
+	_, err := q.exec(ctx, q.setCurrentBranchStmt, setCurrentBranch, arg.ID, arg.CurrentBranch)
+	return err
+}
+
 const setHead = `-- name: SetHead :exec
-INSERT INTO heads (file_id, version_id)
-VALUES ($1, $2)
-ON CONFLICT (file_id)
+INSERT INTO heads (file_id, version_id, branch)
+VALUES ($1, $2, $3)
+ON CONFLICT (file_id, branch)
 DO UPDATE SET version_id = $2, created_at = CURRENT_TIMESTAMP
 `
 
 type SetHeadParams struct {
 	FileID    uint64 `json:"fileId"`
 	VersionID uint64 `json:"versionId"`
+	Branch    string `json:"branch"`
 }
 
 func (q *Queries) SetHead(ctx context.Context, arg SetHeadParams) error {
-	_, err := q.exec(ctx, q.setHeadStmt, setHead, arg.FileID, arg.VersionID)
+	_, err := q.exec(ctx, q.setHeadStmt, setHead, arg.FileID, arg.VersionID, arg.Branch)
 	return err
 }
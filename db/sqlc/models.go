@@ -6,21 +6,41 @@ package sqlc
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/vinimdocarmo/quackfs/db/types"
 )
 
 type Chunk struct {
-	ID              int64        `json:"id"`
-	SnapshotLayerID uint64       `json:"snapshotLayerId"`
-	LayerRange      types.Range  `json:"layerRange"`
-	FileRange       types.Range  `json:"fileRange"`
-	CreatedAt       sql.NullTime `json:"createdAt"`
+	ID              int64          `json:"id"`
+	SnapshotLayerID uint64         `json:"snapshotLayerId"`
+	LayerRange      types.Range    `json:"layerRange"`
+	FileRange       types.Range    `json:"fileRange"`
+	ObjectRange     types.Range    `json:"objectRange"`
+	Nonce           []byte         `json:"nonce"`
+	KeyID           sql.NullString `json:"keyId"`
+	Checksum        sql.NullInt64  `json:"checksum"`
+	ChunkHash       []byte         `json:"chunkHash"`
+	SourceLayerID   sql.NullInt32  `json:"sourceLayerId"`
+	Hole            bool           `json:"hole"`
+	CreatedAt       sql.NullTime   `json:"createdAt"`
 }
 
 type File struct {
-	ID   uint64 `json:"id"`
-	Name string `json:"name"`
+	ID            uint64    `json:"id"`
+	Name          string    `json:"name"`
+	Epoch         int64     `json:"epoch"`
+	CurrentBranch string    `json:"currentBranch"`
+	Mode          int32     `json:"mode"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ModifiedAt    time.Time `json:"modifiedAt"`
+	CurrentSize   int64     `json:"currentSize"`
+}
+
+type FileXattr struct {
+	FileID uint64 `json:"fileId"`
+	Name   string `json:"name"`
+	Value  []byte `json:"value"`
 }
 
 type Head struct {
@@ -28,19 +48,38 @@ type Head struct {
 	FileID    uint64       `json:"fileId"`
 	VersionID uint64       `json:"versionId"`
 	CreatedAt sql.NullTime `json:"createdAt"`
+	Branch    string       `json:"branch"`
 }
 
 type SnapshotLayer struct {
-	ID        uint64        `json:"id"`
-	FileID    uint64        `json:"fileId"`
-	CreatedAt sql.NullTime  `json:"createdAt"`
-	Active    sql.NullInt32 `json:"active"`
-	VersionID sql.NullInt64 `json:"versionId"`
-	ObjectKey string        `json:"objectKey"`
+	ID          uint64        `json:"id"`
+	FileID      uint64        `json:"fileId"`
+	CreatedAt   sql.NullTime  `json:"createdAt"`
+	Active      sql.NullInt32 `json:"active"`
+	VersionID   sql.NullInt64 `json:"versionId"`
+	ObjectKey   string        `json:"objectKey"`
+	Compression string        `json:"compression"`
+	Encrypted   bool          `json:"encrypted"`
+	Archived    bool          `json:"archived"`
+	Compacted   bool          `json:"compacted"`
 }
 
 type Version struct {
 	ID        uint64       `json:"id"`
 	Tag       string       `json:"tag"`
 	CreatedAt sql.NullTime `json:"createdAt"`
+	Message   string       `json:"message"`
+	Author    string       `json:"author"`
+	Origin    string       `json:"origin"`
+}
+
+type WriteOrigin struct {
+	ID              int64        `json:"id"`
+	SnapshotLayerID uint64       `json:"snapshotLayerId"`
+	LayerRange      types.Range  `json:"layerRange"`
+	RequestID       int64        `json:"requestId"`
+	Pid             int64        `json:"pid"`
+	WriteOffset     int64        `json:"writeOffset"`
+	WriteSize       int64        `json:"writeSize"`
+	CreatedAt       sql.NullTime `json:"createdAt"`
 }
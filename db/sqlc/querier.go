@@ -9,24 +9,89 @@ import (
 )
 
 type Querier interface {
+	AcquireFileEpoch(ctx context.Context, id uint64) (int64, error)
 	CalcFileSize(ctx context.Context, fileID uint64) (int64, error)
-	DeleteHead(ctx context.Context, fileID uint64) error
+	// Size of the file as of a layer, i.e. considering only that layer and the ones before it
+	CalcFileSizeAtLayer(ctx context.Context, arg CalcFileSizeAtLayerParams) (int64, error)
+	CreateBranch(ctx context.Context, arg CreateBranchParams) error
+	DeleteFile(ctx context.Context, id uint64) error
+	DeleteFileChunks(ctx context.Context, fileID uint64) error
+	DeleteFileHeads(ctx context.Context, fileID uint64) error
+	// Deletes the layers of a file along with their versions (and write origins)
+	DeleteFileLayers(ctx context.Context, fileID uint64) error
+	DeleteFileXattr(ctx context.Context, arg DeleteFileXattrParams) (int64, error)
+	DeleteHead(ctx context.Context, arg DeleteHeadParams) error
+	// Finds where the data of chunks with the given hashes is already stored for a file: the
+	// first stored copy of each, in a versioned layer whose object is readable (not archived).
+	FindChunksByHash(ctx context.Context, arg FindChunksByHashParams) ([]FindChunksByHashRow, error)
 	GetAllFiles(ctx context.Context) ([]File, error)
 	GetAllHeads(ctx context.Context) ([]GetAllHeadsRow, error)
+	GetAllObjectKeys(ctx context.Context) ([]string, error)
+	// Versions of every file in creation order, a page at a time: pass the created_at and id
+	// of the last row of the previous page (or the zero time and 0 for the first page)
+	GetAllVersionsPage(ctx context.Context, arg GetAllVersionsPageParams) ([]GetAllVersionsPageRow, error)
+	GetBranchVersion(ctx context.Context, arg GetBranchVersionParams) (GetBranchVersionRow, error)
+	GetCurrentBranch(ctx context.Context, id uint64) (string, error)
+	GetFileAttr(ctx context.Context, id uint64) (GetFileAttrRow, error)
+	GetFileCurrentSize(ctx context.Context, id uint64) (int64, error)
+	// FOR SHARE blocks other nodes from acquiring the file until the transaction ends
+	GetFileEpoch(ctx context.Context, id uint64) (int64, error)
 	GetFileIDByName(ctx context.Context, name string) (uint64, error)
+	// Each layer has its own object, as big as the end of its last chunk stored in it (deduplicated
+	// chunks point into the object of an earlier layer)
+	GetFileStats(ctx context.Context) ([]GetFileStatsRow, error)
 	GetFileVersions(ctx context.Context, fileID uint64) ([]Version, error)
+	GetFileXattr(ctx context.Context, arg GetFileXattrParams) ([]byte, error)
+	// Head of the branch the file is currently on
 	GetHeadVersion(ctx context.Context, fileID uint64) (GetHeadVersionRow, error)
+	// Most recent version of a file and its layer
+	GetLatestVersion(ctx context.Context, fileID uint64) (GetLatestVersionRow, error)
 	GetLayerByVersion(ctx context.Context, arg GetLayerByVersionParams) (GetLayerByVersionRow, error)
 	GetLayerChunks(ctx context.Context, snapshotLayerID uint64) ([]GetLayerChunksRow, error)
+	GetLayerObject(ctx context.Context, id uint64) (GetLayerObjectRow, error)
+	// Versioned layers of every file in id order, a page at a time (pass the id of the last layer of
+	// the previous page, or 0 for the first page), with the size their object should have: the end
+	// of the last of their own chunks, as deduplicated chunks are stored in the object of another layer
+	GetLayerObjectsPage(ctx context.Context, arg GetLayerObjectsPageParams) ([]GetLayerObjectsPageRow, error)
 	GetLayersByFileID(ctx context.Context, fileID uint64) ([]GetLayersByFileIDRow, error)
-	GetObjectKey(ctx context.Context, id uint64) (string, error)
+	// Highest n among the file's tags of the form vn, 0 if there are none
+	GetMaxNumericVersionTag(ctx context.Context, fileid uint64) (int64, error)
 	GetOverlappingChunksWithVersion(ctx context.Context, arg GetOverlappingChunksWithVersionParams) ([]GetOverlappingChunksWithVersionRow, error)
+	// Most recent version of a file created at or before asOf. created_at holds the session's
+	// local time, so asOf is converted to it
+	GetVersionAsOf(ctx context.Context, arg GetVersionAsOfParams) (GetVersionAsOfRow, error)
 	GetVersionIDByTag(ctx context.Context, tag string) (uint64, error)
+	GetWriteOrigins(ctx context.Context, snapshotLayerID uint64) ([]GetWriteOriginsRow, error)
+	// Chunks are never removed from a file, so its size only grows
+	GrowFileCurrentSize(ctx context.Context, arg GrowFileCurrentSizeParams) error
 	InsertChunk(ctx context.Context, arg InsertChunkParams) error
+	// Inserts all the chunks of a layer in a single round-trip. Chunks are inserted (and so
+	// get their ids) in array order, which reads rely on to apply them in write order.
+	InsertChunks(ctx context.Context, arg InsertChunksParams) error
 	InsertFile(ctx context.Context, name string) (uint64, error)
+	// Inserts the attribute only if the file doesn't have it yet
+	InsertFileXattr(ctx context.Context, arg InsertFileXattrParams) (int64, error)
 	InsertLayer(ctx context.Context, arg InsertLayerParams) (uint64, error)
-	InsertVersion(ctx context.Context, tag string) (uint64, error)
+	InsertVersion(ctx context.Context, arg InsertVersionParams) (uint64, error)
+	InsertWriteOrigins(ctx context.Context, arg InsertWriteOriginsParams) error
+	ListFileXattrs(ctx context.Context, fileID uint64) ([]string, error)
+	// Held by garbage collection while it looks for and deletes unreferenced objects
+	LockObjectsExclusive(ctx context.Context, lockid int64) error
+	// Held by checkpoints while they upload and reference a new object, so that
+	// garbage collection never sees an uploaded object that isn't referenced yet
+	LockObjectsShared(ctx context.Context, lockid int64) error
+	RenameFile(ctx context.Context, arg RenameFileParams) error
+	// Replaces the value of the attribute only if the file has it already
+	ReplaceFileXattr(ctx context.Context, arg ReplaceFileXattrParams) (int64, error)
+	SetCurrentBranch(ctx context.Context, arg SetCurrentBranchParams) error
+	SetFileMode(ctx context.Context, arg SetFileModeParams) error
+	SetFileModifiedAt(ctx context.Context, arg SetFileModifiedAtParams) error
+	SetFileXattr(ctx context.Context, arg SetFileXattrParams) error
 	SetHead(ctx context.Context, arg SetHeadParams) error
+	SetLayerArchived(ctx context.Context, arg SetLayerArchivedParams) error
+	SetLayerCompacted(ctx context.Context, id uint64) error
+	// Tags are unique per file, versions only become part of a file through its layers
+	VersionTagExists(ctx context.Context, arg VersionTagExistsParams) (bool, error)
 }
 
 var _ Querier = (*Queries)(nil)
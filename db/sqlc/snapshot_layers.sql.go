@@ -10,13 +10,126 @@ import (
 	"database/sql"
 )
 
+const deleteFileLayers = `-- name: DeleteFileLayers :exec
+WITH deleted_layers AS (
+    DELETE FROM snapshot_layers WHERE file_id = $1 RETURNING version_id
+)
+DELETE FROM versions WHERE id IN (SELECT version_id FROM deleted_layers)
+`
+
+// Deletes the layers of a file along with their versions (and write origins)
+func (q *Queries) DeleteFileLayers(ctx context.Context, fileID uint64) error {
+	_, err := q.exec(ctx, q.deleteFileLayersStmt, deleteFileLayers, fileID)
+	return err
+}
+
+const getAllObjectKeys = `-- name: GetAllObjectKeys :many
+SELECT 
+    object_key
+FROM 
+    snapshot_layers
+`
+
+func (q *Queries) GetAllObjectKeys(ctx context.Context) ([]string, error) {
+	rows, err := q.query(ctx, q.getAllObjectKeysStmt, getAllObjectKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var object_key string
+		if err := rows.Scan(&object_key); err != nil {
+			return nil, err
+		}
+		items = append(items, object_key)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFileStats = `-- name: GetFileStats :many
+SELECT
+    files.id,
+    files.name,
+    COUNT(snapshot_layers.version_id)::BIGINT AS versions,
+    COUNT(snapshot_layers.id)::BIGINT AS layers,
+    COALESCE(SUM(layer_objects.size), 0)::BIGINT AS object_bytes
+FROM
+    files
+LEFT JOIN
+    snapshot_layers ON snapshot_layers.file_id = files.id
+LEFT JOIN (
+    SELECT
+        snapshot_layer_id,
+        MAX(upper(object_range)) AS size
+    FROM
+        chunks
+    WHERE
+        source_layer_id IS NULL
+    GROUP BY
+        snapshot_layer_id
+) AS layer_objects ON layer_objects.snapshot_layer_id = snapshot_layers.id
+GROUP BY
+    files.id, files.name
+ORDER BY
+    files.name
+`
+
+type GetFileStatsRow struct {
+	ID          uint64 `json:"id"`
+	Name        string `json:"name"`
+	Versions    int64  `json:"versions"`
+	Layers      int64  `json:"layers"`
+	ObjectBytes int64  `json:"objectBytes"`
+}
+
+// Each layer has its own object, as big as the end of its last chunk stored in it (deduplicated
+// chunks point into the object of an earlier layer)
+func (q *Queries) GetFileStats(ctx context.Context) ([]GetFileStatsRow, error) {
+	rows, err := q.query(ctx, q.getFileStatsStmt, getFileStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetFileStatsRow{}
+	for rows.Next() {
+		var i GetFileStatsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Versions,
+			&i.Layers,
+			&i.ObjectBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getLayerByVersion = `-- name: GetLayerByVersion :one
 SELECT 
     snapshot_layers.id, 
     snapshot_layers.file_id, 
     snapshot_layers.version_id, 
     versions.tag, 
-    snapshot_layers.object_key
+    snapshot_layers.object_key,
+    snapshot_layers.compression,
+    snapshot_layers.encrypted,
+    snapshot_layers.archived
 FROM 
     snapshot_layers
 INNER JOIN 
@@ -31,11 +144,14 @@ type GetLayerByVersionParams struct {
 }
 
 type GetLayerByVersionRow struct {
-	ID        uint64        `json:"id"`
-	FileID    uint64        `json:"fileId"`
-	VersionID sql.NullInt64 `json:"versionId"`
-	Tag       string        `json:"tag"`
-	ObjectKey string        `json:"objectKey"`
+	ID          uint64        `json:"id"`
+	FileID      uint64        `json:"fileId"`
+	VersionID   sql.NullInt64 `json:"versionId"`
+	Tag         string        `json:"tag"`
+	ObjectKey   string        `json:"objectKey"`
+	Compression string        `json:"compression"`
+	Encrypted   bool          `json:"encrypted"`
+	Archived    bool          `json:"archived"`
 }
 
 func (q *Queries) GetLayerByVersion(ctx context.Context, arg GetLayerByVersionParams) (GetLayerByVersionRow, error) {
@@ -47,17 +163,123 @@ func (q *Queries) GetLayerByVersion(ctx context.Context, arg GetLayerByVersionPa
 		&i.VersionID,
 		&i.Tag,
 		&i.ObjectKey,
+		&i.Compression,
+		&i.Encrypted,
+		&i.Archived,
+	)
+	return i, err
+}
+
+const getLayerObject = `-- name: GetLayerObject :one
+SELECT 
+    object_key,
+    compression,
+    encrypted,
+    archived
+FROM 
+    snapshot_layers
+WHERE 
+    id = $1
+`
+
+type GetLayerObjectRow struct {
+	ObjectKey   string `json:"objectKey"`
+	Compression string `json:"compression"`
+	Encrypted   bool   `json:"encrypted"`
+	Archived    bool   `json:"archived"`
+}
+
+func (q *Queries) GetLayerObject(ctx context.Context, id uint64) (GetLayerObjectRow, error) {
+	row := q.queryRow(ctx, q.getLayerObjectStmt, getLayerObject, id)
+	var i GetLayerObjectRow
+	err := row.Scan(
+		&i.ObjectKey,
+		&i.Compression,
+		&i.Encrypted,
+		&i.Archived,
 	)
 	return i, err
 }
 
+const getLayerObjectsPage = `-- name: GetLayerObjectsPage :many
+SELECT
+    sl.id,
+    f.name AS file_name,
+    v.tag,
+    sl.object_key,
+    COALESCE(MAX(UPPER(c.object_range)) FILTER (WHERE c.source_layer_id IS NULL), 0)::BIGINT AS object_size
+FROM
+    snapshot_layers sl
+JOIN
+    files f ON f.id = sl.file_id
+JOIN
+    versions v ON v.id = sl.version_id
+LEFT JOIN
+    chunks c ON c.snapshot_layer_id = sl.id
+WHERE
+    sl.id > $1::BIGINT
+GROUP BY
+    sl.id, f.name, v.tag, sl.object_key
+ORDER BY
+    sl.id
+LIMIT $2::INT
+`
+
+type GetLayerObjectsPageParams struct {
+	AfterID  int64 `json:"afterID"`
+	PageSize int32 `json:"pageSize"`
+}
+
+type GetLayerObjectsPageRow struct {
+	ID         uint64 `json:"id"`
+	FileName   string `json:"fileName"`
+	Tag        string `json:"tag"`
+	ObjectKey  string `json:"objectKey"`
+	ObjectSize int64  `json:"objectSize"`
+}
+
+// Versioned layers of every file in id order, a page at a time (pass the id of the last layer of
+// the previous page, or 0 for the first page), with the size their object should have: the end
+// of the last of their own chunks, as deduplicated chunks are stored in the object of another layer
+func (q *Queries) GetLayerObjectsPage(ctx context.Context, arg GetLayerObjectsPageParams) ([]GetLayerObjectsPageRow, error) {
+	rows, err := q.query(ctx, q.getLayerObjectsPageStmt, getLayerObjectsPage, arg.AfterID, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetLayerObjectsPageRow{}
+	for rows.Next() {
+		var i GetLayerObjectsPageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FileName,
+			&i.Tag,
+			&i.ObjectKey,
+			&i.ObjectSize,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getLayersByFileID = `-- name: GetLayersByFileID :many
 SELECT 
     snapshot_layers.id, 
     snapshot_layers.file_id, 
     snapshot_layers.version_id, 
     versions.tag, 
-    snapshot_layers.object_key
+    snapshot_layers.object_key,
+    snapshot_layers.compression,
+    snapshot_layers.encrypted,
+    snapshot_layers.archived
 FROM 
     snapshot_layers
 LEFT JOIN 
@@ -69,11 +291,14 @@ ORDER BY
 `
 
 type GetLayersByFileIDRow struct {
-	ID        uint64         `json:"id"`
-	FileID    uint64         `json:"fileId"`
-	VersionID sql.NullInt64  `json:"versionId"`
-	Tag       sql.NullString `json:"tag"`
-	ObjectKey string         `json:"objectKey"`
+	ID          uint64         `json:"id"`
+	FileID      uint64         `json:"fileId"`
+	VersionID   sql.NullInt64  `json:"versionId"`
+	Tag         sql.NullString `json:"tag"`
+	ObjectKey   string         `json:"objectKey"`
+	Compression string         `json:"compression"`
+	Encrypted   bool           `json:"encrypted"`
+	Archived    bool           `json:"archived"`
 }
 
 func (q *Queries) GetLayersByFileID(ctx context.Context, fileID uint64) ([]GetLayersByFileIDRow, error) {
@@ -91,6 +316,9 @@ func (q *Queries) GetLayersByFileID(ctx context.Context, fileID uint64) ([]GetLa
 			&i.VersionID,
 			&i.Tag,
 			&i.ObjectKey,
+			&i.Compression,
+			&i.Encrypted,
+			&i.Archived,
 		); err != nil {
 			return nil, err
 		}
@@ -105,39 +333,80 @@ func (q *Queries) GetLayersByFileID(ctx context.Context, fileID uint64) ([]GetLa
 	return items, nil
 }
 
-const getObjectKey = `-- name: GetObjectKey :one
-SELECT 
-    object_key
-FROM 
-    snapshot_layers
-WHERE 
-    id = $1
-`
-
-func (q *Queries) GetObjectKey(ctx context.Context, id uint64) (string, error) {
-	row := q.queryRow(ctx, q.getObjectKeyStmt, getObjectKey, id)
-	var object_key string
-	err := row.Scan(&object_key)
-	return object_key, err
-}
-
 const insertLayer = `-- name: InsertLayer :one
 INSERT INTO 
-    snapshot_layers (file_id, version_id, object_key) 
+    snapshot_layers (file_id, version_id, object_key, compression, encrypted) 
 VALUES 
-    ($1, $2, $3) 
+    ($1, $2, $3, $4, $5) 
 RETURNING id
 `
 
 type InsertLayerParams struct {
-	FileID    uint64        `json:"fileId"`
-	VersionID sql.NullInt64 `json:"versionId"`
-	ObjectKey string        `json:"objectKey"`
+	FileID      uint64        `json:"fileId"`
+	VersionID   sql.NullInt64 `json:"versionId"`
+	ObjectKey   string        `json:"objectKey"`
+	Compression string        `json:"compression"`
+	Encrypted   bool          `json:"encrypted"`
 }
 
 func (q *Queries) InsertLayer(ctx context.Context, arg InsertLayerParams) (uint64, error) {
-	row := q.queryRow(ctx, q.insertLayerStmt, insertLayer, arg.FileID, arg.VersionID, arg.ObjectKey)
+	row := q.queryRow(ctx, q.insertLayerStmt, insertLayer,
+		arg.FileID,
+		arg.VersionID,
+		arg.ObjectKey,
+		arg.Compression,
+		arg.Encrypted,
+	)
 	var id uint64
 	err := row.Scan(&id)
 	return id, err
 }
+
+const lockObjectsExclusive = `-- name: LockObjectsExclusive :exec
+SELECT pg_advisory_xact_lock($1::BIGINT)
+`
+
+// Held by garbage collection while it looks for and deletes unreferenced objects
+func (q *Queries) LockObjectsExclusive(ctx context.Context, lockid int64) error {
+	_, err := q.exec(ctx, q.lockObjectsExclusiveStmt, lockObjectsExclusive, lockid)
+	return err
+}
+
+const lockObjectsShared = `-- name: LockObjectsShared :exec
+SELECT pg_advisory_xact_lock_shared($1::BIGINT)
+`
+
+// Held by checkpoints while they upload and reference a new object, so that
+// garbage collection never sees an uploaded object that isn't referenced yet
+func (q *Queries) LockObjectsShared(ctx context.Context, lockid int64) error {
+	_, err := q.exec(ctx, q.lockObjectsSharedStmt, lockObjectsShared, lockid)
+	return err
+}
+
+const setLayerArchived = `-- name: SetLayerArchived :exec
+UPDATE 
+    snapshot_layers 
+SET 
+    archived = $2 
+WHERE 
+    id = $1
+`
+
+type SetLayerArchivedParams struct {
+	ID       uint64 `json:"id"`
+	Archived bool   `json:"archived"`
+}
+
+func (q *Queries) SetLayerArchived(ctx context.Context, arg SetLayerArchivedParams) error {
+	_, err := q.exec(ctx, q.setLayerArchivedStmt, setLayerArchived, arg.ID, arg.Archived)
+	return err
+}
+
+const setLayerCompacted = `-- name: SetLayerCompacted :exec
+UPDATE snapshot_layers SET compacted = TRUE WHERE id = $1
+`
+
+func (q *Queries) SetLayerCompacted(ctx context.Context, id uint64) error {
+	_, err := q.exec(ctx, q.setLayerCompactedStmt, setLayerCompacted, id)
+	return err
+}
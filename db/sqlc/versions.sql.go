@@ -7,13 +7,91 @@ package sqlc
 
 import (
 	"context"
+	"time"
 )
 
+const getAllVersionsPage = `-- name: GetAllVersionsPage :many
+SELECT
+    v.id,
+    f.name AS file_name,
+    v.tag,
+    COALESCE(v.created_at, 'epoch'::TIMESTAMP)::TIMESTAMP AS created_at,
+    v.message,
+    v.author,
+    v.origin,
+    sl.object_key
+FROM
+    versions v
+JOIN
+    snapshot_layers sl ON v.id = sl.version_id
+JOIN
+    files f ON f.id = sl.file_id
+WHERE
+    (COALESCE(v.created_at, 'epoch'::TIMESTAMP), v.id) > ($1::TIMESTAMP, $2::BIGINT)
+ORDER BY
+    COALESCE(v.created_at, 'epoch'::TIMESTAMP), v.id
+LIMIT $3::INT
+`
+
+type GetAllVersionsPageParams struct {
+	AfterCreatedAt time.Time `json:"afterCreatedAt"`
+	AfterID        int64     `json:"afterID"`
+	PageSize       int32     `json:"pageSize"`
+}
+
+type GetAllVersionsPageRow struct {
+	ID        uint64    `json:"id"`
+	FileName  string    `json:"fileName"`
+	Tag       string    `json:"tag"`
+	CreatedAt time.Time `json:"createdAt"`
+	Message   string    `json:"message"`
+	Author    string    `json:"author"`
+	Origin    string    `json:"origin"`
+	ObjectKey string    `json:"objectKey"`
+}
+
+// Versions of every file in creation order, a page at a time: pass the created_at and id
+// of the last row of the previous page (or the zero time and 0 for the first page)
+func (q *Queries) GetAllVersionsPage(ctx context.Context, arg GetAllVersionsPageParams) ([]GetAllVersionsPageRow, error) {
+	rows, err := q.query(ctx, q.getAllVersionsPageStmt, getAllVersionsPage, arg.AfterCreatedAt, arg.AfterID, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetAllVersionsPageRow{}
+	for rows.Next() {
+		var i GetAllVersionsPageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FileName,
+			&i.Tag,
+			&i.CreatedAt,
+			&i.Message,
+			&i.Author,
+			&i.Origin,
+			&i.ObjectKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFileVersions = `-- name: GetFileVersions :many
 SELECT
     v.id,
     v.tag,
-    v.created_at
+    v.created_at,
+    v.message,
+    v.author,
+    v.origin
 FROM
     versions v
 JOIN
@@ -33,7 +111,14 @@ func (q *Queries) GetFileVersions(ctx context.Context, fileID uint64) ([]Version
 	items := []Version{}
 	for rows.Next() {
 		var i Version
-		if err := rows.Scan(&i.ID, &i.Tag, &i.CreatedAt); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Tag,
+			&i.CreatedAt,
+			&i.Message,
+			&i.Author,
+			&i.Origin,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -47,6 +132,91 @@ func (q *Queries) GetFileVersions(ctx context.Context, fileID uint64) ([]Version
 	return items, nil
 }
 
+const getLatestVersion = `-- name: GetLatestVersion :one
+SELECT
+    v.id AS version_id,
+    v.tag,
+    sl.id AS layer_id
+FROM
+    versions v
+JOIN
+    snapshot_layers sl ON sl.version_id = v.id
+WHERE
+    sl.file_id = $1
+ORDER BY
+    v.created_at DESC, v.id DESC
+LIMIT 1
+`
+
+type GetLatestVersionRow struct {
+	VersionID uint64 `json:"versionId"`
+	Tag       string `json:"tag"`
+	LayerID   uint64 `json:"layerId"`
+}
+
+// Most recent version of a file and its layer
+func (q *Queries) GetLatestVersion(ctx context.Context, fileID uint64) (GetLatestVersionRow, error) {
+	row := q.queryRow(ctx, q.getLatestVersionStmt, getLatestVersion, fileID)
+	var i GetLatestVersionRow
+	err := row.Scan(&i.VersionID, &i.Tag, &i.LayerID)
+	return i, err
+}
+
+const getMaxNumericVersionTag = `-- name: GetMaxNumericVersionTag :one
+SELECT
+    COALESCE(MAX(SUBSTRING(v.tag FROM 2)::BIGINT), 0)::BIGINT AS max_tag
+FROM
+    versions v
+INNER JOIN
+    snapshot_layers sl ON sl.version_id = v.id
+WHERE
+    sl.file_id = $1 AND v.tag ~ '^v[0-9]{1,18}$'
+`
+
+// Highest n among the file's tags of the form vn, 0 if there are none
+func (q *Queries) GetMaxNumericVersionTag(ctx context.Context, fileid uint64) (int64, error) {
+	row := q.queryRow(ctx, q.getMaxNumericVersionTagStmt, getMaxNumericVersionTag, fileid)
+	var max_tag int64
+	err := row.Scan(&max_tag)
+	return max_tag, err
+}
+
+const getVersionAsOf = `-- name: GetVersionAsOf :one
+SELECT
+    v.id AS version_id,
+    v.tag,
+    sl.id AS layer_id
+FROM
+    versions v
+JOIN
+    snapshot_layers sl ON sl.version_id = v.id
+WHERE
+    sl.file_id = $1 AND v.created_at <= ($2::TIMESTAMPTZ)::TIMESTAMP
+ORDER BY
+    v.created_at DESC, v.id DESC
+LIMIT 1
+`
+
+type GetVersionAsOfParams struct {
+	FileID uint64    `json:"fileID"`
+	AsOf   time.Time `json:"asOf"`
+}
+
+type GetVersionAsOfRow struct {
+	VersionID uint64 `json:"versionId"`
+	Tag       string `json:"tag"`
+	LayerID   uint64 `json:"layerId"`
+}
+
+// Most recent version of a file created at or before asOf. created_at holds the session's
+// local time, so asOf is converted to it
+func (q *Queries) GetVersionAsOf(ctx context.Context, arg GetVersionAsOfParams) (GetVersionAsOfRow, error) {
+	row := q.queryRow(ctx, q.getVersionAsOfStmt, getVersionAsOf, arg.FileID, arg.AsOf)
+	var i GetVersionAsOfRow
+	err := row.Scan(&i.VersionID, &i.Tag, &i.LayerID)
+	return i, err
+}
+
 const getVersionIDByTag = `-- name: GetVersionIDByTag :one
 SELECT id FROM versions WHERE tag = $1
 `
@@ -59,12 +229,49 @@ func (q *Queries) GetVersionIDByTag(ctx context.Context, tag string) (uint64, er
 }
 
 const insertVersion = `-- name: InsertVersion :one
-INSERT INTO versions (tag) VALUES ($1) RETURNING id
+INSERT INTO versions (tag, message, author, origin) VALUES ($1, $2, $3, $4) RETURNING id
 `
 
-func (q *Queries) InsertVersion(ctx context.Context, tag string) (uint64, error) {
-	row := q.queryRow(ctx, q.insertVersionStmt, insertVersion, tag)
+type InsertVersionParams struct {
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+	Author  string `json:"author"`
+	Origin  string `json:"origin"`
+}
+
+func (q *Queries) InsertVersion(ctx context.Context, arg InsertVersionParams) (uint64, error) {
+	row := q.queryRow(ctx, q.insertVersionStmt, insertVersion,
+		arg.Tag,
+		arg.Message,
+		arg.Author,
+		arg.Origin,
+	)
 	var id uint64
 	err := row.Scan(&id)
 	return id, err
 }
+
+const versionTagExists = `-- name: VersionTagExists :one
+SELECT EXISTS (
+    SELECT 1
+    FROM
+        versions v
+    INNER JOIN
+        snapshot_layers sl ON sl.version_id = v.id
+    WHERE
+        sl.file_id = $1 AND v.tag = $2
+)::BOOLEAN AS version_exists
+`
+
+type VersionTagExistsParams struct {
+	FileID uint64 `json:"fileID"`
+	Tag    string `json:"tag"`
+}
+
+// Tags are unique per file, versions only become part of a file through its layers
+func (q *Queries) VersionTagExists(ctx context.Context, arg VersionTagExistsParams) (bool, error) {
+	row := q.queryRow(ctx, q.versionTagExistsStmt, versionTagExists, arg.FileID, arg.Tag)
+	var version_exists bool
+	err := row.Scan(&version_exists)
+	return version_exists, err
+}
@@ -0,0 +1,111 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.28.0
+// source: write_origins.sql
+
+package sqlc
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+	"github.com/vinimdocarmo/quackfs/db/types"
+)
+
+const getWriteOrigins = `-- name: GetWriteOrigins :many
+SELECT 
+    layer_range, 
+    request_id, 
+    pid, 
+    write_offset, 
+    write_size
+FROM 
+    write_origins
+WHERE 
+    snapshot_layer_id = $1
+ORDER BY 
+    id ASC
+`
+
+type GetWriteOriginsRow struct {
+	LayerRange  types.Range `json:"layerRange"`
+	RequestID   int64       `json:"requestId"`
+	Pid         int64       `json:"pid"`
+	WriteOffset int64       `json:"writeOffset"`
+	WriteSize   int64       `json:"writeSize"`
+}
+
+func (q *Queries) GetWriteOrigins(ctx context.Context, snapshotLayerID uint64) ([]GetWriteOriginsRow, error) {
+	rows, err := q.query(ctx, q.getWriteOriginsStmt, getWriteOrigins, snapshotLayerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetWriteOriginsRow{}
+	for rows.Next() {
+		var i GetWriteOriginsRow
+		if err := rows.Scan(
+			&i.LayerRange,
+			&i.RequestID,
+			&i.Pid,
+			&i.WriteOffset,
+			&i.WriteSize,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertWriteOrigins = `-- name: InsertWriteOrigins :exec
+INSERT INTO 
+    write_origins (snapshot_layer_id, layer_range, request_id, pid, write_offset, write_size) 
+SELECT 
+    $1::BIGINT,
+    int8range(o.layer_start, o.layer_end),
+    o.request_id,
+    o.pid,
+    o.write_offset,
+    o.write_size
+FROM 
+    ROWS FROM (
+        unnest($2::BIGINT[]),
+        unnest($3::BIGINT[]),
+        unnest($4::BIGINT[]),
+        unnest($5::BIGINT[]),
+        unnest($6::BIGINT[]),
+        unnest($7::BIGINT[])
+    ) WITH ORDINALITY AS o(layer_start, layer_end, request_id, pid, write_offset, write_size, ord)
+ORDER BY 
+    o.ord
+`
+
+type InsertWriteOriginsParams struct {
+	SnapshotLayerID int64   `json:"snapshotLayerID"`
+	LayerStarts     []int64 `json:"layerStarts"`
+	LayerEnds       []int64 `json:"layerEnds"`
+	RequestIDs      []int64 `json:"requestIDs"`
+	Pids            []int64 `json:"pids"`
+	WriteOffsets    []int64 `json:"writeOffsets"`
+	WriteSizes      []int64 `json:"writeSizes"`
+}
+
+func (q *Queries) InsertWriteOrigins(ctx context.Context, arg InsertWriteOriginsParams) error {
+	_, err := q.exec(ctx, q.insertWriteOriginsStmt, insertWriteOrigins,
+		arg.SnapshotLayerID,
+		pq.Array(arg.LayerStarts),
+		pq.Array(arg.LayerEnds),
+		pq.Array(arg.RequestIDs),
+		pq.Array(arg.Pids),
+		pq.Array(arg.WriteOffsets),
+		pq.Array(arg.WriteSizes),
+	)
+	return err
+}
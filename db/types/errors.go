@@ -3,3 +3,55 @@ package types
 import "errors"
 
 var ErrNotFound = errors.New("not found")
+
+// ErrChecksumMismatch is returned when data read from the object store doesn't
+// match the checksum recorded when it was written (i.e. it is corrupted)
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrFenced is returned when a node tries to modify a file that another node
+// has since taken ownership of (i.e. the node's fencing token is stale)
+var ErrFenced = errors.New("fenced: file is owned by a node with a newer epoch")
+
+// ErrBeyondFileSize is returned by strict writes (no zero-filling) that start past the
+// end of the file
+var ErrBeyondFileSize = errors.New("write offset is beyond file size")
+
+// ErrObjectArchived is returned by reads that need a layer object that was moved
+// to cold storage. The version has to be restored before it can be read.
+var ErrObjectArchived = errors.New("object is archived")
+
+// ErrVersionExists is returned when checkpointing a file with a version tag
+// the file already has
+var ErrVersionExists = errors.New("version already exists")
+
+// ErrShortRead is returned when a layer holds fewer bytes than the chunks reading it expect,
+// e.g. when the object store keeps returning a truncated range
+var ErrShortRead = errors.New("short read")
+
+// ErrInvalidFilename is returned when creating a file whose name is empty or contains a
+// path separator
+var ErrInvalidFilename = errors.New("invalid file name")
+
+// ErrFileExists is returned when creating a file with the name of an existing file
+var ErrFileExists = errors.New("file already exists")
+
+// ErrXattrExists is returned when creating an extended attribute a file already has
+var ErrXattrExists = errors.New("extended attribute already exists")
+
+// ErrInvalidXattrName is returned when setting an extended attribute whose name is empty or
+// too long
+var ErrInvalidXattrName = errors.New("invalid extended attribute name")
+
+// ErrXattrTooLarge is returned when setting an extended attribute whose value is too large
+var ErrXattrTooLarge = errors.New("extended attribute value too large")
+
+// ErrStaleFile is returned when a file isn't the one the caller expects anymore, i.e. it was
+// removed or replaced by a new file with the same name since the caller looked it up
+var ErrStaleFile = errors.New("stale file")
+
+// ErrFileBusy is returned when writing to a file while it is being rewritten as a whole, e.g.
+// compacted or reverted
+var ErrFileBusy = errors.New("file is busy")
+
+// ErrCrossShard is returned when renaming a file to a name that routes to another shard
+var ErrCrossShard = errors.New("names are on different shards")
@@ -29,6 +29,12 @@ func (r *Range) Scan(src interface{}) error {
 		return fmt.Errorf("unsupported range type: %T", src)
 	}
 
+	// PostgreSQL normalizes ranges without any value, like [10,10), to empty
+	if rangeStr == "empty" {
+		r[0], r[1] = 0, 0
+		return nil
+	}
+
 	// Parse the PostgreSQL range format (e.g., "[10,20)")
 	rangeStr = strings.Trim(rangeStr, "[)")
 	parts := strings.Split(rangeStr, ",")
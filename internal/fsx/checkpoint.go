@@ -0,0 +1,65 @@
+package fsx
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+)
+
+// Renaming a database file to "<name>@<tag>" (e.g. mv db.duckdb db.duckdb@v2) checkpoints it
+// as version tag instead of renaming it, so versions can be created from a shell.
+const checkpointRenameSep = "@"
+
+// validCheckpointTag is what a tag created by renaming can look like
+var validCheckpointTag = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// checkpointTag returns the tag to checkpoint oldName as, if renaming it to newName is a
+// checkpoint by rename.
+func checkpointTag(oldName string, newName string) (string, bool) {
+	prefix := oldName + checkpointRenameSep
+	if !strings.HasPrefix(newName, prefix) {
+		return "", false
+	}
+	return newName[len(prefix):], true
+}
+
+// checkpointByRename checkpoints the named database file as version tag. The file keeps its name.
+func (dir Dir) checkpointByRename(ctx context.Context, name string, tag string) error {
+	if !dir.names.isValid(name) || dir.names.isWAL(name) {
+		dir.log.Error("Only database files can be checkpointed by renaming", "name", name)
+		return syscall.EINVAL
+	}
+
+	if !validCheckpointTag.MatchString(tag) {
+		dir.log.Error("Invalid version tag", "name", name, "tag", tag)
+		return syscall.EINVAL
+	}
+
+	if _, err := dir.sm.GetFileID(ctx, name); err != nil {
+		if err == types.ErrNotFound {
+			return syscall.ENOENT
+		}
+		return err
+	}
+
+	version, err := dir.sm.Checkpoint(ctx, name, tag)
+	if err != nil {
+		if errors.Is(err, types.ErrVersionExists) {
+			return syscall.EEXIST
+		}
+		dir.log.Error("Failed to checkpoint file", "name", name, "tag", tag, "error", err)
+		return err
+	}
+
+	if version == "" {
+		dir.log.Info("Nothing to checkpoint", "name", name, "tag", tag)
+		return nil
+	}
+
+	dir.log.Info("File checkpointed by rename", "name", name, "version", version)
+	return nil
+}
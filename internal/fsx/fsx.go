@@ -2,55 +2,245 @@ package fsx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/charmbracelet/log"
+	"github.com/vinimdocarmo/quackfs/db/sqlc"
 	"github.com/vinimdocarmo/quackfs/db/types"
 	"github.com/vinimdocarmo/quackfs/internal/storage"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
 	"github.com/vinimdocarmo/quackfs/internal/storage/wal"
 )
 
+// Storage is what the file system stores database files in, i.e. a *storage.Manager,
+// or a *storage.MultiManager to spread files across several of them.
+type Storage interface {
+	InsertFile(ctx context.Context, name string, opts ...storage.InsertFileOpt) (uint64, error)
+	GetFileID(ctx context.Context, filename string) (uint64, error)
+	GetAllFiles(ctx context.Context) ([]sqlc.File, error)
+	SizeOf(ctx context.Context, filename string) (uint64, error)
+	ReadFile(ctx context.Context, filename string, offset uint64, size uint64, opts ...storage.ReadOpt) ([]byte, error)
+	WriteFile(ctx context.Context, filename string, data []byte, offset uint64, opts ...storage.WriteOpt) error
+	RenameFile(ctx context.Context, oldName string, newName string, opts ...storage.RenameOpt) error
+	GetFileAttr(ctx context.Context, filename string) (metadata.FileAttr, error)
+	SetFileMode(ctx context.Context, filename string, mode os.FileMode) error
+	SetFileModTime(ctx context.Context, filename string, modTime time.Time) error
+	GetXattr(ctx context.Context, filename string, name string) ([]byte, error)
+	ListXattrs(ctx context.Context, filename string) ([]string, error)
+	SetXattr(ctx context.Context, filename string, name string, value []byte, opts ...storage.XattrOpt) error
+	RemoveXattr(ctx context.Context, filename string, name string) error
+	GetHead(ctx context.Context, filename string) (string, error)
+	GetLatestVersion(ctx context.Context, filename string) (string, error)
+	Checkpoint(ctx context.Context, filename string, version string, opts ...storage.CheckpointOpt) (string, error)
+	Flush(ctx context.Context, filename string) error
+}
+
+var _ Storage = (*storage.Manager)(nil)
+var _ Storage = (*storage.MultiManager)(nil)
+
 // FS implements the FUSE filesystem.
 type FS struct {
-	sm  *storage.Manager
-	log *log.Logger
-	wm  *wal.WALManager
+	sm       Storage
+	log      *log.Logger
+	wm       *wal.WALManager
+	nodes    *nodes
+	names    *fileNames
+	capacity uint64
+	readOnly bool
+
+	maxWALSize uint64 // per WAL file, 0 for no limit
+
+	flushPolicy FlushPolicy
 }
 
 // Check interface satisfied
 var _ fs.FS = (*FS)(nil)
+var _ fs.FSStatfser = (*FS)(nil)
+
+const (
+	// defaultCapacity is the size reported for the file system by default. Data lives in the
+	// object store, so it is only there to give tools (e.g. DuckDB) plenty of room to write.
+	defaultCapacity = 1 << 50 // 1 PiB
+
+	statfsBlockSize = 4096
+	statfsFreeFiles = 1 << 20
+	maxNameLen      = 255
+)
+
+// FSOpt configures the file system.
+type FSOpt func(*FS)
+
+// WithCapacity sets the total size of the file system, in bytes, reported by statfs (e.g. by df).
+func WithCapacity(capacity uint64) FSOpt {
+	return func(fs *FS) {
+		fs.capacity = capacity
+	}
+}
 
-func NewFS(sm *storage.Manager, log *log.Logger, walPath string) *FS {
+// WithExtensions sets the names of the files that can be created in the file system (see
+// DefaultExtensions for the format), e.g. to store the database files of other embedded databases.
+func WithExtensions(extensions ...string) FSOpt {
+	return func(fs *FS) {
+		fs.names.extensions = extensions
+	}
+}
+
+// WithoutWAL disables the handling of DuckDB WAL files, which are otherwise kept on local disk
+// and trigger a checkpoint when removed. Files with WAL names are stored as database files.
+func WithoutWAL() FSOpt {
+	return func(fs *FS) {
+		fs.names.wal = false
+	}
+}
+
+// WithReadOnly makes the file system read-only: creating, writing, renaming and removing files,
+// and changing their attributes, fail with EROFS. Reads return the head version, if one is set.
+func WithReadOnly() FSOpt {
+	return func(fs *FS) {
+		fs.readOnly = true
+	}
+}
+
+// WithMaxWALSize limits the size of each WAL file to maxSize bytes. Writes that would go past
+// it fail with ENOSPC.
+func WithMaxWALSize(maxSize uint64) FSOpt {
+	return func(fs *FS) {
+		fs.maxWALSize = maxSize
+	}
+}
+
+// FlushPolicy is what is done with the uncommitted writes of a database file when one of its
+// file descriptors is closed (a FUSE flush).
+type FlushPolicy string
+
+const (
+	// FlushNone keeps the writes in memory until DuckDB checkpoints, the default
+	FlushNone FlushPolicy = "none"
+	// FlushCheckpoint checkpoints the writes as a new version with a generated tag, so that
+	// every close of a modified file creates one
+	FlushCheckpoint FlushPolicy = "checkpoint"
+)
+
+// WithFlushPolicy sets what is done with the uncommitted writes of a database file when it's
+// closed, FlushNone by default.
+func WithFlushPolicy(policy FlushPolicy) FSOpt {
+	return func(fs *FS) {
+		fs.flushPolicy = policy
+	}
+}
+
+func NewFS(sm Storage, log *log.Logger, walPath string, opts ...FSOpt) *FS {
 	l := log.With()
 	l.SetPrefix("📄 fsx")
 
-	wm := wal.NewWALManager(walPath, sm, l)
+	fs := &FS{
+		sm:       sm,
+		log:      l,
+		nodes:    newNodes(),
+		names:    &fileNames{extensions: DefaultExtensions, wal: true},
+		capacity: defaultCapacity,
+
+		flushPolicy: FlushNone,
+	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	fs.wm = wal.NewWALManager(walPath, sm, l, wal.WithMaxSize(fs.maxWALSize))
+
+	return fs
+}
+
+// Statfs reports the capacity of the file system, with the sizes of the database and WAL
+// files as used space.
+func (fs *FS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	files, err := fs.sm.GetAllFiles(ctx)
+	if err != nil {
+		fs.log.Error("Failed to get files", "error", err)
+		return err
+	}
+
+	var used uint64
+	for _, file := range files {
+		size, err := fs.sm.SizeOf(ctx, file.Name)
+		if err != nil {
+			fs.log.Error("Failed to get file size", "name", file.Name, "error", err)
+			return err
+		}
+		used += size
+	}
+
+	var walFiles []string
+	if fs.names.walEnabled() {
+		walFiles, err = fs.wm.ListWALFiles()
+		if err != nil {
+			fs.log.Error("Failed to list WAL files", "error", err)
+			return err
+		}
+	}
+
+	for _, walFile := range walFiles {
+		size, err := fs.wm.GetFileSize(walFile)
+		if err != nil {
+			fs.log.Error("Failed to get WAL file size", "name", walFile, "error", err)
+			return err
+		}
+		used += size
+	}
 
-	return &FS{
-		sm:  sm,
-		log: l,
-		wm:  wm,
+	blocks := fs.capacity / statfsBlockSize
+	usedBlocks := (used + statfsBlockSize - 1) / statfsBlockSize
+	freeBlocks := uint64(0)
+	if usedBlocks < blocks {
+		freeBlocks = blocks - usedBlocks
 	}
+
+	numFiles := uint64(len(files) + len(walFiles))
+
+	resp.Blocks = blocks
+	resp.Bfree = freeBlocks
+	resp.Bavail = freeBlocks
+	resp.Files = numFiles + statfsFreeFiles
+	resp.Ffree = statfsFreeFiles
+	resp.Bsize = statfsBlockSize
+	resp.Frsize = statfsBlockSize
+	resp.Namelen = maxNameLen
+
+	fs.log.Debug("Reporting file system stats", "capacity", fs.capacity, "used", used, "files", numFiles)
+	return nil
 }
 
 func (fs *FS) Root() (fs.Node, error) {
 	return Dir{
-		sm:  fs.sm,
-		log: fs.log,
-		wm:  fs.wm,
+		sm:          fs.sm,
+		log:         fs.log,
+		wm:          fs.wm,
+		nodes:       fs.nodes,
+		names:       fs.names,
+		readOnly:    fs.readOnly,
+		flushPolicy: fs.flushPolicy,
 	}, nil
 }
 
 type Dir struct {
-	sm  *storage.Manager
-	log *log.Logger
-	wm  *wal.WALManager
+	sm       Storage
+	log      *log.Logger
+	wm       *wal.WALManager
+	nodes    *nodes
+	names    *fileNames
+	readOnly bool // see WithReadOnly
+
+	flushPolicy FlushPolicy
 }
 
 var _ fs.Node = (*Dir)(nil)
@@ -58,6 +248,7 @@ var _ fs.NodeStringLookuper = (*Dir)(nil)
 var _ fs.HandleReadDirAller = (*Dir)(nil)
 var _ fs.NodeCreater = (*Dir)(nil)
 var _ fs.NodeRemover = (*Dir)(nil)
+var _ fs.NodeRenamer = (*Dir)(nil)
 
 func (dir Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	dir.log.Debug("Getting directory attributes")
@@ -73,12 +264,12 @@ func (dir Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 func (dir Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	dir.log.Debug("Looking up file", "name", name)
 
-	if !checkValidExtension(name) {
+	if !dir.names.isValid(name) {
 		dir.log.Error("File has invalid extension", "name", name)
 		return nil, syscall.ENOENT
 	}
 
-	if wal.IsWALFile(name) {
+	if dir.names.isWAL(name) {
 		exists, err := dir.wm.Exists(name)
 		if err != nil {
 			dir.log.Error("Failed to check if WAL file exists", "name", name, "error", err)
@@ -89,6 +280,10 @@ func (dir Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 			return nil, syscall.ENOENT
 		}
 
+		if file := dir.nodes.get(name, 0); file != nil {
+			return file, nil
+		}
+
 		size, err := dir.wm.GetFileSize(name)
 		if err != nil {
 			dir.log.Error("Failed to get WAL file size", "name", name, "error", err)
@@ -103,16 +298,31 @@ func (dir Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 
 		now := time.Now()
 		file := &File{
-			name:     name,
-			created:  modTime,
-			modified: modTime,
-			accessed: now,
-			fileSize: size,
-			sm:       dir.sm,
-			log:      dir.log,
-			wm:       dir.wm,
+			name:        name,
+			created:     modTime,
+			modified:    modTime,
+			accessed:    now,
+			fileSize:    size,
+			sm:          dir.sm,
+			log:         dir.log,
+			wm:          dir.wm,
+			nodes:       dir.nodes,
+			names:       dir.names,
+			readOnly:    dir.readOnly,
+			flushPolicy: dir.flushPolicy,
 		}
+		return dir.nodes.getOrPut(file), nil
+	}
+
+	fileID, err := dir.sm.GetFileID(ctx, name)
+	if err != nil {
+		if err == types.ErrNotFound {
+			return nil, syscall.ENOENT
+		}
+		return nil, err
+	}
 
+	if file := dir.nodes.get(name, fileID); file != nil {
 		return file, nil
 	}
 
@@ -124,19 +334,32 @@ func (dir Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return nil, err
 	}
 
-	now := time.Now()
+	attr, err := dir.sm.GetFileAttr(ctx, name)
+	if err != nil {
+		if err == types.ErrNotFound {
+			return nil, syscall.ENOENT
+		}
+		return nil, err
+	}
+
 	file := &File{
-		name:     name,
-		created:  now,
-		modified: now,
-		accessed: now,
-		fileSize: size,
-		sm:       dir.sm,
-		log:      dir.log,
-		wm:       dir.wm,
+		name:        name,
+		fileID:      fileID,
+		created:     attr.CreatedAt,
+		modified:    attr.ModifiedAt,
+		accessed:    time.Now(),
+		fileSize:    size,
+		sm:          dir.sm,
+		log:         dir.log,
+		wm:          dir.wm,
+		nodes:       dir.nodes,
+		names:       dir.names,
+		readOnly:    dir.readOnly,
+		flushPolicy: dir.flushPolicy,
 	}
 
-	return file, nil
+	// Another lookup or the create of the file may have added a node since the get above
+	return dir.nodes.getOrPut(file), nil
 }
 
 func (dir Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
@@ -153,10 +376,13 @@ func (dir Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		all = append(all, fuse.Dirent{Name: file.Name, Type: fuse.DT_File})
 	}
 
-	walFiles, err := dir.wm.ListWALFiles()
-	if err != nil {
-		dir.log.Error("Failed to list WAL files", "error", err)
-		return nil, err
+	var walFiles []string
+	if dir.names.walEnabled() {
+		walFiles, err = dir.wm.ListWALFiles()
+		if err != nil {
+			dir.log.Error("Failed to list WAL files", "error", err)
+			return nil, err
+		}
 	}
 
 	for _, walFile := range walFiles {
@@ -170,18 +396,22 @@ func (dir Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 func (dir Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	dir.log.Debug("Directory received remove request", "name", req.Name)
 
+	if dir.readOnly {
+		return syscall.EROFS
+	}
+
 	// For directories, we would check req.Dir, but we don't support directory removal yet
 	if req.Dir {
 		dir.log.Warn("Directory removal not supported", "name", req.Name)
 		return syscall.ENOSYS // Operation not supported
 	}
 
-	if !checkValidExtension(req.Name) {
+	if !dir.names.isValid(req.Name) {
 		dir.log.Error("File has invalid extension", "name", req.Name)
 		return syscall.EINVAL
 	}
 
-	if !wal.IsWALFile(req.Name) {
+	if !dir.names.isWAL(req.Name) {
 		dir.log.Error("File removal is only supported for WAL files for now", "name", req.Name)
 		return syscall.ENOSYS
 	}
@@ -196,15 +426,83 @@ func (dir Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	return nil
 }
 
+// Rename renames a file, replacing the file named req.NewName if there is one, e.g. when a
+// temporary file is renamed over the file it replaces. WAL files can only be renamed to WAL
+// file names, and database files to database file names. Renaming a database file to
+// "<name>@<tag>" checkpoints it as version tag instead, see checkpointRenameSep.
+func (dir Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	dir.log.Debug("Directory received rename request", "name", req.OldName, "newName", req.NewName)
+
+	if dir.readOnly {
+		return syscall.EROFS
+	}
+
+	if _, ok := newDir.(Dir); !ok {
+		return syscall.EXDEV
+	}
+
+	if tag, ok := checkpointTag(req.OldName, req.NewName); ok {
+		return dir.checkpointByRename(ctx, req.OldName, tag)
+	}
+
+	if !dir.names.isValid(req.OldName) || !dir.names.isValid(req.NewName) {
+		dir.log.Error("File has invalid extension", "name", req.OldName, "newName", req.NewName)
+		return syscall.EINVAL
+	}
+
+	if dir.names.isWAL(req.OldName) != dir.names.isWAL(req.NewName) {
+		dir.log.Error("Cannot rename between WAL and database files", "name", req.OldName, "newName", req.NewName)
+		return syscall.EINVAL
+	}
+
+	if dir.names.isWAL(req.OldName) {
+		err := dir.wm.Rename(req.OldName, req.NewName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return syscall.ENOENT
+			}
+			dir.log.Error("Failed to rename WAL file", "name", req.OldName, "newName", req.NewName, "error", err)
+			return err
+		}
+	} else {
+		err := dir.sm.RenameFile(ctx, req.OldName, req.NewName)
+		if err != nil {
+			if err == types.ErrNotFound {
+				return syscall.ENOENT
+			}
+			// mv falls back to copying the file over to the new name
+			if errors.Is(err, types.ErrCrossShard) {
+				return syscall.EXDEV
+			}
+			dir.log.Error("Failed to rename file", "name", req.OldName, "newName", req.NewName, "error", err)
+			return err
+		}
+	}
+
+	// The kernel keeps using the node it looked up under the old name
+	dir.nodes.rename(req.OldName, req.NewName)
+
+	dir.log.Info("File renamed successfully", "name", req.OldName, "newName", req.NewName)
+	return nil
+}
+
+// Create creates a file, or a WAL file in the WAL manager. A database file is inserted along
+// with its mode in one transaction, so it is visible to Lookup and ReadDirAll as soon as it's
+// fully set up: empty until the first write. A lookup racing with the create gets the same
+// node as the create.
 func (dir Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
 	dir.log.Info("Creating file", "filename", req.Name, "flags", req.Flags, "mode", req.Mode)
 
-	if !checkValidExtension(req.Name) {
+	if dir.readOnly {
+		return nil, nil, syscall.EROFS
+	}
+
+	if !dir.names.isValid(req.Name) {
 		dir.log.Info("Rejecting file with invalid extension", "filename", req.Name)
 		return nil, nil, syscall.EINVAL
 	}
 
-	if wal.IsWALFile(req.Name) {
+	if dir.names.isWAL(req.Name) {
 		dir.log.Info("Creating WAL file", "filename", req.Name)
 
 		err := dir.wm.Create(req.Name)
@@ -215,81 +513,189 @@ func (dir Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.C
 
 		now := time.Now()
 		walFile := &File{
-			name:     req.Name,
-			created:  now,
-			modified: now,
-			accessed: now,
-			fileSize: 0,
-			sm:       dir.sm,
-			log:      dir.log,
-			wm:       dir.wm,
+			name:        req.Name,
+			created:     now,
+			modified:    now,
+			accessed:    now,
+			fileSize:    0,
+			sm:          dir.sm,
+			log:         dir.log,
+			wm:          dir.wm,
+			nodes:       dir.nodes,
+			names:       dir.names,
+			readOnly:    dir.readOnly,
+			flushPolicy: dir.flushPolicy,
 		}
+		dir.nodes.put(walFile)
 
 		dir.log.Debug("WAL file created successfully", "filename", req.Name)
 		return walFile, walFile, nil
 	}
 
-	_, err := dir.sm.InsertFile(ctx, req.Name)
+	var insertOpts []storage.InsertFileOpt
+	if mode := req.Mode.Perm() &^ req.Umask.Perm(); mode != defaultFileMode {
+		insertOpts = append(insertOpts, storage.WithFileMode(mode))
+	}
+
+	fileID, err := dir.sm.InsertFile(ctx, req.Name, insertOpts...)
 	if err != nil {
 		dir.log.Error("Failed to insert file into database", "name", req.Name, "error", err)
+		switch {
+		case errors.Is(err, types.ErrFileExists):
+			// e.g. created by another node since the kernel looked the name up
+			return nil, nil, syscall.EEXIST
+		case errors.Is(err, types.ErrInvalidFilename):
+			return nil, nil, syscall.EINVAL
+		}
+		return nil, nil, err
+	}
+
+	attr, err := dir.sm.GetFileAttr(ctx, req.Name)
+	if err != nil {
+		dir.log.Error("Failed to get file attributes", "name", req.Name, "error", err)
 		return nil, nil, err
 	}
 
-	now := time.Now()
 	file := &File{
-		name:     req.Name,
-		created:  now,
-		modified: now,
-		accessed: now,
-		fileSize: 0,
-		sm:       dir.sm,
-		log:      dir.log,
-		wm:       dir.wm,
+		name:        req.Name,
+		fileID:      fileID,
+		created:     attr.CreatedAt,
+		modified:    attr.ModifiedAt,
+		accessed:    time.Now(),
+		fileSize:    0,
+		sm:          dir.sm,
+		log:         dir.log,
+		wm:          dir.wm,
+		nodes:       dir.nodes,
+		names:       dir.names,
+		readOnly:    dir.readOnly,
+		flushPolicy: dir.flushPolicy,
 	}
+	// A lookup racing with the create may have added a node for the file already
+	file = dir.nodes.getOrPut(file)
 
 	dir.log.Debug("File created successfully", "filename", req.Name)
 	return file, file, nil
 }
 
-// checkValidExtension checks if the file has a valid extension (.duckdb or .duckdb.wal)
-func checkValidExtension(filename string) bool {
-	return filename == "duckdb.wal" || filename == "duckdb" || filename == "tmp" ||
-		(len(filename) > 0 && (filename[0] != '.' && (strings.HasSuffix(filename, ".duckdb") ||
-			strings.HasSuffix(filename, ".duckdb.wal"))))
+// DefaultExtensions are the file names accepted by default: DuckDB database and WAL files, and
+// the names DuckDB probes for when opening a database. Entries starting with a dot are
+// extensions, they match names ending with them (except hidden files) and the extension
+// without its dot (e.g. "duckdb" for ".duckdb"). Other entries match that exact name.
+var DefaultExtensions = []string{".duckdb", ".duckdb.wal", "tmp"}
+
+// fileNames decides which files can be created and which of them are WAL files. A nil
+// *fileNames accepts the default extensions and handles WAL files.
+type fileNames struct {
+	extensions []string
+	wal        bool
+}
+
+func (n *fileNames) isValid(filename string) bool {
+	if n == nil {
+		return checkValidExtension(filename, DefaultExtensions)
+	}
+	return checkValidExtension(filename, n.extensions)
+}
+
+func (n *fileNames) walEnabled() bool {
+	return n == nil || n.wal
+}
+
+func (n *fileNames) isWAL(filename string) bool {
+	return n.walEnabled() && wal.IsWALFile(filename)
 }
 
+// checkValidExtension checks if the file name matches one of extensions (see DefaultExtensions)
+func checkValidExtension(filename string, extensions []string) bool {
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ".") {
+			if filename == ext {
+				return true
+			}
+			continue
+		}
+
+		if filename == ext[1:] || (!strings.HasPrefix(filename, ".") && strings.HasSuffix(filename, ext)) {
+			return true
+		}
+	}
+	return false
+}
+
+// File is a node for a database or WAL file.
+//
+// Database file nodes remember the ID of the file they were looked up or created with.
+// If the file has since vanished (e.g. it was removed, or replaced by a new file with the
+// same name, by another node or before a restart), operations through the node fail with
+// ESTALE instead of silently reading or writing a different file. Reads, writes and
+// attribute lookups tell from the file ID the storage resolves anyway, other operations
+// from whether the node was invalidated by a rename replacing its file.
 type File struct {
+	mu       sync.RWMutex // guards name, which changes when the file is renamed
 	name     string
+	fileID   uint64      // 0 for WAL files
+	stale    atomic.Bool // set when the file is replaced by a rename
 	created  time.Time
 	modified time.Time
 	accessed time.Time
 	fileSize uint64
-	sm       *storage.Manager
+	sm       Storage
 	log      *log.Logger
 	wm       *wal.WALManager
+	nodes    *nodes
+	names    *fileNames
+	readOnly bool // see WithReadOnly
+
+	flushPolicy FlushPolicy
 }
 
 var _ fs.Node = (*File)(nil)
 var _ fs.NodeOpener = (*File)(nil)
 var _ fs.NodeFsyncer = (*File)(nil)
+var _ fs.HandleFlusher = (*File)(nil)
 var _ fs.NodeRemover = (*File)(nil)
+var _ fs.NodeForgetter = (*File)(nil)
+var _ fs.NodeSetattrer = (*File)(nil)
+
+// defaultFileMode is the mode of database files, unless changed
+const defaultFileMode = 0644
+
+func (f *File) getName() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.name
+}
+
+func (f *File) setName(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.name = name
+}
+
+// Forget is called when the kernel doesn't reference the node anymore
+func (f *File) Forget() {
+	f.nodes.forget(f)
+}
 
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	f.log.Debug("Getting file attributes", "name", f.name)
+	name := f.getName()
+
+	f.log.Debug("Getting file attributes", "name", name)
 
-	if !checkValidExtension(f.name) {
-		f.log.Error("File has invalid extension", "name", f.name)
+	if !f.names.isValid(name) {
+		f.log.Error("File has invalid extension", "name", name)
 		return syscall.EINVAL
 	}
 
-	if wal.IsWALFile(f.name) {
-		size, err := f.wm.GetFileSize(f.name)
+	if f.names.isWAL(name) {
+		size, err := f.wm.GetFileSize(name)
 		if err != nil {
-			f.log.Error("Failed to get WAL file size", "name", f.name, "error", err)
+			f.log.Error("Failed to get WAL file size", "name", name, "error", err)
 			return err
 		}
 
-		modTime, err := f.wm.GetModTime(f.name)
+		modTime, err := f.wm.GetModTime(name)
 		if err != nil {
 			if os.IsNotExist(err) {
 				a.Mode = 0644
@@ -300,7 +706,7 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 				a.Valid = 1 * time.Second
 				return nil
 			}
-			f.log.Error("Failed to get WAL file mod time", "name", f.name, "error", err)
+			f.log.Error("Failed to get WAL file mod time", "name", name, "error", err)
 			return err
 		}
 
@@ -311,74 +717,163 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 		a.Atime = time.Now()
 		a.Valid = 1 * time.Second
 
-		f.log.Debug("Retrieved WAL file attributes", "name", f.name, "size", a.Size)
+		f.log.Debug("Retrieved WAL file attributes", "name", name, "size", a.Size)
 		return nil
 	}
 
-	size, err := f.sm.SizeOf(ctx, f.name)
+	attr, err := f.sm.GetFileAttr(ctx, name)
+	if err == types.ErrNotFound || (err == nil && attr.ID != f.fileID) {
+		f.log.Warn("Stale file handle", "name", name, "fileID", f.fileID)
+		return syscall.ESTALE
+	}
+	if err != nil {
+		f.log.Error("Failed to get file attributes", "name", name, "error", err)
+		return err
+	}
+
+	size, err := f.sm.SizeOf(ctx, name)
 	if err != nil {
-		f.log.Error("Failed to get file size", "name", f.name, "error", err)
+		f.log.Error("Failed to get file size", "name", name, "error", err)
 		return err
 	}
 
-	a.Mode = 0644
+	// Writes that weren't checkpointed yet only change the modification time in memory
+	modified := f.modified
+	if attr.ModifiedAt.After(modified) {
+		modified = attr.ModifiedAt
+	}
+
+	a.Mode = attr.Mode
 	a.Size = size
-	a.Mtime = f.modified
-	a.Ctime = f.created
+	a.Mtime = modified
+	a.Ctime = attr.CreatedAt
 	a.Atime = f.accessed
 	a.Valid = 1 * time.Second
 
-	f.log.Debug("Retrieved file attributes", "name", f.name, "size", a.Size)
+	f.log.Debug("Retrieved file attributes", "name", name, "size", a.Size)
+	return nil
+}
+
+// Setattr persists mode and modification time changes of database files (e.g. chmod and touch).
+// Other changes, and any change to WAL files, are ignored.
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	name := f.getName()
+
+	f.log.Debug("Setting file attributes", "name", name, "valid", req.Valid)
+
+	if f.readOnly {
+		return syscall.EROFS
+	}
+
+	if f.names.isWAL(name) {
+		return f.Attr(ctx, &resp.Attr)
+	}
+
+	if err := f.checkStale(); err != nil {
+		return err
+	}
+
+	if req.Valid.Mode() {
+		if err := f.sm.SetFileMode(ctx, name, req.Mode); err != nil {
+			f.log.Error("Failed to set file mode", "name", name, "error", err)
+			return err
+		}
+	}
+
+	if req.Valid.Mtime() || req.Valid.MtimeNow() {
+		modTime := req.Mtime
+		if req.Valid.MtimeNow() {
+			modTime = time.Now()
+		}
+
+		if err := f.sm.SetFileModTime(ctx, name, modTime); err != nil {
+			f.log.Error("Failed to set file modification time", "name", name, "error", err)
+			return err
+		}
+		f.modified = modTime
+	}
+
+	return f.Attr(ctx, &resp.Attr)
+}
+
+// checkStale returns ESTALE if the node was invalidated because a rename replaced its file
+func (f *File) checkStale() error {
+	if f.stale.Load() {
+		f.log.Warn("Stale file handle", "name", f.getName(), "fileID", f.fileID)
+		return syscall.ESTALE
+	}
 	return nil
 }
 
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-	f.log.Debug("Opening file", "name", f.name, "flags", req.Flags)
+	name := f.getName()
+
+	f.log.Debug("Opening file", "name", name, "flags", req.Flags)
+
+	if f.readOnly && !req.Flags.IsReadOnly() {
+		return nil, syscall.EROFS
+	}
+
 	return f, nil
 }
 
 func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	f.log.Debug("Reading file", "name", f.name, "offset", req.Offset, "size", req.Size)
+	name := f.getName()
 
-	if !checkValidExtension(f.name) {
-		f.log.Error("File has invalid extension", "name", f.name)
+	f.log.Debug("Reading file", "name", name, "offset", req.Offset, "size", req.Size)
+
+	if !f.names.isValid(name) {
+		f.log.Error("File has invalid extension", "name", name)
 		return syscall.EINVAL
 	}
 
-	if wal.IsWALFile(f.name) {
-		f.log.Debug("Reading WAL file", "name", f.name)
-		data, err := f.wm.Read(f.name, uint64(req.Offset), uint64(req.Size))
+	if f.names.isWAL(name) {
+		f.log.Debug("Reading WAL file", "name", name)
+		data, err := f.wm.Read(name, uint64(req.Offset), uint64(req.Size))
 		if err != nil {
-			f.log.Error("Failed to read WAL file", "name", f.name, "error", err)
+			f.log.Error("Failed to read WAL file", "name", name, "error", err)
 			return err
 		}
 		resp.Data = data
-		f.log.Debug("Read successful for WAL file", "name", f.name, "bytesRead", len(resp.Data))
+		f.log.Debug("Read successful for WAL file", "name", name, "bytesRead", len(resp.Data))
 		return nil
 	}
 
-	data, err := f.sm.ReadFile(ctx, f.name, uint64(req.Offset), uint64(req.Size))
+	data, err := f.sm.ReadFile(ctx, name, uint64(req.Offset), uint64(req.Size), storage.WithReadFileID(f.fileID))
 	if err != nil {
-		f.log.Error("Failed to read data", "name", f.name, "error", err)
+		if errors.Is(err, types.ErrStaleFile) {
+			return syscall.ESTALE
+		}
+		f.log.Error("Failed to read data", "name", name, "error", err)
 		return err
 	}
 
 	resp.Data = data
-	f.log.Debug("Read successful", "name", f.name, "bytesRead", len(resp.Data))
+	f.log.Debug("Read successful", "name", name, "bytesRead", len(resp.Data))
 	return nil
 }
 
 func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
-	if !checkValidExtension(f.name) {
-		f.log.Error("File has invalid extension", "name", f.name)
+	name := f.getName()
+
+	if f.readOnly {
+		f.log.Debug("Rejecting write to read-only file system", "name", name)
+		return syscall.EROFS
+	}
+
+	if !f.names.isValid(name) {
+		f.log.Error("File has invalid extension", "name", name)
 		return syscall.EINVAL
 	}
 
-	if wal.IsWALFile(f.name) {
-		f.log.Info("Writing WAL file", "name", f.name, "size", len(req.Data), "offset", req.Offset, "flags", req.FileFlags)
-		bytesWritten, err := f.wm.Write(f.name, req.Data, uint64(req.Offset))
+	if f.names.isWAL(name) {
+		f.log.Info("Writing WAL file", "name", name, "size", len(req.Data), "offset", req.Offset, "flags", req.FileFlags)
+		bytesWritten, err := f.wm.Write(name, req.Data, uint64(req.Offset))
 		if err != nil {
-			f.log.Error("Failed to write WAL file", "name", f.name, "error", err)
+			f.log.Error("Failed to write WAL file", "name", name, "error", err)
+			if errors.Is(err, wal.ErrWALFull) {
+				return syscall.ENOSPC
+			}
 			return fmt.Errorf("failed to write WAL data: %v", err)
 		}
 
@@ -386,14 +881,29 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.Wri
 		f.modified = time.Now()
 
 		resp.Size = bytesWritten
-		f.log.Debug("Write successful for WAL file", "name", f.name, "bytesWritten", resp.Size)
+		f.log.Debug("Write successful for WAL file", "name", name, "bytesWritten", resp.Size)
 		return nil
 	}
 
-	f.log.Info("Writing to database file", "name", f.name, "size", len(req.Data), "offset", req.Offset, "flags", req.FileFlags)
-	err := f.sm.WriteFile(ctx, f.name, req.Data, uint64(req.Offset))
+	f.log.Info("Writing to database file", "name", name, "size", len(req.Data), "offset", req.Offset, "flags", req.FileFlags)
+	// Like on any POSIX filesystem, writing past the end of the file zero-fills the gap
+	writeOpts := []storage.WriteOpt{storage.WithZeroFill(true), storage.WithWriteFileID(f.fileID), storage.WithWriteOrigin(uint64(req.ID), req.Pid)}
+	appending := req.FileFlags&fuse.OpenAppend != 0
+	if appending {
+		// The offset is the end of the file as the kernel last knew it, which other handles
+		// (or nodes) may have moved since
+		writeOpts = append(writeOpts, storage.WithAppend())
+	}
+	err := f.sm.WriteFile(ctx, name, req.Data, uint64(req.Offset), writeOpts...)
 	if err != nil {
-		f.log.Error("Failed to write data", "name", f.name, "error", err)
+		if errors.Is(err, types.ErrStaleFile) {
+			return syscall.ESTALE
+		}
+		// The file is being compacted or reverted
+		if errors.Is(err, types.ErrFileBusy) {
+			return syscall.EBUSY
+		}
+		f.log.Error("Failed to write data", "name", name, "error", err)
 		// Check if this is a read-only error due to head being set
 		if strings.Contains(err.Error(), "read-only mode because a head is set") {
 			return syscall.EROFS // Return read-only filesystem error
@@ -402,25 +912,58 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.Wri
 	}
 
 	f.fileSize = uint64(req.Offset) + uint64(len(req.Data))
+	if appending {
+		if size, err := f.sm.SizeOf(ctx, name); err == nil {
+			f.fileSize = size
+		}
+	}
 	f.modified = time.Now()
 
 	resp.Size = len(req.Data)
-	f.log.Debug("Write successful", "name", f.name, "bytesWritten", resp.Size)
+	f.log.Debug("Write successful", "name", name, "bytesWritten", resp.Size)
 	return nil
 }
 
 func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	f.log.Debug("Releasing file", "name", f.name, "flags", req.Flags)
+	name := f.getName()
+
+	f.log.Debug("Releasing file", "name", name, "flags", req.Flags)
+	return nil
+}
+
+// Flush is called when a file descriptor of the file is closed, which can happen several
+// times per open (e.g. after dup). With FlushCheckpoint, the uncommitted writes of database
+// files are checkpointed, Flush doing nothing if there are none.
+func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	name := f.getName()
+
+	f.log.Debug("Flushing file", "name", name, "policy", f.flushPolicy)
+
+	if f.flushPolicy != FlushCheckpoint || f.readOnly || f.names.isWAL(name) {
+		return nil
+	}
+
+	if err := f.checkStale(); err != nil {
+		return err
+	}
+
+	if err := f.sm.Flush(ctx, name); err != nil {
+		f.log.Error("Failed to checkpoint file on flush", "name", name, "error", err)
+		return err
+	}
+
 	return nil
 }
 
 func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
-	f.log.Debug("Syncing file", "name", f.name)
+	name := f.getName()
+
+	f.log.Debug("Syncing file", "name", name)
 
-	if wal.IsWALFile(f.name) {
-		err := f.wm.Sync(f.name)
+	if f.names.isWAL(name) {
+		err := f.wm.Sync(name)
 		if err != nil {
-			f.log.Error("Failed to sync WAL file", "name", f.name, "error", err)
+			f.log.Error("Failed to sync WAL file", "name", name, "error", err)
 			return err
 		}
 	}
@@ -429,24 +972,30 @@ func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 }
 
 func (f *File) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
-	f.log.Debug("Removing file", "name", f.name)
+	name := f.getName()
+
+	f.log.Debug("Removing file", "name", name)
+
+	if f.readOnly {
+		return syscall.EROFS
+	}
 
-	if !checkValidExtension(f.name) {
-		f.log.Error("File has invalid extension", "name", f.name)
+	if !f.names.isValid(name) {
+		f.log.Error("File has invalid extension", "name", name)
 		return syscall.EINVAL
 	}
 
-	if !wal.IsWALFile(f.name) {
-		f.log.Error("File removal is only supported for WAL files for now", "name", f.name)
+	if !f.names.isWAL(name) {
+		f.log.Error("File removal is only supported for WAL files for now", "name", name)
 		return syscall.EINVAL
 	}
 
-	err := f.wm.Remove(ctx, f.name)
+	err := f.wm.Remove(ctx, name)
 	if err != nil {
-		f.log.Error("Failed to remove WAL file", "name", f.name, "error", err)
+		f.log.Error("Failed to remove WAL file", "name", name, "error", err)
 		return err
 	}
 
-	f.log.Info("WAL file removed successfully", "name", f.name)
+	f.log.Info("WAL file removed successfully", "name", name)
 	return nil
 }
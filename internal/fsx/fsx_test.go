@@ -1,16 +1,22 @@
 package fsx
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/charmbracelet/log"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vinimdocarmo/quackfs/internal/quackfstest"
 	"github.com/vinimdocarmo/quackfs/internal/storage"
@@ -39,6 +45,7 @@ func TestWriteBeyondFileSize(t *testing.T) {
 	// Create a FUSE file instance
 	file := &File{
 		name:     filename,
+		fileID:   fileID,
 		created:  time.Now(),
 		modified: time.Now(),
 		accessed: time.Now(),
@@ -111,6 +118,422 @@ func TestFileEmptyWriteNonZeroOffset(t *testing.T) {
 	require.Equal(t, "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00hello", string(data))
 }
 
+func TestCheckValidExtension(t *testing.T) {
+	tests := []struct {
+		filename string
+		valid    bool
+	}{
+		// Short names must not panic
+		{"", false},
+		{"x", false},
+		{"ab", false},
+		{"a.d", false},
+		{"a.db", false},
+		{"a.duc", false},
+		{"abcdef", false},
+		// Special cases
+		{"duckdb", true},
+		{"duckdb.wal", true},
+		{"tmp", true},
+		// At the boundaries of the suffixes
+		{"duckdb.", false},
+		{"a.duckdb", true},
+		{"xduckdb", false},
+		{"a.duckdb.wal", true},
+		{"xduckdb.wal", false},
+		{"a.duckdb.wa", false},
+		// Hidden dotfiles are rejected
+		{".duckdb", false},
+		{".duckdb.wal", false},
+		{".a.duckdb", false},
+		{".a.duckdb.wal", false},
+		// Other extensions
+		{"a.sqlite", false},
+		{"a.duckdb.tmp", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			require.NotPanics(t, func() {
+				require.Equal(t, tt.valid, checkValidExtension(tt.filename, DefaultExtensions))
+			})
+		})
+	}
+}
+
+func TestCustomExtensions(t *testing.T) {
+	fsys := NewFS(nil, logger.New(os.Stderr), t.TempDir(), WithExtensions(".sqlite", ".sqlite-wal"), WithoutWAL())
+
+	tests := []struct {
+		filename string
+		valid    bool
+	}{
+		{"a.sqlite", true},
+		{"a.sqlite-wal", true},
+		{"sqlite", true},
+		{".a.sqlite", false},
+		{"a.sqlite.tmp", false},
+		{"a.duckdb", false},
+		{"a.duckdb.wal", false},
+		{"tmp", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			require.Equal(t, tt.valid, fsys.names.isValid(tt.filename))
+			require.False(t, fsys.names.isWAL(tt.filename), "WAL handling is disabled")
+		})
+	}
+
+	// Rejected before reaching the storage manager
+	root, err := fsys.Root()
+	require.NoError(t, err)
+
+	_, _, err = root.(Dir).Create(context.Background(), &fuse.CreateRequest{Name: "a.duckdb"}, &fuse.CreateResponse{})
+	require.ErrorIs(t, err, syscall.EINVAL)
+
+	_, err = root.(Dir).Lookup(context.Background(), "a.duckdb")
+	require.ErrorIs(t, err, syscall.ENOENT)
+
+	// The defaults are unchanged
+	fsys = NewFS(nil, logger.New(os.Stderr), t.TempDir())
+	require.True(t, fsys.names.isValid("a.duckdb"))
+	require.True(t, fsys.names.isWAL("a.duckdb.wal"))
+	require.False(t, fsys.names.isValid("a.sqlite"))
+}
+
+// TestCreateExistingFile tests that creating a file another node created returns EEXIST
+func TestCreateExistingFile(t *testing.T) {
+	sm, log, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "test_create_existing.duckdb"
+
+	// The other node's insert isn't known to this one, as if it raced the kernel's lookup
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	root, err := NewFS(sm, log, t.TempDir()).Root()
+	require.NoError(t, err)
+
+	_, _, err = root.(Dir).Create(ctx, &fuse.CreateRequest{Name: filename, Mode: 0644}, &fuse.CreateResponse{})
+	require.ErrorIs(t, err, syscall.EEXIST)
+}
+
+// TestConcurrentCreateAndLookup tests that racing creates and lookups of a file agree on it
+func TestConcurrentCreateAndLookup(t *testing.T) {
+	sm, log, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "test_concurrent_create.duckdb"
+
+	root, err := NewFS(sm, log, t.TempDir()).Root()
+	require.NoError(t, err)
+	dir := root.(Dir)
+
+	const workers = 8
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+		seen    = map[*File]bool{}
+	)
+	for i := range workers * 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var node fs.Node
+			var err error
+			if i%2 == 0 {
+				node, _, err = dir.Create(ctx, &fuse.CreateRequest{Name: filename, Mode: 0600}, &fuse.CreateResponse{})
+				if errors.Is(err, syscall.EEXIST) {
+					return
+				}
+			} else {
+				node, err = dir.Lookup(ctx, filename)
+				if errors.Is(err, syscall.ENOENT) {
+					return
+				}
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Whoever sees the file sees it fully created
+			var attr fuse.Attr
+			assert.NoError(t, node.Attr(ctx, &attr))
+			assert.Equal(t, os.FileMode(0600), attr.Mode.Perm())
+			assert.Zero(t, attr.Size)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if i%2 == 0 {
+				created++
+			}
+			seen[node.(*File)] = true
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, created, "exactly one create should succeed")
+	assert.Len(t, seen, 1, "every create and lookup should return the same node")
+
+	node, err := dir.Lookup(ctx, filename)
+	require.NoError(t, err)
+	assert.True(t, seen[node.(*File)])
+}
+
+// TestStaleFileHandleAfterRestart tests that a handle to a file that vanished returns ESTALE
+func TestStaleFileHandleAfterRestart(t *testing.T) {
+	sm, log, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// Create a file that is never checkpointed and get a handle to it
+	filename := "test_stale_handle.duckdb"
+	fileID, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	file := &File{
+		name:     filename,
+		fileID:   fileID,
+		created:  time.Now(),
+		modified: time.Now(),
+		accessed: time.Now(),
+		sm:       sm,
+		log:      log,
+	}
+
+	err = file.Write(ctx, &fuse.WriteRequest{Data: []byte("uncheckpointed")}, &fuse.WriteResponse{})
+	require.NoError(t, err)
+
+	// Simulate a restart after which the file doesn't exist anymore
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+	_, err = db.Exec("DELETE FROM files WHERE id = $1", fileID)
+	require.NoError(t, err)
+
+	sm2, sm2Cleanup := quackfstest.SetupStorageManager(t)
+	defer sm2Cleanup()
+	file.sm = sm2
+
+	err = file.Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 10}, &fuse.ReadResponse{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	err = file.Attr(ctx, &fuse.Attr{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	// A new file with the same name is a different file, the old handle is still stale
+	_, err = sm2.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	err = file.Write(ctx, &fuse.WriteRequest{Data: []byte("data")}, &fuse.WriteResponse{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	// Looking the file up again gives a working handle
+	node, err := Dir{sm: sm2, log: log, nodes: newNodes()}.Lookup(ctx, filename)
+	require.NoError(t, err)
+
+	err = node.(*File).Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 10}, &fuse.ReadResponse{})
+	require.NoError(t, err)
+}
+
+// TestStaleFileHandleAfterRename tests that a handle to a file replaced by a rename returns ESTALE
+func TestStaleFileHandleAfterRename(t *testing.T) {
+	sm, log, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	dir := Dir{sm: sm, log: log, nodes: newNodes()}
+
+	oldName := "test_stale_rename_a.duckdb"
+	newName := "test_stale_rename_b.duckdb"
+	for _, name := range []string{oldName, newName} {
+		_, err := sm.InsertFile(ctx, name)
+		require.NoError(t, err)
+		require.NoError(t, sm.WriteFile(ctx, name, []byte(name), 0))
+	}
+
+	renamed, err := dir.Lookup(ctx, oldName)
+	require.NoError(t, err)
+	replaced, err := dir.Lookup(ctx, newName)
+	require.NoError(t, err)
+
+	require.NoError(t, dir.Rename(ctx, &fuse.RenameRequest{OldName: oldName, NewName: newName}, dir))
+
+	replacedFile := replaced.(*File)
+	err = replacedFile.Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 10}, &fuse.ReadResponse{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	err = replacedFile.Write(ctx, &fuse.WriteRequest{Data: []byte("data")}, &fuse.WriteResponse{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	err = replacedFile.Attr(ctx, &fuse.Attr{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	err = replacedFile.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.test", Xattr: []byte("value")})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	err = replacedFile.Listxattr(ctx, &fuse.ListxattrRequest{}, &fuse.ListxattrResponse{})
+	require.ErrorIs(t, err, syscall.ESTALE)
+
+	// The renamed file's handle keeps working under its new name
+	resp := &fuse.ReadResponse{}
+	err = renamed.(*File).Read(ctx, &fuse.ReadRequest{Offset: 0, Size: len(oldName)}, resp)
+	require.NoError(t, err)
+	require.Equal(t, oldName, string(resp.Data))
+}
+
+// TestFileAttrPersistence tests that mode and modification time changes survive a remount
+func TestFileAttrPersistence(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+	log := logger.New(os.Stderr)
+
+	ctx := context.Background()
+	filename := "test_attr_persistence.duckdb"
+
+	root, err := NewFS(sm, log, t.TempDir()).Root()
+	require.NoError(t, err)
+
+	node, _, err := root.(Dir).Create(ctx, &fuse.CreateRequest{Name: filename, Mode: 0644}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	file := node.(*File)
+
+	var attr fuse.Attr
+	require.NoError(t, file.Attr(ctx, &attr))
+	require.Equal(t, os.FileMode(0644), attr.Mode)
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMode | fuse.SetattrMtime, Mode: 0600, Mtime: modTime}
+	resp := &fuse.SetattrResponse{}
+	require.NoError(t, file.Setattr(ctx, req, resp))
+	require.Equal(t, os.FileMode(0600), resp.Attr.Mode)
+
+	// Remount with a new storage manager on the same database
+	sm2 := storage.NewManager(quackfstest.SetupDB(t), store, log)
+	defer sm2.Close()
+
+	root, err = NewFS(sm2, log, t.TempDir()).Root()
+	require.NoError(t, err)
+
+	node, err = root.(Dir).Lookup(ctx, filename)
+	require.NoError(t, err)
+
+	attr = fuse.Attr{}
+	require.NoError(t, node.(*File).Attr(ctx, &attr))
+	require.Equal(t, os.FileMode(0600), attr.Mode)
+	require.True(t, modTime.Equal(attr.Mtime), "expected mtime %v, got %v", modTime, attr.Mtime)
+}
+
+// TestXattrPersistence tests that extended attributes survive a remount
+func TestXattrPersistence(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+	log := logger.New(os.Stderr)
+
+	ctx := context.Background()
+	filename := "test_xattr_persistence.duckdb"
+
+	root, err := NewFS(sm, log, t.TempDir()).Root()
+	require.NoError(t, err)
+
+	node, _, err := root.(Dir).Create(ctx, &fuse.CreateRequest{Name: filename, Mode: 0644}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	file := node.(*File)
+
+	err = file.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.version"}, &fuse.GetxattrResponse{})
+	require.ErrorIs(t, err, fuse.ErrNoXattr)
+
+	require.NoError(t, file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.version", Xattr: []byte("v1")}))
+	require.NoError(t, file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.version", Xattr: []byte("v2"), Flags: xattrReplace}))
+	require.NoError(t, file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.tmp", Xattr: []byte("x")}))
+	require.NoError(t, file.Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.tmp"}))
+
+	err = file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.version", Xattr: []byte("v3"), Flags: xattrCreate})
+	require.ErrorIs(t, err, syscall.EEXIST)
+	err = file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.missing", Xattr: []byte("v3"), Flags: xattrReplace})
+	require.ErrorIs(t, err, fuse.ErrNoXattr)
+	err = file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.big", Xattr: make([]byte, storage.MaxXattrValueSize+1)})
+	require.ErrorIs(t, err, syscall.E2BIG)
+
+	// Remount with a new storage manager on the same database
+	sm2 := storage.NewManager(quackfstest.SetupDB(t), store, log)
+	defer sm2.Close()
+
+	root, err = NewFS(sm2, log, t.TempDir()).Root()
+	require.NoError(t, err)
+
+	node, err = root.(Dir).Lookup(ctx, filename)
+	require.NoError(t, err)
+	file = node.(*File)
+
+	getResp := &fuse.GetxattrResponse{}
+	require.NoError(t, file.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.version"}, getResp))
+	require.Equal(t, []byte("v2"), getResp.Xattr)
+
+	listResp := &fuse.ListxattrResponse{}
+	require.NoError(t, file.Listxattr(ctx, &fuse.ListxattrRequest{}, listResp))
+	require.Equal(t, []byte("user.version\x00"), listResp.Xattr)
+}
+
+// TestHeadXattr tests that the head xattr reports the version reads return
+func TestHeadXattr(t *testing.T) {
+	sm, log, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "test_head_xattr.duckdb"
+
+	root, err := NewFS(sm, log, t.TempDir()).Root()
+	require.NoError(t, err)
+
+	node, _, err := root.(Dir).Create(ctx, &fuse.CreateRequest{Name: filename, Mode: 0644}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	file := node.(*File)
+
+	getHead := func() (string, error) {
+		resp := &fuse.GetxattrResponse{}
+		err := file.Getxattr(ctx, &fuse.GetxattrRequest{Name: headXattr}, resp)
+		return string(resp.Xattr), err
+	}
+
+	// No version yet
+	_, err = getHead()
+	require.ErrorIs(t, err, fuse.ErrNoXattr)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("v1 data"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("v2 data"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	head, err := getHead()
+	require.NoError(t, err)
+	require.Equal(t, "v2", head, "Without a head, the latest version should be reported")
+
+	require.NoError(t, sm.SetHead(ctx, filename, "v1"))
+	head, err = getHead()
+	require.NoError(t, err)
+	require.Equal(t, "v1", head)
+
+	listResp := &fuse.ListxattrResponse{}
+	require.NoError(t, file.Listxattr(ctx, &fuse.ListxattrRequest{}, listResp))
+	require.Equal(t, []byte(headXattr+"\x00"), listResp.Xattr)
+
+	err = file.Setxattr(ctx, &fuse.SetxattrRequest{Name: headXattr, Xattr: []byte("v2")})
+	require.ErrorIs(t, err, syscall.EPERM)
+	err = file.Removexattr(ctx, &fuse.RemovexattrRequest{Name: headXattr})
+	require.ErrorIs(t, err, syscall.EPERM)
+}
+
 // TestStorageCheckpointOnDuckDBCheckpoint tests removal of .duckdb.wal files with checkpointing
 func TestStorageCheckpointOnDuckDBCheckpoint(t *testing.T) {
 	if os.Getenv("TEST_FUSE_SKIP") == "true" {
@@ -170,15 +593,338 @@ func TestStorageCheckpointOnDuckDBCheckpoint(t *testing.T) {
 	}
 }
 
-// WaitForMount attempts to create a file in the mount directory to verify mount is ready
+// TestRenameFile tests renaming a database file over another one through the mount
+func TestRenameFile(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	mountDir, sm, cleanup, errChan := setupFuseMount(t)
+	defer cleanup()
+
+	oldPath := filepath.Join(mountDir, "a.duckdb")
+	newPath := filepath.Join(mountDir, "b.duckdb")
+
+	require.NoError(t, os.WriteFile(newPath, []byte("to be replaced"), 0644))
+
+	f, err := os.Create(oldPath)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("data of a"))
+	require.NoError(t, err)
+
+	require.NoError(t, os.Rename(oldPath, newPath))
+
+	// Handles opened before the rename keep working
+	_, err = f.Write([]byte(", written after the rename"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(newPath)
+	require.NoError(t, err)
+	require.Equal(t, "data of a, written after the rename", string(data))
+
+	_, err = os.Stat(oldPath)
+	require.True(t, os.IsNotExist(err), "The old name should not exist anymore")
+
+	files, err := sm.GetAllFiles(context.Background())
+	require.NoError(t, err)
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	require.Contains(t, names, "b.duckdb")
+	require.NotContains(t, names, "a.duckdb")
+
+	// Database and WAL files can't be renamed into each other
+	err = os.Rename(newPath, filepath.Join(mountDir, "b.duckdb.wal"))
+	require.Error(t, err)
+
+	select {
+	case err := <-errChan:
+		require.NoError(t, err, "FUSE server reported an error")
+	default:
+	}
+}
+
+// TestAppendWrites tests that writes through handles opened with O_APPEND go at the end of the file
+func TestAppendWrites(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	mountDir, _, cleanup, errChan := setupFuseMount(t)
+	defer cleanup()
+
+	path := filepath.Join(mountDir, "append.duckdb")
+	require.NoError(t, os.WriteFile(path, []byte("start;"), 0644))
+
+	a, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	defer b.Close()
+
+	// Each handle's writes land after the other's, not at the end either saw when opened
+	want := "start;"
+	for i := range 3 {
+		for name, f := range map[string]*os.File{"a": a, "b": b} {
+			line := fmt.Sprintf("%s%d;", name, i)
+			_, err := f.Write([]byte(line))
+			require.NoError(t, err)
+			want += line
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, want, string(data))
+
+	select {
+	case err := <-errChan:
+		require.NoError(t, err, "FUSE server reported an error")
+	default:
+	}
+}
+
+func TestCheckpointTag(t *testing.T) {
+	tests := []struct {
+		oldName string
+		newName string
+		tag     string
+		ok      bool
+	}{
+		{"db.duckdb", "db.duckdb@v2", "v2", true},
+		{"db.duckdb", "db.duckdb@", "", true},
+		{"db.duckdb", "db.duckdb@v2@v3", "v2@v3", true},
+		{"db.duckdb", "other.duckdb", "", false},
+		{"db.duckdb", "db.duckdb.tmp", "", false},
+		{"db.duckdb", "xdb.duckdb@v2", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.newName, func(t *testing.T) {
+			tag, ok := checkpointTag(tt.oldName, tt.newName)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.tag, tag)
+		})
+	}
+
+	require.False(t, validCheckpointTag.MatchString("v2@v3"))
+	require.False(t, validCheckpointTag.MatchString(".hidden"))
+	require.True(t, validCheckpointTag.MatchString("before-migration_1.0"))
+}
+
+// TestCheckpointByRename tests that renaming a file to <name>@<tag> creates version tag
+func TestCheckpointByRename(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	mountDir, sm, cleanup, errChan := setupFuseMount(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "test_checkpoint_rename.duckdb"
+	path := filepath.Join(mountDir, filename)
+
+	require.NoError(t, os.WriteFile(path, []byte("version two"), 0644))
+	require.NoError(t, os.Rename(path, path+"@v2"))
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, "v2", versions[0].Tag)
+
+	// The file keeps its name
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "version two", string(data))
+
+	// Tags must be unique and well formed
+	require.NoError(t, os.WriteFile(path, []byte("version three"), 0644))
+	require.ErrorIs(t, os.Rename(path, path+"@v2"), syscall.EEXIST)
+	require.ErrorIs(t, os.Rename(path, path+"@bad tag"), syscall.EINVAL)
+	require.ErrorIs(t, os.Rename(path, path+"@"), syscall.EINVAL)
+
+	select {
+	case err := <-errChan:
+		require.NoError(t, err, "FUSE server reported an error")
+	default:
+	}
+}
+
+// TestStatfs tests that the mount reports a block size and room to write
+func TestStatfs(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	mountDir, _, cleanup, errChan := setupFuseMount(t)
+	defer cleanup()
+
+	require.NoError(t, os.WriteFile(filepath.Join(mountDir, "statfs.duckdb"), make([]byte, 3*statfsBlockSize), 0644))
+
+	var stat syscall.Statfs_t
+	require.NoError(t, syscall.Statfs(mountDir, &stat))
+
+	require.Equal(t, int64(statfsBlockSize), int64(stat.Bsize))
+	require.Equal(t, uint64(defaultCapacity/statfsBlockSize), stat.Blocks)
+	require.LessOrEqual(t, stat.Bfree, stat.Blocks-3, "The written file should be counted as used space")
+	require.NotZero(t, stat.Bavail)
+	require.NotZero(t, stat.Files)
+
+	select {
+	case err := <-errChan:
+		require.NoError(t, err, "FUSE server reported an error")
+	default:
+	}
+}
+
+// fuseSuperMagic is the file system type statfs reports for FUSE mounts
+const fuseSuperMagic = 0x65735546
+
+// TestReadOnlyMount tests that files can be read but not changed on a read-only mount
+func TestReadOnlyMount(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	mountDir, sm, cleanup, errChan := setupFuseMount(t, WithReadOnly())
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "test_read_only.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("read only data"), 0))
+
+	data, err := os.ReadFile(filepath.Join(mountDir, filename))
+	require.NoError(t, err)
+	require.Equal(t, "read only data", string(data))
+
+	entries, err := os.ReadDir(mountDir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Contains(t, names, filename)
+
+	err = os.WriteFile(filepath.Join(mountDir, filename), []byte("changed"), 0644)
+	require.ErrorIs(t, err, syscall.EROFS)
+
+	_, err = os.Create(filepath.Join(mountDir, "test_read_only_new.duckdb"))
+	require.ErrorIs(t, err, syscall.EROFS)
+
+	err = os.Remove(filepath.Join(mountDir, filename))
+	require.ErrorIs(t, err, syscall.EROFS)
+
+	data, err = os.ReadFile(filepath.Join(mountDir, filename))
+	require.NoError(t, err)
+	require.Equal(t, "read only data", string(data), "The file should be unchanged")
+
+	select {
+	case err := <-errChan:
+		require.NoError(t, err, "FUSE server reported an error")
+	default:
+	}
+}
+
+func TestMaxWALSize(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	mountDir, _, cleanup, errChan := setupFuseMount(t, WithMaxWALSize(1024))
+	defer cleanup()
+
+	// WAL files live in the WAL path, not in the storage manager
+	walFile := fmt.Sprintf("test_max_wal_%d.duckdb.wal", time.Now().UnixNano())
+	defer os.Remove(filepath.Join("/tmp", walFile))
+
+	f, err := os.OpenFile(filepath.Join(mountDir, walFile), os.O_CREATE|os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteAt(bytes.Repeat([]byte("a"), 1000), 0)
+	require.NoError(t, err, "Writes under the cap should succeed")
+
+	_, err = f.WriteAt(bytes.Repeat([]byte("b"), 100), 1000)
+	require.ErrorIs(t, err, syscall.ENOSPC)
+
+	_, err = f.WriteAt(bytes.Repeat([]byte("c"), 24), 1000)
+	require.NoError(t, err, "Writes up to the cap should succeed")
+
+	select {
+	case err := <-errChan:
+		require.NoError(t, err, "FUSE server reported an error")
+	default:
+	}
+}
+
+// TestFlushPolicy tests that closing a written file checkpoints it with FlushCheckpoint only
+func TestFlushPolicy(t *testing.T) {
+	if os.Getenv("TEST_FUSE_SKIP") == "true" {
+		t.Skip("Skipping FUSE tests")
+	}
+
+	for _, tt := range []struct {
+		policy   FlushPolicy
+		versions int
+	}{
+		{FlushNone, 0},
+		{FlushCheckpoint, 1},
+	} {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			mountDir, sm, cleanup, errChan := setupFuseMount(t, WithFlushPolicy(tt.policy))
+			defer cleanup()
+
+			ctx := context.Background()
+			filename := fmt.Sprintf("test_flush_%s.duckdb", tt.policy)
+
+			f, err := os.OpenFile(filepath.Join(mountDir, filename), os.O_CREATE|os.O_RDWR, 0644)
+			require.NoError(t, err)
+			_, err = f.Write([]byte("closed data"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			versions, err := sm.GetFileVersions(ctx, filename)
+			require.NoError(t, err)
+			require.Len(t, versions, tt.versions)
+			if tt.versions > 0 {
+				require.Equal(t, storage.OriginFlush, versions[0].Origin)
+			}
+
+			// Closing an unmodified file makes no version
+			f, err = os.Open(filepath.Join(mountDir, filename))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			versions, err = sm.GetFileVersions(ctx, filename)
+			require.NoError(t, err)
+			require.Len(t, versions, tt.versions)
+
+			data, err := os.ReadFile(filepath.Join(mountDir, filename))
+			require.NoError(t, err)
+			require.Equal(t, "closed data", string(data))
+
+			select {
+			case err := <-errChan:
+				require.NoError(t, err, "FUSE server reported an error")
+			default:
+			}
+		})
+	}
+}
+
+// WaitForMount checks the file system type of the mount directory to verify mount is ready
 func waitForMount(mountDir string, t *testing.T) {
 	const attempts = 10
 	for range attempts {
-		testFile := filepath.Join(mountDir, "test_mount_ready.duckdb")
-		f, err := os.Create(testFile)
-		if err == nil {
-			f.Close()
-			os.Remove(testFile)
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mountDir, &stat); err == nil && stat.Type == fuseSuperMagic {
 			return
 		}
 		time.Sleep(100 * time.Millisecond)
@@ -188,7 +934,7 @@ func waitForMount(mountDir string, t *testing.T) {
 
 // SetupFuseMount creates a temporary mount directory and mounts a FUSE filesystem
 // It returns the mountpoint, a cleanup function, and an error status channel
-func setupFuseMount(t *testing.T) (string, *storage.Manager, func(), chan error) {
+func setupFuseMount(t *testing.T, opts ...FSOpt) (string, *storage.Manager, func(), chan error) {
 	// Create a temporary mount directory
 	mountDir, err := os.MkdirTemp("", "fusemnt")
 	if err != nil {
@@ -212,7 +958,7 @@ func setupFuseMount(t *testing.T) (string, *storage.Manager, func(), chan error)
 
 	// Serve the filesystem in a goroutine
 	go func() {
-		errChan <- fs.Serve(conn, NewFS(sm, log, "/tmp"))
+		errChan <- fs.Serve(conn, NewFS(sm, log, "/tmp", opts...))
 	}()
 
 	// Create cleanup function
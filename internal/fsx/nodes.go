@@ -0,0 +1,76 @@
+package fsx
+
+import "sync"
+
+// nodes keeps track of the file nodes the kernel knows about, by name, so that a file is
+// always served by the same node and renames can update the node the kernel holds.
+type nodes struct {
+	mu    sync.Mutex
+	files map[string]*File
+}
+
+func newNodes() *nodes {
+	return &nodes{files: make(map[string]*File)}
+}
+
+// get returns the node of the named file, if there is one for the same file ID (a file
+// with that name may have been replaced since the node was created).
+func (n *nodes) get(name string, fileID uint64) *File {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, ok := n.files[name]
+	if !ok || f.fileID != fileID {
+		return nil
+	}
+	return f
+}
+
+func (n *nodes) put(f *File) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.files[f.getName()] = f
+}
+
+// getOrPut returns the node of f's file if there is one already, otherwise adds f. This keeps
+// concurrent lookups and creates of a file from handing out different nodes for it.
+func (n *nodes) getOrPut(f *File) *File {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	name := f.getName()
+	if existing, ok := n.files[name]; ok && existing.fileID == f.fileID {
+		return existing
+	}
+	n.files[name] = f
+	return f
+}
+
+// rename moves the node of oldName, if any, to newName. A node of a file that was
+// replaced by the rename is dropped and invalidated, operations through it fail with ESTALE.
+func (n *nodes) rename(oldName string, newName string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, ok := n.files[oldName]
+	if replaced, exists := n.files[newName]; exists && replaced != f {
+		replaced.stale.Store(true)
+	}
+	delete(n.files, oldName)
+	delete(n.files, newName)
+
+	if ok {
+		f.setName(newName)
+		n.files[newName] = f
+	}
+}
+
+func (n *nodes) forget(f *File) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.files[f.getName()] == f {
+		delete(n.files, f.getName())
+	}
+}
@@ -0,0 +1,210 @@
+package fsx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage"
+)
+
+// Flags of setxattr(2), the same on Linux and macOS
+const (
+	xattrCreate  = 0x1 // XATTR_CREATE
+	xattrReplace = 0x2 // XATTR_REPLACE
+)
+
+// Extended attributes under readOnlyXattrPrefix are computed by the file system and can't be
+// set nor removed. headXattr holds the tag of the version reads return: the head version if
+// one is set, otherwise the latest version. Files without versions don't have it.
+const (
+	readOnlyXattrPrefix = "user.quackfs."
+	headXattr           = readOnlyXattrPrefix + "head"
+)
+
+var _ fs.NodeGetxattrer = (*File)(nil)
+var _ fs.NodeListxattrer = (*File)(nil)
+var _ fs.NodeSetxattrer = (*File)(nil)
+var _ fs.NodeRemovexattrer = (*File)(nil)
+
+// Getxattr returns an extended attribute of a database file, persisted in the metadata store
+// so it survives remounts, or computed at each call for headXattr. WAL files have none.
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	name := f.getName()
+
+	f.log.Debug("Getting extended attribute", "name", name, "xattr", req.Name)
+
+	if f.names.isWAL(name) {
+		return fuse.ErrNoXattr
+	}
+
+	if err := f.checkStale(); err != nil {
+		return err
+	}
+
+	if req.Name == headXattr {
+		tag, err := f.headVersion(ctx, name)
+		if err != nil {
+			return err
+		}
+		if tag == "" {
+			return fuse.ErrNoXattr
+		}
+		resp.Xattr = []byte(tag)
+		return nil
+	}
+
+	value, err := f.sm.GetXattr(ctx, name, req.Name)
+	if err != nil {
+		if err == types.ErrNotFound {
+			return fuse.ErrNoXattr
+		}
+		f.log.Error("Failed to get extended attribute", "name", name, "xattr", req.Name, "error", err)
+		return err
+	}
+
+	// The kernel asks for the size with a zero size, fs.Serve answers ERANGE if it's too small
+	resp.Xattr = value
+	return nil
+}
+
+// Listxattr lists the extended attributes of a database file.
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	name := f.getName()
+
+	f.log.Debug("Listing extended attributes", "name", name)
+
+	if f.names.isWAL(name) {
+		return nil
+	}
+
+	if err := f.checkStale(); err != nil {
+		return err
+	}
+
+	tag, err := f.headVersion(ctx, name)
+	if err != nil {
+		return err
+	}
+	if tag != "" {
+		resp.Append(headXattr)
+	}
+
+	names, err := f.sm.ListXattrs(ctx, name)
+	if err != nil {
+		f.log.Error("Failed to list extended attributes", "name", name, "error", err)
+		return err
+	}
+
+	resp.Append(names...)
+	return nil
+}
+
+// Setxattr sets an extended attribute of a database file, honoring XATTR_CREATE and
+// XATTR_REPLACE. Values larger than storage.MaxXattrValueSize fail with E2BIG, and the
+// attributes computed by the file system (see readOnlyXattrPrefix) with EPERM.
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	name := f.getName()
+
+	f.log.Debug("Setting extended attribute", "name", name, "xattr", req.Name, "size", len(req.Xattr), "flags", req.Flags)
+
+	if f.readOnly {
+		return syscall.EROFS
+	}
+
+	if strings.HasPrefix(req.Name, readOnlyXattrPrefix) {
+		return syscall.EPERM
+	}
+
+	if f.names.isWAL(name) {
+		return syscall.ENOTSUP
+	}
+
+	if err := f.checkStale(); err != nil {
+		return err
+	}
+
+	var opts []storage.XattrOpt
+	switch {
+	case req.Flags&xattrCreate != 0:
+		opts = append(opts, storage.WithXattrCreate())
+	case req.Flags&xattrReplace != 0:
+		opts = append(opts, storage.WithXattrReplace())
+	}
+
+	err := f.sm.SetXattr(ctx, name, req.Name, req.Xattr, opts...)
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrXattrExists):
+			return syscall.EEXIST
+		case errors.Is(err, types.ErrNotFound):
+			return fuse.ErrNoXattr
+		case errors.Is(err, types.ErrInvalidXattrName):
+			return syscall.ERANGE
+		case errors.Is(err, types.ErrXattrTooLarge):
+			return syscall.E2BIG
+		}
+		f.log.Error("Failed to set extended attribute", "name", name, "xattr", req.Name, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// Removexattr removes an extended attribute of a database file.
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	name := f.getName()
+
+	f.log.Debug("Removing extended attribute", "name", name, "xattr", req.Name)
+
+	if f.readOnly {
+		return syscall.EROFS
+	}
+
+	if strings.HasPrefix(req.Name, readOnlyXattrPrefix) {
+		return syscall.EPERM
+	}
+
+	if f.names.isWAL(name) {
+		return fuse.ErrNoXattr
+	}
+
+	if err := f.checkStale(); err != nil {
+		return err
+	}
+
+	err := f.sm.RemoveXattr(ctx, name, req.Name)
+	if err != nil {
+		if err == types.ErrNotFound {
+			return fuse.ErrNoXattr
+		}
+		f.log.Error("Failed to remove extended attribute", "name", name, "xattr", req.Name, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// headVersion returns the tag of the version reads of the file return, for headXattr: its head
+// version if one is set, otherwise its latest version, or "" if it has no versions.
+func (f *File) headVersion(ctx context.Context, name string) (string, error) {
+	tag, err := f.sm.GetHead(ctx, name)
+	if err != nil {
+		f.log.Error("Failed to get head version", "name", name, "error", err)
+		return "", err
+	}
+	if tag != "" {
+		return tag, nil
+	}
+
+	tag, err = f.sm.GetLatestVersion(ctx, name)
+	if err != nil {
+		f.log.Error("Failed to get latest version", "name", name, "error", err)
+		return "", err
+	}
+	return tag, nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	gcs "cloud.google.com/go/storage"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -17,16 +18,39 @@ import (
 	"github.com/vinimdocarmo/quackfs/pkg/logger"
 )
 
-func SetupStorageManager(t *testing.T) (*storage.Manager, func()) {
-	connStr := GetTestConnectionString(t)
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		t.Fatalf("Failed to open database connection: %v", err)
+// memoryStore is shared by every storage manager created by the helpers, so that
+// tests simulating a restart with a new manager still find the objects they uploaded
+var memoryStore = object.NewMemory()
+
+// SetupStorageManager creates a storage manager backed by the test database.
+// Objects are kept in memory, unless TEST_OBJECT_STORE=s3 is set in which case
+// the LocalStack test bucket is used.
+func SetupStorageManager(t testing.TB, opts ...storage.ManagerOpt) (*storage.Manager, func()) {
+	return SetupStorageManagerWithStore(t, NewTestObjectStore(t), opts...)
+}
+
+// NewTestObjectStore returns the object store selected by the TEST_OBJECT_STORE env var ("memory", "s3" or "gcs")
+func NewTestObjectStore(t testing.TB) object.ObjectStore {
+	switch kind := os.Getenv("TEST_OBJECT_STORE"); kind {
+	case "", "memory":
+		return MemoryStore()
+	case "s3":
+		return NewS3Store(t)
+	case "gcs":
+		return NewGCSStore(t)
+	default:
+		t.Fatalf("Unknown TEST_OBJECT_STORE %q, expected memory, s3 or gcs", kind)
+		return nil
 	}
+}
 
-	// Create a test log
-	log := logger.New(os.Stderr)
+// MemoryStore returns the in-memory object store shared by the test helpers
+func MemoryStore() *object.MemoryStore {
+	return memoryStore
+}
 
+// NewS3Store returns an object store backed by the LocalStack test bucket
+func NewS3Store(t testing.TB) *object.S3Store {
 	// Set up S3 client for tests
 	s3Endpoint := os.Getenv("AWS_ENDPOINT_URL")
 	if s3Endpoint == "" {
@@ -61,12 +85,45 @@ func SetupStorageManager(t *testing.T) (*storage.Manager, func()) {
 		o.DisableLogOutputChecksumValidationSkipped = true
 	})
 
-	objectStore := object.NewS3(s3Client, s3BucketName)
+	return object.NewS3(s3Client, s3BucketName)
+}
+
+// NewGCSStore returns an object store backed by the GCS test bucket. The client talks to the
+// emulator at STORAGE_EMULATOR_HOST if set, otherwise to GCS with the default credentials.
+func NewGCSStore(t testing.TB) *object.GCSStore {
+	bucketName := os.Getenv("GCS_BUCKET_NAME")
+	if bucketName == "" {
+		bucketName = "quackfs-bucket-test"
+	}
+
+	client, err := gcs.NewClient(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to create GCS client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
 
-	sm := storage.NewManager(db, objectStore, log)
+	return object.NewGCS(client, bucketName)
+}
+
+// SetupStorageManagerWithStore creates a storage manager that uses the given object store
+func SetupStorageManagerWithStore(t testing.TB, objectStore object.ObjectStore, opts ...storage.ManagerOpt) (*storage.Manager, func()) {
+	connStr := GetTestConnectionString(t)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("Failed to open database connection: %v", err)
+	}
+
+	// Create a test log
+	log := logger.New(os.Stderr)
+
+	sm := storage.NewManager(db, objectStore, log, opts...)
 
 	cleanup := func() {
 		// delete all rows in all tables
+		_, err = db.Exec("DELETE FROM write_origins")
+		if err != nil {
+			t.Fatalf("Failed to clean write_origins table: %v", err)
+		}
 		_, err = db.Exec("DELETE FROM chunks")
 		if err != nil {
 			t.Fatalf("Failed to clean chunks table: %v", err)
@@ -89,7 +146,7 @@ func SetupStorageManager(t *testing.T) (*storage.Manager, func()) {
 }
 
 // GetTestConnectionString returns the PostgreSQL connection string for tests
-func GetTestConnectionString(t *testing.T) string {
+func GetTestConnectionString(t testing.TB) string {
 	connStr := os.Getenv("POSTGRES_TEST_CONN")
 	if connStr == "" {
 		t.Fatal("PostgreSQL connection string not provided. Set POSTGRES_TEST_CONN environment variable")
@@ -97,7 +154,7 @@ func GetTestConnectionString(t *testing.T) string {
 	return connStr
 }
 
-func SetupDB(t *testing.T) *sql.DB {
+func SetupDB(t testing.TB) *sql.DB {
 	connStr := GetTestConnectionString(t)
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// ArchiveVersion records that the layer object of version tag of a file was moved to cold
+// storage (e.g. by an object store lifecycle rule). Reads that need it fail right away with
+// types.ErrObjectArchived instead of waiting on a slow fetch, until RestoreVersion is called.
+func (mgr *Manager) ArchiveVersion(ctx context.Context, filename string, tag string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.setVersionArchived(ctx, filename, tag, true)
+}
+
+// RestoreVersion records that the layer object of version tag of a file is readable again
+// (e.g. once it has been restored from cold storage).
+func (mgr *Manager) RestoreVersion(ctx context.Context, filename string, tag string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.setVersionArchived(ctx, filename, tag, false)
+}
+
+func (mgr *Manager) setVersionArchived(ctx context.Context, filename string, tag string, archived bool) error {
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, tag, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "filename", filename, "version", tag, "error", err)
+		return fmt.Errorf("failed to get layer for version: %w", err)
+	}
+
+	err = mgr.metaStore.SetLayerArchived(ctx, layer.ID, archived, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to update layer", "filename", filename, "version", tag, "error", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Info("Version archive state updated", "filename", filename, "version", tag, "archived", archived)
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// GetFileAttr returns the attributes (mode and timestamps) of a file.
+func (mgr *Manager) GetFileAttr(ctx context.Context, filename string) (metadata.FileAttr, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return metadata.FileAttr{}, err
+	}
+
+	attr, err := mgr.metaStore.GetFileAttr(ctx, fileID)
+	if err != nil {
+		mgr.log.Error("Failed to get file attributes", "filename", filename, "error", err)
+		return metadata.FileAttr{}, err
+	}
+
+	return attr, nil
+}
+
+type insertFileOptions struct {
+	hasMode bool
+	mode    os.FileMode
+}
+
+// InsertFileOpt configures a single InsertFile call.
+type InsertFileOpt func(*insertFileOptions)
+
+// WithFileMode sets the permission bits of the new file, as SetFileMode would, but in the same
+// transaction as the insert.
+func WithFileMode(mode os.FileMode) InsertFileOpt {
+	return func(o *insertFileOptions) {
+		o.hasMode = true
+		o.mode = mode
+	}
+}
+
+// SetFileMode sets the permission bits of a file (e.g. on chmod). Other mode bits are ignored.
+func (mgr *Manager) SetFileMode(ctx context.Context, filename string, mode os.FileMode) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	if err := mgr.metaStore.SetFileMode(ctx, fileID, mode); err != nil {
+		mgr.log.Error("Failed to set file mode", "filename", filename, "mode", mode, "error", err)
+		return err
+	}
+
+	mgr.log.Debug("File mode set", "filename", filename, "mode", mode)
+	return nil
+}
+
+// SetFileModTime sets the modification time of a file (e.g. on touch). Checkpoints also
+// set it, to the time of the checkpoint.
+func (mgr *Manager) SetFileModTime(ctx context.Context, filename string, modTime time.Time) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	if err := mgr.metaStore.SetFileModifiedAt(ctx, fileID, modTime); err != nil {
+		mgr.log.Error("Failed to set file modification time", "filename", filename, "error", err)
+		return fmt.Errorf("failed to set modification time of %s: %w", filename, err)
+	}
+
+	mgr.log.Debug("File modification time set", "filename", filename, "modTime", modTime)
+	return nil
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// Branches are named heads of a file, each pointing to one of its versions. Files are on the
+// main branch until switched; reads and writes resolve against the head of the current branch
+// the same way they do with SetHead: a branch pointing to a version makes the file read-only
+// and reads return that version. The main branch can also have no head, in which case the
+// latest version is read and written. Versions form a single line, so branches can't diverge:
+// new versions are always checkpointed on top of the latest one, from main.
+
+// CreateBranch creates a branch of a file pointing to version versionTag. It fails if the
+// branch already exists.
+func (mgr *Manager) CreateBranch(ctx context.Context, filename string, branch string, versionTag string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	if branch == "" {
+		return fmt.Errorf("branch name cannot be empty")
+	}
+	return mgr.setBranchHead(ctx, filename, branch, versionTag, true)
+}
+
+// setBranchHead points the head of a branch of a file to a version. Unless create is set,
+// the branch is created if needed or moved if it exists.
+func (mgr *Manager) setBranchHead(ctx context.Context, filename string, branch string, version string, create bool) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return err
+	}
+
+	// Setup deferred rollback in case of error or panic
+	defer func() {
+		if p := recover(); p != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				mgr.log.Error("Failed to rollback transaction after panic", "error", rbErr)
+			}
+			panic(p)
+		} else if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				mgr.log.Error("Failed to rollback transaction", "error", rbErr)
+			}
+		}
+	}()
+
+	// Get the file ID
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	// Make sure the version exists by getting its layer
+	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, version, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "version", version, "error", err)
+		return fmt.Errorf("failed to get layer for version: %w", err)
+	}
+
+	if create {
+		_, _, err = mgr.metaStore.GetBranchVersion(ctx, fileID, branch, metadata.WithTx(tx))
+		if err == nil {
+			err = fmt.Errorf("branch %s of %s already exists", branch, filename)
+			mgr.log.Error("Cannot create branch", "filename", filename, "branch", branch, "error", err)
+			return err
+		} else if err != types.ErrNotFound {
+			mgr.log.Error("Failed to get branch", "filename", filename, "branch", branch, "error", err)
+			return fmt.Errorf("failed to get branch: %w", err)
+		}
+
+		err = mgr.metaStore.CreateBranch(ctx, fileID, branch, layer.VersionID, metadata.WithTx(tx))
+	} else {
+		err = mgr.metaStore.SetHead(ctx, fileID, branch, layer.VersionID, metadata.WithTx(tx))
+	}
+	if err != nil {
+		mgr.log.Error("Failed to set head", "filename", filename, "branch", branch, "version", version, "error", err)
+		return fmt.Errorf("failed to set head: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Info("Head set successfully", "filename", filename, "branch", branch, "version", version)
+
+	return nil
+}
+
+// GetBranchHead gets the version the head of a branch of the file is pointing to, "" if the
+// branch has no head.
+func (mgr *Manager) GetBranchHead(ctx context.Context, filename string, branch string) (string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	// Get the file ID
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return "", fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	_, versionTag, err := mgr.metaStore.GetBranchVersion(ctx, fileID, branch)
+	if err != nil {
+		if err == types.ErrNotFound {
+			mgr.log.Info("No head set for branch", "filename", filename, "branch", branch)
+			return "", nil
+		}
+		mgr.log.Error("Failed to get head version", "filename", filename, "branch", branch, "error", err)
+		return "", fmt.Errorf("failed to get head version: %w", err)
+	}
+
+	return versionTag, nil
+}
+
+// SwitchBranch makes reads and writes of the file resolve against branch. Apart from main,
+// the branch has to exist (see CreateBranch).
+func (mgr *Manager) SwitchBranch(ctx context.Context, filename string, branch string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	if branch != metadata.DefaultBranch {
+		_, _, err = mgr.metaStore.GetBranchVersion(ctx, fileID, branch, metadata.WithTx(tx))
+		if err == types.ErrNotFound {
+			return fmt.Errorf("branch %s of %s does not exist", branch, filename)
+		} else if err != nil {
+			mgr.log.Error("Failed to get branch", "filename", filename, "branch", branch, "error", err)
+			return fmt.Errorf("failed to get branch: %w", err)
+		}
+	}
+
+	if err = mgr.metaStore.SetCurrentBranch(ctx, fileID, branch, metadata.WithTx(tx)); err != nil {
+		mgr.log.Error("Failed to switch branch", "filename", filename, "branch", branch, "error", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Info("Switched branch", "filename", filename, "branch", branch)
+
+	return nil
+}
+
+// GetCurrentBranch returns the branch reads and writes of the file resolve against.
+func (mgr *Manager) GetCurrentBranch(ctx context.Context, filename string) (string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return "", fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	return mgr.metaStore.GetCurrentBranch(ctx, fileID)
+}
+
+// DeleteBranch deletes a branch of a file. Deleting main only removes its head (see DeleteHead),
+// other branches can't be deleted while the file is on them.
+func (mgr *Manager) DeleteBranch(ctx context.Context, filename string, branch string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	if branch == metadata.DefaultBranch {
+		return mgr.DeleteHead(ctx, filename)
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	current, err := mgr.metaStore.GetCurrentBranch(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get current branch", "filename", filename, "error", err)
+		return err
+	}
+	if current == branch {
+		return fmt.Errorf("cannot delete branch %s of %s: it is the current branch, switch first", branch, filename)
+	}
+
+	if err = mgr.metaStore.DeleteHead(ctx, fileID, branch, metadata.WithTx(tx)); err != nil {
+		mgr.log.Error("Failed to delete branch", "filename", filename, "branch", branch, "error", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Info("Branch deleted", "filename", filename, "branch", branch)
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// chunkKey identifies the data of a flushed chunk by where it's stored, so deduplicated
+// chunks share the entry of the chunk they point to. Layer objects are never modified once
+// uploaded, so the data for a given key never changes.
+type chunkKey struct {
+	objectKey   string
+	objectRange [2]uint64
+}
+
+// CacheStats describes the use of the read cache (see WithReadCache) since the manager was created.
+type CacheStats struct {
+	Hits    uint64 // chunks served from the cache
+	Misses  uint64 // chunks that had to be fetched from the object store
+	Entries int    // chunks currently in the cache
+	Bytes   uint64 // size of the chunks currently in the cache
+}
+
+type cacheEntry struct {
+	key  chunkKey
+	data []byte
+}
+
+// chunkCache keeps the data of recently fetched chunks in memory, up to maxBytes.
+// When full, the least recently used entries are evicted first.
+type chunkCache struct {
+	mu       sync.Mutex
+	maxBytes uint64
+	size     uint64
+	entries  map[chunkKey]*list.Element
+	lru      *list.List // of *cacheEntry, most recently used first
+	hits     uint64
+	misses   uint64
+}
+
+func newChunkCache(maxBytes uint64) *chunkCache {
+	return &chunkCache{
+		maxBytes: maxBytes,
+		entries:  make(map[chunkKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *chunkCache) get(key chunkKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).data, true
+}
+
+func (c *chunkCache) put(key chunkKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok || uint64(len(data)) > c.maxBytes {
+		return
+	}
+
+	for c.size+uint64(len(data)) > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.size += uint64(len(data))
+}
+
+// invalidate drops the chunks of the given layer object, e.g. once it is deleted.
+func (c *chunkCache) invalidate(objectKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cacheEntry).key.objectKey == objectKey {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+func (c *chunkCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= uint64(len(entry.data))
+}
+
+func (c *chunkCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.lru.Len(),
+		Bytes:   c.size,
+	}
+}
+
+type layerCacheEntry struct {
+	layerID uint64
+	data    []byte
+}
+
+// layerCache keeps the data of the layers this manager checkpointed last, up to maxBytes, so
+// that reads right after a checkpoint are served from memory. Entries hold the whole layer
+// data as it was in the memtable, which every chunk of the layer (deduplicated or not) reads
+// at its LayerRange. When full, the oldest layers are evicted first.
+type layerCache struct {
+	mu       sync.Mutex
+	maxBytes uint64
+	size     uint64
+	entries  map[uint64]*list.Element
+	order    *list.List // of *layerCacheEntry, most recently sealed first
+}
+
+func newLayerCache(maxBytes uint64) *layerCache {
+	return &layerCache{
+		maxBytes: maxBytes,
+		entries:  make(map[uint64]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the data of chunk c if its layer is in the cache
+func (c *layerCache) get(chunk metadata.Chunk) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[chunk.LayerID]
+	if !ok {
+		return nil, false
+	}
+
+	data := elem.Value.(*layerCacheEntry).data
+	if chunk.LayerRange[1] > uint64(len(data)) {
+		return nil, false
+	}
+	return data[chunk.LayerRange[0]:chunk.LayerRange[1]], true
+}
+
+// put keeps the data of a sealed layer, which must not be modified afterwards
+func (c *layerCache) put(layerID uint64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[layerID]; ok || uint64(len(data)) > c.maxBytes {
+		return
+	}
+
+	for c.size+uint64(len(data)) > c.maxBytes {
+		entry := c.order.Remove(c.order.Back()).(*layerCacheEntry)
+		delete(c.entries, entry.layerID)
+		c.size -= uint64(len(entry.data))
+	}
+
+	c.entries[layerID] = c.order.PushFront(&layerCacheEntry{layerID: layerID, data: data})
+	c.size += uint64(len(data))
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// ChunkInfo describes a chunk of a file, for debugging.
+type ChunkInfo struct {
+	LayerID    uint64    `json:"layerId"`   // 0 for chunks of the active layer
+	Version    string    `json:"version"`   // tag of the layer's version, empty for the active layer
+	ObjectKey  string    `json:"objectKey"` // object holding the chunk's data, empty for the active layer
+	LayerRange [2]uint64 `json:"layerRange"`
+	FileRange  [2]uint64 `json:"fileRange"`
+	Active     bool      `json:"active"` // whether the chunk is an uncommitted write held in memory
+	Dedup      bool      `json:"dedup"`  // whether the chunk's data is stored in the object of an earlier layer
+	Hole       bool      `json:"hole"`   // whether the chunk is a punched hole, reading as zeroes (see PunchHole)
+}
+
+// ListChunks returns the chunks ReadFile would read for the whole file, in the order it applies
+// them: later chunks override earlier ones where their file ranges overlap. As with ReadFile,
+// these are the chunks of the version given with WithVersion or WithAsOf if any, else of the head version
+// if available, otherwise those of every version followed by the ones of the active layer.
+func (mgr *Manager) ListChunks(ctx context.Context, filename string, opts ...ReadOpt) ([]ChunkInfo, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	var readOpts readOptions
+	for _, opt := range opts {
+		opt(&readOpts)
+	}
+
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	versionTag := readOpts.version
+	if versionTag == "" && !readOpts.asOf.IsZero() {
+		versionTag, _, err = mgr.metaStore.GetVersionAsOf(ctx, fileID, readOpts.asOf, metadata.WithTx(tx))
+		if err != nil {
+			mgr.log.Error("Failed to get version as of time", "filename", filename, "asOf", readOpts.asOf, "error", err)
+			return nil, fmt.Errorf("failed to get version as of time: %w", err)
+		}
+	}
+	if versionTag == "" {
+		headVersionID, headVersionTag, err := mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
+		if err != nil && err != types.ErrNotFound {
+			mgr.log.Error("Failed to get head version", "filename", filename, "error", err)
+			return nil, fmt.Errorf("failed to get head version: %w", err)
+		}
+		if headVersionID > 0 {
+			versionTag = headVersionTag
+		}
+	}
+
+	var versionedLayerID uint64
+	if versionTag != "" {
+		layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, versionTag, tx)
+		if err != nil {
+			mgr.log.Error("Failed to get layer for version", "filename", filename, "version", versionTag, "error", err)
+			return nil, fmt.Errorf("failed to get layer for version: %w", err)
+		}
+		versionedLayerID = layer.ID
+	}
+
+	layers, err := mgr.metaStore.LoadLayersByFileID(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to load layers", "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to load layers: %w", err)
+	}
+
+	layersByID := make(map[uint64]*metadata.Layer, len(layers))
+	for _, layer := range layers {
+		layersByID[layer.ID] = layer
+	}
+
+	chunks, err := mgr.metaStore.GetAllOverlappingChunks(ctx, tx, fileID, [2]uint64{0, math.MaxInt64},
+		mgr.memtable[fileID], metadata.WithVersionedLayerID(versionedLayerID))
+	if err != nil {
+		mgr.log.Error("Failed to get chunks", "filename", filename, "error", err)
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	infos := make([]ChunkInfo, 0, len(chunks))
+	for _, c := range chunks {
+		info := ChunkInfo{
+			LayerRange: c.LayerRange,
+			FileRange:  c.FileRange,
+			Active:     !c.Flushed,
+			Dedup:      c.SourceLayerID != 0,
+			Hole:       c.Hole,
+		}
+		if c.Flushed {
+			info.LayerID = c.LayerID
+			if layer, ok := layersByID[c.LayerID]; ok {
+				info.Version = layer.Tag
+			}
+			if layer, ok := layersByID[c.DataLayerID()]; ok {
+				info.ObjectKey = layer.ObjectKey
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Compact rewrites the content of the latest version of a file into a single layer, as a new
+// version with a generated tag (see Checkpoint) and OriginCompact as its origin. Reads of the
+// new version, and of the ones checkpointed after it, only go through the chunks of that layer
+// and later ones, instead of stitching the chunks of every layer since the file was created.
+//
+// Earlier versions are left alone and stay readable, so the objects of their layers are kept.
+// It does nothing if the latest version is already read from a single chunk, and fails if the
+// file has uncommitted writes or a head is set. Writes of the file fail with types.ErrFileBusy
+// while it is compacted, and the compaction fails if another version is checkpointed meanwhile.
+func (mgr *Manager) Compact(ctx context.Context, filename string) error {
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	// The content is written as a fresh active layer, over the whole file
+	if err := mgr.beginRewrite(fileID, filename, "compact"); err != nil {
+		return err
+	}
+	defer mgr.endRewrite(fileID)
+
+	head, err := mgr.GetHead(ctx, filename)
+	if err != nil {
+		return err
+	}
+	if head != "" {
+		return fmt.Errorf("cannot compact %s: it is in read-only mode because a head is set", filename)
+	}
+
+	latest, err := mgr.GetLatestVersion(ctx, filename)
+	if err != nil {
+		return err
+	}
+	if latest == "" {
+		mgr.log.Debug("No version to compact", "filename", filename)
+		return nil
+	}
+
+	chunks, err := mgr.ListChunks(ctx, filename, WithVersion(latest))
+	if err != nil {
+		return fmt.Errorf("failed to list chunks of version %s: %w", latest, err)
+	}
+	if len(chunks) <= 1 {
+		mgr.log.Debug("Version is already compact", "filename", filename, "version", latest)
+		return nil
+	}
+
+	size, err := mgr.SizeOfVersion(ctx, filename, latest)
+	if err != nil {
+		return fmt.Errorf("failed to get size of version %s: %w", latest, err)
+	}
+
+	data, err := mgr.ReadFile(ctx, filename, 0, size, WithVersion(latest))
+	if err != nil {
+		return fmt.Errorf("failed to read version %s: %w", latest, err)
+	}
+
+	if err := mgr.WriteFile(ctx, filename, data, 0, withRewrite()); err != nil {
+		mgr.discardRewrite(fileID)
+		return fmt.Errorf("failed to write compacted content: %w", err)
+	}
+
+	version, err := mgr.Checkpoint(ctx, filename, "", WithOrigin(OriginCompact),
+		WithMessage(fmt.Sprintf("Compaction of %s", latest)), withCompacted(), withRewriteOf(latest))
+	if err != nil {
+		// Don't leave the rewritten content behind as uncommitted writes
+		mgr.discardRewrite(fileID)
+		return fmt.Errorf("failed to checkpoint compacted content: %w", err)
+	}
+
+	mgr.log.Info("File compacted", "filename", filename, "from", latest, "version", version, "chunks", len(chunks), "bytes", size)
+
+	return nil
+}
+
+// withCompacted marks the layer of the checkpoint as holding the whole file
+func withCompacted() CheckpointOpt {
+	return func(o *checkpointOptions) {
+		o.compacted = true
+	}
+}
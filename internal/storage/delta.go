@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// DeltaChunk describes where a chunk of a version delta lives in the layer data
+// and in the virtual file. Both ranges are [start, end). A hole has no data, its layer range
+// is empty.
+type DeltaChunk struct {
+	LayerRange [2]uint64 `json:"layerRange"`
+	FileRange  [2]uint64 `json:"fileRange"`
+	Hole       bool      `json:"hole,omitempty"`
+}
+
+// DeltaHeader is the metadata sent ahead of the layer data in a version delta stream.
+type DeltaHeader struct {
+	Filename string       `json:"filename"`
+	Tag      string       `json:"tag"`
+	Size     uint64       `json:"size"` // size of the layer data following the header
+	Chunks   []DeltaChunk `json:"chunks"`
+}
+
+// A version delta stream is laid out as:
+//
+//	| header length (uint64, big endian) | header (JSON) | layer data (Size bytes) |
+const deltaHeaderLenSize = 8
+
+// maxDeltaHeaderLen guards against allocating huge buffers when decoding a corrupt stream
+const maxDeltaHeaderLen = 64 << 20
+
+// GetVersionDelta streams the layer object of a single version together with its chunk
+// metadata. Applying it on top of the parent version yields the version, so replicas can
+// be kept in sync without reconstructing whole files. Use DecodeVersionDelta to read it.
+func (mgr *Manager) GetVersionDelta(ctx context.Context, filename string, tag string) (io.ReadCloser, error) {
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, tag, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "filename", filename, "version", tag, "error", err)
+		return nil, fmt.Errorf("failed to get layer for version: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	header := DeltaHeader{
+		Filename: filename,
+		Tag:      tag,
+		Chunks:   make([]DeltaChunk, 0, len(layer.Chunks)),
+	}
+	for _, c := range layer.Chunks {
+		header.Chunks = append(header.Chunks, DeltaChunk{LayerRange: c.LayerRange, FileRange: c.FileRange, Hole: c.Hole})
+		header.Size = max(header.Size, c.LayerRange[1])
+	}
+
+	// The delta carries the uncompressed layer data, whatever the layer is stored as
+	data := make([]byte, header.Size)
+	for _, c := range layer.Chunks {
+		if c.Hole {
+			continue
+		}
+		chunkData, err := mgr.getChunkData(ctx, c, nil)
+		if err != nil {
+			mgr.log.Error("Failed to get chunk data", "objectKey", layer.ObjectKey, "error", err)
+			return nil, fmt.Errorf("failed to get chunk data: %w", err)
+		}
+		copy(data[c.LayerRange[0]:c.LayerRange[1]], chunkData)
+	}
+
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delta header: %w", err)
+	}
+
+	headerLen := make([]byte, deltaHeaderLenSize)
+	binary.BigEndian.PutUint64(headerLen, uint64(len(headerData)))
+
+	mgr.log.Debug("Streaming version delta", "filename", filename, "version", tag, "chunks", len(header.Chunks), "size", header.Size)
+
+	return io.NopCloser(io.MultiReader(bytes.NewReader(headerLen), bytes.NewReader(headerData), bytes.NewReader(data))), nil
+}
+
+// ApplyVersionDelta records the layer data and chunks of a version delta (see GetVersionDelta
+// and DecodeVersionDelta) as version newTag of the file. The delta must have been taken on top
+// of parentTag, which has to be the latest version of the file (or "" if it has no versions yet).
+func (mgr *Manager) ApplyVersionDelta(ctx context.Context, filename string, parentTag string, newTag string, delta io.Reader, chunks []DeltaChunk) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	data, err := io.ReadAll(delta)
+	if err != nil {
+		mgr.log.Error("Failed to read delta data", "error", err)
+		return fmt.Errorf("failed to read delta data: %w", err)
+	}
+
+	layerChunks := make([]metadata.Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if c.Hole {
+			if c.LayerRange[0] != c.LayerRange[1] || c.FileRange[0] > c.FileRange[1] {
+				return fmt.Errorf("invalid delta hole: layer range %v, file range %v", c.LayerRange, c.FileRange)
+			}
+			layerChunks = append(layerChunks, metadata.Chunk{FileRange: c.FileRange, Hole: true})
+			continue
+		}
+		if c.LayerRange[0] > c.LayerRange[1] || c.LayerRange[1] > uint64(len(data)) ||
+			c.FileRange[1]-c.FileRange[0] != c.LayerRange[1]-c.LayerRange[0] {
+			return fmt.Errorf("invalid delta chunk: layer range %v, file range %v, delta size %d", c.LayerRange, c.FileRange, len(data))
+		}
+		layerChunks = append(layerChunks, metadata.Chunk{LayerRange: c.LayerRange, FileRange: c.FileRange})
+	}
+
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	// Same rules as Checkpoint: read-only files can't get new versions
+	_, _, err = mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
+	if err == nil {
+		return fmt.Errorf("cannot apply delta: %s is in read-only mode because a head is set, use DeleteHead first", filename)
+	} else if err != types.ErrNotFound {
+		return fmt.Errorf("failed to check head version: %w", err)
+	}
+
+	// Uncommitted writes were made on top of the parent, they would end up on top of the delta instead
+	if mgr.hasPendingWrites(fileID) {
+		return fmt.Errorf("cannot apply delta: %s has uncommitted writes", filename)
+	}
+
+	latestTag, _, err := mgr.metaStore.GetLatestVersion(ctx, fileID, metadata.WithTx(tx))
+	if err != nil && err != types.ErrNotFound {
+		mgr.log.Error("Failed to get latest version", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get latest version: %w", err)
+	}
+
+	if latestTag != parentTag {
+		return fmt.Errorf("cannot apply delta on top of %q: latest version of %s is %q", parentTag, filename, latestTag)
+	}
+
+	err = mgr.checkEpoch(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Cannot apply delta", "filename", filename, "error", err)
+		return fmt.Errorf("cannot apply delta to %s: %w", filename, err)
+	}
+
+	layerID, objectKey, err := mgr.persistLayer(ctx, tx, filename, fileID, newTag, checkpointOptions{origin: OriginManual}, data, layerChunks, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		mgr.deleteOrphanedObject(ctx, layerID, objectKey)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Debug("Version delta applied", "filename", filename, "parent", parentTag, "version", newTag, "layerID", layerID, "objectKey", objectKey)
+
+	return nil
+}
+
+// DecodeVersionDelta reads the header of a version delta stream. The returned reader
+// yields exactly header.Size bytes of layer data.
+func DecodeVersionDelta(r io.Reader) (DeltaHeader, io.Reader, error) {
+	var header DeltaHeader
+
+	headerLen := make([]byte, deltaHeaderLenSize)
+	if _, err := io.ReadFull(r, headerLen); err != nil {
+		return header, nil, fmt.Errorf("failed to read delta header length: %w", err)
+	}
+
+	n := binary.BigEndian.Uint64(headerLen)
+	if n > maxDeltaHeaderLen {
+		return header, nil, fmt.Errorf("invalid delta header length: %d", n)
+	}
+
+	headerData := make([]byte, n)
+	if _, err := io.ReadFull(r, headerData); err != nil {
+		return header, nil, fmt.Errorf("failed to read delta header: %w", err)
+	}
+
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return header, nil, fmt.Errorf("failed to decode delta header: %w", err)
+	}
+
+	return header, io.LimitReader(r, int64(header.Size)), nil
+}
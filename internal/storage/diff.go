@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// DiffRange is a range of the file that changed between two versions, along with the
+// version that last wrote it. The range is [start, end).
+type DiffRange struct {
+	FileRange [2]uint64
+	Version   string
+}
+
+// Diff returns the byte ranges of the file that were written after version fromTag, up to
+// and including version toTag, ordered by offset. Each range is attributed to the version
+// whose data is visible there in toTag. fromTag must be older than toTag.
+//
+// Ranges are computed from the chunk metadata only: a range rewritten with the same bytes
+// is still reported as changed.
+func (mgr *Manager) Diff(ctx context.Context, filename string, fromTag string, toTag string) ([]DiffRange, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	fromLayer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, fromTag, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "filename", filename, "version", fromTag, "error", err)
+		return nil, fmt.Errorf("failed to get layer for version %s: %w", fromTag, err)
+	}
+
+	toLayer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, toTag, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "filename", filename, "version", toTag, "error", err)
+		return nil, fmt.Errorf("failed to get layer for version %s: %w", toTag, err)
+	}
+
+	if fromLayer.ID > toLayer.ID {
+		return nil, fmt.Errorf("cannot diff %s: version %s is newer than version %s", filename, fromTag, toTag)
+	}
+
+	layers, err := mgr.metaStore.LoadLayersByFileID(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to load layers", "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to load layers: %w", err)
+	}
+
+	// Overlay the chunks of the layers after fromTag in write order, keeping only the visible parts
+	var ranges []DiffRange
+	for _, layer := range layers {
+		if layer.ID <= fromLayer.ID || layer.ID > toLayer.ID {
+			continue
+		}
+
+		chunks := toLayer.Chunks
+		if layer.ID != toLayer.ID {
+			chunks, err = mgr.metaStore.GetLayerChunks(ctx, layer.ID)
+			if err != nil {
+				mgr.log.Error("Failed to get layer chunks", "layerID", layer.ID, "error", err)
+				return nil, fmt.Errorf("failed to get layer chunks: %w", err)
+			}
+		}
+
+		for _, c := range chunks {
+			ranges = overlayRange(ranges, DiffRange{FileRange: c.FileRange, Version: layer.Tag})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	slices.SortFunc(ranges, func(a, b DiffRange) int {
+		return cmp.Compare(a.FileRange[0], b.FileRange[0])
+	})
+
+	// Merge adjacent ranges written by the same version
+	merged := []DiffRange{}
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].Version == r.Version && merged[n-1].FileRange[1] == r.FileRange[0] {
+			merged[n-1].FileRange[1] = r.FileRange[1]
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged, nil
+}
+
+// overlayRange adds r on top of the non-overlapping ranges, cutting out the parts of
+// existing ranges it overlaps.
+func overlayRange(ranges []DiffRange, r DiffRange) []DiffRange {
+	result := make([]DiffRange, 0, len(ranges)+2)
+	for _, existing := range ranges {
+		if !metadata.RangesOverlap(existing.FileRange, r.FileRange) {
+			result = append(result, existing)
+			continue
+		}
+
+		if existing.FileRange[0] < r.FileRange[0] {
+			result = append(result, DiffRange{FileRange: [2]uint64{existing.FileRange[0], r.FileRange[0]}, Version: existing.Version})
+		}
+		if existing.FileRange[1] > r.FileRange[1] {
+			result = append(result, DiffRange{FileRange: [2]uint64{r.FileRange[1], existing.FileRange[1]}, Version: existing.Version})
+		}
+	}
+
+	if r.FileRange[0] < r.FileRange[1] {
+		result = append(result, r)
+	}
+
+	return result
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// Compression is how chunk data is compressed in layer objects.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+)
+
+// layerEncoding is how the chunks of a layer are stored in its object.
+//
+// Chunks are encoded one by one (compressed, then encrypted) and stored back to back in
+// the layer object, so that a single chunk can still be fetched with a ranged GetObject
+// request. Where each chunk ends up in the object is recorded in its ObjectRange.
+type layerEncoding struct {
+	compression Compression
+	keyring     *keyring // nil when the layer isn't encrypted
+	keyID       string   // key new chunks are encrypted with
+}
+
+// encodeLayer returns the object to upload for a layer, along with its chunks
+// updated with where (and how) their data is stored in that object. Only the data of
+// chunks ends up in the object, so chunks can be left out (e.g. deduplicated ones).
+func encodeLayer(enc layerEncoding, data []byte, chunks []metadata.Chunk) ([]byte, []metadata.Chunk, error) {
+	encoded := make([]metadata.Chunk, len(chunks))
+	copy(encoded, chunks)
+
+	if enc.compression == CompressionNone && enc.keyring == nil && coversData(chunks, data) {
+		for i, c := range encoded {
+			encoded[i].ObjectRange = c.LayerRange
+			encoded[i].Checksum = chunkChecksum(data[c.LayerRange[0]:c.LayerRange[1]])
+			encoded[i].HasChecksum = true
+		}
+		return data, encoded, nil
+	}
+
+	var cipher *chunkCipher
+	if enc.keyring != nil {
+		var err error
+		if cipher, err = enc.keyring.get(enc.keyID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var object bytes.Buffer
+	for i, c := range encoded {
+		chunkData := data[c.LayerRange[0]:c.LayerRange[1]]
+		encoded[i].Checksum = chunkChecksum(chunkData)
+		encoded[i].HasChecksum = true
+
+		if enc.compression != CompressionNone {
+			var compressed bytes.Buffer
+			if err := compressChunk(enc.compression, &compressed, chunkData); err != nil {
+				return nil, nil, err
+			}
+			chunkData = compressed.Bytes()
+		}
+
+		if cipher != nil {
+			var err error
+			chunkData, encoded[i].Nonce, err = cipher.seal(chunkData)
+			if err != nil {
+				return nil, nil, err
+			}
+			encoded[i].KeyID = enc.keyID
+		}
+
+		start := uint64(object.Len())
+		object.Write(chunkData)
+		encoded[i].ObjectRange = [2]uint64{start, uint64(object.Len())}
+	}
+
+	return object.Bytes(), encoded, nil
+}
+
+// coversData reports whether the layer ranges of chunks follow each other from the start of
+// data up to its end, as they do for all the chunks of an active layer
+func coversData(chunks []metadata.Chunk, data []byte) bool {
+	var end uint64
+	for _, c := range chunks {
+		if c.LayerRange[0] != end {
+			return false
+		}
+		end = c.LayerRange[1]
+	}
+	return end == uint64(len(data))
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkChecksum returns the CRC32C of a chunk's data
+func chunkChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoli)
+}
+
+// chunkHash returns the SHA-256 of a chunk's data, which identifies it for deduplication
+func chunkHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func compressChunk(compression Compression, w io.Writer, data []byte) error {
+	switch compression {
+	case CompressionGzip:
+		zw := gzip.NewWriter(w)
+		if _, err := zw.Write(data); err != nil {
+			return fmt.Errorf("failed to compress chunk: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress chunk: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown compression: %q", compression)
+	}
+}
+
+// decodeChunk returns the original data of a chunk from its stored data.
+func decodeChunk(enc layerEncoding, c metadata.Chunk, data []byte) ([]byte, error) {
+	if enc.keyring != nil {
+		keyID := c.KeyID
+		if keyID == "" {
+			keyID = defaultKeyID
+		}
+
+		cipher, err := enc.keyring.get(keyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt chunk: %w", err)
+		}
+
+		data, err = cipher.open(data, c.Nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch enc.compression {
+	case CompressionNone, "":
+		return data, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+		}
+		defer zr.Close()
+
+		decoded, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unknown compression: %q", enc.compression)
+	}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// chunkCipher encrypts chunk data with AES-GCM. Every chunk gets its own random nonce,
+// which is stored along with the chunk metadata.
+type chunkCipher struct {
+	aead cipher.AEAD
+}
+
+// newChunkCipher creates a cipher from an AES key of 16, 24 or 32 bytes.
+func newChunkCipher(key []byte) (*chunkCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES-GCM cipher: %w", err)
+	}
+
+	return &chunkCipher{aead: aead}, nil
+}
+
+// defaultKeyID is the id of the key set with WithEncryptionKey. Chunks encrypted
+// before key ids were recorded are assumed to be encrypted with it.
+const defaultKeyID = "default"
+
+// keyring holds the data encryption keys by id. Every chunk records the id of the key
+// it was encrypted with, so the key used for new data can be rotated without
+// rewriting data encrypted with older keys.
+type keyring struct {
+	ciphers map[string]*chunkCipher
+}
+
+func newKeyring(keys map[string][]byte) (*keyring, error) {
+	kr := &keyring{ciphers: make(map[string]*chunkCipher, len(keys))}
+
+	for id, key := range keys {
+		c, err := newChunkCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		kr.ciphers[id] = c
+	}
+
+	return kr, nil
+}
+
+func (kr *keyring) get(keyID string) (*chunkCipher, error) {
+	c, ok := kr.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q not found in keyring", keyID)
+	}
+	return c, nil
+}
+
+func (c *chunkCipher) seal(plaintext []byte) ([]byte, []byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return c.aead.Seal(nil, nonce, plaintext, nil), nonce, nil
+}
+
+func (c *chunkCipher) open(ciphertext []byte, nonce []byte) ([]byte, error) {
+	if len(nonce) != c.aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt chunk: invalid nonce size %d", len(nonce))
+	}
+
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk: %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// layersPrefix is the prefix of every layer object key
+const layersPrefix = "layers/"
+
+// GC deletes the layer objects in the object store that are no longer referenced by
+// any layer (e.g. ones left behind by deleted versions) and returns their keys.
+//
+// It is safe to run concurrently with reads and checkpoints, including from other
+// nodes: objects referenced by a layer are never deleted, and checkpoints uploading a
+// new object are waited for (and held back) while garbage collection runs.
+func (mgr *Manager) GC(ctx context.Context) ([]string, error) {
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = mgr.metaStore.LockObjectsExclusive(ctx, tx)
+	if err != nil {
+		mgr.log.Error("Failed to lock objects", "error", err)
+		return nil, fmt.Errorf("failed to lock objects: %w", err)
+	}
+
+	referencedKeys, err := mgr.metaStore.GetAllObjectKeys(ctx, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get referenced object keys", "error", err)
+		return nil, err
+	}
+
+	referenced := make(map[string]bool, len(referencedKeys))
+	for _, key := range referencedKeys {
+		referenced[key] = true
+	}
+
+	storedKeys, err := mgr.objectStore.ListObjects(ctx, layersPrefix)
+	if err != nil {
+		mgr.log.Error("Failed to list objects", "error", err)
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	deleted := []string{}
+	for _, key := range storedKeys {
+		if referenced[key] {
+			continue
+		}
+
+		if err := mgr.objectStore.DeleteObject(ctx, key); err != nil {
+			mgr.log.Error("Failed to delete unreferenced object", "objectKey", key, "error", err)
+			return deleted, fmt.Errorf("failed to delete object %s: %w", key, err)
+		}
+
+		if mgr.cache != nil {
+			mgr.cache.invalidate(key)
+		}
+
+		mgr.log.Debug("Deleted unreferenced object", "objectKey", key)
+		deleted = append(deleted, key)
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return deleted, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Info("Garbage collection done", "referenced", len(referencedKeys), "deleted", len(deleted))
+
+	return deleted, nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// healthCheckKey is the object written and read back by Health, outside of the layers so
+// that the garbage collector ignores it
+const healthCheckKey = "health/check"
+
+// Health checks that the metadata store and the object store are reachable, pinging the
+// database and writing then reading back a small object. The returned error describes
+// every check that failed.
+func (mgr *Manager) Health(ctx context.Context) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	var errs []error
+
+	if err := mgr.db.PingContext(ctx); err != nil {
+		mgr.log.Error("Metadata store health check failed", "error", err)
+		errs = append(errs, fmt.Errorf("metadata store is unreachable: %w", err))
+	}
+
+	if err := mgr.checkObjectStore(ctx); err != nil {
+		mgr.log.Error("Object store health check failed", "error", err)
+		errs = append(errs, fmt.Errorf("object store is unreachable: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkObjectStore does a round-trip of a unique payload to the object store
+func (mgr *Manager) checkObjectStore(ctx context.Context) error {
+	payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	if err := mgr.objectStore.PutObject(ctx, healthCheckKey, payload); err != nil {
+		return fmt.Errorf("failed to put %s: %w", healthCheckKey, err)
+	}
+
+	data, err := mgr.objectStore.GetObject(ctx, healthCheckKey, [2]uint64{0, uint64(len(payload) - 1)})
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", healthCheckKey, err)
+	}
+
+	if !bytes.Equal(data, payload) {
+		return fmt.Errorf("read back %q from %s, expected %q", data, healthCheckKey, payload)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// versionsPageSize is the number of versions fetched at once by WalkVersions
+const versionsPageSize = 1000
+
+// VersionInfo describes a version of a file, as listed by GetAllVersions.
+type VersionInfo struct {
+	ID        uint64
+	FileName  string
+	Tag       string
+	CreatedAt time.Time
+	Message   string
+	Author    string
+	Origin    string // OriginManual, OriginWAL, OriginFlush or OriginCompact
+	ObjectKey string // key of the layer object holding the version's data
+}
+
+// WalkVersions calls fn for every version of every file, in creation order. Versions are
+// fetched a page at a time, so the whole history is never loaded in memory. Walking stops
+// at the first error returned by fn, which is returned.
+func (mgr *Manager) WalkVersions(ctx context.Context, fn func(VersionInfo) error) error {
+	var afterCreatedAt time.Time
+	var afterID uint64
+
+	for {
+		rows, err := mgr.metaStore.GetAllVersionsPage(ctx, afterCreatedAt, afterID, versionsPageSize)
+		if err != nil {
+			mgr.log.Error("Failed to get versions", "after", afterID, "error", err)
+			return fmt.Errorf("failed to get versions: %w", err)
+		}
+
+		for _, row := range rows {
+			err := fn(VersionInfo{
+				ID:        row.ID,
+				FileName:  row.FileName,
+				Tag:       row.Tag,
+				CreatedAt: row.CreatedAt,
+				Message:   row.Message,
+				Author:    row.Author,
+				Origin:    row.Origin,
+				ObjectKey: row.ObjectKey,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < versionsPageSize {
+			return nil
+		}
+
+		last := rows[len(rows)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+}
+
+// GetAllVersions returns the versions of every file, in creation order. Use WalkVersions
+// for large histories.
+func (mgr *Manager) GetAllVersions(ctx context.Context) ([]VersionInfo, error) {
+	var versions []VersionInfo
+	err := mgr.WalkVersions(ctx, func(v VersionInfo) error {
+		versions = append(versions, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
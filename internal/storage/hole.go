@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// PunchHole makes the file range [offset, offset+length) read as zeroes, like
+// fallocate(FALLOC_FL_PUNCH_HOLE | FALLOC_FL_KEEP_SIZE) does: it records a tombstone chunk, a
+// hole, in the active layer, which overrides the data of earlier chunks there and is persisted
+// with the layer on Checkpoint. Earlier versions keep their data. The size of the file doesn't
+// change, the part of the range past its end is ignored.
+func (mgr *Manager) PunchHole(ctx context.Context, filename string, offset uint64, length uint64) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	mgr.log.Debug("Punching hole", "filename", filename, "offset", offset, "length", length)
+
+	activeLayer, fileSize, err := mgr.prepareWrite(ctx, filename, writeOptions{})
+	if err != nil {
+		return err
+	}
+
+	end := min(offset+length, fileSize)
+	if offset >= end {
+		return nil
+	}
+
+	err = mgr.journalWrites(activeLayer.FileID, journalRecord{fileSize: fileSize, offset: offset, hole: end - offset, isHole: true})
+	if err != nil {
+		return err
+	}
+
+	appendHole(activeLayer, offset, end)
+	return nil
+}
+
+// appendHole adds a hole covering the file range [start, end) to the active layer. It has no
+// data: its layer range is empty, at the end of the layer data. It must be called with mgr.mu
+// held.
+func appendHole(activeLayer *metadata.Layer, start uint64, end uint64) {
+	layerSize := uint64(len(activeLayer.Data))
+	activeLayer.Chunks = append(activeLayer.Chunks, metadata.Chunk{
+		LayerRange: [2]uint64{layerSize, layerSize},
+		FileRange:  [2]uint64{start, end},
+		Hole:       true,
+	})
+	activeLayer.FileEnd = max(activeLayer.FileEnd, end)
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// journalHeaderSize is the size of the fixed part of a journal record: the CRC32C of the rest
+// of the record, the size of the file before the write, the offset and size of the write, and
+// its flags. The data of the write follows.
+const journalHeaderSize = 4 + 8 + 8 + 8 + 1
+
+const (
+	journalInPlace byte = 1 << iota // the write was made with WithInPlaceOverwrite
+	journalHole                     // a hole punched by PunchHole, the offset and length of the data are its range
+)
+
+const journalExt = ".journal"
+
+// journalRecord is a write of an active layer, with what appendWrite needs to apply it again,
+// or a hole punched in it, with what appendHole needs
+type journalRecord struct {
+	fileSize uint64
+	offset   uint64
+	inPlace  bool
+	data     []byte
+	hole     uint64 // length of the hole, only meaningful if isHole is true
+	isHole   bool
+}
+
+// journal keeps the writes of active layers in a local directory, in one file per file with
+// uncommitted writes named <fileID>.journal, so that they can be replayed into the memtable
+// after a crash (see WithJournal). It must be used with mgr.mu held.
+type journal struct {
+	dir   string
+	files map[uint64]*os.File // opened for appending, by file id
+}
+
+func openJournal(dir string) (*journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	return &journal{dir: dir, files: make(map[uint64]*os.File)}, nil
+}
+
+func (j *journal) path(fileID uint64) string {
+	return filepath.Join(j.dir, strconv.FormatUint(fileID, 10)+journalExt)
+}
+
+// append writes records to the journal of a file and syncs it. If that fails, the journal is
+// truncated back to its previous size, so it never holds writes that weren't applied.
+func (j *journal) append(fileID uint64, records []journalRecord) error {
+	f, ok := j.files[fileID]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(j.path(fileID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		// Make sure a newly created journal survives a crash too
+		if err := syncDir(j.dir); err != nil {
+			f.Close()
+			return err
+		}
+		j.files[fileID] = f
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, r := range records {
+		buf = appendJournalRecord(buf, r)
+	}
+
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		if truncErr := f.Truncate(info.Size()); truncErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to truncate journal: %w", truncErr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// remove deletes the journal of a file, if it has one
+func (j *journal) remove(fileID uint64) error {
+	if f, ok := j.files[fileID]; ok {
+		f.Close()
+		delete(j.files, fileID)
+	}
+
+	err := os.Remove(j.path(fileID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// read returns the records of every journal in the directory, by file id. A record that was
+// cut short or corrupted by a crash while it was written ends the journal: it is truncated
+// there, and the number of dropped bytes is returned by file id.
+func (j *journal) read() (map[uint64][]journalRecord, map[uint64]int64, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list journals: %w", err)
+	}
+
+	records := make(map[uint64][]journalRecord)
+	dropped := make(map[uint64]int64)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), journalExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		fileID, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(j.path(fileID))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read journal of file %d: %w", fileID, err)
+		}
+
+		var valid int
+		for valid < len(data) {
+			r, n, ok := parseJournalRecord(data[valid:])
+			if !ok {
+				break
+			}
+			records[fileID] = append(records[fileID], r)
+			valid += n
+		}
+
+		if valid < len(data) {
+			if err := os.Truncate(j.path(fileID), int64(valid)); err != nil {
+				return nil, nil, fmt.Errorf("failed to truncate journal of file %d: %w", fileID, err)
+			}
+			dropped[fileID] = int64(len(data) - valid)
+		}
+	}
+
+	return records, dropped, nil
+}
+
+func (j *journal) close() error {
+	var errs []error
+	for fileID, f := range j.files {
+		errs = append(errs, f.Close())
+		delete(j.files, fileID)
+	}
+	return errors.Join(errs...)
+}
+
+func appendJournalRecord(buf []byte, r journalRecord) []byte {
+	start := len(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, 0) // checksum, set once the record is complete
+	buf = binary.LittleEndian.AppendUint64(buf, r.fileSize)
+	buf = binary.LittleEndian.AppendUint64(buf, r.offset)
+	var flags byte
+	if r.inPlace {
+		flags |= journalInPlace
+	}
+	if r.isHole {
+		// A hole has no data, the length field holds its length instead
+		flags |= journalHole
+		buf = binary.LittleEndian.AppendUint64(buf, r.hole)
+	} else {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(r.data)))
+	}
+	buf = append(buf, flags)
+	buf = append(buf, r.data...)
+
+	binary.LittleEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+4:], castagnoli))
+	return buf
+}
+
+// parseJournalRecord parses the record at the start of data, returning its size, or false if
+// it is incomplete or corrupted
+func parseJournalRecord(data []byte) (journalRecord, int, bool) {
+	if len(data) < journalHeaderSize {
+		return journalRecord{}, 0, false
+	}
+
+	size := binary.LittleEndian.Uint64(data[20:28])
+	isHole := data[28]&journalHole != 0
+
+	n := journalHeaderSize
+	if !isHole {
+		if size > uint64(len(data)-journalHeaderSize) {
+			return journalRecord{}, 0, false
+		}
+		n += int(size)
+	}
+	if crc32.Checksum(data[4:n], castagnoli) != binary.LittleEndian.Uint32(data[0:4]) {
+		return journalRecord{}, 0, false
+	}
+
+	r := journalRecord{
+		fileSize: binary.LittleEndian.Uint64(data[4:12]),
+		offset:   binary.LittleEndian.Uint64(data[12:20]),
+		inPlace:  data[28]&journalInPlace != 0,
+		isHole:   isHole,
+	}
+	if isHole {
+		r.hole = size
+	} else {
+		r.data = data[journalHeaderSize:n]
+	}
+	return r, n, true
+}
+
+// syncDir makes the creation of the files of a directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// replayJournal rebuilds the active layers of the files with journaled writes, applying the
+// writes again in order.
+func (mgr *Manager) replayJournal() error {
+	records, dropped, err := mgr.journal.read()
+	if err != nil {
+		return err
+	}
+
+	for fileID, n := range dropped {
+		mgr.log.Warn("Dropped incomplete write at the end of journal", "fileID", fileID, "bytes", n)
+	}
+
+	for fileID, fileRecords := range records {
+		activeLayer := &metadata.Layer{
+			FileID: fileID,
+			Chunks: []metadata.Chunk{},
+			Data:   []byte{},
+			Active: true,
+		}
+		for _, r := range fileRecords {
+			if r.isHole {
+				appendHole(activeLayer, r.offset, r.offset+r.hole)
+				continue
+			}
+			mgr.appendWrite(activeLayer, r.fileSize, r.data, r.offset, writeOptions{inPlace: r.inPlace})
+		}
+		mgr.memtable[fileID] = activeLayer
+
+		mgr.log.Info("Replayed uncommitted writes from journal", "fileID", fileID, "writes", len(fileRecords), "bytes", humanize.Bytes(activeLayer.Size))
+	}
+
+	return nil
+}
+
+// journalWrites records writes of a file in the journal, when enabled, before they are
+// applied to its active layer. It must be called with mgr.mu held.
+func (mgr *Manager) journalWrites(fileID uint64, records ...journalRecord) error {
+	if mgr.journalErr != nil {
+		return fmt.Errorf("cannot journal writes: %w", mgr.journalErr)
+	}
+	if mgr.journal == nil {
+		return nil
+	}
+
+	if err := mgr.journal.append(fileID, records); err != nil {
+		mgr.log.Error("Failed to journal writes", "fileID", fileID, "error", err)
+		return fmt.Errorf("failed to journal writes: %w", err)
+	}
+	return nil
+}
+
+// dropJournal deletes the journal of a file whose active layer was checkpointed or discarded.
+// It must be called with mgr.mu held.
+func (mgr *Manager) dropJournal(fileID uint64) {
+	if mgr.journal == nil {
+		return
+	}
+
+	if err := mgr.journal.remove(fileID); err != nil {
+		mgr.log.Error("Failed to delete journal, its writes will be replayed on restart", "fileID", fileID, "error", err)
+	}
+}
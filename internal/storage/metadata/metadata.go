@@ -3,20 +3,46 @@ package metadata
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/vinimdocarmo/quackfs/db/sqlc"
 	"github.com/vinimdocarmo/quackfs/db/types"
 )
 
 // Chunk holds information about where data was written in the layer data
 type Chunk struct {
-	LayerID    uint64    // 0 if flushed is false
-	Flushed    bool      // whether the chunk metadata has been persisted to the database
-	LayerRange [2]uint64 // Range within a layer as an array of two integers
-	FileRange  [2]uint64 // Range within the virtual file as an array of two integers
+	LayerID     uint64    // 0 if flushed is false
+	Flushed     bool      // whether the chunk metadata has been persisted to the database
+	LayerRange  [2]uint64 // Range within a layer as an array of two integers
+	FileRange   [2]uint64 // Range within the virtual file as an array of two integers
+	ObjectRange [2]uint64 // Range of the stored (possibly compressed) data within the layer object, set once flushed
+	Nonce       []byte    // Nonce the stored data was encrypted with, nil if the layer isn't encrypted
+	KeyID       string    // ID of the key the stored data was encrypted with, empty if the layer isn't encrypted
+	Checksum    uint32    // CRC32C of the chunk data, only meaningful if HasChecksum is true
+	HasChecksum bool      // false for chunks persisted before checksums were introduced
+	// Hash is the SHA-256 of the chunk data, used to find identical chunks. nil for chunks
+	// persisted before deduplication was introduced.
+	Hash []byte
+	// SourceLayerID is the layer whose object holds the chunk data (at ObjectRange) when the
+	// chunk is deduplicated, 0 if it's stored in the object of its own layer
+	SourceLayerID uint64
+	// Hole marks a tombstone left by Manager.PunchHole: its file range reads as zeroes, and it
+	// has no data, so its layer and object ranges are empty
+	Hole bool
+}
+
+// DataLayerID returns the id of the layer whose object holds the data of the chunk
+func (c Chunk) DataLayerID() uint64 {
+	if c.SourceLayerID != 0 {
+		return c.SourceLayerID
+	}
+	return c.LayerID
 }
 
 // Layer represents a snapshot layer.
@@ -33,15 +59,37 @@ type Chunk struct {
 // are stored in the chunks metadata. Which write will be represented by a
 // chunkMetadata.
 type Layer struct {
-	ID        uint64
-	FileID    uint64
-	Active    bool // whether or not it is the current active layer (memory resident)
-	VersionID uint64
-	Tag       string
-	Chunks    []Chunk
-	Size      uint64
-	Data      []byte
-	ObjectKey string
+	ID          uint64
+	FileID      uint64
+	Active      bool // whether or not it is the current active layer (memory resident)
+	VersionID   uint64
+	Tag         string
+	Chunks      []Chunk // in write order, later chunks win where file ranges overlap (for the active layer, layer ranges follow each other over Data)
+	Size        uint64
+	Data        []byte
+	ObjectKey   string
+	Compression string
+	Encrypted   bool
+	Archived    bool          // whether the layer object is in cold storage
+	Origins     []WriteOrigin // writes that produced the layer's data, only recorded when write tracing is enabled
+	FileEnd     uint64        // for the active layer, the highest end of its chunks' file ranges
+}
+
+// WriteOrigin records which request (e.g. a FUSE write) added a range of a layer's data.
+type WriteOrigin struct {
+	LayerRange [2]uint64 // bytes of the layer the write added
+	RequestID  uint64
+	PID        uint32
+	Offset     uint64 // file offset of the write
+	Size       uint64
+}
+
+// FileAttr holds the attributes of a file kept across remounts.
+type FileAttr struct {
+	ID         uint64      // ID of the file, which tells replaced files apart from the file they replaced
+	Mode       os.FileMode // permission bits
+	CreatedAt  time.Time
+	ModifiedAt time.Time
 }
 
 type MetadataStore struct {
@@ -93,15 +141,325 @@ func (ms *MetadataStore) GetFileIDByName(ctx context.Context, name string, opts
 	return fileID, nil
 }
 
-func (ms *MetadataStore) InsertFile(ctx context.Context, name string) (uint64, error) {
-	fileID, err := ms.queries.InsertFile(ctx, name)
+// uniqueViolation is the PostgreSQL error code of unique constraint violations
+const uniqueViolation = "23505"
+
+// InsertFile inserts a file, failing with types.ErrFileExists if one already has the name
+func (ms *MetadataStore) InsertFile(ctx context.Context, name string, opts ...QueryOpt) (uint64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	fileID, err := queries.InsertFile(ctx, name)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("file %q: %w", name, types.ErrFileExists)
+		}
 		return 0, err
 	}
 
 	return fileID, nil
 }
 
+// GetFileAttr returns the attributes of a file
+func (ms *MetadataStore) GetFileAttr(ctx context.Context, fileID uint64, opts ...QueryOpt) (FileAttr, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	row, err := queries.GetFileAttr(ctx, fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return FileAttr{}, types.ErrNotFound
+		}
+		return FileAttr{}, fmt.Errorf("failed to get file attributes: %w", err)
+	}
+
+	return FileAttr{
+		ID:         fileID,
+		Mode:       os.FileMode(row.Mode) & os.ModePerm,
+		CreatedAt:  row.CreatedAt,
+		ModifiedAt: row.ModifiedAt,
+	}, nil
+}
+
+// XattrSetMode tells SetFileXattr what to do depending on whether the file already has the
+// extended attribute.
+type XattrSetMode int
+
+const (
+	XattrUpsert  XattrSetMode = iota // create the attribute or replace its value
+	XattrCreate                      // fail with types.ErrXattrExists if the file has the attribute
+	XattrReplace                     // fail with types.ErrNotFound if the file doesn't have the attribute
+)
+
+// GetFileXattr returns the value of an extended attribute of a file, or types.ErrNotFound
+func (ms *MetadataStore) GetFileXattr(ctx context.Context, fileID uint64, name string, opts ...QueryOpt) ([]byte, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	value, err := queries.GetFileXattr(ctx, sqlc.GetFileXattrParams{FileID: fileID, Name: name})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, types.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get extended attribute: %w", err)
+	}
+
+	return value, nil
+}
+
+// ListFileXattrs returns the names of the extended attributes of a file, sorted
+func (ms *MetadataStore) ListFileXattrs(ctx context.Context, fileID uint64, opts ...QueryOpt) ([]string, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	names, err := queries.ListFileXattrs(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+
+	return names, nil
+}
+
+// SetFileXattr sets the value of an extended attribute of a file, as told by mode
+func (ms *MetadataStore) SetFileXattr(ctx context.Context, fileID uint64, name string, value []byte, mode XattrSetMode, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	// The column is NOT NULL, an empty value is still a value
+	if value == nil {
+		value = []byte{}
+	}
+
+	switch mode {
+	case XattrCreate:
+		n, err := queries.InsertFileXattr(ctx, sqlc.InsertFileXattrParams{FileID: fileID, Name: name, Value: value})
+		if err != nil {
+			return fmt.Errorf("failed to create extended attribute: %w", err)
+		}
+		if n == 0 {
+			return types.ErrXattrExists
+		}
+	case XattrReplace:
+		n, err := queries.ReplaceFileXattr(ctx, sqlc.ReplaceFileXattrParams{FileID: fileID, Name: name, Value: value})
+		if err != nil {
+			return fmt.Errorf("failed to replace extended attribute: %w", err)
+		}
+		if n == 0 {
+			return types.ErrNotFound
+		}
+	default:
+		err := queries.SetFileXattr(ctx, sqlc.SetFileXattrParams{FileID: fileID, Name: name, Value: value})
+		if err != nil {
+			return fmt.Errorf("failed to set extended attribute: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RemoveFileXattr removes an extended attribute of a file, or returns types.ErrNotFound
+func (ms *MetadataStore) RemoveFileXattr(ctx context.Context, fileID uint64, name string, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	n, err := queries.DeleteFileXattr(ctx, sqlc.DeleteFileXattrParams{FileID: fileID, Name: name})
+	if err != nil {
+		return fmt.Errorf("failed to remove extended attribute: %w", err)
+	}
+	if n == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
+
+// SetFileMode sets the permission bits of a file
+func (ms *MetadataStore) SetFileMode(ctx context.Context, fileID uint64, mode os.FileMode, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.SetFileMode(ctx, sqlc.SetFileModeParams{
+		ID:   fileID,
+		Mode: int32(mode & os.ModePerm),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	return nil
+}
+
+// SetFileModifiedAt sets the modification time of a file
+func (ms *MetadataStore) SetFileModifiedAt(ctx context.Context, fileID uint64, modifiedAt time.Time, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.SetFileModifiedAt(ctx, sqlc.SetFileModifiedAtParams{
+		ID:         fileID,
+		ModifiedAt: modifiedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set file modification time: %w", err)
+	}
+	return nil
+}
+
+// RenameFile changes the name of a file
+func (ms *MetadataStore) RenameFile(ctx context.Context, fileID uint64, name string, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.RenameFile(ctx, sqlc.RenameFileParams{
+		Name: name,
+		ID:   fileID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// DeleteFile deletes a file along with its heads, layers, chunks, versions and extended
+// attributes. The layer objects are left in the object store, for garbage collection to delete.
+func (ms *MetadataStore) DeleteFile(ctx context.Context, tx *sql.Tx, fileID uint64) error {
+	queries := ms.queries.WithTx(tx)
+
+	if err := queries.DeleteFileChunks(ctx, fileID); err != nil {
+		return fmt.Errorf("failed to delete chunks: %w", err)
+	}
+	if err := queries.DeleteFileHeads(ctx, fileID); err != nil {
+		return fmt.Errorf("failed to delete heads: %w", err)
+	}
+	if err := queries.DeleteFileLayers(ctx, fileID); err != nil {
+		return fmt.Errorf("failed to delete layers: %w", err)
+	}
+	if err := queries.DeleteFile(ctx, fileID); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// AcquireFileEpoch bumps the fencing token of a file and returns the new value.
+// Whoever holds the latest epoch is the only one allowed to modify the file.
+func (ms *MetadataStore) AcquireFileEpoch(ctx context.Context, fileID uint64, opts ...QueryOpt) (int64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	epoch, err := queries.AcquireFileEpoch(ctx, fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, types.ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to acquire file epoch: %w", err)
+	}
+
+	return epoch, nil
+}
+
+// GetFileEpoch returns the current fencing token of a file. When called within a
+// transaction, the file can't be acquired by anyone else until the transaction ends.
+func (ms *MetadataStore) GetFileEpoch(ctx context.Context, fileID uint64, opts ...QueryOpt) (int64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	epoch, err := queries.GetFileEpoch(ctx, fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, types.ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to get file epoch: %w", err)
+	}
+
+	return epoch, nil
+}
+
 func (ms *MetadataStore) GetAllFiles(ctx context.Context) ([]sqlc.File, error) {
 	return ms.queries.GetAllFiles(ctx)
 }
@@ -135,6 +493,79 @@ func (ms *MetadataStore) CalcSizeOf(ctx context.Context, fileID uint64, opts ...
 	return uint64(fileSize), nil
 }
 
+// CalcSizeOfLayer returns the size of the file as of the given layer, ignoring later layers.
+func (ms *MetadataStore) CalcSizeOfLayer(ctx context.Context, fileID uint64, layerID uint64, opts ...QueryOpt) (uint64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	fileSize, err := queries.CalcFileSizeAtLayer(ctx, sqlc.CalcFileSizeAtLayerParams{
+		FileID:  fileID,
+		LayerID: layerID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(fileSize), nil
+}
+
+// GetFileSize returns the size of the file as of its latest layer, as recorded by
+// GrowFileSize. It's the same as CalcSizeOf, without going through the chunks.
+func (ms *MetadataStore) GetFileSize(ctx context.Context, fileID uint64, opts ...QueryOpt) (uint64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	size, err := queries.GetFileCurrentSize(ctx, fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, types.ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to get file size: %w", err)
+	}
+
+	return uint64(size), nil
+}
+
+// GrowFileSize records that the file is at least size bytes long, e.g. after inserting the
+// chunks of a new layer. It must be called in the transaction inserting them.
+func (ms *MetadataStore) GrowFileSize(ctx context.Context, fileID uint64, size uint64, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.GrowFileCurrentSize(ctx, sqlc.GrowFileCurrentSizeParams{
+		ID:   fileID,
+		Size: int64(size),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update file size: %w", err)
+	}
+	return nil
+}
+
 func (ms *MetadataStore) InsertChunk(ctx context.Context, layerID uint64, c Chunk, opts ...QueryOpt) error {
 	options := QueryOpts{}
 	for _, opt := range opts {
@@ -145,11 +576,258 @@ func (ms *MetadataStore) InsertChunk(ctx context.Context, layerID uint64, c Chun
 
 	layerRange := types.Range(c.LayerRange)
 	fileRange := types.Range(c.FileRange)
+	objectRange := types.Range(c.ObjectRange)
 
 	params := sqlc.InsertChunkParams{
 		SnapshotLayerID: layerID,
 		LayerRange:      layerRange,
 		FileRange:       fileRange,
+		ObjectRange:     objectRange,
+		Nonce:           c.Nonce,
+		KeyID:           sql.NullString{String: c.KeyID, Valid: c.KeyID != ""},
+		Checksum:        sql.NullInt64{Int64: int64(c.Checksum), Valid: c.HasChecksum},
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err = queries.InsertChunk(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to insert chunk: %w", err)
+	}
+
+	return nil
+}
+
+// InsertChunks inserts all the chunks of a layer with a single query. Chunks keep their
+// order, so later chunks in the slice take precedence over earlier ones when read.
+func (ms *MetadataStore) InsertChunks(ctx context.Context, layerID uint64, chunks []Chunk, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	params := sqlc.InsertChunksParams{
+		SnapshotLayerID: int64(layerID),
+		LayerStarts:     make([]int64, len(chunks)),
+		LayerEnds:       make([]int64, len(chunks)),
+		FileStarts:      make([]int64, len(chunks)),
+		FileEnds:        make([]int64, len(chunks)),
+		ObjectStarts:    make([]int64, len(chunks)),
+		ObjectEnds:      make([]int64, len(chunks)),
+		Nonces:          make([][]byte, len(chunks)),
+		KeyIDs:          make([]string, len(chunks)),
+		Checksums:       make([]int64, len(chunks)),
+		HasChecksums:    make([]bool, len(chunks)),
+		ChunkHashes:     make([][]byte, len(chunks)),
+		SourceLayerIDs:  make([]int64, len(chunks)),
+		Holes:           make([]bool, len(chunks)),
+	}
+
+	for i, c := range chunks {
+		params.LayerStarts[i] = int64(c.LayerRange[0])
+		params.LayerEnds[i] = int64(c.LayerRange[1])
+		params.FileStarts[i] = int64(c.FileRange[0])
+		params.FileEnds[i] = int64(c.FileRange[1])
+		params.ObjectStarts[i] = int64(c.ObjectRange[0])
+		params.ObjectEnds[i] = int64(c.ObjectRange[1])
+		params.Nonces[i] = c.Nonce
+		params.KeyIDs[i] = c.KeyID
+		params.Checksums[i] = int64(c.Checksum)
+		params.HasChecksums[i] = c.HasChecksum
+		params.ChunkHashes[i] = c.Hash
+		params.SourceLayerIDs[i] = int64(c.SourceLayerID)
+		params.Holes[i] = c.Hole
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.InsertChunks(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to insert chunks: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MetadataStore) LoadLayersByFileID(ctx context.Context, fileID uint64, opts ...QueryOpt) ([]*Layer, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var rows []sqlc.GetLayersByFileIDRow
+	var err error
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	rows, err = queries.GetLayersByFileID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []*Layer
+
+	for _, row := range rows {
+		layer := &Layer{FileID: row.FileID}
+		layer.ID = row.ID
+		if row.VersionID.Valid {
+			layer.VersionID = uint64(row.VersionID.Int64)
+		} else {
+			layer.VersionID = 0
+		}
+		if row.Tag.Valid {
+			layer.Tag = row.Tag.String
+		}
+		layer.ObjectKey = row.ObjectKey
+		layer.Compression = row.Compression
+		layer.Encrypted = row.Encrypted
+		layer.Archived = row.Archived
+		layers = append(layers, layer)
+	}
+
+	return layers, nil
+}
+
+// InsertVersion inserts a new version, message and author may be empty
+func (ms *MetadataStore) InsertVersion(ctx context.Context, tx *sql.Tx, version string, message string, author string, origin string) (uint64, error) {
+	queries := ms.queries.WithTx(tx)
+	versionID, err := queries.InsertVersion(ctx, sqlc.InsertVersionParams{
+		Tag:     version,
+		Message: message,
+		Author:  author,
+		Origin:  origin,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert new version: %w", err)
+	}
+	return versionID, nil
+}
+
+// VersionExists reports whether the file already has a version with the given tag
+func (ms *MetadataStore) VersionExists(ctx context.Context, fileID uint64, tag string, opts ...QueryOpt) (bool, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	exists, err := queries.VersionTagExists(ctx, sqlc.VersionTagExistsParams{
+		FileID: fileID,
+		Tag:    tag,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check version tag: %w", err)
+	}
+	return exists, nil
+}
+
+// MaxNumericVersionTag returns the highest n among the file's version tags of the form
+// "vn" (e.g. 3 for v1, v3 and nightly), or 0 if it has none
+func (ms *MetadataStore) MaxNumericVersionTag(ctx context.Context, fileID uint64, opts ...QueryOpt) (uint64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	maxTag, err := queries.GetMaxNumericVersionTag(ctx, fileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get highest version tag: %w", err)
+	}
+	return uint64(maxTag), nil
+}
+
+func (ms *MetadataStore) InsertLayer(ctx context.Context, tx *sql.Tx, fileID uint64, versionID uint64, objectKey string, compression string, encrypted bool) (uint64, error) {
+	params := sqlc.InsertLayerParams{
+		FileID:      fileID,
+		VersionID:   sql.NullInt64{Int64: int64(versionID), Valid: true},
+		ObjectKey:   objectKey,
+		Compression: compression,
+		Encrypted:   encrypted,
+	}
+
+	layerID, err := ms.queries.WithTx(tx).InsertLayer(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert layer: %w", err)
+	}
+	return layerID, nil
+}
+
+// GetLayerObject returns the key of a layer's object and how its chunks are stored in it.
+// Only the object related fields of the returned layer are set, and it is nil if the layer doesn't exist.
+func (ms *MetadataStore) GetLayerObject(ctx context.Context, layerID uint64) (*Layer, error) {
+	row, err := ms.queries.GetLayerObject(ctx, layerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving object key: %w", err)
+	}
+	return &Layer{
+		ID:          layerID,
+		ObjectKey:   row.ObjectKey,
+		Compression: row.Compression,
+		Encrypted:   row.Encrypted,
+		Archived:    row.Archived,
+	}, nil
+}
+
+// SetLayerArchived records whether the object of a layer is in cold storage
+func (ms *MetadataStore) SetLayerArchived(ctx context.Context, layerID uint64, archived bool, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.SetLayerArchived(ctx, sqlc.SetLayerArchivedParams{
+		ID:       layerID,
+		Archived: archived,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set layer archived: %w", err)
+	}
+
+	return nil
+}
+
+// SetLayerCompacted marks a layer as holding the whole content of its file, so that reads of
+// its version and later ones skip the chunks of the layers before it
+func (ms *MetadataStore) SetLayerCompacted(ctx context.Context, layerID uint64, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
 	}
 
 	queries := ms.queries
@@ -158,86 +836,63 @@ func (ms *MetadataStore) InsertChunk(ctx context.Context, layerID uint64, c Chun
 		queries = ms.queries.WithTx(options.tx)
 	}
 
-	err = queries.InsertChunk(ctx, params)
-	if err != nil {
-		return fmt.Errorf("failed to insert chunk: %w", err)
+	if err := queries.SetLayerCompacted(ctx, layerID); err != nil {
+		return fmt.Errorf("failed to set layer compacted: %w", err)
 	}
 
 	return nil
 }
 
-func (ms *MetadataStore) LoadLayersByFileID(ctx context.Context, fileID uint64, opts ...QueryOpt) ([]*Layer, error) {
+// GetAllObjectKeys returns the object keys referenced by every layer of every file
+func (ms *MetadataStore) GetAllObjectKeys(ctx context.Context, opts ...QueryOpt) ([]string, error) {
 	options := QueryOpts{}
 	for _, opt := range opts {
 		opt(&options)
 	}
 
-	var rows []sqlc.GetLayersByFileIDRow
-	var err error
-
 	queries := ms.queries
 
 	if options.tx != nil {
 		queries = ms.queries.WithTx(options.tx)
 	}
 
-	rows, err = queries.GetLayersByFileID(ctx, fileID)
+	keys, err := queries.GetAllObjectKeys(ctx)
 	if err != nil {
-		return nil, err
-	}
-
-	var layers []*Layer
-
-	for _, row := range rows {
-		layer := &Layer{FileID: row.FileID}
-		layer.ID = row.ID
-		if row.VersionID.Valid {
-			layer.VersionID = uint64(row.VersionID.Int64)
-		} else {
-			layer.VersionID = 0
-		}
-		if row.Tag.Valid {
-			layer.Tag = row.Tag.String
-		}
-		layer.ObjectKey = row.ObjectKey
-		layers = append(layers, layer)
+		return nil, fmt.Errorf("failed to get object keys: %w", err)
 	}
 
-	return layers, nil
+	return keys, nil
 }
 
-func (ms *MetadataStore) InsertVersion(ctx context.Context, tx *sql.Tx, version string) (uint64, error) {
-	queries := ms.queries.WithTx(tx)
-	versionID, err := queries.InsertVersion(ctx, version)
-	if err != nil {
-		return 0, fmt.Errorf("failed to insert new version: %w", err)
-	}
-	return versionID, nil
-}
+// Advisory locks taken by the package, all held until the end of their transaction:
+//   - objectsLockID coordinates object uploads with garbage collection
+//   - lockNamespace and migrationsLockKey serialize migrations across nodes
+//
+// Postgres keeps locks taken with a single bigint key apart from the ones taken with two int4
+// keys, so new locks go in lockNamespace with a key of their own.
+const (
+	objectsLockID = 0x717561636b6673 // "quackfs"
 
-func (ms *MetadataStore) InsertLayer(ctx context.Context, tx *sql.Tx, fileID uint64, versionID uint64, objectKey string) (uint64, error) {
-	params := sqlc.InsertLayerParams{
-		FileID:    fileID,
-		VersionID: sql.NullInt64{Int64: int64(versionID), Valid: true},
-		ObjectKey: objectKey,
-	}
+	lockNamespace     = 0x71756163 // "quac"
+	migrationsLockKey = 1
+)
 
-	layerID, err := ms.queries.WithTx(tx).InsertLayer(ctx, params)
-	if err != nil {
-		return 0, fmt.Errorf("failed to insert layer: %w", err)
+// LockObjectsShared blocks garbage collection until tx ends. It must be held while
+// uploading an object that tx is going to reference.
+func (ms *MetadataStore) LockObjectsShared(ctx context.Context, tx *sql.Tx) error {
+	if err := ms.queries.WithTx(tx).LockObjectsShared(ctx, objectsLockID); err != nil {
+		return fmt.Errorf("failed to acquire shared objects lock: %w", err)
 	}
-	return layerID, nil
+	return nil
 }
 
-func (ms *MetadataStore) GetObjectKey(ctx context.Context, layerID uint64) (string, error) {
-	objectKey, err := ms.queries.GetObjectKey(ctx, layerID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", nil
-		}
-		return "", fmt.Errorf("error retrieving object key: %w", err)
+// LockObjectsExclusive waits for in-flight uploads to be committed and blocks new
+// ones until tx ends.
+func (ms *MetadataStore) LockObjectsExclusive(ctx context.Context, tx *sql.Tx) error {
+	if err := ms.queries.WithTx(tx).LockObjectsExclusive(ctx, objectsLockID); err != nil {
+		return fmt.Errorf("failed to acquire exclusive objects lock: %w", err)
 	}
-	return objectKey, nil
+	return nil
 }
 
 func (ms *MetadataStore) GetLayerByVersion(ctx context.Context, fileID uint64, versionTag string, tx *sql.Tx) (*Layer, error) {
@@ -250,7 +905,7 @@ func (ms *MetadataStore) GetLayerByVersion(ctx context.Context, fileID uint64, v
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("version tag not found")
+			return nil, fmt.Errorf("version tag not found: %s: %w", versionTag, types.ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to fetch layer: %w", err)
 	}
@@ -266,6 +921,9 @@ func (ms *MetadataStore) GetLayerByVersion(ctx context.Context, fileID uint64, v
 	}
 	layer.Tag = row.Tag
 	layer.ObjectKey = row.ObjectKey
+	layer.Compression = row.Compression
+	layer.Encrypted = row.Encrypted
+	layer.Archived = row.Archived
 
 	// Load the chunk metadata for this layer
 	chunks, err := ms.GetLayerChunks(ctx, layer.ID)
@@ -303,12 +961,20 @@ func RangesOverlap(range1 [2]uint64, range2 [2]uint64) bool {
 }
 
 // Helper function to convert chunk row data into a Chunk struct
-func toChunk(layerID uint64, layerRange types.Range, fileRange types.Range, flushed bool) Chunk {
+func toChunk(layerID uint64, layerRange types.Range, fileRange types.Range, objectRange types.Range, nonce []byte, keyID sql.NullString, checksum sql.NullInt64, hash []byte, sourceLayerID sql.NullInt32, hole bool, flushed bool) Chunk {
 	return Chunk{
-		LayerID:    layerID,
-		Flushed:    flushed,
-		LayerRange: [2]uint64(layerRange),
-		FileRange:  [2]uint64(fileRange),
+		LayerID:       layerID,
+		Flushed:       flushed,
+		LayerRange:    [2]uint64(layerRange),
+		FileRange:     [2]uint64(fileRange),
+		ObjectRange:   [2]uint64(objectRange),
+		Nonce:         nonce,
+		KeyID:         keyID.String,
+		Checksum:      uint32(checksum.Int64),
+		HasChecksum:   checksum.Valid,
+		Hash:          hash,
+		SourceLayerID: uint64(sourceLayerID.Int32),
+		Hole:          hole,
 	}
 }
 
@@ -321,13 +987,58 @@ func (ms *MetadataStore) GetLayerChunks(ctx context.Context, layerID uint64) ([]
 	var chunks []Chunk
 
 	for _, row := range rows {
-		chunk := toChunk(layerID, row.LayerRange, row.FileRange, true)
+		chunk := toChunk(layerID, row.LayerRange, row.FileRange, row.ObjectRange, row.Nonce, row.KeyID, row.Checksum, row.ChunkHash, row.SourceLayerID, row.Hole, true)
 		chunks = append(chunks, chunk)
 	}
 
 	return chunks, nil
 }
 
+// FindChunksByHash returns where the data of the chunks of a file with the given hashes is
+// stored, by hash. Only the object related fields of the returned chunks are set: the data
+// is at ObjectRange in the object of layer SourceLayerID, encoded with Nonce and KeyID. Only
+// data in readable (not archived) layers that are encrypted if encrypted is true, and not if
+// it's false, is returned.
+func (ms *MetadataStore) FindChunksByHash(ctx context.Context, fileID uint64, hashes [][]byte, encrypted bool, opts ...QueryOpt) (map[string]Chunk, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	found := make(map[string]Chunk)
+	if len(hashes) == 0 {
+		return found, nil
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	rows, err := queries.FindChunksByHash(ctx, sqlc.FindChunksByHashParams{
+		Hashes:    hashes,
+		FileID:    fileID,
+		Encrypted: encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find chunks by hash: %w", err)
+	}
+
+	for _, row := range rows {
+		found[string(row.ChunkHash)] = Chunk{
+			Flushed:       true,
+			ObjectRange:   [2]uint64(row.ObjectRange),
+			Nonce:         row.Nonce,
+			KeyID:         row.KeyID.String,
+			Hash:          row.ChunkHash,
+			SourceLayerID: row.DataLayerID,
+		}
+	}
+
+	return found, nil
+}
+
 type ChunkQueryOpt func(*ChunkQueryOpts)
 
 type ChunkQueryOpts struct {
@@ -364,7 +1075,7 @@ func (ms *MetadataStore) getOverlappingChunks(ctx context.Context, tx *sql.Tx, f
 	}
 
 	for _, row := range rows {
-		chunk := toChunk(row.SnapshotLayerID, row.LayerRange, row.FileRange, true)
+		chunk := toChunk(row.SnapshotLayerID, row.LayerRange, row.FileRange, row.ObjectRange, row.Nonce, row.KeyID, row.Checksum, row.ChunkHash, row.SourceLayerID, row.Hole, true)
 		chunks = append(chunks, chunk)
 	}
 
@@ -395,8 +1106,11 @@ func (ms *MetadataStore) GetAllOverlappingChunks(ctx context.Context, tx *sql.Tx
 	return chunks, nil
 }
 
-// SetHead sets the head pointer for a file to a specific version
-func (ms *MetadataStore) SetHead(ctx context.Context, fileID uint64, versionID uint64, opts ...QueryOpt) error {
+// DefaultBranch is the branch files are on until switched, the one the single head API works with
+const DefaultBranch = "main"
+
+// SetHead sets the head pointer of a branch of a file to a specific version, creating the branch if needed
+func (ms *MetadataStore) SetHead(ctx context.Context, fileID uint64, branch string, versionID uint64, opts ...QueryOpt) error {
 	options := QueryOpts{}
 	for _, opt := range opts {
 		opt(&options)
@@ -412,6 +1126,7 @@ func (ms *MetadataStore) SetHead(ctx context.Context, fileID uint64, versionID u
 	err = queries.SetHead(ctx, sqlc.SetHeadParams{
 		FileID:    fileID,
 		VersionID: versionID,
+		Branch:    branch,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to set head: %w", err)
@@ -419,7 +1134,31 @@ func (ms *MetadataStore) SetHead(ctx context.Context, fileID uint64, versionID u
 	return nil
 }
 
-// GetHeadVersion gets the current version the file head is pointing to
+// CreateBranch creates a branch of a file pointing to a specific version. It fails if the branch exists.
+func (ms *MetadataStore) CreateBranch(ctx context.Context, fileID uint64, branch string, versionID uint64, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.CreateBranch(ctx, sqlc.CreateBranchParams{
+		FileID:    fileID,
+		VersionID: versionID,
+		Branch:    branch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create branch: %w", err)
+	}
+	return nil
+}
+
+// GetHeadVersion gets the version the head of the file's current branch is pointing to
 func (ms *MetadataStore) GetHeadVersion(ctx context.Context, fileID uint64, opts ...QueryOpt) (uint64, string, error) {
 	options := QueryOpts{}
 	for _, opt := range opts {
@@ -445,8 +1184,131 @@ func (ms *MetadataStore) GetHeadVersion(ctx context.Context, fileID uint64, opts
 	return version.VersionID, version.VersionTag, nil
 }
 
-// DeleteHead removes the head pointer for a file
-func (ms *MetadataStore) DeleteHead(ctx context.Context, fileID uint64, opts ...QueryOpt) error {
+// GetLatestVersion gets the most recent version of the file and the ID of its layer, or
+// types.ErrNotFound if the file has no versions
+func (ms *MetadataStore) GetLatestVersion(ctx context.Context, fileID uint64, opts ...QueryOpt) (string, uint64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	version, err := queries.GetLatestVersion(ctx, fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, types.ErrNotFound
+		}
+		return "", 0, err
+	}
+	return version.Tag, version.LayerID, nil
+}
+
+// GetVersionAsOf gets the most recent version of the file created at or before asOf and the
+// ID of its layer, or types.ErrNotFound if every version of the file is newer
+func (ms *MetadataStore) GetVersionAsOf(ctx context.Context, fileID uint64, asOf time.Time, opts ...QueryOpt) (string, uint64, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	version, err := queries.GetVersionAsOf(ctx, sqlc.GetVersionAsOfParams{
+		FileID: fileID,
+		AsOf:   asOf,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, types.ErrNotFound
+		}
+		return "", 0, err
+	}
+	return version.Tag, version.LayerID, nil
+}
+
+// GetBranchVersion gets the version the head of a branch of the file is pointing to
+func (ms *MetadataStore) GetBranchVersion(ctx context.Context, fileID uint64, branch string, opts ...QueryOpt) (uint64, string, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	version, err := queries.GetBranchVersion(ctx, sqlc.GetBranchVersionParams{
+		FileID: fileID,
+		Branch: branch,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", types.ErrNotFound
+		}
+		return 0, "", err
+	}
+	return version.VersionID, version.VersionTag, nil
+}
+
+// GetCurrentBranch returns the branch the file is on
+func (ms *MetadataStore) GetCurrentBranch(ctx context.Context, fileID uint64, opts ...QueryOpt) (string, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	branch, err := queries.GetCurrentBranch(ctx, fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", types.ErrNotFound
+		}
+		return "", fmt.Errorf("failed to get current branch: %w", err)
+	}
+	return branch, nil
+}
+
+// SetCurrentBranch sets the branch reads and writes of the file resolve against
+func (ms *MetadataStore) SetCurrentBranch(ctx context.Context, fileID uint64, branch string, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.SetCurrentBranch(ctx, sqlc.SetCurrentBranchParams{
+		ID:            fileID,
+		CurrentBranch: branch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set current branch: %w", err)
+	}
+	return nil
+}
+
+// DeleteHead removes the head pointer of a branch of a file
+func (ms *MetadataStore) DeleteHead(ctx context.Context, fileID uint64, branch string, opts ...QueryOpt) error {
 	options := QueryOpts{}
 	for _, opt := range opts {
 		opt(&options)
@@ -459,14 +1321,17 @@ func (ms *MetadataStore) DeleteHead(ctx context.Context, fileID uint64, opts ...
 		queries = ms.queries.WithTx(options.tx)
 	}
 
-	err = queries.DeleteHead(ctx, fileID)
+	err = queries.DeleteHead(ctx, sqlc.DeleteHeadParams{
+		FileID: fileID,
+		Branch: branch,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete head: %w", err)
 	}
 	return nil
 }
 
-// GetAllHeads returns all head pointers
+// GetAllHeads returns all head pointers, of every branch
 func (ms *MetadataStore) GetAllHeads(ctx context.Context) ([]sqlc.GetAllHeadsRow, error) {
 	rows, err := ms.queries.GetAllHeads(ctx)
 	if err != nil {
@@ -475,6 +1340,54 @@ func (ms *MetadataStore) GetAllHeads(ctx context.Context) ([]sqlc.GetAllHeadsRow
 	return rows, nil
 }
 
+// GetAllVersionsPage returns up to pageSize versions of all files, in creation order, that
+// were created after the version with the given creation time and ID (zero values for the first page)
+func (ms *MetadataStore) GetAllVersionsPage(ctx context.Context, afterCreatedAt time.Time, afterID uint64, pageSize int) ([]sqlc.GetAllVersionsPageRow, error) {
+	rows, err := ms.queries.GetAllVersionsPage(ctx, sqlc.GetAllVersionsPageParams{
+		AfterCreatedAt: afterCreatedAt,
+		AfterID:        int64(afterID),
+		PageSize:       int32(pageSize),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get versions: %w", err)
+	}
+	return rows, nil
+}
+
+// GetLayerObjectsPage returns up to pageSize versioned layers of all files, in id order, with
+// an ID greater than afterID (0 for the first page), along with the size of their objects
+func (ms *MetadataStore) GetLayerObjectsPage(ctx context.Context, afterID uint64, pageSize int) ([]sqlc.GetLayerObjectsPageRow, error) {
+	rows, err := ms.queries.GetLayerObjectsPage(ctx, sqlc.GetLayerObjectsPageParams{
+		AfterID:  int64(afterID),
+		PageSize: int32(pageSize),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layers: %w", err)
+	}
+	return rows, nil
+}
+
+// GetFileStats returns the number of versions and layers of each file, and the size of its
+// layer objects, ordered by file name.
+func (ms *MetadataStore) GetFileStats(ctx context.Context, opts ...QueryOpt) ([]sqlc.GetFileStatsRow, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	rows, err := queries.GetFileStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file stats: %w", err)
+	}
+	return rows, nil
+}
+
 // GetFileVersions returns all versions for a specific file ID
 func (ms *MetadataStore) GetFileVersions(ctx context.Context, fileID uint64, opts ...QueryOpt) ([]sqlc.Version, error) {
 	options := QueryOpts{}
@@ -498,3 +1411,79 @@ func (ms *MetadataStore) GetFileVersions(ctx context.Context, fileID uint64, opt
 
 	return versions, nil
 }
+
+// InsertWriteOrigins records the origins of the writes that produced a layer's data
+func (ms *MetadataStore) InsertWriteOrigins(ctx context.Context, layerID uint64, origins []WriteOrigin, opts ...QueryOpt) error {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(origins) == 0 {
+		return nil
+	}
+
+	params := sqlc.InsertWriteOriginsParams{
+		SnapshotLayerID: int64(layerID),
+		LayerStarts:     make([]int64, len(origins)),
+		LayerEnds:       make([]int64, len(origins)),
+		RequestIDs:      make([]int64, len(origins)),
+		Pids:            make([]int64, len(origins)),
+		WriteOffsets:    make([]int64, len(origins)),
+		WriteSizes:      make([]int64, len(origins)),
+	}
+
+	for i, o := range origins {
+		params.LayerStarts[i] = int64(o.LayerRange[0])
+		params.LayerEnds[i] = int64(o.LayerRange[1])
+		params.RequestIDs[i] = int64(o.RequestID)
+		params.Pids[i] = int64(o.PID)
+		params.WriteOffsets[i] = int64(o.Offset)
+		params.WriteSizes[i] = int64(o.Size)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	err := queries.InsertWriteOrigins(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to insert write origins: %w", err)
+	}
+
+	return nil
+}
+
+// GetWriteOrigins returns the recorded origins of the writes that produced a layer's data, in write order
+func (ms *MetadataStore) GetWriteOrigins(ctx context.Context, layerID uint64, opts ...QueryOpt) ([]WriteOrigin, error) {
+	options := QueryOpts{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	queries := ms.queries
+
+	if options.tx != nil {
+		queries = ms.queries.WithTx(options.tx)
+	}
+
+	rows, err := queries.GetWriteOrigins(ctx, layerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get write origins: %w", err)
+	}
+
+	origins := make([]WriteOrigin, 0, len(rows))
+	for _, row := range rows {
+		origins = append(origins, WriteOrigin{
+			LayerRange: row.LayerRange,
+			RequestID:  uint64(row.RequestID),
+			PID:        uint32(row.Pid),
+			Offset:     uint64(row.WriteOffset),
+			Size:       uint64(row.WriteSize),
+		})
+	}
+
+	return origins, nil
+}
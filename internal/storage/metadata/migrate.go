@@ -0,0 +1,119 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/vinimdocarmo/quackfs/db"
+)
+
+// Migrate brings the schema of database up to date and returns the versions of the migrations
+// it applied. An empty database gets the current schema, with every migration recorded as
+// applied since the schema already includes them. Otherwise the migrations missing from the
+// schema_migrations table are applied in order. Everything runs in a single transaction, so a
+// failed migration leaves the database untouched.
+func Migrate(ctx context.Context, database *sql.DB) ([]string, error) {
+	versions, err := migrationVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Nodes starting at the same time would otherwise apply the same migrations concurrently
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1::INT4, $2::INT4)", lockNamespace, migrationsLockKey); err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+
+	var empty bool
+	err = tx.QueryRowContext(ctx, `SELECT NOT EXISTS (
+		SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'files'
+	)`).Scan(&empty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check whether the database is empty: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	if empty {
+		if _, err := tx.ExecContext(ctx, db.Schema); err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	applied := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = true
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+
+	var newlyApplied []string
+	for _, version := range versions {
+		if applied[version] {
+			continue
+		}
+
+		if !empty {
+			migration, err := fs.ReadFile(db.Migrations, path.Join("migrations", version+".sql"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read migration %s: %w", version, err)
+			}
+			if _, err := tx.ExecContext(ctx, string(migration)); err != nil {
+				return nil, fmt.Errorf("failed to apply migration %s: %w", version, err)
+			}
+			newlyApplied = append(newlyApplied, version)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			return nil, fmt.Errorf("failed to record migration %s: %w", version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return newlyApplied, nil
+}
+
+// migrationVersions returns the versions of the embedded migrations (their file names without
+// the .sql extension), in the order they must be applied
+func migrationVersions() ([]string, error) {
+	names, err := fs.Glob(db.Migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	slices.Sort(names)
+
+	versions := make([]string, len(names))
+	for i, name := range names {
+		versions[i] = strings.TrimSuffix(path.Base(name), ".sql")
+	}
+	return versions, nil
+}
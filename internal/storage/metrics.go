@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/vinimdocarmo/quackfs/pkg/metrics"
+)
+
+// meteredStore records the latency of every request to an object store.
+type meteredStore struct {
+	store   objectStore
+	metrics metrics.Metrics
+}
+
+func (s meteredStore) PutObject(ctx context.Context, key string, data []byte) error {
+	start := time.Now()
+	err := s.store.PutObject(ctx, key, data)
+	s.metrics.ObjectStoreRequest("put", time.Since(start), err)
+	return err
+}
+
+func (s meteredStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	start := time.Now()
+	data, err := s.store.GetObject(ctx, key, dataRange)
+	s.metrics.ObjectStoreRequest("get", time.Since(start), err)
+	return data, err
+}
+
+func (s meteredStore) DeleteObject(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.store.DeleteObject(ctx, key)
+	s.metrics.ObjectStoreRequest("delete", time.Since(start), err)
+	return err
+}
+
+func (s meteredStore) ObjectSize(ctx context.Context, key string) (uint64, error) {
+	start := time.Now()
+	size, err := s.store.ObjectSize(ctx, key)
+	s.metrics.ObjectStoreRequest("head", time.Since(start), err)
+	return size, err
+}
+
+func (s meteredStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := s.store.ListObjects(ctx, prefix)
+	s.metrics.ObjectStoreRequest("list", time.Since(start), err)
+	return keys, err
+}
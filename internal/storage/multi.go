@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vinimdocarmo/quackfs/db/sqlc"
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// virtualNodes is the number of points each shard gets on the hash ring. More points spread
+// files more evenly across shards.
+const virtualNodes = 64
+
+// MultiManager spreads files across several Managers (shards), e.g. each with its own
+// Postgres database and bucket. Each file lives on exactly one shard, chosen by a consistent
+// hash of its name, so MultiManager can be used wherever a single Manager is.
+//
+// Shards are identified by name: as long as names don't change, files keep routing to the
+// same shard, and adding a shard only moves the files that now hash to it.
+type MultiManager struct {
+	shards map[string]*Manager
+	ring   []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+// NewMultiManager creates a MultiManager routing files to the given shards, by name.
+func NewMultiManager(shards map[string]*Manager) (*MultiManager, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("at least one shard is required")
+	}
+
+	mm := &MultiManager{shards: shards}
+	for name, shard := range shards {
+		if shard == nil {
+			return nil, fmt.Errorf("shard %q has no manager", name)
+		}
+		for i := range virtualNodes {
+			mm.ring = append(mm.ring, ringPoint{hash: hashKey(fmt.Sprintf("%s#%d", name, i)), shard: name})
+		}
+	}
+
+	// Ties are broken by name so that the ring doesn't depend on map iteration order
+	slices.SortFunc(mm.ring, func(a, b ringPoint) int {
+		if c := cmp.Compare(a.hash, b.hash); c != 0 {
+			return c
+		}
+		return strings.Compare(a.shard, b.shard)
+	})
+
+	return mm, nil
+}
+
+// hashKey places a key on the ring. FNV is not used because similar short keys (db_1,
+// db_2, ...) end up clustered on the ring, and shards get very uneven shares.
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// ShardFor returns the name of the shard holding the file.
+func (mm *MultiManager) ShardFor(filename string) string {
+	h := hashKey(filename)
+	i := sort.Search(len(mm.ring), func(i int) bool { return mm.ring[i].hash >= h })
+	if i == len(mm.ring) {
+		i = 0
+	}
+	return mm.ring[i].shard
+}
+
+// ManagerFor returns the Manager of the shard holding the file, for operations
+// MultiManager doesn't expose.
+func (mm *MultiManager) ManagerFor(filename string) *Manager {
+	return mm.shards[mm.ShardFor(filename)]
+}
+
+func (mm *MultiManager) InsertFile(ctx context.Context, name string, opts ...InsertFileOpt) (uint64, error) {
+	return mm.ManagerFor(name).InsertFile(ctx, name, opts...)
+}
+
+// GetFileID returns the ID of the file on its shard. IDs are only unique within a shard.
+func (mm *MultiManager) GetFileID(ctx context.Context, filename string) (uint64, error) {
+	return mm.ManagerFor(filename).GetFileID(ctx, filename)
+}
+
+// RenameFile renames a file within its shard. Renaming it to a name that routes to another
+// shard fails with types.ErrCrossShard.
+func (mm *MultiManager) RenameFile(ctx context.Context, oldName string, newName string, opts ...RenameOpt) error {
+	from, to := mm.ShardFor(oldName), mm.ShardFor(newName)
+	if from != to {
+		return fmt.Errorf("cannot rename %s to %s (shards %s and %s): %w", oldName, newName, from, to, types.ErrCrossShard)
+	}
+	return mm.shards[from].RenameFile(ctx, oldName, newName, opts...)
+}
+
+func (mm *MultiManager) GetFileAttr(ctx context.Context, filename string) (metadata.FileAttr, error) {
+	return mm.ManagerFor(filename).GetFileAttr(ctx, filename)
+}
+
+func (mm *MultiManager) SetFileMode(ctx context.Context, filename string, mode os.FileMode) error {
+	return mm.ManagerFor(filename).SetFileMode(ctx, filename, mode)
+}
+
+func (mm *MultiManager) SetFileModTime(ctx context.Context, filename string, modTime time.Time) error {
+	return mm.ManagerFor(filename).SetFileModTime(ctx, filename, modTime)
+}
+
+func (mm *MultiManager) GetXattr(ctx context.Context, filename string, name string) ([]byte, error) {
+	return mm.ManagerFor(filename).GetXattr(ctx, filename, name)
+}
+
+func (mm *MultiManager) ListXattrs(ctx context.Context, filename string) ([]string, error) {
+	return mm.ManagerFor(filename).ListXattrs(ctx, filename)
+}
+
+func (mm *MultiManager) SetXattr(ctx context.Context, filename string, name string, value []byte, opts ...XattrOpt) error {
+	return mm.ManagerFor(filename).SetXattr(ctx, filename, name, value, opts...)
+}
+
+func (mm *MultiManager) RemoveXattr(ctx context.Context, filename string, name string) error {
+	return mm.ManagerFor(filename).RemoveXattr(ctx, filename, name)
+}
+
+func (mm *MultiManager) WriteFile(ctx context.Context, filename string, data []byte, offset uint64, opts ...WriteOpt) error {
+	return mm.ManagerFor(filename).WriteFile(ctx, filename, data, offset, opts...)
+}
+
+func (mm *MultiManager) PunchHole(ctx context.Context, filename string, offset uint64, length uint64) error {
+	return mm.ManagerFor(filename).PunchHole(ctx, filename, offset, length)
+}
+
+func (mm *MultiManager) ReadFile(ctx context.Context, filename string, offset uint64, size uint64, opts ...ReadOpt) ([]byte, error) {
+	return mm.ManagerFor(filename).ReadFile(ctx, filename, offset, size, opts...)
+}
+
+func (mm *MultiManager) SizeOf(ctx context.Context, filename string) (uint64, error) {
+	return mm.ManagerFor(filename).SizeOf(ctx, filename)
+}
+
+func (mm *MultiManager) SizeOfVersion(ctx context.Context, filename string, versionTag string) (uint64, error) {
+	return mm.ManagerFor(filename).SizeOfVersion(ctx, filename, versionTag)
+}
+
+func (mm *MultiManager) Checkpoint(ctx context.Context, filename string, version string, opts ...CheckpointOpt) (string, error) {
+	return mm.ManagerFor(filename).Checkpoint(ctx, filename, version, opts...)
+}
+
+func (mm *MultiManager) Flush(ctx context.Context, filename string) error {
+	return mm.ManagerFor(filename).Flush(ctx, filename)
+}
+
+func (mm *MultiManager) Revert(ctx context.Context, filename string, targetTag string, newTag string) error {
+	return mm.ManagerFor(filename).Revert(ctx, filename, targetTag, newTag)
+}
+
+func (mm *MultiManager) SetHead(ctx context.Context, filename string, version string) error {
+	return mm.ManagerFor(filename).SetHead(ctx, filename, version)
+}
+
+func (mm *MultiManager) GetHead(ctx context.Context, filename string) (string, error) {
+	return mm.ManagerFor(filename).GetHead(ctx, filename)
+}
+
+func (mm *MultiManager) GetLatestVersion(ctx context.Context, filename string) (string, error) {
+	return mm.ManagerFor(filename).GetLatestVersion(ctx, filename)
+}
+
+func (mm *MultiManager) DeleteHead(ctx context.Context, filename string) error {
+	return mm.ManagerFor(filename).DeleteHead(ctx, filename)
+}
+
+func (mm *MultiManager) CreateBranch(ctx context.Context, filename string, branch string, versionTag string) error {
+	return mm.ManagerFor(filename).CreateBranch(ctx, filename, branch, versionTag)
+}
+
+func (mm *MultiManager) SwitchBranch(ctx context.Context, filename string, branch string) error {
+	return mm.ManagerFor(filename).SwitchBranch(ctx, filename, branch)
+}
+
+func (mm *MultiManager) GetCurrentBranch(ctx context.Context, filename string) (string, error) {
+	return mm.ManagerFor(filename).GetCurrentBranch(ctx, filename)
+}
+
+func (mm *MultiManager) DeleteBranch(ctx context.Context, filename string, branch string) error {
+	return mm.ManagerFor(filename).DeleteBranch(ctx, filename, branch)
+}
+
+func (mm *MultiManager) GetFileVersions(ctx context.Context, filename string) ([]sqlc.Version, error) {
+	return mm.ManagerFor(filename).GetFileVersions(ctx, filename)
+}
+
+// GetAllFiles lists the files of every shard, sorted by name. Files found on a shard they
+// don't hash to (e.g. left behind while re-sharding, or when shards share a database) are
+// skipped, since reads and writes would never reach them there.
+func (mm *MultiManager) GetAllFiles(ctx context.Context) ([]sqlc.File, error) {
+	files := []sqlc.File{}
+	for name, shard := range mm.shards {
+		shardFiles, err := shard.GetAllFiles(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get files of shard %s: %w", name, err)
+		}
+
+		for _, file := range shardFiles {
+			if mm.ShardFor(file.Name) == name {
+				files = append(files, file)
+			}
+		}
+	}
+
+	slices.SortFunc(files, func(a, b sqlc.File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return files, nil
+}
+
+// Close closes every shard.
+func (mm *MultiManager) Close() error {
+	var errs []error
+	for name, shard := range mm.shards {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close shard %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunConformanceTests checks that the stores returned by factory behave the way the storage
+// manager expects, in particular that GetObject ranges are inclusive of both ends, which
+// getChunkData relies on. factory is called once per subtest.
+func RunConformanceTests(t *testing.T, factory func() ObjectStore) {
+	// Shared buckets keep the objects of earlier runs, each run gets its own keys
+	prefix := fmt.Sprintf("conformance/%d/", time.Now().UnixNano())
+	ctx := context.Background()
+
+	t.Run("PutGet", func(t *testing.T) {
+		store := factory()
+		key := prefix + "put-get"
+		require.NoError(t, store.PutObject(ctx, key, []byte("0123456789")))
+		t.Cleanup(func() { store.DeleteObject(ctx, key) })
+
+		got, err := store.GetObject(ctx, key, [2]uint64{0, 9})
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(got))
+
+		size, err := store.ObjectSize(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(10), size)
+	})
+
+	t.Run("InclusiveRange", func(t *testing.T) {
+		store := factory()
+		key := prefix + "inclusive-range"
+		require.NoError(t, store.PutObject(ctx, key, []byte("0123456789")))
+		t.Cleanup(func() { store.DeleteObject(ctx, key) })
+
+		got, err := store.GetObject(ctx, key, [2]uint64{3, 5})
+		require.NoError(t, err)
+		assert.Equal(t, "345", string(got), "range should be inclusive of both ends")
+
+		got, err = store.GetObject(ctx, key, [2]uint64{0, 0})
+		require.NoError(t, err)
+		assert.Equal(t, "0", string(got))
+
+		got, err = store.GetObject(ctx, key, [2]uint64{9, 9})
+		require.NoError(t, err)
+		assert.Equal(t, "9", string(got))
+	})
+
+	t.Run("MissingKey", func(t *testing.T) {
+		store := factory()
+
+		_, err := store.GetObject(ctx, prefix+"missing", [2]uint64{0, 1})
+		assert.True(t, errors.Is(err, ErrNotFound), "GetObject of a missing key should return ErrNotFound, got %v", err)
+
+		_, err = store.ObjectSize(ctx, prefix+"missing")
+		assert.True(t, errors.Is(err, ErrNotFound), "ObjectSize of a missing key should return ErrNotFound, got %v", err)
+
+		assert.NoError(t, store.DeleteObject(ctx, prefix+"missing"), "deleting a missing key should succeed")
+	})
+
+	t.Run("OutOfRange", func(t *testing.T) {
+		store := factory()
+		key := prefix + "out-of-range"
+		require.NoError(t, store.PutObject(ctx, key, []byte("0123456789")))
+		t.Cleanup(func() { store.DeleteObject(ctx, key) })
+
+		_, err := store.GetObject(ctx, key, [2]uint64{5, 4})
+		assert.Error(t, err, "start after end should fail")
+
+		_, err = store.GetObject(ctx, key, [2]uint64{10, 12})
+		assert.Error(t, err, "start past the end of the object should fail")
+
+		// Backends either fail or, like S3, return the bytes that are available, but never more
+		got, err := store.GetObject(ctx, key, [2]uint64{5, 20})
+		if err == nil {
+			assert.Equal(t, "56789", string(got))
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		store := factory()
+		key := prefix + "overwrite"
+		require.NoError(t, store.PutObject(ctx, key, []byte("0123456789")))
+		t.Cleanup(func() { store.DeleteObject(ctx, key) })
+		require.NoError(t, store.PutObject(ctx, key, []byte("abc")))
+
+		got, err := store.GetObject(ctx, key, [2]uint64{0, 2})
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(got))
+
+		size, err := store.ObjectSize(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), size, "overwriting should replace the whole object")
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := factory()
+		keys := []string{prefix + "list/a/1", prefix + "list/a/2", prefix + "list/b/1"}
+		for _, key := range keys {
+			require.NoError(t, store.PutObject(ctx, key, []byte("x")))
+		}
+		t.Cleanup(func() {
+			for _, key := range keys {
+				store.DeleteObject(ctx, key)
+			}
+		})
+
+		got, err := store.ListObjects(ctx, prefix+"list/a/")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, keys[:2], got)
+
+		got, err = store.ListObjects(ctx, prefix+"list/c/")
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
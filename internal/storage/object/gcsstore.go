@@ -0,0 +1,116 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSStore stores objects in a Google Cloud Storage bucket.
+type GCSStore struct {
+	client     *storage.Client
+	bucketName string
+}
+
+func NewGCS(client *storage.Client, bucketName string) *GCSStore {
+	return &GCSStore{
+		client:     client,
+		bucketName: bucketName,
+	}
+}
+
+func (s *GCSStore) object(key string) *storage.ObjectHandle {
+	return s.client.Bucket(s.bucketName).Object(key)
+}
+
+// PutObject uploads data with a single writer. The client sends it in one request, or as a
+// resumable upload for large objects, so it retries failed requests on its own.
+func (s *GCSStore) PutObject(ctx context.Context, key string, data []byte) error {
+	// The object is only created when the writer is closed; cancelling the context before that aborts the upload
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := s.object(key).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		cancel()
+		w.Close()
+		return fmt.Errorf("failed to upload data to GCS: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to upload data to GCS: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GCSStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	if dataRange[0] > dataRange[1] {
+		return nil, fmt.Errorf("invalid data range: %v", dataRange)
+	}
+
+	// The range is inclusive of its end, the reader takes a length
+	r, err := s.object(key).NewRangeReader(ctx, int64(dataRange[0]), int64(dataRange[1]-dataRange[0]+1))
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, fmt.Errorf("error retrieving data from GCS: %w: %w", ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("error retrieving data from GCS: %w", err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data from GCS response: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *GCSStore) DeleteObject(ctx context.Context, key string) error {
+	// Unlike S3, GCS complains about missing keys
+	err := s.object(key).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete object from GCS: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GCSStore) ObjectSize(ctx context.Context, key string) (uint64, error) {
+	attrs, err := s.object(key).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return 0, fmt.Errorf("error retrieving object size from GCS: %w: %w", ErrNotFound, err)
+		}
+		return 0, fmt.Errorf("error retrieving object size from GCS: %w", err)
+	}
+
+	return uint64(attrs.Size), nil
+}
+
+func (s *GCSStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, fmt.Errorf("failed to list objects in GCS: %w", err)
+	}
+
+	it := s.client.Bucket(s.bucketName).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in GCS: %w", err)
+		}
+		keys = append(keys, attrs.Name)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,17 @@
+package objectstore_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/vinimdocarmo/quackfs/internal/quackfstest"
+	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
+)
+
+func TestGCSConformance(t *testing.T) {
+	if os.Getenv("TEST_OBJECT_STORE") != "gcs" {
+		t.Skip("Set TEST_OBJECT_STORE=gcs to run against the GCS test bucket")
+	}
+
+	objectstore.RunConformanceTests(t, func() objectstore.ObjectStore { return quackfstest.NewGCSStore(t) })
+}
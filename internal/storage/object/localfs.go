@@ -0,0 +1,175 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalFSStore stores objects as regular files under a root directory.
+// It is meant for local development and CI where S3/LocalStack isn't available.
+type LocalFSStore struct {
+	rootDir string
+}
+
+func NewLocalFS(rootDir string) *LocalFSStore {
+	return &LocalFSStore{
+		rootDir: rootDir,
+	}
+}
+
+// path maps an object key to a file path inside the root directory.
+func (s *LocalFSStore) path(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("invalid object key: empty")
+	}
+
+	p := filepath.Join(s.rootDir, filepath.FromSlash(key))
+
+	rel, err := filepath.Rel(s.rootDir, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid object key: %s", key)
+	}
+
+	return p, nil
+}
+
+func (s *LocalFSStore) PutObject(ctx context.Context, key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for object: %w", err)
+	}
+
+	// Write to a temporary file first so readers never observe a partially written object
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary object file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close object file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to move object file into place: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LocalFSStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	if dataRange[0] > dataRange[1] {
+		return nil, fmt.Errorf("invalid data range: %v", dataRange)
+	}
+
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("error retrieving data from local filesystem: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("error retrieving data from local filesystem: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving data from local filesystem: %w", err)
+	}
+
+	if dataRange[0] >= uint64(info.Size()) {
+		return nil, fmt.Errorf("invalid data range: %v, object size is %d", dataRange, info.Size())
+	}
+
+	// Like S3, a range ending past the end of the object returns the bytes that are available
+	data := make([]byte, dataRange[1]-dataRange[0]+1)
+	n, err := f.ReadAt(data, int64(dataRange[0]))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading data from local filesystem: %w", err)
+	}
+
+	return data[:n], nil
+}
+
+func (s *LocalFSStore) DeleteObject(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LocalFSStore) ObjectSize(ctx context.Context, key string) (uint64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fmt.Errorf("error retrieving object size from local filesystem: %w", ErrNotFound)
+		}
+		return 0, fmt.Errorf("error retrieving object size from local filesystem: %w", err)
+	}
+
+	return uint64(info.Size()), nil
+}
+
+func (s *LocalFSStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+
+	err := filepath.WalkDir(s.rootDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == s.rootDir {
+				return filepath.SkipDir // nothing was stored yet
+			}
+			return err
+		}
+
+		// Skip directories and objects that are still being written
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.rootDir, p)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects in local filesystem: %w", err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,126 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFSPutGet(t *testing.T) {
+	rootDir := t.TempDir()
+	store := NewLocalFS(rootDir)
+	ctx := context.Background()
+
+	data := []byte("hello local filesystem")
+	err := store.PutObject(ctx, "layers/db.duckdb/1-1", data)
+	require.NoError(t, err)
+
+	// The object must be stored under rootDir/<key>
+	onDisk, err := os.ReadFile(filepath.Join(rootDir, "layers", "db.duckdb", "1-1"))
+	require.NoError(t, err)
+	assert.Equal(t, data, onDisk)
+
+	// Full object, inclusive range
+	got, err := store.GetObject(ctx, "layers/db.duckdb/1-1", [2]uint64{0, uint64(len(data) - 1)})
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Partial range, inclusive of both ends
+	got, err = store.GetObject(ctx, "layers/db.duckdb/1-1", [2]uint64{6, 10})
+	require.NoError(t, err)
+	assert.Equal(t, "local", string(got))
+
+	// Single byte range
+	got, err = store.GetObject(ctx, "layers/db.duckdb/1-1", [2]uint64{0, 0})
+	require.NoError(t, err)
+	assert.Equal(t, "h", string(got))
+
+	// Overwriting replaces the content
+	err = store.PutObject(ctx, "layers/db.duckdb/1-1", []byte("replaced"))
+	require.NoError(t, err)
+	got, err = store.GetObject(ctx, "layers/db.duckdb/1-1", [2]uint64{0, 7})
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(got))
+}
+
+func TestLocalFSMissingKey(t *testing.T) {
+	store := NewLocalFS(t.TempDir())
+
+	_, err := store.GetObject(context.Background(), "layers/missing/1-1", [2]uint64{0, 10})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNotFound), "missing key should return ErrNotFound, got %v", err)
+}
+
+func TestLocalFSObjectSize(t *testing.T) {
+	store := NewLocalFS(t.TempDir())
+	ctx := context.Background()
+
+	require.NoError(t, store.PutObject(ctx, "layers/1/1", []byte("0123456789")))
+
+	size, err := store.ObjectSize(ctx, "layers/1/1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), size)
+
+	_, err = store.ObjectSize(ctx, "layers/1/2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalFSInvalidRequests(t *testing.T) {
+	store := NewLocalFS(t.TempDir())
+	ctx := context.Background()
+
+	require.NoError(t, store.PutObject(ctx, "obj", []byte("0123456789")))
+
+	_, err := store.GetObject(ctx, "obj", [2]uint64{5, 4})
+	assert.Error(t, err, "start after end should fail")
+
+	_, err = store.GetObject(ctx, "obj", [2]uint64{10, 12})
+	assert.Error(t, err, "range starting past the end of the object should fail")
+
+	err = store.PutObject(ctx, "../escape", []byte("x"))
+	assert.Error(t, err, "keys escaping the root directory should be rejected")
+}
+
+func TestLocalFSDeleteObject(t *testing.T) {
+	store := NewLocalFS(t.TempDir())
+	ctx := context.Background()
+
+	require.NoError(t, store.PutObject(ctx, "layers/db.duckdb/1-1", []byte("0123456789")))
+	require.NoError(t, store.DeleteObject(ctx, "layers/db.duckdb/1-1"))
+
+	_, err := store.GetObject(ctx, "layers/db.duckdb/1-1", [2]uint64{0, 9})
+	assert.True(t, errors.Is(err, ErrNotFound), "deleted key should return ErrNotFound, got %v", err)
+
+	// Deleting again is a no-op
+	assert.NoError(t, store.DeleteObject(ctx, "layers/db.duckdb/1-1"))
+
+	err = store.DeleteObject(ctx, "../escape")
+	assert.Error(t, err, "keys escaping the root directory should be rejected")
+}
+
+func TestLocalFSListObjects(t *testing.T) {
+	store := NewLocalFS(filepath.Join(t.TempDir(), "objects"))
+	ctx := context.Background()
+
+	// Nothing stored yet, the root directory doesn't even exist
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for _, key := range []string{"layers/b/1-2", "layers/a/1-1", "other/x"} {
+		require.NoError(t, store.PutObject(ctx, key, []byte("data")))
+	}
+
+	keys, err = store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"layers/a/1-1", "layers/b/1-2"}, keys)
+}
+
+func TestLocalFSConformance(t *testing.T) {
+	RunConformanceTests(t, func() ObjectStore { return NewLocalFS(t.TempDir()) })
+}
@@ -0,0 +1,151 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps objects in memory. It is meant for tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+	uploads map[string]*memoryUpload // multipart uploads in progress, by upload ID
+	nextID  int
+}
+
+type memoryUpload struct {
+	key   string
+	parts map[int32][]byte
+}
+
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+func (s *MemoryStore) PutObject(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Copy the data so later changes to the caller's slice don't leak into the store
+	s.objects[key] = append([]byte(nil), data...)
+
+	return nil
+}
+
+func (s *MemoryStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("error retrieving data from memory: %w", ErrNotFound)
+	}
+
+	if dataRange[0] > dataRange[1] || dataRange[1] >= uint64(len(obj)) {
+		return nil, fmt.Errorf("invalid data range: %v, object size is %d", dataRange, len(obj))
+	}
+
+	return append([]byte(nil), obj[dataRange[0]:dataRange[1]+1]...), nil
+}
+
+func (s *MemoryStore) DeleteObject(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+
+	return nil
+}
+
+func (s *MemoryStore) ObjectSize(ctx context.Context, key string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return 0, fmt.Errorf("error retrieving object size from memory: %w", ErrNotFound)
+	}
+
+	return uint64(len(obj)), nil
+}
+
+func (s *MemoryStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// The multipart methods simulate S3's multipart uploads, so that putMultipart can be tested
+// without S3: parts are kept aside until the upload is completed.
+
+func (s *MemoryStore) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	uploadID := fmt.Sprintf("upload-%d", s.nextID)
+	s.uploads[uploadID] = &memoryUpload{key: key, parts: make(map[int32][]byte)}
+
+	return uploadID, nil
+}
+
+func (s *MemoryStore) uploadPart(ctx context.Context, key string, uploadID string, number int32, data []byte) (completedPart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return completedPart{}, fmt.Errorf("no multipart upload %s of %s", uploadID, key)
+	}
+	upload.parts[number] = append([]byte(nil), data...)
+
+	return completedPart{number: number, etag: fmt.Sprintf("%s-%d", uploadID, number)}, nil
+}
+
+func (s *MemoryStore) completeMultipartUpload(ctx context.Context, key string, uploadID string, parts []completedPart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return fmt.Errorf("no multipart upload %s of %s", uploadID, key)
+	}
+
+	var obj []byte
+	for i, part := range parts {
+		data, ok := upload.parts[part.number]
+		if !ok || (i > 0 && part.number <= parts[i-1].number) {
+			return fmt.Errorf("invalid part %d of multipart upload %s", part.number, uploadID)
+		}
+		obj = append(obj, data...)
+	}
+
+	s.objects[key] = obj
+	delete(s.uploads, uploadID)
+
+	return nil
+}
+
+func (s *MemoryStore) abortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.uploads, uploadID)
+
+	return nil
+}
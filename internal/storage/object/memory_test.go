@@ -0,0 +1,157 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryPutGet(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+
+	data := []byte("0123456789")
+	require.NoError(t, store.PutObject(ctx, "obj", data))
+
+	// Changing the caller's slice must not change the stored object
+	data[0] = 'x'
+
+	got, err := store.GetObject(ctx, "obj", [2]uint64{0, 9})
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(got))
+
+	got, err = store.GetObject(ctx, "obj", [2]uint64{3, 5})
+	require.NoError(t, err)
+	assert.Equal(t, "345", string(got), "range should be inclusive of both ends")
+
+	got, err = store.GetObject(ctx, "obj", [2]uint64{9, 9})
+	require.NoError(t, err)
+	assert.Equal(t, "9", string(got))
+}
+
+func TestMemoryInvalidRequests(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+
+	require.NoError(t, store.PutObject(ctx, "obj", []byte("0123456789")))
+
+	_, err := store.GetObject(ctx, "missing", [2]uint64{0, 1})
+	assert.True(t, errors.Is(err, ErrNotFound), "missing key should return ErrNotFound, got %v", err)
+
+	_, err = store.GetObject(ctx, "obj", [2]uint64{5, 10})
+	assert.Error(t, err, "range ending past the end of the object should fail")
+
+	_, err = store.GetObject(ctx, "obj", [2]uint64{5, 4})
+	assert.Error(t, err, "start after end should fail")
+}
+
+func TestMemoryObjectSize(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+
+	require.NoError(t, store.PutObject(ctx, "obj", []byte("0123456789")))
+
+	size, err := store.ObjectSize(ctx, "obj")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), size)
+
+	_, err = store.ObjectSize(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryDeleteObject(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+
+	require.NoError(t, store.PutObject(ctx, "obj", []byte("0123456789")))
+	require.NoError(t, store.DeleteObject(ctx, "obj"))
+
+	_, err := store.GetObject(ctx, "obj", [2]uint64{0, 9})
+	assert.True(t, errors.Is(err, ErrNotFound), "deleted key should return ErrNotFound, got %v", err)
+
+	// Deleting again is a no-op
+	assert.NoError(t, store.DeleteObject(ctx, "obj"))
+}
+
+func TestMemoryListObjects(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+
+	for _, key := range []string{"layers/b/1-2", "layers/a/1-1", "other/x"} {
+		require.NoError(t, store.PutObject(ctx, key, []byte("data")))
+	}
+
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"layers/a/1-1", "layers/b/1-2"}, keys)
+
+	keys, err = store.ListObjects(ctx, "missing/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// failingParts makes uploading part failAt fail, to test that the upload is aborted
+type failingParts struct {
+	*MemoryStore
+	failAt int32
+}
+
+func (s *failingParts) uploadPart(ctx context.Context, key string, uploadID string, number int32, data []byte) (completedPart, error) {
+	if number == s.failAt {
+		return completedPart{}, errors.New("connection reset")
+	}
+	return s.MemoryStore.uploadPart(ctx, key, uploadID, number, data)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+
+	// A layer of 10 parts and a bit
+	data := make([]byte, 10*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	t.Run("round-trips a large layer", func(t *testing.T) {
+		store := NewMemory()
+		require.NoError(t, putMultipart(ctx, store, "layer", data, 1024))
+
+		got, err := store.GetObject(ctx, "layer", [2]uint64{0, uint64(len(data) - 1)})
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		got, err = store.GetObject(ctx, "layer", [2]uint64{1000, 1100})
+		require.NoError(t, err)
+		assert.Equal(t, data[1000:1101], got, "ranges should span part boundaries")
+
+		assert.Empty(t, store.uploads, "completed upload should be cleaned up")
+	})
+
+	t.Run("aborts the upload when a part fails", func(t *testing.T) {
+		store := &failingParts{MemoryStore: NewMemory(), failAt: 4}
+
+		err := putMultipart(ctx, store, "layer", data, 1024)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "part 4")
+
+		_, err = store.GetObject(ctx, "layer", [2]uint64{0, 0})
+		assert.ErrorIs(t, err, ErrNotFound, "no partial object should be left behind")
+		assert.Empty(t, store.uploads, "failed upload should be aborted")
+	})
+
+	t.Run("raises the part size to stay within the part limit", func(t *testing.T) {
+		store := NewMemory()
+		require.NoError(t, putMultipart(ctx, store, "layer", make([]byte, maxParts+10), 1))
+
+		obj, err := store.GetObject(ctx, "layer", [2]uint64{0, maxParts + 9})
+		require.NoError(t, err)
+		assert.Len(t, obj, maxParts+10)
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	RunConformanceTests(t, func() ObjectStore { return NewMemory() })
+}
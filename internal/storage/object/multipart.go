@@ -0,0 +1,72 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// DefaultMultipartThreshold is the size above which S3Store uploads objects in parts.
+	DefaultMultipartThreshold = 64 << 20
+	// DefaultPartSize is the size of the parts of a multipart upload, but the last one.
+	DefaultPartSize = 16 << 20
+	// MinPartSize is the smallest part size S3 accepts (but for the last part).
+	MinPartSize = 5 << 20
+	// maxParts is the largest number of parts of an S3 multipart upload
+	maxParts = 10000
+)
+
+// completedPart is an uploaded part of a multipart upload.
+type completedPart struct {
+	number   int32
+	etag     string
+	checksum string
+}
+
+// multipartUploader is implemented by stores able to upload an object in parts.
+type multipartUploader interface {
+	createMultipartUpload(ctx context.Context, key string) (uploadID string, err error)
+	uploadPart(ctx context.Context, key string, uploadID string, number int32, data []byte) (completedPart, error)
+	completeMultipartUpload(ctx context.Context, key string, uploadID string, parts []completedPart) error
+	abortMultipartUpload(ctx context.Context, key string, uploadID string) error
+}
+
+// putMultipart uploads data to key in parts of partSize bytes (the last one can be smaller).
+// The part size is raised if needed to stay within the maximum number of parts. If any part
+// fails the upload is aborted, so no partial object nor orphaned parts are left behind.
+func putMultipart(ctx context.Context, u multipartUploader, key string, data []byte, partSize int) (err error) {
+	partSize = max(partSize, (len(data)+maxParts-1)/maxParts, 1)
+
+	uploadID, err := u.createMultipartUpload(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload of %s: %w", key, err)
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Abort even if ctx is done, otherwise the parts would be kept (and billed) until the
+		// bucket's lifecycle rules clean them up
+		if abortErr := u.abortMultipartUpload(context.WithoutCancel(ctx), key, uploadID); abortErr != nil {
+			err = fmt.Errorf("%w (and failed to abort multipart upload: %w)", err, abortErr)
+		}
+	}()
+
+	parts := make([]completedPart, 0, (len(data)+partSize-1)/partSize)
+	for offset, number := 0, int32(1); offset < len(data); offset, number = offset+partSize, number+1 {
+		end := min(offset+partSize, len(data))
+
+		part, err := u.uploadPart(ctx, key, uploadID, number, data[offset:end])
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d of %s: %w", number, key, err)
+		}
+		parts = append(parts, part)
+	}
+
+	if err := u.completeMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+	}
+
+	return nil
+}
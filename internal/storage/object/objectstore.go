@@ -0,0 +1,26 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned (wrapped) by every backend when the requested key does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectStore is the set of operations every object store backend implements.
+type ObjectStore interface {
+	// PutObject uploads data to the object store.
+	PutObject(ctx context.Context, key string, data []byte) error
+	// GetObject returns a slice of data from the given offset up to size bytes.
+	// Range is inclusive of the start and the end (i.e. [start, end])
+	GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error)
+	// DeleteObject removes an object from the object store.
+	// Deleting a key that doesn't exist is not an error.
+	DeleteObject(ctx context.Context, key string) error
+	// ListObjects returns the keys of all objects starting with prefix.
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+	// ObjectSize returns the size of an object without fetching it, wrapping ErrNotFound
+	// if it doesn't exist.
+	ObjectSize(ctx context.Context, key string) (uint64, error)
+}
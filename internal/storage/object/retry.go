@@ -0,0 +1,175 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// RetryOptions configures how RetryingStore retries failed requests.
+type RetryOptions struct {
+	MaxAttempts    int           // attempts per request, including the first one
+	InitialBackoff time.Duration // wait before the first retry
+	MaxBackoff     time.Duration // upper bound of the wait between attempts
+	Multiplier     float64       // growth of the wait after each retry
+	Jitter         float64       // fraction of the wait that is randomized, between 0 and 1
+
+	// Retryable decides which errors are retried, IsRetryable if nil
+	Retryable func(error) bool
+	// Sleep waits between attempts, it should return early with the context's error when it's done.
+	// Meant for tests, waits on a timer if nil.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// DefaultRetryOptions retries a request up to 3 times, waiting 100ms, 200ms and 400ms (±20%).
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// retryableCodes are the error codes of S3 (and compatible) APIs worth retrying
+var retryableCodes = map[string]bool{
+	"RequestTimeout":          true,
+	"RequestTimeoutException": true,
+	"SlowDown":                true,
+	"Throttling":              true,
+	"ThrottlingException":     true,
+	"RequestLimitExceeded":    true,
+	"InternalError":           true,
+	"ServiceUnavailable":      true,
+}
+
+// IsRetryable reports whether a failed request may succeed if tried again: timeouts, network
+// errors, throttling and 5xx responses. Missing objects and cancelled requests are not retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && retryableCodes[apiErr.ErrorCode()] {
+		return true
+	}
+
+	var respErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &respErr) {
+		status := respErr.HTTPStatusCode()
+		return status >= 500 || status == 429
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// RetryingStore wraps an object store, retrying failed PutObject and GetObject requests with
+// exponential backoff. Requests aren't retried once their context is done.
+type RetryingStore struct {
+	store ObjectStore
+	opts  RetryOptions
+}
+
+var _ ObjectStore = (*RetryingStore)(nil)
+
+func NewRetrying(store ObjectStore, opts RetryOptions) *RetryingStore {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.Multiplier < 1 {
+		opts.Multiplier = 1
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = sleep
+	}
+
+	return &RetryingStore{store: store, opts: opts}
+}
+
+func (s *RetryingStore) PutObject(ctx context.Context, key string, data []byte) error {
+	return s.do(ctx, func() error {
+		return s.store.PutObject(ctx, key, data)
+	})
+}
+
+func (s *RetryingStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	var data []byte
+	err := s.do(ctx, func() error {
+		var err error
+		data, err = s.store.GetObject(ctx, key, dataRange)
+		return err
+	})
+	return data, err
+}
+
+func (s *RetryingStore) DeleteObject(ctx context.Context, key string) error {
+	return s.store.DeleteObject(ctx, key)
+}
+
+func (s *RetryingStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	return s.store.ListObjects(ctx, prefix)
+}
+
+func (s *RetryingStore) ObjectSize(ctx context.Context, key string) (uint64, error) {
+	var size uint64
+	err := s.do(ctx, func() error {
+		var err error
+		size, err = s.store.ObjectSize(ctx, key)
+		return err
+	})
+	return size, err
+}
+
+func (s *RetryingStore) do(ctx context.Context, op func() error) error {
+	backoff := s.opts.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= s.opts.MaxAttempts || !s.opts.Retryable(err) || ctx.Err() != nil {
+			if err != nil && attempt > 1 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		if err := s.opts.Sleep(ctx, withJitter(backoff, s.opts.Jitter)); err != nil {
+			return err
+		}
+
+		backoff = min(time.Duration(float64(backoff)*s.opts.Multiplier), s.opts.MaxBackoff)
+	}
+}
+
+// withJitter returns d changed by a random amount of up to ±jitter*d
+func withJitter(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 || d <= 0 {
+		return d
+	}
+	delta := float64(d) * min(jitter, 1) * (2*rand.Float64() - 1)
+	return d + time.Duration(delta)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
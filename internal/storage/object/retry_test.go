@@ -0,0 +1,136 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStore fails the first failures requests with err, then passes them to the wrapped store
+type flakyStore struct {
+	ObjectStore
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakyStore) PutObject(ctx context.Context, key string, data []byte) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return s.ObjectStore.PutObject(ctx, key, data)
+}
+
+func (s *flakyStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, s.err
+	}
+	return s.ObjectStore.GetObject(ctx, key, dataRange)
+}
+
+type statusError struct{ status int }
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", e.status) }
+func (e statusError) HTTPStatusCode() int { return e.status }
+
+type codeError struct{ code string }
+
+func (e codeError) Error() string     { return e.code }
+func (e codeError) ErrorCode() string { return e.code }
+
+// testRetryOptions records the waits instead of sleeping
+func testRetryOptions(waits *[]time.Duration) RetryOptions {
+	opts := DefaultRetryOptions()
+	opts.Jitter = 0
+	opts.Sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return opts
+}
+
+func TestRetryingStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("succeeds after two failures", func(t *testing.T) {
+		var waits []time.Duration
+		flaky := &flakyStore{ObjectStore: NewMemory(), failures: 2, err: statusError{503}}
+		store := NewRetrying(flaky, testRetryOptions(&waits))
+
+		require.NoError(t, store.PutObject(ctx, "obj", []byte("0123456789")))
+		assert.Equal(t, 3, flaky.calls)
+		assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, waits, "backoff should double")
+
+		flaky.calls, flaky.failures, waits = 0, 2, nil
+		got, err := store.GetObject(ctx, "obj", [2]uint64{0, 3})
+		require.NoError(t, err)
+		assert.Equal(t, "0123", string(got))
+		assert.Equal(t, 3, flaky.calls)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		var waits []time.Duration
+		flaky := &flakyStore{ObjectStore: NewMemory(), failures: 10, err: codeError{"SlowDown"}}
+		store := NewRetrying(flaky, testRetryOptions(&waits))
+
+		err := store.PutObject(ctx, "obj", []byte("data"))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, flaky.err))
+		assert.Equal(t, 4, flaky.calls)
+		assert.Len(t, waits, 3)
+	})
+
+	t.Run("does not retry errors that are not retryable", func(t *testing.T) {
+		for _, err := range []error{
+			fmt.Errorf("object obj: %w", ErrNotFound),
+			statusError{403},
+			context.Canceled,
+		} {
+			var waits []time.Duration
+			flaky := &flakyStore{ObjectStore: NewMemory(), failures: 1, err: err}
+			store := NewRetrying(flaky, testRetryOptions(&waits))
+
+			_, getErr := store.GetObject(ctx, "obj", [2]uint64{0, 1})
+			assert.ErrorIs(t, getErr, err)
+			assert.Equal(t, 1, flaky.calls, "%v should not be retried", err)
+			assert.Empty(t, waits)
+		}
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		flaky := &flakyStore{ObjectStore: NewMemory(), failures: 10, err: statusError{500}}
+		opts := DefaultRetryOptions()
+		opts.InitialBackoff = time.Hour
+		store := NewRetrying(flaky, opts)
+
+		err := store.PutObject(ctx, "obj", []byte("data"))
+		require.Error(t, err)
+		assert.Equal(t, 1, flaky.calls)
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(statusError{500}))
+	assert.True(t, IsRetryable(statusError{503}))
+	assert.True(t, IsRetryable(statusError{429}))
+	assert.True(t, IsRetryable(codeError{"RequestTimeout"}))
+	assert.True(t, IsRetryable(fmt.Errorf("put: %w", codeError{"ThrottlingException"})))
+	assert.True(t, IsRetryable(context.DeadlineExceeded))
+
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(statusError{404}))
+	assert.False(t, IsRetryable(codeError{"NoSuchKey"}))
+	assert.False(t, IsRetryable(fmt.Errorf("get: %w", ErrNotFound)))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(errors.New("invalid range")))
+}
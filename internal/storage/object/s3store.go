@@ -3,6 +3,7 @@ package objectstore
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -12,18 +13,48 @@ import (
 )
 
 type S3Store struct {
-	client     *s3.Client
-	bucketName string
+	client             *s3.Client
+	bucketName         string
+	multipartThreshold int
+	partSize           int
 }
 
-func NewS3(client *s3.Client, bucketName string) *S3Store {
-	return &S3Store{
-		client:     client,
-		bucketName: bucketName,
+type S3Opt func(*S3Store)
+
+// WithMultipart makes objects larger than threshold bytes be uploaded in parts of partSize
+// bytes. S3 requires parts (but the last one) to be at least MinPartSize bytes.
+func WithMultipart(threshold int, partSize int) S3Opt {
+	return func(s *S3Store) {
+		s.multipartThreshold = threshold
+		s.partSize = partSize
+	}
+}
+
+func NewS3(client *s3.Client, bucketName string, opts ...S3Opt) *S3Store {
+	s := &S3Store{
+		client:             client,
+		bucketName:         bucketName,
+		multipartThreshold: DefaultMultipartThreshold,
+		partSize:           DefaultPartSize,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
+// PutObject uploads data in a single request, or in parts if it is larger than the multipart
+// threshold (see WithMultipart).
 func (s *S3Store) PutObject(ctx context.Context, key string, data []byte) error {
+	if len(data) > s.multipartThreshold {
+		if err := putMultipart(ctx, s, key, data, s.partSize); err != nil {
+			return fmt.Errorf("failed to upload data to S3: %w", err)
+		}
+		return nil
+	}
+
 	r := bytes.NewReader(data)
 	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:            aws.String(s.bucketName),
@@ -38,13 +69,76 @@ func (s *S3Store) PutObject(ctx context.Context, key string, data []byte) error
 	return nil
 }
 
+func (s *S3Store) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	resp, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s.bucketName),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(resp.UploadId), nil
+}
+
+func (s *S3Store) uploadPart(ctx context.Context, key string, uploadID string, number int32, data []byte) (completedPart, error) {
+	resp, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:            aws.String(s.bucketName),
+		Key:               aws.String(key),
+		UploadId:          aws.String(uploadID),
+		PartNumber:        aws.Int32(number),
+		Body:              bytes.NewReader(data),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return completedPart{}, err
+	}
+
+	return completedPart{
+		number:   number,
+		etag:     aws.ToString(resp.ETag),
+		checksum: aws.ToString(resp.ChecksumCRC32),
+	}, nil
+}
+
+func (s *S3Store) completeMultipartUpload(ctx context.Context, key string, uploadID string, parts []completedPart) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{
+			PartNumber: aws.Int32(part.number),
+			ETag:       aws.String(part.etag),
+		}
+		if part.checksum != "" {
+			completed[i].ChecksumCRC32 = aws.String(part.checksum)
+		}
+	}
+
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+func (s *S3Store) abortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
 func (s *S3Store) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(key),
 	}
 
-	if dataRange[0] < dataRange[1] {
+	if dataRange[0] <= dataRange[1] {
 		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", dataRange[0], dataRange[1]))
 	} else {
 		return nil, fmt.Errorf("invalid data range: %v", dataRange)
@@ -52,6 +146,10 @@ func (s *S3Store) GetObject(ctx context.Context, key string, dataRange [2]uint64
 
 	resp, err := s.client.GetObject(ctx, input)
 	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("error retrieving data from S3: %w: %w", ErrNotFound, err)
+		}
 		return nil, fmt.Errorf("error retrieving data from S3: %w", err)
 	}
 	defer resp.Body.Close()
@@ -63,3 +161,55 @@ func (s *S3Store) GetObject(ctx context.Context, key string, dataRange [2]uint64
 
 	return data, nil
 }
+
+func (s *S3Store) DeleteObject(ctx context.Context, key string) error {
+	// S3 doesn't complain about missing keys, so deleting is already idempotent
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object from S3: %w", err)
+	}
+
+	return nil
+}
+
+func (s *S3Store) ObjectSize(ctx context.Context, key string) (uint64, error) {
+	resp, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// HEAD responses have no body, so a missing key is a plain NotFound rather than NoSuchKey
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return 0, fmt.Errorf("error retrieving object size from S3: %w: %w", ErrNotFound, err)
+		}
+		return 0, fmt.Errorf("error retrieving object size from S3: %w", err)
+	}
+
+	return uint64(aws.ToInt64(resp.ContentLength)), nil
+}
+
+func (s *S3Store) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in S3: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+
+	return keys, nil
+}
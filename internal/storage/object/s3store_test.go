@@ -0,0 +1,17 @@
+package objectstore_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/vinimdocarmo/quackfs/internal/quackfstest"
+	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
+)
+
+func TestS3Conformance(t *testing.T) {
+	if os.Getenv("TEST_OBJECT_STORE") != "s3" {
+		t.Skip("Set TEST_OBJECT_STORE=s3 to run against the LocalStack test bucket")
+	}
+
+	objectstore.RunConformanceTests(t, func() objectstore.ObjectStore { return quackfstest.NewS3Store(t) })
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// CheckpointPlan describes what a checkpoint of a file would persist.
+type CheckpointPlan struct {
+	Version string // tag Checkpoint would generate, empty if there is nothing to checkpoint
+	Bytes   uint64 // size of the active layer, before compression, encryption and deduplication
+	Chunks  int    // chunks of the active layer
+	// The key of the layer object depends on the id of the new version, which is only assigned
+	// by the checkpoint, so only the prefix it will start with is known
+	ObjectKeyPrefix string
+}
+
+// CheckpointPlan returns what Checkpoint would persist for the file, without uploading or
+// recording anything. Like Checkpoint, it fails if the file has a head and returns an empty
+// plan (with an empty Version) if there is nothing to checkpoint.
+func (mgr *Manager) CheckpointPlan(ctx context.Context, filename string) (CheckpointPlan, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return CheckpointPlan{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		if err == types.ErrNotFound {
+			mgr.log.Warn("File not found, nothing to checkpoint", "filename", filename)
+			return CheckpointPlan{}, nil
+		}
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return CheckpointPlan{}, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	_, _, err = mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
+	if err == nil {
+		mgr.log.Error("Cannot checkpoint file with head pointing to version", "filename", filename)
+		return CheckpointPlan{}, fmt.Errorf("cannot checkpoint file: %s is in read-only mode because a head is set, use DeleteHead first", filename)
+	} else if err != types.ErrNotFound {
+		mgr.log.Error("Failed to check head version", "filename", filename, "error", err)
+		return CheckpointPlan{}, fmt.Errorf("failed to check head version: %w", err)
+	}
+
+	activeLayer, exists := mgr.memtable[fileID]
+	if !exists || len(activeLayer.Chunks) == 0 {
+		return CheckpointPlan{}, nil
+	}
+
+	version, err := mgr.nextVersionTag(ctx, fileID, tx)
+	if err != nil {
+		mgr.log.Error("Failed to generate version tag", "filename", filename, "error", err)
+		return CheckpointPlan{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return CheckpointPlan{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return CheckpointPlan{
+		Version:         version,
+		Bytes:           uint64(len(activeLayer.Data)),
+		Chunks:          len(activeLayer.Chunks),
+		ObjectKeyPrefix: commonPrefix(mgr.layerObjectKey(filename, fileID, 1), mgr.layerObjectKey(filename, fileID, 2)),
+	}, nil
+}
+
+// commonPrefix returns the longest prefix of a and b
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < min(len(a), len(b)) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
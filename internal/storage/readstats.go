@@ -0,0 +1,55 @@
+package storage
+
+import "time"
+
+// ReadStats describes how much work it took to reconstruct the data returned by a
+// read. Files whose reads touch many chunks or layers are good candidates for compaction.
+type ReadStats struct {
+	ChunksScanned int    // chunks overlapping the read range, flushed or not
+	LayersTouched int    // distinct layers those chunks belong to, including the active one
+	BytesFetched  uint64 // bytes fetched from the object store (as stored, i.e. compressed and/or encrypted)
+	CacheHits     int    // flushed chunks served from the read cache
+}
+
+type readOptions struct {
+	stats   *ReadStats
+	version string
+	asOf    time.Time
+	fileID  uint64
+}
+
+// ReadOpt configures a single ReadFile call.
+type ReadOpt func(*readOptions)
+
+// WithReadStats makes ReadFile fill stats with the cost of the read. Stats are reset
+// at the start of the read.
+func WithReadStats(stats *ReadStats) ReadOpt {
+	return func(o *readOptions) {
+		o.stats = stats
+	}
+}
+
+// WithVersion makes ReadFile read version tag of the file, regardless of its head
+// pointer and ignoring uncommitted writes.
+func WithVersion(tag string) ReadOpt {
+	return func(o *readOptions) {
+		o.version = tag
+	}
+}
+
+// WithAsOf makes ReadFile read the latest version of the file created at or before t, ignoring
+// its head pointer and uncommitted writes. The read fails if every version is newer than t.
+// WithVersion takes precedence over it.
+func WithAsOf(t time.Time) ReadOpt {
+	return func(o *readOptions) {
+		o.asOf = t
+	}
+}
+
+// WithReadFileID makes ReadFile fail with types.ErrStaleFile if the file doesn't exist anymore
+// or isn't the file with ID fileID anymore, e.g. because it was replaced by a rename.
+func WithReadFileID(fileID uint64) ReadOpt {
+	return func(o *readOptions) {
+		o.fileID = fileID
+	}
+}
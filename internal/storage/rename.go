@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+type renameOptions struct {
+	noReplace bool
+}
+
+// RenameOpt configures a single RenameFile call.
+type RenameOpt func(*renameOptions)
+
+// WithNoReplace makes RenameFile fail with types.ErrFileExists instead of replacing the file
+// already named newName, if any. Renames through the mount replace it, like rename(2) does.
+func WithNoReplace() RenameOpt {
+	return func(o *renameOptions) {
+		o.noReplace = true
+	}
+}
+
+// RenameFile renames a file, keeping its versions and uncommitted writes. If a file named
+// newName exists, it is replaced (unless WithNoReplace is given): it is deleted along with its
+// versions, and its layer objects are left for GC to delete. Returns types.ErrNotFound if
+// oldName doesn't exist, and types.ErrInvalidFilename if newName isn't a valid file name.
+func (mgr *Manager) RenameFile(ctx context.Context, oldName string, newName string, opts ...RenameOpt) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	var renameOpts renameOptions
+	for _, opt := range opts {
+		opt(&renameOpts)
+	}
+
+	if err := checkFileName(newName); err != nil {
+		return err
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, oldName, metadata.WithTx(tx))
+	if err != nil {
+		if err != types.ErrNotFound {
+			mgr.log.Error("Failed to get file ID", "filename", oldName, "error", err)
+		}
+		return err
+	}
+
+	err = mgr.checkEpoch(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Cannot rename file", "filename", oldName, "error", err)
+		return fmt.Errorf("cannot rename file %s: %w", oldName, err)
+	}
+
+	if renameOpts.noReplace {
+		_, err := mgr.metaStore.GetFileIDByName(ctx, newName, metadata.WithTx(tx))
+		if err == nil {
+			return fmt.Errorf("cannot rename %s to %s: file %q: %w", oldName, newName, newName, types.ErrFileExists)
+		} else if err != types.ErrNotFound {
+			mgr.log.Error("Failed to get file ID", "filename", newName, "error", err)
+			return fmt.Errorf("failed to get file ID: %w", err)
+		}
+	}
+
+	// The memtable and the epochs are keyed by file ID, they follow the renamed file as is
+	replacedID, err := mgr.deleteFileByName(ctx, tx, newName)
+	if err != nil {
+		return err
+	}
+
+	if err = mgr.metaStore.RenameFile(ctx, fileID, newName, metadata.WithTx(tx)); err != nil {
+		mgr.log.Error("Failed to rename file", "filename", oldName, "newName", newName, "error", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if replacedID != 0 {
+		delete(mgr.memtable, replacedID)
+		mgr.dropJournal(replacedID)
+		delete(mgr.epochs, replacedID)
+	}
+
+	delete(mgr.uncommitted, newName)
+	if _, ok := mgr.uncommitted[oldName]; ok {
+		delete(mgr.uncommitted, oldName)
+		mgr.uncommitted[newName] = fileID
+	}
+
+	mgr.log.Info("File renamed", "filename", oldName, "newName", newName, "fileID", fileID, "replacedFileID", replacedID)
+
+	return nil
+}
+
+// deleteFileByName deletes the file with the given name within tx, if there is one, and
+// returns its ID (0 if there was none).
+func (mgr *Manager) deleteFileByName(ctx context.Context, tx *sql.Tx, name string) (uint64, error) {
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, name, metadata.WithTx(tx))
+	if err == types.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", name, "error", err)
+		return 0, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	// Another node may still be writing to it
+	err = mgr.checkEpoch(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Cannot delete file", "filename", name, "error", err)
+		return 0, fmt.Errorf("cannot delete file %s: %w", name, err)
+	}
+
+	if err := mgr.metaStore.DeleteFile(ctx, tx, fileID); err != nil {
+		mgr.log.Error("Failed to delete file", "filename", name, "error", err)
+		return 0, err
+	}
+
+	return fileID, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// undoTimeout bounds putting a file back as it was after a failed Revert, which happens even
+// if the context of the revert is done
+const undoTimeout = 30 * time.Second
+
+// Revert creates version newTag with the content of version targetTag, on top of the latest
+// version, so writes can continue from an older state. If a head is set it is cleared: the file
+// leaves read-only mode and reads return the reverted content.
+//
+// Files never shrink, so if the file has grown since targetTag, the bytes past the end of
+// targetTag are zeroed in the new version. An empty newTag gets a generated tag, see Checkpoint.
+// Writes of the file fail with types.ErrFileBusy while it is reverted, and the revert fails if
+// another version is checkpointed meanwhile.
+func (mgr *Manager) Revert(ctx context.Context, filename string, targetTag string, newTag string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	// The reverted content is written as a fresh active layer, over the whole file
+	if err := mgr.beginRewrite(fileID, filename, "revert"); err != nil {
+		return err
+	}
+	defer mgr.endRewrite(fileID)
+
+	targetSize, err := mgr.SizeOfVersion(ctx, filename, targetTag)
+	if err != nil {
+		return fmt.Errorf("failed to get size of version %s: %w", targetTag, err)
+	}
+
+	var data []byte
+	if targetSize > 0 {
+		data, err = mgr.ReadFile(ctx, filename, 0, targetSize, WithVersion(targetTag))
+		if err != nil {
+			return fmt.Errorf("failed to read version %s: %w", targetTag, err)
+		}
+	}
+
+	latest, err := mgr.GetLatestVersion(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	head, err := mgr.GetHead(ctx, filename)
+	if err != nil {
+		return err
+	}
+	if head != "" {
+		if err := mgr.DeleteHead(ctx, filename); err != nil {
+			return err
+		}
+	}
+
+	// Put things back as they were if the new version can't be created, even if ctx is done
+	undo := func(err error) error {
+		mgr.discardRewrite(fileID)
+
+		if head == "" {
+			return err
+		}
+		restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), undoTimeout)
+		defer cancel()
+		if restoreErr := mgr.SetHead(restoreCtx, filename, head); restoreErr != nil {
+			mgr.log.Error("Failed to restore head after failed revert", "filename", filename, "head", head, "error", restoreErr)
+			return errors.Join(err, fmt.Errorf("failed to restore head %s, it has to be set again: %w", head, restoreErr))
+		}
+		return err
+	}
+
+	size, err := mgr.SizeOf(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file size", "filename", filename, "error", err)
+		return undo(fmt.Errorf("failed to get file size: %w", err))
+	}
+	if size > targetSize {
+		data = append(data, make([]byte, size-targetSize)...)
+	}
+
+	if len(data) == 0 {
+		return undo(fmt.Errorf("cannot revert %s to %s: there is no data to revert to", filename, targetTag))
+	}
+
+	if err := mgr.WriteFile(ctx, filename, data, 0, withRewrite()); err != nil {
+		return undo(fmt.Errorf("failed to write reverted content: %w", err))
+	}
+
+	version, err := mgr.Checkpoint(ctx, filename, newTag, WithMessage(fmt.Sprintf("Revert to %s", targetTag)), withRewriteOf(latest))
+	if err != nil {
+		return undo(fmt.Errorf("failed to checkpoint reverted content: %w", err))
+	}
+
+	mgr.log.Info("File reverted", "filename", filename, "target", targetTag, "version", version)
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+)
+
+// beginRewrite marks a file as being rewritten by op (Compact or Revert), which writes new
+// content for the whole file as uncommitted writes and checkpoints them. Until endRewrite,
+// other writes of the file fail with types.ErrFileBusy, since the rewrite would overwrite
+// them, and other checkpoints of it leave the rewritten content alone. It fails if the file
+// has uncommitted writes, they would be mixed with the rewritten content, or if it is
+// already being rewritten.
+func (mgr *Manager) beginRewrite(fileID uint64, filename string, op string) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if mgr.rewriting[fileID] {
+		return fmt.Errorf("cannot %s %s: %w", op, filename, types.ErrFileBusy)
+	}
+	if mgr.hasPendingWrites(fileID) {
+		return fmt.Errorf("cannot %s %s: it has uncommitted writes", op, filename)
+	}
+
+	mgr.rewriting[fileID] = true
+	return nil
+}
+
+// endRewrite lets other writes and checkpoints of a file rewritten since beginRewrite go on.
+func (mgr *Manager) endRewrite(fileID uint64) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	delete(mgr.rewriting, fileID)
+}
+
+// discardRewrite drops the uncommitted writes of a rewrite that couldn't be checkpointed.
+// The file had none when the rewrite began and nothing else could write it since, so they
+// are all the rewrite's own.
+func (mgr *Manager) discardRewrite(fileID uint64) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	delete(mgr.memtable, fileID)
+	mgr.dropJournal(fileID)
+}
+
+// withRewrite lets the writes of a rewrite (see beginRewrite) through
+func withRewrite() WriteOpt {
+	return func(o *writeOptions) {
+		o.rewrite = true
+	}
+}
+
+// withRewriteOf makes the checkpoint of a rewrite (see beginRewrite) that was based on
+// version base fail if another version was checkpointed since, by this node or another one,
+// instead of replacing its content with the rewritten one.
+func withRewriteOf(base string) CheckpointOpt {
+	return func(o *checkpointOptions) {
+		o.rewrite = true
+		o.rewriteBase = base
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+// PublishSnapshot reconstructs the full content of version tag of a file and uploads it
+// to the object store as a single standalone object at destKey. The object is a plain copy
+// of the file (e.g. a regular .duckdb database), so it can be downloaded and used without quackfs.
+func (mgr *Manager) PublishSnapshot(ctx context.Context, filename string, tag string, destKey string) error {
+	if destKey == "" || strings.HasPrefix(destKey, layersPrefix) {
+		return fmt.Errorf("invalid snapshot key %q: must be non-empty and outside of %s", destKey, layersPrefix)
+	}
+
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, tag, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "filename", filename, "version", tag, "error", err)
+		return fmt.Errorf("failed to get layer for version: %w", err)
+	}
+
+	chunks, err := mgr.metaStore.GetAllOverlappingChunks(ctx, tx, fileID, [2]uint64{0, math.MaxInt64}, nil,
+		metadata.WithVersionedLayerID(layer.ID))
+	if err != nil {
+		mgr.log.Error("Failed to get chunks for version", "filename", filename, "version", tag, "error", err)
+		return fmt.Errorf("failed to get chunks for version: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	var size uint64
+	for _, c := range chunks {
+		size = max(size, c.FileRange[1])
+	}
+
+	// Chunks are ordered by id, so later writes overwrite earlier ones
+	data := make([]byte, size)
+	for _, c := range chunks {
+		if c.Hole {
+			clear(data[c.FileRange[0]:c.FileRange[1]])
+			continue
+		}
+		chunkData, err := mgr.getChunkData(ctx, c, nil)
+		if err != nil {
+			mgr.log.Error("Failed to get chunk data", "layerID", c.LayerID, "error", err)
+			return fmt.Errorf("failed to get chunk data: %w", err)
+		}
+		copy(data[c.FileRange[0]:c.FileRange[1]], chunkData)
+	}
+
+	if err = mgr.objectStore.PutObject(ctx, destKey, data); err != nil {
+		mgr.log.Error("Failed to upload snapshot", "destKey", destKey, "error", err)
+		return fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+
+	mgr.log.Info("Snapshot published", "filename", filename, "version", tag, "destKey", destKey, "size", size)
+
+	return nil
+}
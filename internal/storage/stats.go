@@ -0,0 +1,60 @@
+package storage
+
+import "context"
+
+// FileStats describes how much data of a file is persisted and buffered.
+type FileStats struct {
+	Name          string `json:"name"`
+	Versions      int    `json:"versions"`
+	SealedLayers  int    `json:"sealedLayers"`  // layers persisted to the object store
+	MemtableBytes uint64 `json:"memtableBytes"` // uncommitted writes held in memory by this manager
+	ObjectBytes   uint64 `json:"objectBytes"`   // size of the file's layer objects in the object store
+}
+
+// ManagerStats aggregates the FileStats of all files.
+type ManagerStats struct {
+	Files         int         `json:"files"`
+	Versions      int         `json:"versions"`
+	SealedLayers  int         `json:"sealedLayers"`
+	MemtableBytes uint64      `json:"memtableBytes"`
+	ObjectBytes   uint64      `json:"objectBytes"`
+	PerFile       []FileStats `json:"perFile"`
+}
+
+// Stats returns the amount of data buffered in memory and persisted, per file and in total.
+// Only the writes buffered by this manager are counted, not those of other nodes.
+func (mgr *Manager) Stats(ctx context.Context) (ManagerStats, error) {
+	rows, err := mgr.metaStore.GetFileStats(ctx)
+	if err != nil {
+		mgr.log.Error("Failed to get file stats", "error", err)
+		return ManagerStats{}, err
+	}
+
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	stats := ManagerStats{
+		Files:   len(rows),
+		PerFile: make([]FileStats, 0, len(rows)),
+	}
+
+	for _, row := range rows {
+		fileStats := FileStats{
+			Name:         row.Name,
+			Versions:     int(row.Versions),
+			SealedLayers: int(row.Layers),
+			ObjectBytes:  uint64(row.ObjectBytes),
+		}
+		if activeLayer, exists := mgr.memtable[row.ID]; exists {
+			fileStats.MemtableBytes = uint64(len(activeLayer.Data))
+		}
+
+		stats.Versions += fileStats.Versions
+		stats.SealedLayers += fileStats.SealedLayers
+		stats.MemtableBytes += fileStats.MemtableBytes
+		stats.ObjectBytes += fileStats.ObjectBytes
+		stats.PerFile = append(stats.PerFile, fileStats)
+	}
+
+	return stats, nil
+}
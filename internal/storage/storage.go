@@ -1,16 +1,24 @@
 package storage
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/dustin/go-humanize"
 	"github.com/vinimdocarmo/quackfs/db/sqlc"
 	"github.com/vinimdocarmo/quackfs/db/types"
 	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
+	"github.com/vinimdocarmo/quackfs/pkg/metrics"
 )
 
 type objectStore interface {
@@ -19,6 +27,14 @@ type objectStore interface {
 	// GetObject returns a slice of data from the given offset up to size bytes.
 	// Range is inclusive of the start and the end (i.e. [start, end])
 	GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error)
+	// DeleteObject removes an object from the object store.
+	// Deleting a key that doesn't exist is not an error.
+	DeleteObject(ctx context.Context, key string) error
+	// ListObjects returns the keys of all objects starting with prefix.
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+	// ObjectSize returns the size of an object without fetching it, wrapping objectstore.ErrNotFound
+	// if it doesn't exist.
+	ObjectSize(ctx context.Context, key string) (uint64, error)
 }
 
 type Manager struct {
@@ -26,49 +42,531 @@ type Manager struct {
 	log         *log.Logger
 	mu          sync.RWMutex               // Add a mutex to protect memtable
 	memtable    map[uint64]*metadata.Layer // Stores a mapping of file ids to their active layer
+	uncommitted map[string]uint64          // ids of the files with no versions, by name, whose data is all in the memtable
 	objectStore objectStore
 	metaStore   *metadata.MetadataStore
+	epochs      map[uint64]int64 // Fencing tokens held by this node, by file id
+	rewriting   map[uint64]bool  // ids of the files being rewritten by Compact or Revert, see beginRewrite
+
+	replicas         []objectStore
+	readStores       []*readStore // primary object store followed by its replicas, in failover order
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	cache            *chunkCache // nil when the read cache is disabled
+	cacheBytes       uint64
+	sealed           *layerCache // nil when sealed layers aren't kept in memory
+	sealedBytes      uint64
+	fetchSem         chan struct{} // limits the number of concurrent object store fetches
+	fetchConcurrency int
+
+	objectKey   ObjectKeyFunc     // how the objects of new layers are named
+	compression Compression       // how new layers are compressed
+	dedup       bool              // whether checkpoints reference identical stored chunks instead of uploading them
+	keys        map[string][]byte // encryption keys by id
+	keyring     *keyring          // nil when encryption is disabled
+	keyringErr  error             // set when one of the configured encryption keys is invalid
+	activeKeyID string            // key new layers are encrypted with
+
+	traceWrites bool // whether to record the origin of writes (see WithWriteOrigin)
+
+	metrics metrics.Metrics
+
+	maxOpenConns    int           // connections to the metadata store, in use or idle
+	maxIdleConns    int           // idle connections kept open for later queries
+	connMaxLifetime time.Duration // connections are closed (and reopened) once this old
+
+	opTimeout time.Duration // bound of each operation on the metadata and object stores, 0 for none
+
+	journalDir string   // where writes are journaled, empty when they aren't
+	journal    *journal // nil when writes aren't journaled
+	journalErr error    // set when the journal couldn't be opened or replayed
+}
+
+// readStore is an object store that chunk data can be read from, guarded by a circuit breaker.
+type readStore struct {
+	name    string
+	store   objectStore
+	breaker *circuitBreaker
+}
+
+type ManagerOpt func(*Manager)
+
+const (
+	// DefaultMaxOpenConns is the default maximum number of connections to the metadata store.
+	DefaultMaxOpenConns = 32
+	// DefaultMaxIdleConns is the default number of idle connections kept to the metadata store.
+	DefaultMaxIdleConns = 8
+	// DefaultConnMaxLifetime is the default maximum age of a connection to the metadata store.
+	DefaultConnMaxLifetime = 30 * time.Minute
+	// DefaultOperationTimeout is the operation timeout used by quackfs and op, see WithOperationTimeout.
+	DefaultOperationTimeout = time.Minute
+)
+
+// WithReplicas configures object stores holding copies of the primary store's objects.
+// Reads fail over to them, in order, when the primary store can't serve a chunk.
+// Keeping the replicas in sync (e.g. with S3 bucket replication) is up to the operator.
+func WithReplicas(replicas ...objectStore) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.replicas = append(mgr.replicas, replicas...)
+	}
+}
+
+// WithCircuitBreaker configures after how many consecutive failures an object store
+// is skipped by reads, and for how long. It only has an effect when replicas are configured.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.breakerThreshold = threshold
+		mgr.breakerCooldown = cooldown
+	}
+}
+
+// WithReadCache keeps up to maxBytes of chunk data fetched from the object store in memory,
+// so that reading the same chunks again doesn't go to the object store. It is disabled by default.
+func WithReadCache(maxBytes uint64) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.cacheBytes = maxBytes
+	}
+}
+
+// WithSealedLayerCache keeps the data of the layers this manager checkpoints in memory, up to
+// maxBytes in total, so that reads right after a checkpoint don't fetch from the object store
+// what was in the memtable a moment before. Unlike WithReadCache it holds whole layers, the
+// most recently checkpointed ones, whether they are read or not. It is disabled by default.
+func WithSealedLayerCache(maxBytes uint64) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.sealedBytes = maxBytes
+	}
+}
+
+// WithFetchConcurrency limits how many chunks can be fetched from the object store at the same time.
+// A read spanning several flushed chunks fetches up to n of them concurrently.
+func WithFetchConcurrency(n int) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.fetchConcurrency = n
+	}
+}
+
+// WithCompression compresses the data of new layers before uploading them to the object store.
+// Layers are always read back according to how they were written, regardless of this setting.
+func WithCompression(compression Compression) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.compression = compression
+	}
+}
+
+// WithEncryptionKey encrypts the data of new layers with AES-GCM before uploading them to the
+// object store. The key must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+// Reading encrypted layers requires the same key, while unencrypted layers can always be read.
+func WithEncryptionKey(key []byte) ManagerOpt {
+	return WithKeyring(map[string][]byte{defaultKeyID: key}, defaultKeyID)
+}
+
+// WithKeyring is like WithEncryptionKey, but with several keys identified by an id.
+// New layers are encrypted with the active key, which can be changed with RotateKey.
+// Each chunk records the id of its key, so that data encrypted with older keys stays readable
+// as long as those keys are kept in the keyring.
+func WithKeyring(keys map[string][]byte, activeKeyID string) ManagerOpt {
+	return func(mgr *Manager) {
+		if mgr.keys == nil {
+			mgr.keys = make(map[string][]byte)
+		}
+		for id, key := range keys {
+			mgr.keys[id] = key
+		}
+		mgr.activeKeyID = activeKeyID
+	}
+}
+
+// WithWriteTracing enables recording the origin of each write given with WithWriteOrigin
+// alongside the layer it ends up in, see GetWriteOrigins. This is meant for debugging and
+// costs an extra insert per checkpoint.
+func WithWriteTracing() ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.traceWrites = true
+	}
+}
+
+// WithChunkDedup makes checkpoints store only the chunks whose data isn't already stored for
+// the file: a chunk identical (by SHA-256) to a chunk of an earlier, readable version points to
+// that chunk's data instead of being uploaded again. Only chunks of encrypted layers are
+// reused for encrypted layers, and only chunks of plain ones for plain ones. Chunks pointing
+// to the data of an archived version make reads fail until it's restored, like any other read
+// needing that version.
+func WithChunkDedup() ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.dedup = true
+	}
+}
+
+// WithMetrics records the bytes written and read, the duration of checkpoints, the latency of
+// object store requests and the use of the read cache in m. Nothing is recorded by default.
+func WithMetrics(m metrics.Metrics) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.metrics = m
+	}
+}
+
+// WithConnPool configures the pool of connections to the metadata store: at most maxOpen
+// connections (0 for no limit), of which up to maxIdle are kept open when idle, each being
+// closed once maxLifetime old (0 to keep them forever).
+//
+// Reads (e.g. each FUSE read request) hold a connection for their read-only transaction until
+// they return, including while they fetch chunks from the object store. So at most maxOpen
+// reads make progress concurrently and the others wait for a connection, which is safe as a
+// read never needs a second one, but slow object store fetches can starve writes and
+// checkpoints of connections. Raise maxOpen for read-heavy workloads, within the
+// max_connections of PostgreSQL shared by every node.
+func WithConnPool(maxOpen int, maxIdle int, maxLifetime time.Duration) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.maxOpenConns = maxOpen
+		mgr.maxIdleConns = maxIdle
+		mgr.connMaxLifetime = maxLifetime
+	}
+}
+
+// WithOperationTimeout bounds how long each file operation (e.g. ReadFile, WriteFile, Checkpoint
+// or SizeOf) may take, queries to the metadata store and object store requests included, so that
+// a hung PostgreSQL or object store makes it fail with context.DeadlineExceeded instead of
+// blocking its caller forever. The deadline of the caller's context still applies if it's earlier.
+// Operations going over every file (GC, Verify, RotateKey, Stats, WalkVersions, Migrate), the ones
+// made of several operations (Compact), and the ones streaming data of an arbitrary size
+// (Warmup, GetVersionDelta, ApplyVersionDelta, PublishSnapshot) aren't bounded. It is disabled
+// (0) by default.
+func WithOperationTimeout(timeout time.Duration) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.opTimeout = timeout
+	}
+}
+
+// WithJournal appends every write to a journal in dir, synced to disk before WriteFile returns,
+// and replays the writes found there into the active layers when the manager is created. So
+// the uncommitted writes of a manager that crashed (or was stopped without checkpointing) are
+// not lost, at the cost of a local write and sync per write. The journal of a file is deleted
+// once its writes are checkpointed. Each manager needs its own directory, and a manager must
+// be created with the same one after a crash to recover its writes.
+//
+// The origins of journaled writes (see WithWriteOrigin) aren't kept. A crash right after a
+// checkpoint commits can leave its journal behind, whose writes are then checkpointed again:
+// the file reads the same but gets an extra version.
+func WithJournal(dir string) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.journalDir = dir
+	}
+}
+
+// withTimeout bounds ctx by the operation timeout, if one is configured
+func (mgr *Manager) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if mgr.opTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, mgr.opTimeout)
 }
 
 // NewManager creates (or reloads) a StorageManager using the provided metadataStore.
-func NewManager(db *sql.DB, store objectStore, log *log.Logger) *Manager {
+func NewManager(db *sql.DB, store objectStore, log *log.Logger, opts ...ManagerOpt) *Manager {
 	managerLog := log.With()
 	managerLog.SetPrefix("💽 storage")
 
 	sm := &Manager{
-		db:          db,
-		log:         managerLog,
-		memtable:    make(map[uint64]*metadata.Layer),
-		objectStore: store,
-		metaStore:   metadata.NewMetadataStore(db),
+		db:               db,
+		log:              managerLog,
+		memtable:         make(map[uint64]*metadata.Layer),
+		uncommitted:      make(map[string]uint64),
+		objectStore:      store,
+		metaStore:        metadata.NewMetadataStore(db),
+		epochs:           make(map[uint64]int64),
+		rewriting:        make(map[uint64]bool),
+		breakerThreshold: 5,
+		breakerCooldown:  30 * time.Second,
+		fetchConcurrency: 16,
+		objectKey:        DefaultObjectKey,
+		compression:      CompressionNone,
+		metrics:          metrics.Nop{},
+		maxOpenConns:     DefaultMaxOpenConns,
+		maxIdleConns:     DefaultMaxIdleConns,
+		connMaxLifetime:  DefaultConnMaxLifetime,
+	}
+
+	for _, opt := range opts {
+		opt(sm)
+	}
+
+	// Managers that never touch the metadata store (e.g. in tests) can be created without a database
+	if db != nil {
+		db.SetMaxOpenConns(sm.maxOpenConns)
+		db.SetMaxIdleConns(sm.maxIdleConns)
+		db.SetConnMaxLifetime(sm.connMaxLifetime)
+	}
+
+	if _, ok := sm.metrics.(metrics.Nop); !ok {
+		store = meteredStore{store: store, metrics: sm.metrics}
+		sm.objectStore = store
+		for i, replica := range sm.replicas {
+			sm.replicas[i] = meteredStore{store: replica, metrics: sm.metrics}
+		}
+	}
+
+	sm.readStores = append(sm.readStores, &readStore{name: "primary", store: store})
+	for i, replica := range sm.replicas {
+		sm.readStores = append(sm.readStores, &readStore{name: fmt.Sprintf("replica-%d", i+1), store: replica})
+	}
+
+	if sm.cacheBytes > 0 {
+		sm.cache = newChunkCache(sm.cacheBytes)
+	}
+	if sm.sealedBytes > 0 {
+		sm.sealed = newLayerCache(sm.sealedBytes)
+	}
+
+	sm.fetchSem = make(chan struct{}, max(sm.fetchConcurrency, 1))
+
+	if len(sm.keys) > 0 {
+		sm.keyring, sm.keyringErr = newKeyring(sm.keys)
+		if sm.keyringErr == nil {
+			_, sm.keyringErr = sm.keyring.get(sm.activeKeyID)
+		}
+		if sm.keyringErr != nil {
+			managerLog.Error("Invalid encryption keys, checkpoints will fail", "error", sm.keyringErr)
+		}
+	}
+
+	// Circuit breakers only make sense when there is somewhere else to read from
+	if len(sm.readStores) > 1 {
+		for _, rs := range sm.readStores {
+			rs.breaker = newCircuitBreaker(sm.breakerThreshold, sm.breakerCooldown)
+		}
+	}
+
+	if sm.journalDir != "" {
+		sm.journal, sm.journalErr = openJournal(sm.journalDir)
+		if sm.journalErr == nil {
+			sm.journalErr = sm.replayJournal()
+		}
+		if sm.journalErr != nil {
+			managerLog.Error("Failed to replay journal, writes will fail", "dir", sm.journalDir, "error", sm.journalErr)
+		}
 	}
 
 	return sm
 }
 
+type writeOptions struct {
+	zeroFill  bool
+	inPlace   bool
+	append    bool
+	fileID    uint64
+	rewrite   bool // see withRewrite
+	hasOrigin bool
+	requestID uint64
+	pid       uint32
+}
+
+// WriteOpt configures a single WriteFile call.
+type WriteOpt func(*writeOptions)
+
+// WithZeroFill sets what WriteFile does when offset is past the end of the file. When true
+// (the default, matching POSIX/FUSE semantics) the gap is filled with zeroes. When false
+// the write fails with types.ErrBeyondFileSize.
+func WithZeroFill(zeroFill bool) WriteOpt {
+	return func(o *writeOptions) {
+		o.zeroFill = zeroFill
+	}
+}
+
+// WithInPlaceOverwrite makes WriteFile overwrite the data of the uncommitted write it falls
+// within, when that is the last one to cover its range, instead of appending it to the active
+// layer. This keeps the active layer, and so the next checkpoint, from growing with pages
+// that are rewritten over and over, like DuckDB's headers. Other writes are appended as usual.
+func WithInPlaceOverwrite() WriteOpt {
+	return func(o *writeOptions) {
+		o.inPlace = true
+	}
+}
+
+// WithAppend makes WriteFile write at the end of the file whatever the offset, like writes to
+// files opened with O_APPEND. The end is found while holding the lock the write is made with,
+// so concurrent appends never overlap nor leave gaps.
+func WithAppend() WriteOpt {
+	return func(o *writeOptions) {
+		o.append = true
+	}
+}
+
+// WithWriteFileID makes WriteFile fail with types.ErrStaleFile, writing nothing, if the file
+// doesn't exist anymore or isn't the file with ID fileID anymore, e.g. because it was replaced
+// by a rename.
+func WithWriteFileID(fileID uint64) WriteOpt {
+	return func(o *writeOptions) {
+		o.fileID = fileID
+	}
+}
+
+// WithWriteOrigin sets the request (e.g. FUSE request id and pid of the caller) the write
+// originates from. It is only recorded when write tracing is enabled (see WithWriteTracing).
+func WithWriteOrigin(requestID uint64, pid uint32) WriteOpt {
+	return func(o *writeOptions) {
+		o.hasOrigin = true
+		o.requestID = requestID
+		o.pid = pid
+	}
+}
+
 // WriteFile writes data to the active layer at the specified offset.
-func (mgr *Manager) WriteFile(ctx context.Context, filename string, data []byte, offset uint64) error {
+// Writes past the end of the file zero-fill the gap, unless WithZeroFill(false) is given.
+func (mgr *Manager) WriteFile(ctx context.Context, filename string, data []byte, offset uint64, opts ...WriteOpt) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	writeOpts := writeOptions{zeroFill: true}
+	for _, opt := range opts {
+		opt(&writeOpts)
+	}
+
 	mgr.mu.Lock()         // Lock before accessing activeLayers
 	defer mgr.mu.Unlock() // Ensure unlock when function returns
 
 	mgr.log.Debug("Writing data", "filename", filename, "size", len(data), "offset", offset)
 
+	activeLayer, fileSize, err := mgr.prepareWrite(ctx, filename, writeOpts)
+	if err != nil {
+		return err
+	}
+
+	if writeOpts.append {
+		offset = fileSize
+	}
+
+	if offset > fileSize && !writeOpts.zeroFill {
+		mgr.log.Error("Write offset is beyond file size", "filename", filename, "offset", offset, "size", fileSize)
+		return fmt.Errorf("cannot write to %s at offset %d: %w of %d bytes", filename, offset, types.ErrBeyondFileSize, fileSize)
+	}
+
+	err = mgr.journalWrites(activeLayer.FileID, journalRecord{fileSize: fileSize, offset: offset, inPlace: writeOpts.inPlace, data: data})
+	if err != nil {
+		return err
+	}
+
+	mgr.appendWrite(activeLayer, fileSize, data, offset, writeOpts)
+	return nil
+}
+
+// WriteOp is a single write of WriteFileBatch.
+type WriteOp struct {
+	Offset uint64
+	Data   []byte
+}
+
+// WriteFileBatch applies writes to the active layer in order, as if WriteFile was called for
+// each of them, but looks up the file and takes the lock only once, which is cheaper for bursts
+// of small writes. The options apply to every write. With WithZeroFill(false), nothing is
+// written if any of the writes would start past the end of the file.
+func (mgr *Manager) WriteFileBatch(ctx context.Context, filename string, writes []WriteOp, opts ...WriteOpt) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	writeOpts := writeOptions{zeroFill: true}
+	for _, opt := range opts {
+		opt(&writeOpts)
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	mgr.log.Debug("Writing batch", "filename", filename, "writes", len(writes))
+
+	activeLayer, fileSize, err := mgr.prepareWrite(ctx, filename, writeOpts)
+	if err != nil {
+		return err
+	}
+
+	if writeOpts.append {
+		// Each write goes at the end of the file as extended by the writes before it
+		writes = slices.Clone(writes)
+		size := fileSize
+		for i := range writes {
+			writes[i].Offset = size
+			size += uint64(len(writes[i].Data))
+		}
+	}
+
+	// Every write extends the file up to its end (zero-filling any gap before it)
+	endOf := func(size uint64, w WriteOp) uint64 {
+		return max(size, w.Offset+uint64(len(w.Data)))
+	}
+
+	if !writeOpts.zeroFill {
+		size := fileSize
+		for _, w := range writes {
+			if w.Offset > size {
+				mgr.log.Error("Write offset is beyond file size", "filename", filename, "offset", w.Offset, "size", size)
+				return fmt.Errorf("cannot write to %s at offset %d: %w of %d bytes", filename, w.Offset, types.ErrBeyondFileSize, size)
+			}
+			size = endOf(size, w)
+		}
+	}
+
+	records := make([]journalRecord, len(writes))
+	size := fileSize
+	for i, w := range writes {
+		records[i] = journalRecord{fileSize: size, offset: w.Offset, inPlace: writeOpts.inPlace, data: w.Data}
+		size = endOf(size, w)
+	}
+	if err := mgr.journalWrites(activeLayer.FileID, records...); err != nil {
+		return err
+	}
+
+	for _, w := range writes {
+		mgr.appendWrite(activeLayer, fileSize, w.Data, w.Offset, writeOpts)
+		fileSize = endOf(fileSize, w)
+	}
+	return nil
+}
+
+// prepareWrite checks that filename can be written to with writeOpts and returns its active
+// layer, created if needed, and the current size of the file. It must be called with mgr.mu held.
+func (mgr *Manager) prepareWrite(ctx context.Context, filename string, writeOpts writeOptions) (*metadata.Layer, uint64, error) {
 	// Get the file ID from the file name
 	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if writeOpts.fileID != 0 && (err == nil || err == types.ErrNotFound) && fileID != writeOpts.fileID {
+		mgr.log.Warn("File was removed or replaced", "filename", filename, "fileID", writeOpts.fileID)
+		return nil, 0, fmt.Errorf("cannot write to file %s: %w", filename, types.ErrStaleFile)
+	}
 	if err != nil {
 		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
-		return fmt.Errorf("failed to get file ID: %w", err)
+		return nil, 0, fmt.Errorf("failed to get file ID: %w", err)
 	}
 
 	// Check if file has a head pointer, if so it's in read-only mode
 	_, _, err = mgr.metaStore.GetHeadVersion(ctx, fileID)
 	if err == nil {
 		mgr.log.Error("Cannot write to file with head pointing to version", "filename", filename)
-		return fmt.Errorf("cannot write to file: %s is in read-only mode because a head is set", filename)
+		return nil, 0, fmt.Errorf("cannot write to file: %s is in read-only mode because a head is set", filename)
+	}
+
+	// The rewrite would overwrite the write (see beginRewrite)
+	if mgr.rewriting[fileID] && !writeOpts.rewrite {
+		mgr.log.Error("Cannot write to file being rewritten", "filename", filename)
+		return nil, 0, fmt.Errorf("cannot write to file %s: %w", filename, types.ErrFileBusy)
+	}
+
+	err = mgr.checkEpoch(ctx, fileID)
+	if err != nil {
+		mgr.log.Error("Cannot write to file", "filename", filename, "error", err)
+		return nil, 0, fmt.Errorf("cannot write to file %s: %w", filename, err)
 	}
 
 	activeLayer, exists := mgr.memtable[fileID]
 	if !exists {
+		// Until its first version, the file can be read from memory alone
+		_, _, err = mgr.metaStore.GetLatestVersion(ctx, fileID)
+		if err == types.ErrNotFound {
+			mgr.uncommitted[filename] = fileID
+		} else if err != nil {
+			mgr.log.Error("Failed to get latest version", "filename", filename, "error", err)
+			return nil, 0, fmt.Errorf("failed to get latest version: %w", err)
+		}
+
 		activeLayer = &metadata.Layer{
 			FileID: fileID,
 			Chunks: []metadata.Chunk{},
@@ -81,9 +579,15 @@ func (mgr *Manager) WriteFile(ctx context.Context, filename string, data []byte,
 	fileSize, err := mgr.calcSizeOf(ctx, fileID)
 	if err != nil {
 		mgr.log.Error("Failed to calculate size of file", "error", err)
-		return fmt.Errorf("failed to calculate size of file: %w", err)
+		return nil, 0, fmt.Errorf("failed to calculate size of file: %w", err)
 	}
 
+	return activeLayer, fileSize, nil
+}
+
+// appendWrite appends a write at offset to the active layer of a file of size fileSize,
+// zero-filling the gap if offset is past the end. It must be called with mgr.mu held.
+func (mgr *Manager) appendWrite(activeLayer *metadata.Layer, fileSize uint64, data []byte, offset uint64, writeOpts writeOptions) {
 	if offset > fileSize {
 		// Calculate how many zero bytes to add
 		bytesToAdd := offset - fileSize
@@ -91,11 +595,7 @@ func (mgr *Manager) WriteFile(ctx context.Context, filename string, data []byte,
 		// Create a buffer of zero bytes
 		zeroes := make([]byte, bytesToAdd)
 
-		var layerSize uint64 = 0
-		if len(activeLayer.Chunks) > 0 {
-			layerSize = activeLayer.Chunks[len(activeLayer.Chunks)-1].FileRange[1]
-		}
-
+		layerSize := uint64(len(activeLayer.Data))
 		layerRange := [2]uint64{layerSize, layerSize + bytesToAdd}
 		fileRange := [2]uint64{fileSize, fileSize + bytesToAdd}
 
@@ -106,13 +606,44 @@ func (mgr *Manager) WriteFile(ctx context.Context, filename string, data []byte,
 			Flushed:    false, // since we're writing to the active layer, it's not flushed yet
 		})
 		activeLayer.Size = layerRange[1]
+		activeLayer.FileEnd = max(activeLayer.FileEnd, fileRange[1])
+	}
+
+	if writeOpts.inPlace {
+		if i := overwritableChunk(activeLayer.Chunks, offset, offset+uint64(len(data))); i >= 0 {
+			chunk := activeLayer.Chunks[i]
+			layerStart := chunk.LayerRange[0] + offset - chunk.FileRange[0]
+			copy(activeLayer.Data[layerStart:], data)
+			mgr.traceWrite(activeLayer, layerStart, data, offset, writeOpts)
+			mgr.metrics.WriteBytes(len(data))
+			return
+		}
 	}
 
-	var layerSize uint64 = 0
-	if len(activeLayer.Chunks) > 0 {
-		layerSize = activeLayer.Chunks[len(activeLayer.Chunks)-1].LayerRange[1]
+	mgr.traceWrite(activeLayer, uint64(len(activeLayer.Data)), data, offset, writeOpts)
+
+	// Sequential writes fast path: when writing right after the previous chunk, in the file and in
+	// the layer, extend that chunk instead of adding a new one. As the previous chunk is the last
+	// one written, extending it still makes the new data win over any earlier overlapping chunk,
+	// and this keeps the number of chunks (and so the work done on reads) down for appends and
+	// sequential rewrites, including writes following the zero-filled gap above.
+	if n := len(activeLayer.Chunks); n > 0 {
+		last := &activeLayer.Chunks[n-1]
+		if !last.Hole && last.FileRange[1] == offset && last.LayerRange[1] == uint64(len(activeLayer.Data)) {
+			activeLayer.Data = append(activeLayer.Data, data...)
+			last.LayerRange[1] += uint64(len(data))
+			last.FileRange[1] += uint64(len(data))
+			activeLayer.Size = last.LayerRange[1]
+			activeLayer.FileEnd = max(activeLayer.FileEnd, last.FileRange[1])
+			mgr.metrics.WriteBytes(len(data))
+			return
+		}
 	}
 
+	// The data is appended, so it always goes at the end of the layer. Chunks are kept in write
+	// order, which is what makes later writes win over earlier overlapping ones on reads.
+	layerSize := uint64(len(activeLayer.Data))
+
 	mgr.log.Debug("active layer info", "chunks", len(activeLayer.Chunks), "bytes", humanize.Bytes(layerSize))
 
 	layerRange := [2]uint64{layerSize, layerSize + uint64(len(data))}
@@ -125,8 +656,47 @@ func (mgr *Manager) WriteFile(ctx context.Context, filename string, data []byte,
 		Flushed:    false, // since we're writing to the active layer, it's not flushed yet
 	})
 	activeLayer.Size = layerRange[1]
+	activeLayer.FileEnd = max(activeLayer.FileEnd, fileRange[1])
 
-	return nil
+	mgr.metrics.WriteBytes(len(data))
+}
+
+// traceWrite records the origin of a write whose data is at layerStart in the active layer,
+// when write tracing is enabled and the write has one.
+func (mgr *Manager) traceWrite(activeLayer *metadata.Layer, layerStart uint64, data []byte, offset uint64, writeOpts writeOptions) {
+	if !mgr.traceWrites || !writeOpts.hasOrigin {
+		return
+	}
+
+	activeLayer.Origins = append(activeLayer.Origins, metadata.WriteOrigin{
+		LayerRange: [2]uint64{layerStart, layerStart + uint64(len(data))},
+		RequestID:  writeOpts.requestID,
+		PID:        writeOpts.pid,
+		Offset:     offset,
+		Size:       uint64(len(data)),
+	})
+}
+
+// overwritableChunk returns the index of the chunk whose data a write of the file range
+// [start, end) can overwrite in place, or -1. That is the last chunk overlapping the range, if
+// it holds the whole range: no later chunk overrides it there, so reads see the new data. A hole
+// has no data to overwrite.
+func overwritableChunk(chunks []metadata.Chunk, start uint64, end uint64) int {
+	if start == end {
+		return -1
+	}
+
+	for i := len(chunks) - 1; i >= 0; i-- {
+		chunk := chunks[i]
+		if chunk.FileRange[0] >= end || chunk.FileRange[1] <= start {
+			continue
+		}
+		if !chunk.Hole && chunk.FileRange[0] <= start && chunk.FileRange[1] >= end {
+			return i
+		}
+		return -1
+	}
+	return -1
 }
 
 func (mgr *Manager) GetActiveLayerSize(ctx context.Context, fileID uint64) uint64 {
@@ -152,7 +722,25 @@ func (mgr *Manager) GetActiveLayerData(ctx context.Context, fileID uint64) []byt
 	return l.Data
 }
 
+// hasPendingWrites tells whether the file has uncommitted writes, including holes, which have
+// no data. It must be called with mgr.mu held.
+func (mgr *Manager) hasPendingWrites(fileID uint64) bool {
+	activeLayer, exists := mgr.memtable[fileID]
+	return exists && len(activeLayer.Chunks) > 0
+}
+
+// GetFileID returns the ID of a file, or types.ErrNotFound if it doesn't exist
+func (mgr *Manager) GetFileID(ctx context.Context, filename string) (uint64, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.metaStore.GetFileIDByName(ctx, filename)
+}
+
 func (mgr *Manager) SizeOf(ctx context.Context, filename string) (uint64, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
 	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
 	if err != nil {
 		return 0, err
@@ -161,9 +749,64 @@ func (mgr *Manager) SizeOf(ctx context.Context, filename string) (uint64, error)
 	return mgr.calcSizeOf(ctx, fileID)
 }
 
+// SizeOfVersion returns the size of the file as of version versionTag, i.e. the highest end
+// offset written in the layers up to and including that version's. Uncommitted writes are ignored.
+func (mgr *Manager) SizeOfVersion(ctx context.Context, filename string, versionTag string) (uint64, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return 0, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, versionTag, tx)
+	if err != nil {
+		mgr.log.Error("Error fetching layer for version", "version", versionTag, "filename", filename, "error", err)
+		return 0, err
+	}
+
+	size, err := mgr.metaStore.CalcSizeOfLayer(ctx, fileID, layer.ID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to calculate size of version", "version", versionTag, "filename", filename, "error", err)
+		return 0, fmt.Errorf("failed to calculate size of version: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return size, nil
+}
+
 // ReadFile returns a slice of data from the given offset up to size bytes.
-// It automatically uses the head version if available, otherwise uses the latest version.
-func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64, size uint64) ([]byte, error) {
+// It reads the version given with WithVersion or WithAsOf if any, else the head version if
+// available, otherwise the latest version.
+func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64, size uint64, opts ...ReadOpt) ([]byte, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	var readOpts readOptions
+	for _, opt := range opts {
+		opt(&readOpts)
+	}
+
+	stats := readOpts.stats
+	if stats != nil {
+		*stats = ReadStats{}
+	}
+
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
 
@@ -172,6 +815,13 @@ func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64
 		"offset", offset,
 		"size", size)
 
+	// A file without versions has no head nor chunks in the metadata store, only its active layer
+	if fileID, ok := mgr.uncommitted[filename]; ok && readOpts.version == "" && readOpts.asOf.IsZero() && (readOpts.fileID == 0 || fileID == readOpts.fileID) {
+		if activeLayer, exists := mgr.memtable[fileID]; exists {
+			return mgr.readActiveLayer(activeLayer, offset, size, stats), nil
+		}
+	}
+
 	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
 		ReadOnly: true,
 	})
@@ -194,6 +844,11 @@ func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64
 	}()
 
 	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if readOpts.fileID != 0 && (err == nil || err == types.ErrNotFound) && fileID != readOpts.fileID {
+		mgr.log.Warn("File was removed or replaced", "filename", filename, "fileID", readOpts.fileID)
+		err = fmt.Errorf("cannot read file %s: %w", filename, types.ErrStaleFile)
+		return nil, err
+	}
 	if fileID == 0 {
 		mgr.log.Error("File not found", "filename", filename)
 		return nil, fmt.Errorf("file not found")
@@ -203,16 +858,48 @@ func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64
 		return nil, fmt.Errorf("failed to get file ID: %w", err)
 	}
 
-	// Check if the file has a head pointer and use that version if available
-	var versionedLayerId uint64
-	headVersionId, headVersionTag, err := mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
-	hasHeadVersion := headVersionId > 0
+	// Read the version given with WithVersion or resolved from WithAsOf, or else the head
+	// version if the file has a head pointer
+	versionTag := readOpts.version
+	if versionTag == "" && !readOpts.asOf.IsZero() {
+		versionTag, _, err = mgr.metaStore.GetVersionAsOf(ctx, fileID, readOpts.asOf, metadata.WithTx(tx))
+		if err != nil {
+			mgr.log.Error("Failed to get version as of time", "filename", filename, "asOf", readOpts.asOf, "error", err)
+			if err == types.ErrNotFound {
+				err = fmt.Errorf("%w: no version of %s was created at or before %s", types.ErrNotFound, filename, readOpts.asOf.Format(time.RFC3339))
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to get version as of time: %w", err)
+		}
+	}
+	if versionTag == "" {
+		var headVersionId uint64
+		var headVersionTag string
+		headVersionId, headVersionTag, err = mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
+		if err != nil && err != types.ErrNotFound {
+			mgr.log.Error("Failed to get head version", "filename", filename, "error", err)
+			return nil, fmt.Errorf("failed to get head version: %w", err)
+		}
+		if headVersionId > 0 {
+			versionTag = headVersionTag
+		}
+	}
+	hasVersion := versionTag != ""
 
-	if hasHeadVersion {
-		mgr.log.Debug("using head version for file", "filename", filename, "version", headVersionTag)
-		versionedLayer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, headVersionTag, tx)
+	var versionedLayerId uint64
+	if hasVersion {
+		mgr.log.Debug("using version for file", "filename", filename, "version", versionTag)
+		var versionedLayer *metadata.Layer
+		versionedLayer, err = mgr.metaStore.GetLayerByVersion(ctx, fileID, versionTag, tx)
 		if err != nil {
-			mgr.log.Error("Error fetching layer for head version", "version", headVersionTag, "filename", filename, "error", err)
+			mgr.log.Error("Error fetching layer for version", "version", versionTag, "filename", filename, "error", err)
+			return nil, err
+		}
+
+		// Don't wait on a fetch from cold storage, the caller has to restore the version first
+		if versionedLayer.Archived {
+			err = fmt.Errorf("%w: version %s of %s is in cold storage, use RestoreVersion first", types.ErrObjectArchived, versionTag, filename)
+			mgr.log.Error("Cannot read archived version", "version", versionTag, "filename", filename)
 			return nil, err
 		}
 		versionedLayerId = versionedLayer.ID
@@ -231,51 +918,64 @@ func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64
 		return nil, err
 	}
 
-	var maxEndOffset uint64
+	// The buffer has to hold every chunk, sealed or in the active layer, as the latter can
+	// extend past the end of all sealed chunks. Reading past the end of the file yields nothing.
+	maxEndOffset := offset
 	for _, chunk := range chunks {
-		if chunk.FileRange[1] > maxEndOffset {
-			maxEndOffset = chunk.FileRange[1]
-		}
+		maxEndOffset = max(maxEndOffset, chunk.FileRange[1])
 	}
 
 	buf := make([]byte, maxEndOffset-offset)
 
-	for _, chunk := range chunks {
-		var bufferPos uint64
-		var chunkStartPos uint64
-		var dataSize uint64
-		var data []byte
+	if stats != nil {
+		stats.ChunksScanned = len(chunks)
 
-		// The layer for this chunk hasn't been flushed to storage yet. It's in the active layer.
-		if !chunk.Flushed {
-			data = activeLayer.Data[chunk.LayerRange[0]:chunk.LayerRange[1]]
-		} else {
-			data, err = mgr.getChunkData(ctx, chunk)
-			if err != nil {
-				mgr.log.Error("Failed to get chunk data", "error", err)
-				return nil, fmt.Errorf("failed to get chunk data: %w", err)
-			}
+		layers := make(map[uint64]bool)
+		for _, chunk := range chunks {
+			layers[chunk.LayerID] = true
 		}
+		stats.LayersTouched = len(layers)
+	}
 
-		if chunk.FileRange[0] < offset {
-			// Chunk starts before the requested offset
-			// We only want to copy the portion starting from the requested offset
-			chunkStartPos = offset - chunk.FileRange[0]
-			bufferPos = 0
+	// Flushed chunks are fetched concurrently, a window of chunks at a time to keep memory bounded,
+	// and copied in order so that later chunks still override earlier ones
+	windowSize := max(mgr.fetchConcurrency, 1)
+	for start, end := 0, 0; start < len(chunks); start = end {
+		end = windowEnd(chunks, start, windowSize)
+		window := chunks[start:end]
 
-			dataSize = uint64(len(data)) - chunkStartPos
-		} else {
-			bufferPos = chunk.FileRange[0] - offset
-			chunkStartPos = 0
-			dataSize = uint64(len(data))
+		var data [][]byte
+		data, err = mgr.fetchChunks(ctx, window, activeLayer, stats)
+		if err != nil {
+			// The caller gave up (e.g. a cancelled FUSE request)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				mgr.log.Debug("Read cancelled", "filename", filename, "error", ctxErr)
+				return nil, fmt.Errorf("read of %s cancelled: %w", filename, ctxErr)
+			}
+			mgr.log.Error("Failed to get chunk data", "error", err)
+			return nil, fmt.Errorf("failed to get chunk data: %w", err)
 		}
 
-		// Calculate the end position in the buffer
-		endPos := bufferPos + dataSize
+		for i, chunk := range window {
+			copyChunk(buf, offset, chunk, data[i])
+		}
+	}
 
-		if endPos <= uint64(len(buf)) {
-			copy(buf[bufferPos:endPos], data[chunkStartPos:chunkStartPos+dataSize])
+	// The chunks only tell where the data they overlap ends. A shorter read than asked for
+	// means the end of the file to the kernel, so if the file goes on past a range without
+	// chunks, the read is zero-filled up to the end of the file or of the read.
+	if uint64(len(buf)) < size {
+		var fileSize uint64
+		if hasVersion {
+			fileSize, err = mgr.metaStore.CalcSizeOfLayer(ctx, fileID, versionedLayerId, metadata.WithTx(tx))
+		} else {
+			fileSize, err = mgr.calcSizeOf(ctx, fileID, metadata.WithTx(tx))
+		}
+		if err != nil {
+			mgr.log.Error("Failed to calculate size of file", "filename", filename, "error", err)
+			return nil, fmt.Errorf("failed to calculate size of file: %w", err)
 		}
+		buf = zeroFillTo(buf, offset, min(offset+size, fileSize))
 	}
 
 	if uint64(len(buf)) > size {
@@ -287,11 +987,13 @@ func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	if hasHeadVersion {
-		mgr.log.Debug("Returning data range with head version",
+	mgr.metrics.ReadBytes(len(buf))
+
+	if hasVersion {
+		mgr.log.Debug("Returning data range with version",
 			"offset", offset,
 			"size", len(buf),
-			"version", headVersionTag)
+			"version", versionTag)
 	} else {
 		mgr.log.Debug("Returning data range (latest version)",
 			"offset", offset,
@@ -301,22 +1003,76 @@ func (mgr *Manager) ReadFile(ctx context.Context, filename string, offset uint64
 	return buf, nil
 }
 
-// InsertFile inserts a new file into the files table and returns its ID.
-func (mgr *Manager) InsertFile(ctx context.Context, name string) (uint64, error) {
+// InsertFile inserts a new file into the files table and returns its ID. It fails with
+// types.ErrInvalidFilename if the name isn't a valid file name (see checkFileName) and with
+// types.ErrFileExists if a file already has it.
+func (mgr *Manager) InsertFile(ctx context.Context, name string, opts ...InsertFileOpt) (uint64, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	insertOpts := insertFileOptions{}
+	for _, opt := range opts {
+		opt(&insertOpts)
+	}
+
 	mgr.log.Debug("Inserting new file into metadata store", "name", name)
 
-	fileID, err := mgr.metaStore.InsertFile(ctx, name)
-	if err != nil {
-		mgr.log.Error("Failed to insert new file", "name", name, "error", err)
+	if err := checkFileName(name); err != nil {
+		mgr.log.Error("Rejecting invalid file name", "name", name, "error", err)
 		return 0, err
 	}
 
-	mgr.log.Debug("File inserted successfully", "name", name, "fileID", fileID)
-	return fileID, nil
-}
-
-// calcSizeOf calculates the total byte size of the virtual file from all layers and their chunks, respecting layer creation order and handling overlapping file ranges.
-//
+	// The file and its attributes are committed together, so that it's never seen half set up
+	tx, err := mgr.db.BeginTx(ctx, nil)
+	if err != nil {
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.InsertFile(ctx, name, metadata.WithTx(tx))
+	if errors.Is(err, types.ErrFileExists) {
+		// Not necessarily a failure, callers may insert files to make sure they exist
+		mgr.log.Debug("File already exists", "name", name)
+		return 0, err
+	}
+	if err != nil {
+		mgr.log.Error("Failed to insert new file", "name", name, "error", err)
+		return 0, err
+	}
+
+	if insertOpts.hasMode {
+		if err := mgr.metaStore.SetFileMode(ctx, fileID, insertOpts.mode, metadata.WithTx(tx)); err != nil {
+			mgr.log.Error("Failed to set file mode", "name", name, "mode", insertOpts.mode, "error", err)
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	mgr.log.Debug("File inserted successfully", "name", name, "fileID", fileID)
+	return fileID, nil
+}
+
+// checkFileName checks that name can be looked up in the (flat) mount directory: it must not
+// be empty, "." nor "..", nor contain a path separator or a NUL byte.
+func checkFileName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty file name: %w", types.ErrInvalidFilename)
+	case name == "." || name == "..":
+		return fmt.Errorf("file name %q: %w", name, types.ErrInvalidFilename)
+	case strings.ContainsAny(name, "/\x00"):
+		return fmt.Errorf("file name %q contains a path separator or a NUL byte: %w", name, types.ErrInvalidFilename)
+	}
+	return nil
+}
+
+// calcSizeOf calculates the total byte size of the virtual file from all layers and their chunks, respecting layer creation order and handling overlapping file ranges.
+//
 // File offset →    0    5    10   15   20   25   30   35   40
 // Layer 3 (newest) ···╔═════╗···╔═══╗··························
 // Layer 2          ········╔══════════╗·······╔═══════════════╗
@@ -329,45 +1085,88 @@ func (mgr *Manager) InsertFile(ctx context.Context, name string) (uint64, error)
 // File size is determined by the highest end offset across all chunks
 func (mgr *Manager) calcSizeOf(ctx context.Context, fileID uint64, opts ...metadata.QueryOpt) (uint64, error) {
 	activeLayer, exists := mgr.memtable[fileID]
-	if exists && len(activeLayer.Chunks) > 0 {
-		endOffset := uint64(0)
-		for _, chunk := range activeLayer.Chunks {
-			if chunk.FileRange[1] > endOffset {
-				endOffset = chunk.FileRange[1]
-			}
-		}
-		return endOffset, nil
-	}
 
-	highestOffsetCommited, err := mgr.metaStore.CalcSizeOf(ctx, fileID, opts...)
+	// Checkpoints keep the size of the committed chunks up to date, and writes the end of the
+	// active layer, so that the chunks don't have to be gone through on every stat
+	highestOffsetCommited, err := mgr.metaStore.GetFileSize(ctx, fileID, opts...)
 	if err != nil {
 		return 0, err
 	}
 
 	var highestOffsetInActiveLayer uint64
 	if exists && activeLayer != nil {
-		for _, chunk := range activeLayer.Chunks {
-			if chunk.FileRange[1] > highestOffsetInActiveLayer {
-				highestOffsetInActiveLayer = chunk.FileRange[1]
-			}
-		}
+		highestOffsetInActiveLayer = activeLayer.FileEnd
 	}
 
 	return max(highestOffsetCommited, highestOffsetInActiveLayer), nil
 }
 
-// Checkpoint persists the active layer to storage and creates a new version
-func (mgr *Manager) Checkpoint(ctx context.Context, filename string, version string) error {
+// Origins of versions, i.e. what triggered the checkpoint that created them
+const (
+	OriginManual  = "manual"  // an explicit checkpoint, e.g. through the API or by renaming to name@tag
+	OriginWAL     = "wal"     // DuckDB removing its WAL file after merging it into the database
+	OriginFlush   = "flush"   // Flush persisting the uncommitted writes of an embedding application
+	OriginCompact = "compact" // Compact rewriting the whole file into a single layer
+)
+
+type checkpointOptions struct {
+	message     string
+	author      string
+	origin      string
+	compacted   bool   // whether the active layer holds the whole file, see Compact
+	rewrite     bool   // whether this is the checkpoint of a rewrite, see withRewriteOf
+	rewriteBase string // version the rewritten content is based on, see withRewriteOf
+}
+
+// CheckpointOpt configures a single Checkpoint call.
+type CheckpointOpt func(*checkpointOptions)
+
+// WithMessage records a commit-style message describing the new version.
+func WithMessage(message string) CheckpointOpt {
+	return func(o *checkpointOptions) {
+		o.message = message
+	}
+}
+
+// WithAuthor records who created the new version.
+func WithAuthor(author string) CheckpointOpt {
+	return func(o *checkpointOptions) {
+		o.author = author
+	}
+}
+
+// WithOrigin records what triggered the checkpoint, OriginManual by default.
+func WithOrigin(origin string) CheckpointOpt {
+	return func(o *checkpointOptions) {
+		o.origin = origin
+	}
+}
+
+// Checkpoint persists the active layer to storage and creates a new version. If version
+// is empty, the tag is generated: v1, v2, ... following the file's highest tag of that form.
+// It returns the tag of the new version, or "" if there was nothing to checkpoint.
+func (mgr *Manager) Checkpoint(ctx context.Context, filename string, version string, opts ...CheckpointOpt) (string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	start := time.Now()
+
+	checkpointOpts := checkpointOptions{origin: OriginManual}
+	for _, opt := range opts {
+		opt(&checkpointOpts)
+	}
+
 	mgr.mu.Lock()         // Lock before accessing activeLayers
 	defer mgr.mu.Unlock() // Ensure unlock when function returns
 
 	tx, err := mgr.db.BeginTx(ctx, nil)
 	if err != nil {
 		mgr.log.Error("Failed to begin transaction", "error", err)
-		return err
+		return "", err
 	}
 
-	// Setup deferred rollback in case of error or panic
+	// Setup deferred rollback unless committed, including on returns that don't set err
+	// (e.g. when there is nothing to checkpoint) so the connection isn't held forever
 	defer func() {
 		if p := recover(); p != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
@@ -375,10 +1174,8 @@ func (mgr *Manager) Checkpoint(ctx context.Context, filename string, version str
 			}
 			// Re-panic after rollback
 			panic(p)
-		} else if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				mgr.log.Error("Failed to rollback transaction", "error", rbErr)
-			}
+		} else if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			mgr.log.Error("Failed to rollback transaction", "error", rbErr)
 		}
 	}()
 
@@ -386,189 +1183,1081 @@ func (mgr *Manager) Checkpoint(ctx context.Context, filename string, version str
 	if err != nil {
 		if err == types.ErrNotFound {
 			mgr.log.Warn("File not found, nothing to checkpoint", "filename", filename)
-			return nil
+			return "", nil
 		}
 		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
-		return fmt.Errorf("failed to get file ID: %w", err)
+		return "", fmt.Errorf("failed to get file ID: %w", err)
 	}
 
 	// Check if file has a head pointer, if so it's in read-only mode
 	_, _, err = mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
 	if err == nil {
 		mgr.log.Error("Cannot checkpoint file with head pointing to version", "filename", filename)
-		return fmt.Errorf("cannot checkpoint file: %s is in read-only mode because a head is set, use DeleteHead first", filename)
+		return "", fmt.Errorf("cannot checkpoint file: %s is in read-only mode because a head is set, use DeleteHead first", filename)
 	} else if err != types.ErrNotFound {
 		mgr.log.Error("Failed to check head version", "filename", filename, "error", err)
-		return fmt.Errorf("failed to check head version: %w", err)
+		return "", fmt.Errorf("failed to check head version: %w", err)
+	}
+
+	// The rewrite checkpoints its own content, there are no other writes (see beginRewrite)
+	if mgr.rewriting[fileID] && !checkpointOpts.rewrite {
+		mgr.log.Debug("File is being rewritten, nothing to checkpoint", "filename", filename)
+		return "", nil
 	}
 
 	activeLayer, exists := mgr.memtable[fileID]
-	if !exists || len(activeLayer.Data) == 0 {
+	if !exists || len(activeLayer.Chunks) == 0 {
 		mgr.log.Warn("No active layer or data to checkpoint", "filename", filename)
-		return nil // No active layer means no changes to checkpoint
+		return "", nil // No active layer means no changes to checkpoint
+	}
+
+	// A layer breaking the chunk ordering would be read back differently than it was written
+	if err = checkActiveLayer(activeLayer); err != nil {
+		mgr.log.Error("Refusing to checkpoint inconsistent active layer", "filename", filename, "error", err)
+		return "", fmt.Errorf("cannot checkpoint file %s: %w", filename, err)
+	}
+
+	// Holding the epoch row for the rest of the transaction makes sure no other node
+	// can take ownership of the file while the checkpoint is being committed
+	err = mgr.checkEpoch(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Cannot checkpoint file", "filename", filename, "error", err)
+		return "", fmt.Errorf("cannot checkpoint file %s: %w", filename, err)
+	}
+
+	if checkpointOpts.rewriteBase != "" {
+		var latest string
+		latest, _, err = mgr.metaStore.GetLatestVersion(ctx, fileID, metadata.WithTx(tx))
+		if err != nil {
+			mgr.log.Error("Failed to get latest version", "filename", filename, "error", err)
+			return "", fmt.Errorf("failed to get latest version: %w", err)
+		}
+		if latest != checkpointOpts.rewriteBase {
+			mgr.log.Error("File changed while it was rewritten", "filename", filename, "base", checkpointOpts.rewriteBase, "latest", latest)
+			return "", fmt.Errorf("cannot checkpoint file %s: version %s was checkpointed since %s, which the rewrite is based on", filename, latest, checkpointOpts.rewriteBase)
+		}
+	}
+
+	if version == "" {
+		version, err = mgr.nextVersionTag(ctx, fileID, tx)
+		if err != nil {
+			mgr.log.Error("Failed to generate version tag", "filename", filename, "error", err)
+			return "", err
+		}
+	}
+
+	layerID, objectKey, err := mgr.persistLayer(ctx, tx, filename, fileID, version, checkpointOpts, activeLayer.Data, activeLayer.Chunks, activeLayer.Origins)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		mgr.deleteOrphanedObject(ctx, layerID, objectKey)
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	delete(mgr.memtable, fileID)
+	mgr.dropJournal(fileID)
+	if mgr.sealed != nil {
+		mgr.sealed.put(layerID, activeLayer.Data)
+	}
+
+	mgr.metrics.CheckpointDuration(time.Since(start))
+
+	mgr.log.Debug("Checkpoint successful", "version", version, "layerID", layerID, "objectKey", objectKey)
+
+	return version, nil
+}
+
+// Flush persists the uncommitted writes of a file as a new version with a generated tag, so
+// that they survive the manager without going through DuckDB's WAL. Unlike Checkpoint it
+// takes no tag and does nothing if there are no uncommitted writes, so it can be called any
+// number of times.
+func (mgr *Manager) Flush(ctx context.Context, filename string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	mgr.mu.RLock()
+	pending := mgr.hasPendingWrites(fileID)
+	mgr.mu.RUnlock()
+
+	if !pending {
+		return nil
+	}
+
+	version, err := mgr.Checkpoint(ctx, filename, "", WithOrigin(OriginFlush))
+	if err != nil {
+		return fmt.Errorf("failed to flush %s: %w", filename, err)
+	}
+
+	if version != "" {
+		mgr.log.Debug("Flushed uncommitted writes", "filename", filename, "version", version)
+	}
+	return nil
+}
+
+// CheckpointWAL checkpoints the database file of a DuckDB WAL file (db.duckdb for
+// db.duckdb.wal) with a generated tag, as if DuckDB had removed the WAL after a CHECKPOINT.
+// The WAL file is left alone.
+func (mgr *Manager) CheckpointWAL(ctx context.Context, walName string, opts ...CheckpointOpt) (string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	dbName, ok := strings.CutSuffix(walName, ".wal")
+	if !ok || dbName == "" {
+		return "", fmt.Errorf("invalid WAL file name: %s", walName)
 	}
 
-	versionID, err := mgr.metaStore.InsertVersion(ctx, tx, version)
+	return mgr.Checkpoint(ctx, dbName, "", opts...)
+}
+
+// nextVersionTag returns the tag given to a version checkpointed without one
+func (mgr *Manager) nextVersionTag(ctx context.Context, fileID uint64, tx *sql.Tx) (string, error) {
+	maxTag, err := mgr.metaStore.MaxNumericVersionTag(ctx, fileID, metadata.WithTx(tx))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v%d", maxTag+1), nil
+}
+
+// ObjectKeyFunc names the object holding the data of a new layer of a file, given the file's
+// current name and id and the id of the new version. The key is relative to the "layers/"
+// prefix, which garbage collection lists, and must be unique per file and version.
+type ObjectKeyFunc func(filename string, fileID uint64, versionID uint64) string
+
+// DefaultObjectKey is the default ObjectKeyFunc, naming objects <fileID>/<versionID>. It only
+// depends on ids, so keys are always safe for S3 and objects don't have to move when a file is
+// renamed.
+func DefaultObjectKey(filename string, fileID uint64, versionID uint64) string {
+	return fmt.Sprintf("%d/%d", fileID, versionID)
+}
+
+// FileNameObjectKey is an ObjectKeyFunc naming objects <filename>/<fileID>-<versionID>, with
+// the filename escaped for use in a URL path, which makes objects easier to find in the bucket.
+// The key keeps the name the file had when the layer was checkpointed.
+func FileNameObjectKey(filename string, fileID uint64, versionID uint64) string {
+	return fmt.Sprintf("%s/%d-%d", url.PathEscape(filename), fileID, versionID)
+}
+
+// WithObjectKeyFunc sets how the objects of new layers are named, DefaultObjectKey by default.
+// Layers record the key they were uploaded with, so changing it doesn't rename (nor lose track
+// of) the objects of existing layers.
+func WithObjectKeyFunc(fn ObjectKeyFunc) ManagerOpt {
+	return func(mgr *Manager) {
+		mgr.objectKey = fn
+	}
+}
+
+// layerObjectKey returns the key of the object holding the data of a new layer
+func (mgr *Manager) layerObjectKey(filename string, fileID uint64, versionID uint64) string {
+	return layersPrefix + mgr.objectKey(filename, fileID, versionID)
+}
+
+// persistLayer uploads the data of a layer to the object store and records it, along
+// with its chunks (and write origins, if any), as a new version of the file within tx.
+func (mgr *Manager) persistLayer(ctx context.Context, tx *sql.Tx, filename string, fileID uint64, version string, versionOpts checkpointOptions, data []byte, chunks []metadata.Chunk, origins []metadata.WriteOrigin) (uint64, string, error) {
+	// The file is getting a version, reads have to go to the metadata store from now on
+	for name, id := range mgr.uncommitted {
+		if id == fileID {
+			delete(mgr.uncommitted, name)
+		}
+	}
+
+	// Keep garbage collection from deleting the object before the layer referencing it is committed
+	err := mgr.metaStore.LockObjectsShared(ctx, tx)
+	if err != nil {
+		mgr.log.Error("Failed to lock objects", "error", err)
+		return 0, "", fmt.Errorf("failed to lock objects: %w", err)
+	}
+
+	// Tags have to be unique per file, otherwise reads of a version could return the wrong layer.
+	// Checked before uploading anything so a rejected checkpoint leaves no object behind.
+	exists, err := mgr.metaStore.VersionExists(ctx, fileID, version, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to check version tag", "tag", version, "error", err)
+		return 0, "", err
+	}
+	if exists {
+		mgr.log.Error("Version tag already used by file", "fileID", fileID, "tag", version)
+		return 0, "", fmt.Errorf("cannot create version %q: %w", version, types.ErrVersionExists)
+	}
+
+	versionID, err := mgr.metaStore.InsertVersion(ctx, tx, version, versionOpts.message, versionOpts.author, versionOpts.origin)
 	if err != nil {
 		mgr.log.Error("Failed to insert new version", "tag", version, "error", err)
-		return fmt.Errorf("failed to insert new version: %w", err)
+		return 0, "", fmt.Errorf("failed to insert new version: %w", err)
+	}
+
+	objectKey := mgr.layerObjectKey(filename, fileID, versionID)
+
+	if mgr.keyringErr != nil {
+		return 0, "", fmt.Errorf("failed to encode layer: %w", mgr.keyringErr)
+	}
+
+	chunks, err = mgr.dedupChunks(ctx, tx, fileID, data, chunks)
+	if err != nil {
+		mgr.log.Error("Failed to deduplicate chunks", "error", err)
+		return 0, "", err
+	}
+
+	// Only the chunks that aren't deduplicated are stored in the new object, holes have no data
+	var stored []metadata.Chunk
+	var storedIdx []int
+	for i, c := range chunks {
+		if c.SourceLayerID == 0 && !c.Hole {
+			stored = append(stored, c)
+			storedIdx = append(storedIdx, i)
+		}
 	}
 
-	objectKey := fmt.Sprintf("layers/%s/%d-%d", filename, fileID, versionID)
+	enc := layerEncoding{compression: mgr.compression, keyring: mgr.keyring, keyID: mgr.activeKeyID}
+	object, encoded, err := encodeLayer(enc, data, stored)
+	if err != nil {
+		mgr.log.Error("Failed to encode layer", "compression", mgr.compression, "error", err)
+		return 0, "", fmt.Errorf("failed to encode layer: %w", err)
+	}
+	for j, i := range storedIdx {
+		chunks[i] = encoded[j]
+	}
 
-	err = mgr.objectStore.PutObject(ctx, objectKey, activeLayer.Data)
+	err = mgr.objectStore.PutObject(ctx, objectKey, object)
 	if err != nil {
 		mgr.log.Error("Failed to upload data to object store", "error", err)
-		return fmt.Errorf("failed to upload data to object store: %w", err)
+		return 0, "", fmt.Errorf("failed to upload data to object store: %w", err)
 	}
 
-	layerID, err := mgr.metaStore.InsertLayer(ctx, tx, fileID, versionID, objectKey)
+	// The object store has no transactions: if recording the layer fails, nothing will reference the object
+	defer func() {
+		if err != nil {
+			mgr.deleteOrphanedObject(ctx, 0, objectKey)
+		}
+	}()
+
+	layerID, err := mgr.metaStore.InsertLayer(ctx, tx, fileID, versionID, objectKey, string(mgr.compression), mgr.keyring != nil)
 	if err != nil {
 		mgr.log.Error("Failed to commit layer with version", "error", err)
-		return fmt.Errorf("failed to commit layer with version: %w", err)
+		return 0, "", fmt.Errorf("failed to commit layer with version: %w", err)
 	}
 
-	for _, c := range activeLayer.Chunks {
-		err = mgr.metaStore.InsertChunk(ctx, layerID, c, metadata.WithTx(tx))
+	err = mgr.metaStore.InsertChunks(ctx, layerID, chunks, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to commit layer's chunks", "error", err)
+		return 0, "", fmt.Errorf("failed to commit layer's chunks: %w", err)
+	}
+
+	if versionOpts.compacted {
+		err = mgr.metaStore.SetLayerCompacted(ctx, layerID, metadata.WithTx(tx))
 		if err != nil {
-			mgr.log.Error("Failed to commit layer's chunks", "error", err)
-			return fmt.Errorf("failed to commit layer's chunks: %w", err)
+			mgr.log.Error("Failed to mark layer as compacted", "error", err)
+			return 0, "", err
 		}
 	}
 
-	err = tx.Commit()
+	var fileEnd uint64
+	for _, c := range chunks {
+		fileEnd = max(fileEnd, c.FileRange[1])
+	}
+	err = mgr.metaStore.GrowFileSize(ctx, fileID, fileEnd, metadata.WithTx(tx))
 	if err != nil {
-		mgr.log.Error("Failed to commit transaction", "error", err)
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		mgr.log.Error("Failed to update file size", "error", err)
+		return 0, "", err
 	}
 
-	delete(mgr.memtable, fileID)
+	err = mgr.metaStore.InsertWriteOrigins(ctx, layerID, origins, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to commit layer's write origins", "error", err)
+		return 0, "", fmt.Errorf("failed to commit layer's write origins: %w", err)
+	}
 
-	mgr.log.Debug("Checkpoint successful", "layerID", layerID, "objectKey", objectKey)
+	// The new content is what's persisted across remounts, so is its modification time
+	err = mgr.metaStore.SetFileModifiedAt(ctx, fileID, time.Now(), metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to update file modification time", "error", err)
+		return 0, "", err
+	}
 
-	return nil
+	return layerID, objectKey, nil
 }
 
-// GetAllFiles returns a list of all files in the database
-func (mgr *Manager) GetAllFiles(ctx context.Context) ([]sqlc.File, error) {
-	return mgr.metaStore.GetAllFiles(ctx)
+// deleteOrphanedObject deletes the object uploaded for a layer whose transaction failed. If
+// layerID is not 0, the object is kept when the layer turns out to be committed after all
+// (e.g. the connection broke after the commit went through). Objects that can't be deleted
+// are left for GC.
+func (mgr *Manager) deleteOrphanedObject(ctx context.Context, layerID uint64, objectKey string) {
+	// The failure may be the caller giving up, which must not keep the cleanup from running
+	ctx = context.WithoutCancel(ctx)
+
+	if layerID != 0 {
+		layer, err := mgr.metaStore.GetLayerObject(ctx, layerID)
+		if err != nil {
+			mgr.log.Error("Failed to check whether layer was committed, leaving its object to GC", "layerID", layerID, "objectKey", objectKey, "error", err)
+			return
+		}
+		if layer != nil {
+			mgr.log.Warn("Layer was committed despite the error, keeping its object", "layerID", layerID, "objectKey", objectKey)
+			return
+		}
+	}
+
+	if err := mgr.objectStore.DeleteObject(ctx, objectKey); err != nil {
+		mgr.log.Error("Failed to delete orphaned object, leaving it to GC", "objectKey", objectKey, "error", err)
+		return
+	}
+	mgr.log.Debug("Deleted orphaned object", "objectKey", objectKey)
 }
 
-// LoadLayersByFileID delegates to the metadata store
-func (mgr *Manager) LoadLayersByFileID(ctx context.Context, fileID uint64, opts ...metadata.QueryOpt) ([]*metadata.Layer, error) {
-	return mgr.metaStore.LoadLayersByFileID(ctx, fileID, opts...)
+// dedupChunks returns the chunks with the hash of their data set and, with WithChunkDedup,
+// pointing to the data of an identical chunk already stored for the file if there's one.
+// Holes are left alone, they have no data.
+func (mgr *Manager) dedupChunks(ctx context.Context, tx *sql.Tx, fileID uint64, data []byte, chunks []metadata.Chunk) ([]metadata.Chunk, error) {
+	hashed := make([]metadata.Chunk, len(chunks))
+	hashes := make([][]byte, 0, len(chunks))
+	for i, c := range chunks {
+		hashed[i] = c
+		if c.Hole {
+			continue
+		}
+		hashed[i].Hash = chunkHash(data[c.LayerRange[0]:c.LayerRange[1]])
+		hashes = append(hashes, hashed[i].Hash)
+	}
+
+	if !mgr.dedup {
+		return hashed, nil
+	}
+
+	found, err := mgr.metaStore.FindChunksByHash(ctx, fileID, hashes, mgr.keyring != nil, metadata.WithTx(tx))
+	if err != nil {
+		return nil, err
+	}
+
+	var dedupBytes uint64
+	for i, c := range hashed {
+		if c.Hole {
+			continue
+		}
+		source, ok := found[string(c.Hash)]
+		if !ok {
+			continue
+		}
+
+		hashed[i].SourceLayerID = source.SourceLayerID
+		hashed[i].ObjectRange = source.ObjectRange
+		hashed[i].Nonce = source.Nonce
+		hashed[i].KeyID = source.KeyID
+		hashed[i].Checksum = chunkChecksum(data[c.LayerRange[0]:c.LayerRange[1]])
+		hashed[i].HasChecksum = true
+		dedupBytes += c.LayerRange[1] - c.LayerRange[0]
+	}
+
+	mgr.log.Debug("Deduplicated chunks", "fileID", fileID, "chunks", len(chunks), "bytes", len(data), "dedupBytes", dedupBytes)
+	return hashed, nil
 }
 
-// getChunkData retrieves chunk data from the object store using range requests
-func (mgr *Manager) getChunkData(ctx context.Context, c metadata.Chunk) ([]byte, error) {
-	objectKey, err := mgr.metaStore.GetObjectKey(ctx, c.LayerID)
+// GetWriteOrigins returns the recorded origins of the writes that produced version tag of
+// the file, in write order. It is empty unless write tracing was enabled (see WithWriteTracing).
+func (mgr *Manager) GetWriteOrigins(ctx context.Context, filename string, tag string) ([]metadata.WriteOrigin, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving object key: %w", err)
+		mgr.log.Error("Failed to begin transaction", "error", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to get file ID: %w", err)
 	}
 
-	if objectKey == "" {
-		return []byte{}, nil
+	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, tag, tx)
+	if err != nil {
+		mgr.log.Error("Failed to get layer for version", "filename", filename, "version", tag, "error", err)
+		return nil, fmt.Errorf("failed to get layer for version: %w", err)
 	}
 
-	layerSize := c.LayerRange[1] - c.LayerRange[0]
-	dataRange := [2]uint64{c.LayerRange[0], c.LayerRange[1] - 1} // layer range is exclusive of the end, but object range is inclusive
-	data, err := mgr.objectStore.GetObject(ctx, objectKey, dataRange)
+	origins, err := mgr.metaStore.GetWriteOrigins(ctx, layer.ID, metadata.WithTx(tx))
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving data from object store: %w", err)
+		mgr.log.Error("Failed to get write origins", "filename", filename, "version", tag, "error", err)
+		return nil, err
 	}
 
-	if uint64(len(data)) != layerSize {
-		return nil, fmt.Errorf("received incorrect number of bytes from object store: got %d, expected %d", len(data), layerSize)
+	if err = tx.Commit(); err != nil {
+		mgr.log.Error("Failed to commit transaction", "error", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return data, nil
+	return origins, nil
 }
 
-// SetHead sets the head pointer for a file to a specific version
-func (mgr *Manager) SetHead(ctx context.Context, filename string, version string) error {
+// RotateKey makes future checkpoints encrypt new layers with the key newKeyID from the keyring.
+// Data encrypted with previous keys isn't rewritten, so those keys must stay in the keyring.
+func (mgr *Manager) RotateKey(ctx context.Context, newKeyID string) error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	tx, err := mgr.db.BeginTx(ctx, nil)
+	if mgr.keyring == nil {
+		return fmt.Errorf("cannot rotate key: encryption is not enabled")
+	}
+
+	if _, err := mgr.keyring.get(newKeyID); err != nil {
+		return fmt.Errorf("cannot rotate key: %w", err)
+	}
+
+	mgr.log.Info("Rotating encryption key", "from", mgr.activeKeyID, "to", newKeyID)
+	mgr.activeKeyID = newKeyID
+	mgr.keyringErr = nil // the keyring itself is valid, only the active key could have been missing
+
+	return nil
+}
+
+// checkEpoch makes sure this node still owns the file. The first time the node
+// modifies a file it takes ownership by acquiring a new epoch. From then on,
+// ErrFenced is returned if another node has acquired the file in the meantime.
+func (mgr *Manager) checkEpoch(ctx context.Context, fileID uint64, opts ...metadata.QueryOpt) error {
+	held, ok := mgr.epochs[fileID]
+	if !ok {
+		epoch, err := mgr.metaStore.AcquireFileEpoch(ctx, fileID, opts...)
+		if err != nil {
+			return err
+		}
+
+		mgr.epochs[fileID] = epoch
+		mgr.log.Debug("Acquired file epoch", "fileID", fileID, "epoch", epoch)
+		return nil
+	}
+
+	current, err := mgr.metaStore.GetFileEpoch(ctx, fileID, opts...)
 	if err != nil {
-		mgr.log.Error("Failed to begin transaction", "error", err)
 		return err
 	}
 
-	// Setup deferred rollback in case of error or panic
-	defer func() {
-		if p := recover(); p != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				mgr.log.Error("Failed to rollback transaction after panic", "error", rbErr)
+	if current != held {
+		return fmt.Errorf("%w: holding epoch %d but the current epoch is %d", types.ErrFenced, held, current)
+	}
+
+	return nil
+}
+
+// fetchChunks returns the data of chunks, in the same order. Chunks of the active layer are
+// read from memory, flushed chunks are fetched from the object store concurrently (within the
+// limit set by WithFetchConcurrency), a run of chunks stored next to each other in the same
+// object with a single request (see chunkRuns). No new fetch is started once ctx is done.
+func (mgr *Manager) fetchChunks(ctx context.Context, chunks []metadata.Chunk, activeLayer *metadata.Layer, stats *ReadStats) ([][]byte, error) {
+	data := make([][]byte, len(chunks))
+	errs := make([]error, len(chunks))
+	chunkStats := make([]ReadStats, len(chunks))
+
+	var wg sync.WaitGroup
+
+	for i, chunk := range chunks {
+		// The layer for this chunk hasn't been flushed to storage yet. It's in the active layer.
+		if !chunk.Flushed {
+			if chunk.LayerRange[1] > uint64(len(activeLayer.Data)) {
+				errs[i] = fmt.Errorf("%w: active layer of file %d, bytes %d-%d (exclusive): holds %d bytes",
+					types.ErrShortRead, activeLayer.FileID, chunk.LayerRange[0], chunk.LayerRange[1], len(activeLayer.Data))
+				break
 			}
-			panic(p)
-		} else if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				mgr.log.Error("Failed to rollback transaction", "error", rbErr)
+			data[i] = activeLayer.Data[chunk.LayerRange[0]:chunk.LayerRange[1]]
+		}
+	}
+
+	// Chunks stored next to each other in the same object are fetched with a single request
+	for _, idx := range chunkRuns(chunks) {
+		if err := ctx.Err(); err != nil {
+			errs[idx[0]] = err
+			break
+		}
+
+		run := make([]metadata.Chunk, len(idx))
+		for j, i := range idx {
+			run[j] = chunks[i]
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runData, err := mgr.getChunkRunData(ctx, run, &chunkStats[idx[0]])
+			if err != nil {
+				errs[idx[0]] = err
+				return
+			}
+			for j, i := range idx {
+				data[i] = runData[j]
 			}
+		}()
+	}
+
+	wg.Wait()
+
+	if stats != nil {
+		for _, cs := range chunkStats {
+			stats.BytesFetched += cs.BytesFetched
+			stats.CacheHits += cs.CacheHits
 		}
-	}()
+	}
 
-	// Get the file ID
-	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
-	if err != nil {
-		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
-		return fmt.Errorf("failed to get file ID: %w", err)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// readActiveLayer reads the range starting at offset of a file all of whose data is in its
+// active layer, without querying the metadata store.
+func (mgr *Manager) readActiveLayer(activeLayer *metadata.Layer, offset uint64, size uint64, stats *ReadStats) []byte {
+	readRange := [2]uint64{offset, offset + size}
+
+	maxEndOffset := offset
+	var chunks []metadata.Chunk
+	for _, chunk := range activeLayer.Chunks {
+		if metadata.RangesOverlap(chunk.FileRange, readRange) {
+			chunks = append(chunks, chunk)
+			maxEndOffset = max(maxEndOffset, chunk.FileRange[1])
+		}
+	}
+
+	if stats != nil {
+		stats.ChunksScanned = len(chunks)
+		stats.LayersTouched = min(len(chunks), 1)
+	}
+
+	// Later chunks override earlier ones, as in ReadFile
+	buf := make([]byte, maxEndOffset-offset)
+	for _, chunk := range chunks {
+		copyChunk(buf, offset, chunk, activeLayer.Data[chunk.LayerRange[0]:chunk.LayerRange[1]])
+	}
+
+	// As in ReadFile, only the end of the file may cut a read short
+	var fileSize uint64
+	for _, chunk := range activeLayer.Chunks {
+		fileSize = max(fileSize, chunk.FileRange[1])
+	}
+	buf = zeroFillTo(buf, offset, min(offset+size, fileSize))
+
+	if uint64(len(buf)) > size {
+		buf = buf[:size]
+	}
+
+	mgr.metrics.ReadBytes(len(buf))
+	mgr.log.Debug("Returning data range from the active layer", "offset", offset, "size", len(buf))
+
+	return buf
+}
+
+// zeroFillTo extends buf, holding the data of the file from offset on, with zeroes up to end
+func zeroFillTo(buf []byte, offset uint64, end uint64) []byte {
+	if end <= offset+uint64(len(buf)) {
+		return buf
+	}
+	return append(buf, make([]byte, end-offset-uint64(len(buf)))...)
+}
+
+// checkActiveLayer checks the invariant reads rely on for overlapping writes to resolve to the
+// latest one: the chunks of the active layer are in write order, so their layer ranges follow
+// each other from the start of the layer data up to its end, each as long as its file range,
+// except for holes whose layer range is empty.
+func checkActiveLayer(layer *metadata.Layer) error {
+	var end uint64
+	for i, chunk := range layer.Chunks {
+		if chunk.LayerRange[0] != end {
+			return fmt.Errorf("chunk %d of the active layer starts at %d instead of %d, chunks are out of write order", i, chunk.LayerRange[0], end)
+		}
+		if chunk.Hole {
+			if chunk.LayerRange[1] != end {
+				return fmt.Errorf("hole %d of the active layer has layer range %v, it should be empty", i, chunk.LayerRange)
+			}
+			continue
+		}
+		if chunk.LayerRange[1]-chunk.LayerRange[0] != chunk.FileRange[1]-chunk.FileRange[0] {
+			return fmt.Errorf("chunk %d of the active layer has layer range %v but file range %v", i, chunk.LayerRange, chunk.FileRange)
+		}
+		end = chunk.LayerRange[1]
+	}
+
+	if end != uint64(len(layer.Data)) {
+		return fmt.Errorf("chunks of the active layer cover %d bytes but it holds %d", end, len(layer.Data))
+	}
+	return nil
+}
+
+// copyChunk copies the part of the chunk data that falls in the range starting at offset
+// into buf, which holds that range. A hole zeroes its part of the range instead.
+func copyChunk(buf []byte, offset uint64, chunk metadata.Chunk, data []byte) {
+	if chunk.Hole {
+		start := max(chunk.FileRange[0], offset)
+		end := min(chunk.FileRange[1], offset+uint64(len(buf)))
+		if start < end {
+			clear(buf[start-offset : end-offset])
+		}
+		return
+	}
+
+	var bufferPos uint64
+
+	if chunk.FileRange[0] < offset {
+		// Chunk starts before the requested offset
+		// We only want to copy the portion starting from the requested offset
+		skip := offset - chunk.FileRange[0]
+		if skip >= uint64(len(data)) {
+			return
+		}
+		data = data[skip:]
+	} else {
+		bufferPos = chunk.FileRange[0] - offset
+	}
+
+	if bufferPos >= uint64(len(buf)) {
+		return
 	}
 
-	// Make sure the version exists by getting its layer
-	layer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, version, tx)
+	// Copy as much of the chunk as fits rather than nothing if it goes past the end of buf
+	copy(buf[bufferPos:], data)
+}
+
+// GetAllFiles returns a list of all files in the database
+func (mgr *Manager) GetAllFiles(ctx context.Context) ([]sqlc.File, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.metaStore.GetAllFiles(ctx)
+}
+
+// LoadLayersByFileID delegates to the metadata store
+func (mgr *Manager) LoadLayersByFileID(ctx context.Context, fileID uint64, opts ...metadata.QueryOpt) ([]*metadata.Layer, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.metaStore.LoadLayersByFileID(ctx, fileID, opts...)
+}
+
+// getChunkData retrieves chunk data from the read cache, or from the object store using range requests.
+// If stats is not nil, the cache hit or the bytes fetched are added to it.
+func (mgr *Manager) getChunkData(ctx context.Context, c metadata.Chunk, stats *ReadStats) ([]byte, error) {
+	data, err := mgr.getChunkRunData(ctx, []metadata.Chunk{c}, stats)
 	if err != nil {
-		mgr.log.Error("Failed to get layer for version", "version", version, "error", err)
-		return fmt.Errorf("failed to get layer for version: %w", err)
+		return nil, err
+	}
+	return data[0], nil
+}
+
+// windowEnd returns the end of the window of chunks starting at start that takes at most n
+// fetches, a run of chunks following each other in the same object taking a single one
+func windowEnd(chunks []metadata.Chunk, start int, n int) int {
+	fetches := 0
+	for i := start; i < len(chunks); i++ {
+		c := chunks[i]
+		if !c.Flushed || c.Hole {
+			continue
+		}
+		if i > start && followsInObject(chunks[i-1], c) {
+			continue
+		}
+		if fetches == n {
+			return i
+		}
+		fetches++
+	}
+	return len(chunks)
+}
+
+// followsInObject tells whether chunk c is stored right after chunk prev, in the same object
+func followsInObject(prev metadata.Chunk, c metadata.Chunk) bool {
+	return prev.Flushed && c.Flushed && !prev.Hole && !c.Hole && prev.DataLayerID() == c.DataLayerID() && prev.ObjectRange[1] == c.ObjectRange[0]
+}
+
+// maxCoalescedFetch caps the bytes fetched by a single request for a run of chunks, so that
+// large reads are still spread over concurrent requests
+const maxCoalescedFetch = 8 << 20 // 8 MiB
+
+// chunkRuns groups the indexes of the flushed chunks that are stored one after the other in the
+// object of the same layer, in object order, so that each run can be fetched with a single
+// range request. Runs are cut at maxCoalescedFetch bytes. Holes have nothing to fetch.
+func chunkRuns(chunks []metadata.Chunk) [][]int {
+	byLayer := map[uint64][]int{}
+	var layerIDs []uint64
+	for i, c := range chunks {
+		if !c.Flushed || c.Hole {
+			continue
+		}
+		id := c.DataLayerID()
+		if _, ok := byLayer[id]; !ok {
+			layerIDs = append(layerIDs, id)
+		}
+		byLayer[id] = append(byLayer[id], i)
+	}
+
+	var runs [][]int
+	for _, id := range layerIDs {
+		idx := byLayer[id]
+		slices.SortStableFunc(idx, func(a, b int) int {
+			return cmp.Compare(chunks[a].ObjectRange[0], chunks[b].ObjectRange[0])
+		})
+
+		run := []int{idx[0]}
+		for _, i := range idx[1:] {
+			first, last := chunks[run[0]], chunks[run[len(run)-1]]
+			if chunks[i].ObjectRange[0] != last.ObjectRange[1] || chunks[i].ObjectRange[1]-first.ObjectRange[0] > maxCoalescedFetch {
+				runs = append(runs, run)
+				run = nil
+			}
+			run = append(run, i)
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// getChunkRunData retrieves the data of chunks of the same layer stored one after the other
+// (see chunkRuns), in the same order. The chunks that aren't cached are fetched with as few
+// range requests as possible, one unless cached chunks split the run.
+func (mgr *Manager) getChunkRunData(ctx context.Context, run []metadata.Chunk, stats *ReadStats) ([][]byte, error) {
+	data := make([][]byte, len(run))
+
+	missing := make([]int, 0, len(run))
+	for i, c := range run {
+		if mgr.sealed != nil {
+			if d, ok := mgr.sealed.get(c); ok {
+				if stats != nil {
+					stats.CacheHits++
+				}
+				data[i] = d
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return data, nil
 	}
 
-	// Set the head
-	err = mgr.metaStore.SetHead(ctx, fileID, layer.VersionID, metadata.WithTx(tx))
+	// Deduplicated chunks are stored in the object of an earlier layer
+	dataLayerID := run[0].DataLayerID()
+	layer, err := mgr.metaStore.GetLayerObject(ctx, dataLayerID)
 	if err != nil {
-		mgr.log.Error("Failed to set head", "filename", filename, "version", version, "error", err)
-		return fmt.Errorf("failed to set head: %w", err)
+		return nil, fmt.Errorf("error retrieving object key: %w", err)
 	}
 
-	err = tx.Commit()
+	if layer == nil {
+		for _, i := range missing {
+			data[i] = []byte{}
+		}
+		return data, nil
+	}
+
+	objectKey := layer.ObjectKey
+	if mgr.cache != nil {
+		uncached := missing[:0]
+		for _, i := range missing {
+			d, ok := mgr.cache.get(chunkKey{objectKey: objectKey, objectRange: run[i].ObjectRange})
+			mgr.metrics.CacheLookup(ok)
+			if ok {
+				if stats != nil {
+					stats.CacheHits++
+				}
+				data[i] = d
+				continue
+			}
+			uncached = append(uncached, i)
+		}
+		missing = uncached
+	}
+	if len(missing) == 0 {
+		return data, nil
+	}
+
+	if layer.Archived {
+		return nil, fmt.Errorf("%w: layer %d is in cold storage, use RestoreVersion first", types.ErrObjectArchived, dataLayerID)
+	}
+
+	enc := layerEncoding{compression: Compression(layer.Compression)}
+	if layer.Encrypted {
+		if mgr.keyring == nil {
+			return nil, fmt.Errorf("cannot decrypt chunk of layer %d: no valid encryption key configured", dataLayerID)
+		}
+		enc.keyring = mgr.keyring
+	}
+
+	// Cached chunks split the run, the chunks on each side of them are fetched separately
+	for len(missing) > 0 {
+		n := 1
+		for n < len(missing) && missing[n] == missing[n-1]+1 {
+			n++
+		}
+		if err := mgr.fetchChunkRun(ctx, objectKey, enc, run, missing[:n], data, stats); err != nil {
+			return nil, err
+		}
+		missing = missing[n:]
+	}
+
+	return data, nil
+}
+
+// fetchChunkRun fetches the chunks run[idx] of the object objectKey with a single range
+// request, from the start of the first one to the end of the last one, and sets their decoded
+// data in data.
+func (mgr *Manager) fetchChunkRun(ctx context.Context, objectKey string, enc layerEncoding, run []metadata.Chunk, idx []int, data [][]byte, stats *ReadStats) error {
+	start, end := run[idx[0]].ObjectRange[0], run[idx[len(idx)-1]].ObjectRange[1]
+	dataRange := [2]uint64{start, end - 1} // object range is exclusive of the end, but GetObject's range is inclusive
+
+	select {
+	case mgr.fetchSem <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("error retrieving data from object store: %w", ctx.Err())
+	}
+	defer func() { <-mgr.fetchSem }()
+
+	fetched, err := mgr.getObject(ctx, objectKey, dataRange, end-start)
 	if err != nil {
-		mgr.log.Error("Failed to commit transaction", "error", err)
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to read chunk of sealed layer %d: %w", run[idx[0]].DataLayerID(), err)
 	}
 
-	mgr.log.Info("Head set successfully", "filename", filename, "version", version)
+	if stats != nil {
+		stats.BytesFetched += uint64(len(fetched))
+	}
+
+	for _, i := range idx {
+		c := run[i]
+		d, err := decodeChunk(enc, c, fetched[c.ObjectRange[0]-start:c.ObjectRange[1]-start])
+		if err != nil {
+			return err
+		}
+
+		if layerSize := c.LayerRange[1] - c.LayerRange[0]; uint64(len(d)) != layerSize {
+			return fmt.Errorf("decoded chunk has incorrect size: got %d, expected %d", len(d), layerSize)
+		}
+
+		if c.HasChecksum {
+			if checksum := chunkChecksum(d); checksum != c.Checksum {
+				mgr.log.Error("Corrupted chunk data", "layerID", c.LayerID, "layerRange", c.LayerRange, "objectKey", objectKey)
+				return fmt.Errorf("%w: chunk of layer %d at layer range %v (object %s, bytes %v): got %08x, expected %08x",
+					types.ErrChecksumMismatch, c.LayerID, c.LayerRange, objectKey, [2]uint64{c.ObjectRange[0], c.ObjectRange[1] - 1}, checksum, c.Checksum)
+			}
+		}
+
+		if mgr.cache != nil {
+			mgr.cache.put(chunkKey{objectKey: objectKey, objectRange: c.ObjectRange}, d)
+		}
+
+		data[i] = d
+	}
 
 	return nil
 }
 
-// GetHead gets the current version the file head is pointing to
-func (mgr *Manager) GetHead(ctx context.Context, filename string) (string, error) {
+// CacheStats returns the hit and miss counts and the current size of the read cache.
+// It returns zero stats when the read cache is disabled.
+func (mgr *Manager) CacheStats() CacheStats {
+	if mgr.cache == nil {
+		return CacheStats{}
+	}
+	return mgr.cache.stats()
+}
+
+// Warmup fetches the chunks overlapping the given file ranges into the read cache, so that
+// later reads of those ranges don't have to go to the object store. Ranges are [start, end).
+// Chunks are fetched concurrently, within the limit set by WithFetchConcurrency.
+func (mgr *Manager) Warmup(ctx context.Context, filename string, ranges [][2]uint64) error {
+	if mgr.cache == nil {
+		return fmt.Errorf("cannot warm up %s: read cache is disabled", filename)
+	}
+
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
 
-	// Get the file ID
-	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	chunks, err := mgr.getFlushedChunks(ctx, filename, ranges)
 	if err != nil {
-		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
-		return "", fmt.Errorf("failed to get file ID: %w", err)
+		mgr.log.Error("Failed to get chunks to warm up", "filename", filename, "error", err)
+		return fmt.Errorf("failed to get chunks to warm up: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(chunks))
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = mgr.getChunkData(ctx, chunk, nil)
+		}()
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		mgr.log.Error("Failed to warm up read cache", "filename", filename, "error", err)
+		return fmt.Errorf("failed to warm up read cache: %w", err)
+	}
+
+	mgr.log.Debug("Read cache warmed up", "filename", filename, "ranges", len(ranges), "chunks", len(chunks))
+
+	return nil
+}
+
+// getFlushedChunks returns the persisted chunks that overlap with any of the given ranges,
+// as seen by reads (i.e. respecting the head version if one is set).
+func (mgr *Manager) getFlushedChunks(ctx context.Context, filename string, ranges [][2]uint64) ([]metadata.Chunk, error) {
+	tx, err := mgr.db.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Get the head version
-	_, versionTag, err := mgr.metaStore.GetHeadVersion(ctx, fileID)
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename, metadata.WithTx(tx))
 	if err != nil {
-		if err == types.ErrNotFound {
-			mgr.log.Info("No head set for file", "filename", filename)
-			return "", nil
+		return nil, fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	var versionedLayerID uint64
+	_, headVersionTag, err := mgr.metaStore.GetHeadVersion(ctx, fileID, metadata.WithTx(tx))
+	if err == nil {
+		versionedLayer, err := mgr.metaStore.GetLayerByVersion(ctx, fileID, headVersionTag, tx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer for head version: %w", err)
+		}
+		versionedLayerID = versionedLayer.ID
+	} else if err != types.ErrNotFound {
+		return nil, fmt.Errorf("failed to get head version: %w", err)
+	}
+
+	var chunks []metadata.Chunk
+	seen := make(map[[3]uint64]bool) // layer ID and layer range of the chunks
+
+	for _, r := range ranges {
+		overlapping, err := mgr.metaStore.GetAllOverlappingChunks(ctx, tx, fileID, r, nil, metadata.WithVersionedLayerID(versionedLayerID))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, chunk := range overlapping {
+			// Holes have no data to fetch
+			if chunk.Hole {
+				continue
+			}
+			key := [3]uint64{chunk.LayerID, chunk.LayerRange[0], chunk.LayerRange[1]}
+			if !seen[key] {
+				seen[key] = true
+				chunks = append(chunks, chunk)
+			}
 		}
-		mgr.log.Error("Failed to get head version", "filename", filename, "error", err)
-		return "", fmt.Errorf("failed to get head version: %w", err)
 	}
 
-	return versionTag, nil
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return chunks, nil
 }
 
-// DeleteHead removes the head pointer for a file
+// getObject fetches a range of an object, failing over from the primary object store
+// to the replicas (in order) when a store errors out or returns the wrong number of bytes.
+// Stores whose circuit breaker is open are skipped.
+func (mgr *Manager) getObject(ctx context.Context, objectKey string, dataRange [2]uint64, size uint64) ([]byte, error) {
+	if len(mgr.readStores) == 1 {
+		data, err := mgr.getObjectFrom(ctx, mgr.objectStore, objectKey, dataRange, size)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving data from object store: %w", err)
+		}
+
+		return data, nil
+	}
+
+	var errs []error
+
+	for _, rs := range mgr.readStores {
+		if !rs.breaker.allow() {
+			mgr.log.Debug("Skipping object store with open circuit breaker", "store", rs.name, "objectKey", objectKey)
+			errs = append(errs, fmt.Errorf("%s: circuit breaker is open", rs.name))
+			continue
+		}
+
+		data, err := mgr.getObjectFrom(ctx, rs.store, objectKey, dataRange, size)
+		if err != nil {
+			// The caller gave up, there is no point in trying the other stores
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("error retrieving data from object store: %w", ctx.Err())
+			}
+
+			// A missing object doesn't mean the store is unhealthy (e.g. replication lag)
+			if !errors.Is(err, objectstore.ErrNotFound) {
+				rs.breaker.failure()
+			}
+
+			mgr.log.Warn("Failed to get object, trying next store", "store", rs.name, "objectKey", objectKey, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", rs.name, err))
+			continue
+		}
+
+		rs.breaker.success()
+
+		mgr.log.Debug("Chunk data served by object store", "store", rs.name, "objectKey", objectKey, "range", dataRange)
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("error retrieving data from object stores: %w", errors.Join(errs...))
+}
+
+// getObjectFrom gets the inclusive dataRange of an object from store, expecting size bytes.
+// Range requests returning fewer bytes are often transient, so a short read is retried once
+// before failing with types.ErrShortRead.
+func (mgr *Manager) getObjectFrom(ctx context.Context, store objectStore, objectKey string, dataRange [2]uint64, size uint64) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, err := store.GetObject(ctx, objectKey, dataRange)
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(len(data)) == size {
+			return data, nil
+		}
+
+		if attempt == 2 || ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: object %s, bytes %d-%d (inclusive): got %d bytes, expected %d",
+				types.ErrShortRead, objectKey, dataRange[0], dataRange[1], len(data), size)
+		}
+
+		mgr.log.Warn("Short read from object store, retrying", "objectKey", objectKey, "range", dataRange, "got", len(data), "expected", size)
+	}
+}
+
+// SetHead sets the head pointer for a file to a specific version, i.e. the head of the main branch
+func (mgr *Manager) SetHead(ctx context.Context, filename string, version string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.setBranchHead(ctx, filename, metadata.DefaultBranch, version, false)
+}
+
+// GetHead gets the version the head of the file's main branch is pointing to, "" if none is set
+func (mgr *Manager) GetHead(ctx context.Context, filename string) (string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	return mgr.GetBranchHead(ctx, filename, metadata.DefaultBranch)
+}
+
+// DeleteHead removes the head pointer of the file's main branch
 func (mgr *Manager) DeleteHead(ctx context.Context, filename string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
@@ -580,7 +2269,7 @@ func (mgr *Manager) DeleteHead(ctx context.Context, filename string) error {
 	}
 
 	// Delete the head
-	err = mgr.metaStore.DeleteHead(ctx, fileID)
+	err = mgr.metaStore.DeleteHead(ctx, fileID, metadata.DefaultBranch)
 	if err != nil {
 		mgr.log.Error("Failed to delete head", "filename", filename, "error", err)
 		return fmt.Errorf("failed to delete head: %w", err)
@@ -593,6 +2282,9 @@ func (mgr *Manager) DeleteHead(ctx context.Context, filename string) error {
 
 // GetAllHeads returns all head pointers with file names and version tags
 func (mgr *Manager) GetAllHeads(ctx context.Context) ([]sqlc.GetAllHeadsRow, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
 
@@ -605,8 +2297,35 @@ func (mgr *Manager) GetAllHeads(ctx context.Context) ([]sqlc.GetAllHeadsRow, err
 	return heads, nil
 }
 
+// GetLatestVersion returns the tag of the most recent version of a file, ignoring its head,
+// or "" if it has no versions
+func (mgr *Manager) GetLatestVersion(ctx context.Context, filename string) (string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		mgr.log.Error("Failed to get file ID", "filename", filename, "error", err)
+		return "", fmt.Errorf("failed to get file ID: %w", err)
+	}
+
+	tag, _, err := mgr.metaStore.GetLatestVersion(ctx, fileID)
+	if errors.Is(err, types.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		mgr.log.Error("Failed to get latest version", "filename", filename, "error", err)
+		return "", fmt.Errorf("failed to get latest version: %w", err)
+	}
+
+	return tag, nil
+}
+
 // GetFileVersions returns all versions for a specific file
 func (mgr *Manager) GetFileVersions(ctx context.Context, filename string) ([]sqlc.Version, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
 
@@ -627,8 +2346,32 @@ func (mgr *Manager) GetFileVersions(ctx context.Context, filename string) ([]sql
 	return versions, nil
 }
 
+// Migrate brings the schema of the metadata store up to date (see metadata.Migrate).
+func (mgr *Manager) Migrate(ctx context.Context) error {
+	applied, err := metadata.Migrate(ctx, mgr.db)
+	if err != nil {
+		mgr.log.Error("Failed to migrate metadata store", "error", err)
+		return fmt.Errorf("failed to migrate metadata store: %w", err)
+	}
+
+	if len(applied) > 0 {
+		mgr.log.Info("Applied metadata store migrations", "versions", applied)
+	} else {
+		mgr.log.Debug("Metadata store schema is up to date")
+	}
+	return nil
+}
+
 // close closes the database.
 func (mgr *Manager) Close() error {
+	if mgr.journal != nil {
+		mgr.mu.Lock()
+		if err := mgr.journal.close(); err != nil {
+			mgr.log.Error("Error closing journal", "error", err)
+		}
+		mgr.mu.Unlock()
+	}
+
 	mgr.log.Debug("Closing metadata store database connection")
 	err := mgr.db.Close()
 	if err != nil {
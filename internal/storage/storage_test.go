@@ -1,15 +1,35 @@
 package storage_test
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vinimdocarmo/quackfs/db/sqlc"
+	"github.com/vinimdocarmo/quackfs/db/types"
 	"github.com/vinimdocarmo/quackfs/internal/quackfstest"
+	"github.com/vinimdocarmo/quackfs/internal/storage"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
+	"github.com/vinimdocarmo/quackfs/pkg/logger"
+	"github.com/vinimdocarmo/quackfs/pkg/metrics"
 )
 
 func TestWriteReadActiveLayer(t *testing.T) {
@@ -61,7 +81,7 @@ func TestCheckpointingNewActiveLayer(t *testing.T) {
 	err = mgr.WriteFile(ctx, filename, input1, 0)
 	require.NoError(t, err, "Write error")
 
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Checkpoint failed")
 
 	db := quackfstest.SetupDB(t)
@@ -93,7 +113,7 @@ func TestReadFromActiveLayer(t *testing.T) {
 	require.NoError(t, err, "Write error")
 
 	// Seal the layer
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to commit layer")
 
 	// Write more data
@@ -183,7 +203,7 @@ func TestStorageManagerPersistence(t *testing.T) {
 	require.NoError(t, err, "Failed to write initial data")
 
 	// Seal the layer to simulate a checkpoint
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to commit layer")
 
 	// Write more data to active layer
@@ -192,7 +212,7 @@ func TestStorageManagerPersistence(t *testing.T) {
 	require.NoError(t, err, "Failed to write more data")
 
 	// Checkpoint again to persist the second data
-	err = mgr.Checkpoint(ctx, filename, "v2")
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
 	require.NoError(t, err, "Failed to commit second layer")
 
 	// Verify the data is correct
@@ -228,7 +248,7 @@ func TestStorageManagerPersistence(t *testing.T) {
 	assert.Equal(t, expectedContent3, fullContent3, "Full content should include all writes")
 
 	// Checkpoint again to persist the third data
-	err = mgr2.Checkpoint(ctx, filename, "v3")
+	_, err = mgr2.Checkpoint(ctx, filename, "v3")
 	require.NoError(t, err, "Failed to commit third layer")
 
 	// Create yet another storage manager to verify all three checkpoints persist
@@ -265,7 +285,7 @@ func TestFuseScenario(t *testing.T) {
 	assert.Equal(t, initialData, readData, "Read data should match written data")
 
 	// Simulate a checkpoint
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to commit layer")
 
 	// Write more data
@@ -285,7 +305,7 @@ func TestFuseScenario(t *testing.T) {
 	assert.Equal(t, uint64(len(combinedData)), size, "File size should match combined data length")
 
 	// Create checkpoint for additional data
-	err = mgr.Checkpoint(ctx, filename, "v2")
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
 	require.NoError(t, err, "Failed to commit second layer")
 
 	// Create a new storage manager to simulate restarting
@@ -333,13 +353,14 @@ func TestWriteBeyondFileSize(t *testing.T) {
 	err = mgr.WriteFile(ctx, filename, []byte("first"), 0)
 	require.NoError(t, err, "Failed to write 'first'")
 
+	// By default, writing beyond the file size zero-fills the gap
 	err = mgr.WriteFile(ctx, filename, []byte("second"), 10)
-	require.NoError(t, err, "Write should fail because it's beyond the file size")
+	require.NoError(t, err, "Failed to write 'second' beyond the file size")
 
 	// check file content
 	content, err := mgr.ReadFile(ctx, filename, 0, 16)
 	require.NoError(t, err, "Failed to read file content")
-	assert.Equal(t, []byte("first\x00\x00\x00\x00\x00second"), content, "File content should match 'firstsecond'")
+	assert.Equal(t, []byte("first\x00\x00\x00\x00\x00second"), content, "Gap between 'first' and 'second' should be zero-filled")
 }
 
 func TestCalculateVirtualFileSize(t *testing.T) {
@@ -378,7 +399,7 @@ func TestCalculateVirtualFileSize(t *testing.T) {
 	assert.Equal(t, expectedSize, size, "File size should be based on highest offset + data length")
 
 	// Seal the layer and write more data at a higher offset
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to commit layer")
 
 	// Write at an even higher offset
@@ -413,7 +434,7 @@ func TestExampleWorkflow(t *testing.T) {
 	require.NoError(t, err, "Failed to write initial data")
 
 	// Simulate a checkpoint using our test instance.
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to commit layer")
 
 	// Write additional data.
@@ -431,6 +452,206 @@ func TestExampleWorkflow(t *testing.T) {
 	assert.Equal(t, expectedContent, fullContent, "Full content should be the concatenation of data1 and data2")
 }
 
+func TestWriteFileBatch(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// Appends, an overwrite, a write past the end and one inside the zero-filled gap
+	writes := []storage.WriteOp{
+		{Offset: 0, Data: []byte("hello")},
+		{Offset: 5, Data: []byte(" world")},
+		{Offset: 2, Data: []byte("LL")},
+		{Offset: 20, Data: []byte("tail")},
+		{Offset: 14, Data: []byte("gap")},
+	}
+
+	sequential, batch := "testfile_batch_sequential", "testfile_batch"
+	for _, filename := range []string{sequential, batch} {
+		_, err := mgr.InsertFile(ctx, filename)
+		require.NoError(t, err, "Failed to insert file")
+		require.NoError(t, mgr.WriteFile(ctx, filename, []byte("committed"), 0))
+		_, err = mgr.Checkpoint(ctx, filename, "v1")
+		require.NoError(t, err, "Failed to checkpoint")
+	}
+
+	for _, w := range writes {
+		require.NoError(t, mgr.WriteFile(ctx, sequential, w.Data, w.Offset))
+	}
+	require.NoError(t, mgr.WriteFileBatch(ctx, batch, writes))
+
+	want, err := mgr.ReadFile(ctx, sequential, 0, 100)
+	require.NoError(t, err)
+	got, err := mgr.ReadFile(ctx, batch, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "heLLo world\x00\x00\x00gap\x00\x00\x00tail", string(want))
+	assert.Equal(t, want, got, "A batch should have the same result as its writes one by one")
+
+	size, err := mgr.SizeOf(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(24), size)
+
+	// Without zero-filling, a batch with a write past the end is rejected as a whole
+	err = mgr.WriteFileBatch(ctx, batch, []storage.WriteOp{
+		{Offset: 0, Data: []byte("HE")},
+		{Offset: 30, Data: []byte("far")},
+	}, storage.WithZeroFill(false))
+	assert.ErrorIs(t, err, types.ErrBeyondFileSize)
+
+	got, err = mgr.ReadFile(ctx, batch, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "Nothing should have been written")
+}
+
+func TestWriteFileAppend(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_append"
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("head"), 0))
+
+	// The offsets are ignored, appends racing with each other follow each other
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, mgr.WriteFile(ctx, filename, []byte{byte('a' + i)}, 0, storage.WithAppend()))
+		}()
+	}
+	wg.Wait()
+
+	data, err := mgr.ReadFile(ctx, filename, 0, 4+writers)
+	require.NoError(t, err)
+	assert.Equal(t, "head", string(data[:4]))
+	assert.ElementsMatch(t, []byte("abcdefgh"), data[4:], "every append should be written once, without gaps")
+
+	require.NoError(t, mgr.WriteFileBatch(ctx, filename, []storage.WriteOp{
+		{Offset: 0, Data: []byte("12")},
+		{Offset: 100, Data: []byte("34")},
+	}, storage.WithAppend()))
+
+	size, err := mgr.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4+writers+4), size)
+	data, err = mgr.ReadFile(ctx, filename, 4+writers, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "1234", string(data))
+}
+
+func TestInPlaceOverwrite(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_in_place"
+
+	fileID, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Writing the second page first keeps the pages in chunks of their own
+	page := bytes.Repeat([]byte{'a'}, 4096)
+	require.NoError(t, mgr.WriteFile(ctx, filename, page, 4096))
+	require.NoError(t, mgr.WriteFile(ctx, filename, page, 0))
+	size := mgr.GetActiveLayerSize(ctx, fileID)
+
+	// Rewriting the header page, whole or in part, doesn't grow the active layer
+	for i := range 100 {
+		header := bytes.Repeat([]byte{byte('0' + i%10)}, 4096)
+		require.NoError(t, mgr.WriteFile(ctx, filename, header, 0, storage.WithInPlaceOverwrite()))
+		require.NoError(t, mgr.WriteFile(ctx, filename, []byte("hdr"), 10, storage.WithInPlaceOverwrite()))
+		assert.Equal(t, size, mgr.GetActiveLayerSize(ctx, fileID), "write %d should overwrite the page in place", i)
+	}
+
+	data, err := mgr.ReadFile(ctx, filename, 0, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "99999999", string(data))
+	data, err = mgr.ReadFile(ctx, filename, 8, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "99hdr999", string(data))
+
+	// A write overlapping a later chunk, or spanning two of them, is appended
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("xx"), 100))
+	size += 2
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("yyyy"), 98, storage.WithInPlaceOverwrite()))
+	size += 4
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("zz"), 4095, storage.WithInPlaceOverwrite()))
+	size += 2
+	assert.Equal(t, size, mgr.GetActiveLayerSize(ctx, fileID))
+
+	data, err = mgr.ReadFile(ctx, filename, 96, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "99yyyy99", string(data))
+	data, err = mgr.ReadFile(ctx, filename, 4094, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "9zza", string(data))
+
+	// The overwritten data is what gets checkpointed
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+	data, err = mgr.ReadFile(ctx, filename, 4094, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "9zza", string(data))
+}
+
+func TestCoalesceSequentialWrites(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_coalesce"
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	const numBlocks = 1000
+	block := []byte("0123456789abcdef")
+	size := uint64(numBlocks * len(block))
+
+	writeBlocks := func() {
+		for i := range numBlocks {
+			require.NoError(t, mgr.WriteFile(ctx, filename, block, uint64(i*len(block))))
+		}
+	}
+
+	// Appends, then sequential rewrites of the committed data
+	writeBlocks()
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+	writeBlocks()
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	infos, err := mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, infos, 2, "Each layer should have a single chunk")
+	for i, version := range []string{"v1", "v2"} {
+		assert.Equal(t, version, infos[i].Version)
+		assert.Equal(t, [2]uint64{0, size}, infos[i].FileRange)
+		assert.Equal(t, [2]uint64{0, size}, infos[i].LayerRange)
+	}
+
+	// Non-contiguous and overlapping writes still get chunks of their own
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("xx"), 4))
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("yy"), 2))
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("zz"), 3))
+
+	infos, err = mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	assert.Len(t, infos, 5)
+
+	content, err := mgr.ReadFile(ctx, filename, 0, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "01yzzx67", string(content))
+}
+
 func TestWriteToSameOffsetTwice(t *testing.T) {
 	mgr, cleanup := quackfstest.SetupStorageManager(t)
 	defer cleanup()
@@ -513,7 +734,7 @@ func TestVersionedLayers(t *testing.T) {
 
 	// Checkpoint with version tag "v1"
 	versionTag1 := "v1"
-	err = mgr.Checkpoint(ctx, filename, versionTag1)
+	_, err = mgr.Checkpoint(ctx, filename, versionTag1)
 	require.NoError(t, err, "Failed to checkpoint file with version tag")
 
 	// Write more data
@@ -523,7 +744,7 @@ func TestVersionedLayers(t *testing.T) {
 
 	// Checkpoint with version tag "v2"
 	versionTag2 := "v2"
-	err = mgr.Checkpoint(ctx, filename, versionTag2)
+	_, err = mgr.Checkpoint(ctx, filename, versionTag2)
 	require.NoError(t, err, "Failed to checkpoint file with version tag")
 
 	// Load all layers for the file
@@ -583,7 +804,7 @@ func TestGetDataRangeWithVersion(t *testing.T) {
 
 	// Create version v1
 	v1Tag := "v1"
-	err = mgr.Checkpoint(ctx, filename, v1Tag)
+	_, err = mgr.Checkpoint(ctx, filename, v1Tag)
 	require.NoError(t, err, "Failed to checkpoint with version v1")
 
 	// Write more content
@@ -593,7 +814,7 @@ func TestGetDataRangeWithVersion(t *testing.T) {
 
 	// Create version v2
 	v2Tag := "v2"
-	err = mgr.Checkpoint(ctx, filename, v2Tag)
+	_, err = mgr.Checkpoint(ctx, filename, v2Tag)
 	require.NoError(t, err, "Failed to checkpoint with version v2")
 
 	// Write final content
@@ -616,7 +837,7 @@ func TestGetDataRangeWithVersion(t *testing.T) {
 	assert.Contains(t, err.Error(), "read-only mode", "Error should mention read-only mode")
 
 	// Try to checkpoint file with head set - should fail
-	err = mgr.Checkpoint(ctx, filename, "new-version")
+	_, err = mgr.Checkpoint(ctx, filename, "new-version")
 	require.Error(t, err, "Expected error when checkpointing file with head set")
 	assert.Contains(t, err.Error(), "read-only mode", "Error should mention read-only mode")
 
@@ -644,6 +865,50 @@ func TestGetDataRangeWithVersion(t *testing.T) {
 	assert.Equal(t, string(latestContent), string(newContent), "Expected latest content to be %q, got %q", newContent, latestContent)
 }
 
+func TestReadFileAsOf(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+
+	filename := "testfile_as_of"
+	ctx := context.Background()
+
+	fileID, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Backdate each version so that the test controls when they were created
+	createdAt := map[string]time.Time{
+		"v1": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		"v2": time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC),
+	}
+	for _, tag := range []string{"v1", "v2"} {
+		require.NoError(t, mgr.WriteFile(ctx, filename, []byte("version "+tag), 0))
+		_, err = mgr.Checkpoint(ctx, filename, tag)
+		require.NoError(t, err, "Failed to checkpoint")
+		_, err = db.ExecContext(ctx, `UPDATE versions v SET created_at = $1::TIMESTAMPTZ FROM snapshot_layers sl
+			WHERE sl.version_id = v.id AND sl.file_id = $2 AND v.tag = $3`, createdAt[tag], fileID, tag)
+		require.NoError(t, err, "Failed to backdate version")
+	}
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("uncommitted"), 0))
+
+	content, err := mgr.ReadFile(ctx, filename, 0, 100, storage.WithAsOf(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	assert.Equal(t, "version v1", string(content))
+
+	// The bound is inclusive, and time zones are taken into account
+	content, err = mgr.ReadFile(ctx, filename, 0, 100, storage.WithAsOf(createdAt["v2"].In(time.FixedZone("UTC+2", 2*60*60))))
+	require.NoError(t, err)
+	assert.Equal(t, "version v2", string(content))
+
+	_, err = mgr.ReadFile(ctx, filename, 0, 100, storage.WithAsOf(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)))
+	assert.ErrorIs(t, err, types.ErrNotFound, "No version predates the timestamp")
+
+	content, err = mgr.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "uncommitted", string(content))
+}
 func TestWithinAndOverlappingWrites(t *testing.T) {
 	/**
 	[2000, 4000):   	----------
@@ -741,6 +1006,48 @@ func TestWithinAndOverlappingWrites(t *testing.T) {
 	}
 }
 
+func TestReadFileEOF(t *testing.T) {
+	for _, committed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("committed=%v", committed), func(t *testing.T) {
+			mgr, cleanup := quackfstest.SetupStorageManager(t)
+			defer cleanup()
+
+			ctx := context.Background()
+			filename := "testfile_read_eof"
+
+			_, err := mgr.InsertFile(ctx, filename)
+			require.NoError(t, err, "Failed to insert file")
+
+			// The write past the end leaves a zero-filled gap in [10, 100)
+			require.NoError(t, mgr.WriteFile(ctx, filename, []byte("0123456789"), 0))
+			require.NoError(t, mgr.WriteFile(ctx, filename, []byte("tail"), 100))
+			if committed {
+				_, err = mgr.Checkpoint(ctx, filename, "v1")
+				require.NoError(t, err, "Failed to checkpoint")
+			}
+
+			size, err := mgr.SizeOf(ctx, filename)
+			require.NoError(t, err)
+			require.Equal(t, uint64(104), size)
+
+			for _, tc := range []struct {
+				name   string
+				offset uint64
+				want   string
+			}{
+				{"at end of file", 104, ""},
+				{"in gap", 50, strings.Repeat("\x00", 10)},
+				{"across end of file", 98, "\x00\x00tail"},
+				{"far past end of file", 1 << 40, ""},
+			} {
+				data, err := mgr.ReadFile(ctx, filename, tc.offset, 10)
+				require.NoError(t, err, tc.name)
+				assert.Equal(t, tc.want, string(data), tc.name)
+			}
+		})
+	}
+}
+
 func TestReadFileStartingMidChunk(t *testing.T) {
 	mgr, cleanup := quackfstest.SetupStorageManager(t)
 	defer cleanup()
@@ -757,7 +1064,7 @@ func TestReadFileStartingMidChunk(t *testing.T) {
 	require.NoError(t, err, "Failed to write initial data")
 
 	// Create a checkpoint to seal this layer
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to checkpoint")
 
 	// Write more data at a later position - this will be our second chunk
@@ -898,13 +1205,15 @@ func TestConcurrentCheckpoint(t *testing.T) {
 	go func() {
 		defer wg.Done()
 		barrier.Wait()
-		assert.NoError(t, mgr.Checkpoint(ctx, filename, "v1"))
+		_, err = mgr.Checkpoint(ctx, filename, "v1")
+		assert.NoError(t, err)
 	}()
 
 	go func() {
 		defer wg.Done()
 		barrier.Wait()
-		assert.NoError(t, mgr.Checkpoint(ctx, filename, "v2"))
+		_, err = mgr.Checkpoint(ctx, filename, "v2")
+		assert.NoError(t, err)
 	}()
 
 	barrier.Done()
@@ -973,7 +1282,7 @@ func TestHeadReadOnlyMode(t *testing.T) {
 	require.NoError(t, err, "Failed to write initial content")
 
 	// Checkpoint
-	err = mgr.Checkpoint(ctx, filename, "v1")
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
 	require.NoError(t, err, "Failed to checkpoint")
 
 	// Set head to version
@@ -991,7 +1300,7 @@ func TestHeadReadOnlyMode(t *testing.T) {
 	assert.Contains(t, err.Error(), "read-only mode", "Error should mention read-only mode")
 
 	// Try to checkpoint - should fail due to read-only mode
-	err = mgr.Checkpoint(ctx, filename, "v2")
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
 	require.Error(t, err, "Checkpointing should fail when head is set")
 	assert.Contains(t, err.Error(), "read-only mode", "Error should mention read-only mode")
 
@@ -1009,3 +1318,3684 @@ func TestHeadReadOnlyMode(t *testing.T) {
 	require.NoError(t, err, "Reading should succeed after head is removed")
 	assert.Equal(t, newContent, readNewContent, "New content should be visible")
 }
+
+// flakyStore wraps an object store and makes GetObject fail while failGets is set
+type flakyStore struct {
+	objectstore.ObjectStore
+	failGets atomic.Bool
+	gets     atomic.Int64
+}
+
+func (s *flakyStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	s.gets.Add(1)
+	if s.failGets.Load() {
+		return nil, errors.New("primary store is down")
+	}
+	return s.ObjectStore.GetObject(ctx, key, dataRange)
+}
+
+// slowStore wraps an object store and makes each GetObject take delay, calling onGet first
+type slowStore struct {
+	objectstore.ObjectStore
+	delay time.Duration
+	onGet func()
+	gets  atomic.Int64
+}
+
+func (s *slowStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	s.gets.Add(1)
+	if s.onGet != nil {
+		s.onGet()
+	}
+
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.ObjectStore.GetObject(ctx, key, dataRange)
+}
+
+func TestReadFileCancelled(t *testing.T) {
+	store := &slowStore{ObjectStore: quackfstest.MemoryStore(), delay: 200 * time.Millisecond}
+	// Fetch chunks one at a time, so the cancellation is seen before the next fetch
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithFetchConcurrency(1))
+	defer cleanup()
+
+	filename := "testfile_read_cancelled"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// One layer per version, so a full read needs one fetch per version
+	const versions = 5
+	for i := range versions {
+		require.NoError(t, mgr.WriteFile(ctx, filename, []byte("0123456789"), uint64(i*10)))
+		_, err = mgr.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i+1))
+		require.NoError(t, err, "Failed to checkpoint")
+	}
+
+	// The client gives up while the first chunk is being fetched
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	store.onGet = cancel
+
+	start := time.Now()
+	_, err = mgr.ReadFile(readCtx, filename, 0, versions*10)
+	elapsed := time.Since(start)
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(1), store.gets.Load(), "No chunk should be fetched after the read was cancelled")
+	assert.Less(t, elapsed, store.delay, "The read should abort without waiting for the fetch")
+
+	// The read transaction was rolled back, the manager keeps working
+	store.onGet = nil
+	content, err := mgr.ReadFile(ctx, filename, 0, versions*10)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("0123456789"), versions), content)
+}
+
+func TestReadFileConcurrentFetch(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	serial, cleanupSerial := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithFetchConcurrency(1))
+	defer cleanupSerial()
+	concurrent, cleanupConcurrent := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithFetchConcurrency(4))
+	defer cleanupConcurrent()
+
+	filename := "testfile_concurrent_fetch"
+	ctx := context.Background()
+
+	_, err := serial.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Overlapping writes across many layers, so that later chunks have to override earlier ones
+	const fileSize = 4096
+	expected := make([]byte, fileSize)
+	for i := range 20 {
+		offset := uint64(i * 173 % (fileSize - 512))
+		data := bytes.Repeat([]byte{byte('a' + i)}, 300+i*7)
+		copy(expected[offset:], data)
+
+		require.NoError(t, serial.WriteFile(ctx, filename, data, offset))
+		_, err = serial.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i+1))
+		require.NoError(t, err, "Failed to checkpoint")
+	}
+
+	// Uncommitted writes are mixed in with the fetched chunks
+	require.NoError(t, serial.WriteFile(ctx, filename, []byte("uncommitted"), 100))
+	copy(expected[100:], "uncommitted")
+
+	size, err := serial.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	expected = expected[:size]
+
+	serialData, err := serial.ReadFile(ctx, filename, 0, size)
+	require.NoError(t, err)
+	assert.Equal(t, expected, serialData)
+
+	// The concurrent manager has no uncommitted writes, compare the versions
+	serialData, err = serial.ReadFile(ctx, filename, 37, size-100, storage.WithVersion("v20"))
+	require.NoError(t, err)
+
+	var stats storage.ReadStats
+	concurrentData, err := concurrent.ReadFile(ctx, filename, 37, size-100, storage.WithReadStats(&stats))
+	require.NoError(t, err)
+	assert.Equal(t, serialData, concurrentData, "Concurrent fetches should assemble the same bytes as serial ones")
+	assert.NotZero(t, stats.BytesFetched)
+}
+
+func TestReadFailsOverToReplica(t *testing.T) {
+	// Both stores share the same backing directory to simulate a replicated bucket
+	backing := objectstore.NewLocalFS(t.TempDir())
+	primary := &flakyStore{ObjectStore: backing}
+	replica := &flakyStore{ObjectStore: backing}
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, primary,
+		storage.WithReplicas(replica),
+		storage.WithCircuitBreaker(1, time.Minute))
+	defer cleanup()
+
+	filename := "testfile_replica_failover"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	data := []byte("data served by the replica")
+	err = mgr.WriteFile(ctx, filename, data, 0)
+	require.NoError(t, err, "Failed to write data")
+
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	// Healthy primary serves the read
+	content, err := mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.NoError(t, err, "Failed to read from primary")
+	assert.Equal(t, data, content)
+	assert.Equal(t, int64(1), primary.gets.Load(), "Primary should have served the read")
+	assert.Equal(t, int64(0), replica.gets.Load(), "Replica should not have been used")
+
+	// Primary goes down, the replica serves the read
+	primary.failGets.Store(true)
+
+	content, err = mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.NoError(t, err, "Read should fail over to the replica")
+	assert.Equal(t, data, content)
+	assert.Equal(t, int64(2), primary.gets.Load(), "Primary should have been tried first")
+	assert.Equal(t, int64(1), replica.gets.Load(), "Replica should have served the read")
+
+	// The circuit breaker is now open, so the primary is skipped entirely
+	content, err = mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.NoError(t, err, "Read should be served by the replica")
+	assert.Equal(t, data, content)
+	assert.Equal(t, int64(2), primary.gets.Load(), "Primary should be skipped while the breaker is open")
+	assert.Equal(t, int64(2), replica.gets.Load(), "Replica should have served the read")
+
+	// When every store fails the read errors out
+	replica.failGets.Store(true)
+
+	_, err = mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.Error(t, err, "Read should fail when no store can serve it")
+}
+
+func TestCheckpointFencedForStaleNode(t *testing.T) {
+	// Two managers sharing the same database simulate two nodes
+	nodeA, cleanupA := quackfstest.SetupStorageManager(t)
+	defer cleanupA()
+	nodeB, cleanupB := quackfstest.SetupStorageManager(t)
+	defer cleanupB()
+
+	filename := "testfile_fencing"
+	ctx := context.Background()
+
+	_, err := nodeA.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Node A takes ownership of the file with its first write
+	err = nodeA.WriteFile(ctx, filename, []byte("written by node A"), 0)
+	require.NoError(t, err, "Node A should be able to write")
+
+	// Node B also believes it owns the file and takes over with a newer epoch
+	dataB := []byte("written by node B")
+	err = nodeB.WriteFile(ctx, filename, dataB, 0)
+	require.NoError(t, err, "Node B should be able to write")
+
+	// Node A's token is now stale, so both its checkpoints and writes are rejected
+	_, err = nodeA.Checkpoint(ctx, filename, "v1")
+	require.Error(t, err, "Checkpoint from the stale node should fail")
+	assert.True(t, errors.Is(err, types.ErrFenced), "Expected ErrFenced, got %v", err)
+
+	err = nodeA.WriteFile(ctx, filename, []byte("more from node A"), 0)
+	require.Error(t, err, "Write from the stale node should fail")
+	assert.True(t, errors.Is(err, types.ErrFenced), "Expected ErrFenced, got %v", err)
+
+	// Node B holds the latest epoch and can checkpoint
+	_, err = nodeB.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Checkpoint from the owning node should succeed")
+
+	// Only node B's data was persisted
+	nodeC, cleanupC := quackfstest.SetupStorageManager(t)
+	defer cleanupC()
+
+	content, err := nodeC.ReadFile(ctx, filename, 0, uint64(len(dataB)))
+	require.NoError(t, err, "Failed to read checkpointed data")
+	assert.Equal(t, dataB, content)
+}
+
+func TestWarmupPopulatesReadCache(t *testing.T) {
+	store := &flakyStore{ObjectStore: objectstore.NewMemory()}
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithReadCache(1<<20))
+	defer cleanup()
+
+	filename := "testfile_warmup"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	data1 := []byte("first chunk of data")
+	data2 := []byte("second chunk of data")
+	err = mgr.WriteFile(ctx, filename, data1, 0)
+	require.NoError(t, err, "Failed to write first chunk")
+	err = mgr.WriteFile(ctx, filename, data2, uint64(len(data1)))
+	require.NoError(t, err, "Failed to write second chunk")
+
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	// Only warm up the first chunk
+	err = mgr.Warmup(ctx, filename, [][2]uint64{{0, uint64(len(data1))}})
+	require.NoError(t, err, "Failed to warm up")
+	assert.Equal(t, int64(1), store.gets.Load(), "Warmup should fetch the first chunk")
+
+	// Reading the warmed range is served from the cache
+	content, err := mgr.ReadFile(ctx, filename, 0, uint64(len(data1)))
+	require.NoError(t, err, "Failed to read warmed range")
+	assert.Equal(t, data1, content)
+	assert.Equal(t, int64(1), store.gets.Load(), "Read of a warmed range should not hit the object store")
+
+	// Reading the whole file only fetches the chunk that wasn't warmed up
+	content, err = mgr.ReadFile(ctx, filename, 0, uint64(len(data1)+len(data2)))
+	require.NoError(t, err, "Failed to read whole file")
+	assert.Equal(t, append(data1, data2...), content)
+	assert.Equal(t, int64(2), store.gets.Load(), "Only the cold chunk should be fetched")
+}
+
+func TestReadCache(t *testing.T) {
+	store := &flakyStore{ObjectStore: quackfstest.MemoryStore()}
+	// Room for two of the three chunks below
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithReadCache(20))
+	defer cleanup()
+
+	ctx := context.Background()
+
+	filenames := []string{"testfile_cache_a", "testfile_cache_b", "testfile_cache_c"}
+	for _, filename := range filenames {
+		_, err := mgr.InsertFile(ctx, filename)
+		require.NoError(t, err, "Failed to insert file")
+		require.NoError(t, mgr.WriteFile(ctx, filename, []byte("0123456789"), 0))
+		_, err = mgr.Checkpoint(ctx, filename, "v1")
+		require.NoError(t, err, "Failed to checkpoint")
+	}
+
+	// Identical reads only hit the object store once
+	for range 2 {
+		content, err := mgr.ReadFile(ctx, filenames[0], 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(content))
+	}
+	assert.Equal(t, int64(1), store.gets.Load(), "The second read should be served from the cache")
+	assert.Equal(t, storage.CacheStats{Hits: 1, Misses: 1, Entries: 1, Bytes: 10}, mgr.CacheStats())
+
+	// Reading b then a, then c evicts b, the least recently used
+	_, err := mgr.ReadFile(ctx, filenames[1], 0, 10)
+	require.NoError(t, err)
+	_, err = mgr.ReadFile(ctx, filenames[0], 0, 10)
+	require.NoError(t, err)
+	_, err = mgr.ReadFile(ctx, filenames[2], 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), store.gets.Load())
+
+	_, err = mgr.ReadFile(ctx, filenames[0], 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), store.gets.Load(), "a should still be cached")
+
+	_, err = mgr.ReadFile(ctx, filenames[1], 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), store.gets.Load(), "b should have been evicted")
+
+	// Deleting a layer object drops its chunks from the cache
+	require.NoError(t, mgr.RenameFile(ctx, filenames[0], filenames[1]))
+	_, err = mgr.GC(ctx)
+	require.NoError(t, err)
+
+	stats := mgr.CacheStats()
+	assert.Equal(t, 1, stats.Entries, "Only the chunk of a should be left")
+	assert.Equal(t, uint64(10), stats.Bytes)
+}
+
+func TestReadCoalescesContiguousChunks(t *testing.T) {
+	store := &flakyStore{ObjectStore: quackfstest.MemoryStore()}
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithReadCache(1<<20))
+	defer cleanup()
+
+	filename := "testfile_read_coalesced"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Writing the pages backwards gives one chunk per page, stored next to each other in the layer object
+	const pages, pageSize = 10, 16
+	expected := make([]byte, pages*pageSize)
+	for i := pages - 1; i >= 0; i-- {
+		page := bytes.Repeat([]byte{byte('a' + i)}, pageSize)
+		copy(expected[i*pageSize:], page)
+		require.NoError(t, mgr.WriteFile(ctx, filename, page, uint64(i*pageSize)))
+	}
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	chunks, err := mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, chunks, pages)
+
+	// The whole file is a single request
+	content, err := mgr.ReadFile(ctx, filename, 0, pages*pageSize)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+	assert.Equal(t, int64(1), store.gets.Load(), "Contiguous chunks should be fetched with a single request")
+
+	// A cached page in the middle splits the others in two requests
+	filename = "testfile_read_coalesced_split"
+	_, err = mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+	for i := pages - 1; i >= 0; i-- {
+		require.NoError(t, mgr.WriteFile(ctx, filename, expected[i*pageSize:(i+1)*pageSize], uint64(i*pageSize)))
+	}
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	content, err = mgr.ReadFile(ctx, filename, 4*pageSize, pageSize)
+	require.NoError(t, err)
+	assert.Equal(t, expected[4*pageSize:5*pageSize], content)
+	require.Equal(t, int64(2), store.gets.Load())
+
+	content, err = mgr.ReadFile(ctx, filename, 0, pages*pageSize)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+	assert.Equal(t, int64(4), store.gets.Load(), "The chunks around the cached one should be fetched with one request each")
+}
+
+func TestSealedLayerCache(t *testing.T) {
+	store := &flakyStore{ObjectStore: quackfstest.MemoryStore()}
+	// Room for one of the two layers below
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithSealedLayerCache(15))
+	defer cleanup()
+
+	ctx := context.Background()
+
+	filenames := []string{"testfile_sealed_a", "testfile_sealed_b"}
+	for _, filename := range filenames {
+		_, err := mgr.InsertFile(ctx, filename)
+		require.NoError(t, err, "Failed to insert file")
+	}
+
+	require.NoError(t, mgr.WriteFile(ctx, filenames[0], []byte("0123456789"), 0))
+	_, err := mgr.Checkpoint(ctx, filenames[0], "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	content, err := mgr.ReadFile(ctx, filenames[0], 2, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "23456", string(content))
+	assert.Equal(t, int64(0), store.gets.Load(), "The checkpointed layer should be read from memory")
+
+	// Checkpointing b evicts a
+	require.NoError(t, mgr.WriteFile(ctx, filenames[1], []byte("abcdefghij"), 0))
+	_, err = mgr.Checkpoint(ctx, filenames[1], "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	content, err = mgr.ReadFile(ctx, filenames[1], 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghij", string(content))
+	assert.Equal(t, int64(0), store.gets.Load())
+
+	content, err = mgr.ReadFile(ctx, filenames[0], 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(content))
+	assert.Equal(t, int64(1), store.gets.Load(), "a should have been evicted")
+}
+
+func TestVersionDeltaRoundTrip(t *testing.T) {
+	source, cleanupSource := quackfstest.SetupStorageManager(t)
+	defer cleanupSource()
+
+	// The replica has its own object store, so it can only get data through the deltas
+	replica, cleanupReplica := quackfstest.SetupStorageManagerWithStore(t, objectstore.NewMemory())
+	defer cleanupReplica()
+
+	sourceFile := "testfile_delta_source"
+	replicaFile := "testfile_delta_replica"
+	ctx := context.Background()
+
+	_, err := source.InsertFile(ctx, sourceFile)
+	require.NoError(t, err, "Failed to insert source file")
+	_, err = replica.InsertFile(ctx, replicaFile)
+	require.NoError(t, err, "Failed to insert replica file")
+
+	// v1 writes some data, v2 overwrites part of it and writes past the end of the file
+	require.NoError(t, source.WriteFile(ctx, sourceFile, []byte("hello world"), 0))
+	_, err = source.Checkpoint(ctx, sourceFile, "v1")
+	require.NoError(t, err)
+	require.NoError(t, source.WriteFile(ctx, sourceFile, []byte("WORLD"), 6))
+	require.NoError(t, source.WriteFile(ctx, sourceFile, []byte("!"), 15))
+	_, err = source.Checkpoint(ctx, sourceFile, "v2")
+	require.NoError(t, err)
+
+	for _, tag := range []string{"v1", "v2"} {
+		delta, err := source.GetVersionDelta(ctx, sourceFile, tag)
+		require.NoError(t, err, "Failed to get delta for %s", tag)
+
+		header, layerData, err := storage.DecodeVersionDelta(delta)
+		require.NoError(t, err, "Failed to decode delta for %s", tag)
+		assert.Equal(t, tag, header.Tag)
+
+		data, err := io.ReadAll(layerData)
+		require.NoError(t, err, "Failed to read delta data for %s", tag)
+		require.NoError(t, delta.Close())
+		require.Equal(t, header.Size, uint64(len(data)), "Delta should contain the whole layer")
+
+		// Apply the delta on top of the replica's parent version
+		for _, c := range header.Chunks {
+			err = replica.WriteFile(ctx, replicaFile, data[c.LayerRange[0]:c.LayerRange[1]], c.FileRange[0])
+			require.NoError(t, err, "Failed to apply chunk")
+		}
+		_, err = replica.Checkpoint(ctx, replicaFile, tag)
+		require.NoError(t, err)
+
+		sourceSize, err := source.SizeOf(ctx, sourceFile)
+		require.NoError(t, err)
+		replicaSize, err := replica.SizeOf(ctx, replicaFile)
+		require.NoError(t, err)
+		require.Equal(t, sourceSize, replicaSize, "Replica size should match after applying %s", tag)
+	}
+
+	size, err := source.SizeOf(ctx, sourceFile)
+	require.NoError(t, err)
+
+	expected, err := source.ReadFile(ctx, sourceFile, 0, size)
+	require.NoError(t, err, "Failed to read source file")
+	content, err := replica.ReadFile(ctx, replicaFile, 0, size)
+	require.NoError(t, err, "Failed to read replica file")
+	assert.Equal(t, expected, content, "Replica content should match the source")
+	assert.Equal(t, []byte("hello WORLD\x00\x00\x00\x00!"), content)
+}
+
+func TestGCDeletesUnreferencedObjects(t *testing.T) {
+	store := objectstore.NewMemory()
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	filename := "testfile_gc"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	data := []byte("data that must survive garbage collection")
+	require.NoError(t, mgr.WriteFile(ctx, filename, data, 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	referenced, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, referenced, 1, "Checkpoint should have uploaded one object")
+
+	// Simulate an object left behind by a deleted version
+	orphan := "layers/deleted.duckdb/1-1"
+	require.NoError(t, store.PutObject(ctx, orphan, []byte("orphaned data")))
+
+	deleted, err := mgr.GC(ctx)
+	require.NoError(t, err, "GC failed")
+	assert.Equal(t, []string{orphan}, deleted, "Only the unreferenced object should be deleted")
+
+	_, err = store.GetObject(ctx, orphan, [2]uint64{0, 0})
+	assert.True(t, errors.Is(err, objectstore.ErrNotFound), "Orphaned object should be gone, got %v", err)
+
+	// The referenced data is still readable
+	content, err := mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.NoError(t, err, "Failed to read file after GC")
+	assert.Equal(t, data, content)
+
+	// Running again is a no-op
+	deleted, err = mgr.GC(ctx)
+	require.NoError(t, err, "Second GC failed")
+	assert.Empty(t, deleted)
+}
+
+func TestApplyVersionDelta(t *testing.T) {
+	source, cleanupSource := quackfstest.SetupStorageManager(t)
+	defer cleanupSource()
+
+	replica, cleanupReplica := quackfstest.SetupStorageManagerWithStore(t, objectstore.NewMemory())
+	defer cleanupReplica()
+
+	sourceFile := "testfile_apply_delta_source"
+	replicaFile := "testfile_apply_delta_replica"
+	ctx := context.Background()
+
+	_, err := source.InsertFile(ctx, sourceFile)
+	require.NoError(t, err, "Failed to insert source file")
+	_, err = replica.InsertFile(ctx, replicaFile)
+	require.NoError(t, err, "Failed to insert replica file")
+
+	require.NoError(t, source.WriteFile(ctx, sourceFile, []byte("hello world"), 0))
+	_, err = source.Checkpoint(ctx, sourceFile, "v1")
+	require.NoError(t, err)
+	require.NoError(t, source.WriteFile(ctx, sourceFile, []byte("WORLD"), 6))
+	_, err = source.Checkpoint(ctx, sourceFile, "v2")
+	require.NoError(t, err)
+
+	applyDelta := func(parentTag, tag string) error {
+		delta, err := source.GetVersionDelta(ctx, sourceFile, tag)
+		require.NoError(t, err, "Failed to get delta for %s", tag)
+		defer delta.Close()
+
+		header, layerData, err := storage.DecodeVersionDelta(delta)
+		require.NoError(t, err, "Failed to decode delta for %s", tag)
+
+		return replica.ApplyVersionDelta(ctx, replicaFile, parentTag, header.Tag, layerData, header.Chunks)
+	}
+
+	// v2 can't be applied before its parent
+	err = applyDelta("v1", "v2")
+	require.Error(t, err, "Applying a delta on top of a missing parent should fail")
+
+	require.NoError(t, applyDelta("", "v1"), "Failed to apply v1")
+	require.NoError(t, applyDelta("v1", "v2"), "Failed to apply v2")
+
+	content, err := replica.ReadFile(ctx, replicaFile, 0, 11)
+	require.NoError(t, err, "Failed to read replica file")
+	assert.Equal(t, []byte("hello WORLD"), content)
+
+	versions, err := replica.GetFileVersions(ctx, replicaFile)
+	require.NoError(t, err, "Failed to get replica versions")
+	assert.Len(t, versions, 2)
+
+	// The replica can also be pinned to the parent version
+	require.NoError(t, replica.SetHead(ctx, replicaFile, "v1"))
+	content, err = replica.ReadFile(ctx, replicaFile, 0, 11)
+	require.NoError(t, err, "Failed to read replica file at v1")
+	assert.Equal(t, []byte("hello world"), content)
+	require.NoError(t, replica.DeleteHead(ctx, replicaFile))
+}
+
+func TestCheckpointWithCompression(t *testing.T) {
+	store := objectstore.NewMemory()
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithCompression(storage.CompressionGzip))
+	defer cleanup()
+
+	filename := "testfile_compression"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Highly compressible data, like most DuckDB pages, spread over several chunks
+	page1 := bytes.Repeat([]byte("duck"), 1024)
+	page2 := bytes.Repeat([]byte{0}, 4096)
+	require.NoError(t, mgr.WriteFile(ctx, filename, page1, 0))
+	require.NoError(t, mgr.WriteFile(ctx, filename, page2, uint64(len(page1))))
+	// Write past the end of the file so a zero-filled chunk is created too
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("tail"), 10000))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	expected := make([]byte, 10004)
+	copy(expected, page1)
+	copy(expected[10000:], "tail")
+
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	_, err = store.GetObject(ctx, keys[0], [2]uint64{0, uint64(len(expected)) - 1})
+	require.Error(t, err, "The stored object should be smaller than the raw layer data")
+
+	content, err := mgr.ReadFile(ctx, filename, 0, uint64(len(expected)))
+	require.NoError(t, err, "Failed to read compressed layer")
+	assert.Equal(t, expected, content)
+
+	// Reads in the middle of a chunk still work
+	content, err = mgr.ReadFile(ctx, filename, 2, 6)
+	require.NoError(t, err, "Failed to read part of a compressed chunk")
+	assert.Equal(t, []byte("ckduck"), content)
+
+	// A manager without compression writes raw layers and reads compressed ones
+	mgr2, cleanup2 := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup2()
+
+	require.NoError(t, mgr2.WriteFile(ctx, filename, []byte("TAIL"), 10000))
+	_, err = mgr2.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+	copy(expected[10000:], "TAIL")
+
+	content, err = mgr2.ReadFile(ctx, filename, 0, uint64(len(expected)))
+	require.NoError(t, err, "Failed to read mixed layers")
+	assert.Equal(t, expected, content)
+}
+
+func TestCheckpointWithEncryption(t *testing.T) {
+	objectsDir := t.TempDir()
+	store := objectstore.NewLocalFS(objectsDir)
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store,
+		storage.WithEncryptionKey(key), storage.WithCompression(storage.CompressionGzip))
+	defer cleanup()
+
+	filename := "testfile_encryption"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	secret := []byte("very secret data, very secret data, very secret data")
+	require.NoError(t, mgr.WriteFile(ctx, filename, secret, 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	// The stored object doesn't contain the plaintext
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	object, err := os.ReadFile(filepath.Join(objectsDir, keys[0]))
+	require.NoError(t, err)
+	assert.NotContains(t, string(object), "secret", "Object should not contain the plaintext")
+
+	content, err := mgr.ReadFile(ctx, filename, 0, uint64(len(secret)))
+	require.NoError(t, err, "Failed to read encrypted layer with the key")
+	assert.Equal(t, secret, content)
+
+	// A reader without the key can't decode the layer
+	noKey, cleanupNoKey := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanupNoKey()
+
+	_, err = noKey.ReadFile(ctx, filename, 0, uint64(len(secret)))
+	require.Error(t, err, "Reading an encrypted layer without the key should fail")
+
+	// Neither can a reader with another key
+	wrongKey, cleanupWrongKey := quackfstest.SetupStorageManagerWithStore(t, store,
+		storage.WithEncryptionKey(bytes.Repeat([]byte{0x24}, 32)))
+	defer cleanupWrongKey()
+
+	_, err = wrongKey.ReadFile(ctx, filename, 0, uint64(len(secret)))
+	require.Error(t, err, "Reading an encrypted layer with the wrong key should fail")
+
+	// Layers written without encryption stay readable by a manager with a key
+	require.NoError(t, noKey.WriteFile(ctx, filename, []byte("plain"), uint64(len(secret))))
+	_, err = noKey.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	content, err = mgr.ReadFile(ctx, filename, 0, uint64(len(secret)+5))
+	require.NoError(t, err, "Failed to read mixed layers with the key")
+	assert.Equal(t, append(secret, []byte("plain")...), content)
+}
+
+func TestReadDetectsCorruptedChunk(t *testing.T) {
+	store := objectstore.NewMemory()
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	filename := "testfile_checksum"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	data := []byte("data that will get corrupted")
+	require.NoError(t, mgr.WriteFile(ctx, filename, data, 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	content, err := mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.NoError(t, err, "Failed to read intact data")
+	assert.Equal(t, data, content)
+
+	// Flip a byte of the stored object, keeping its size
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	object, err := store.GetObject(ctx, keys[0], [2]uint64{0, uint64(len(data)) - 1})
+	require.NoError(t, err)
+	object[5] ^= 0xff
+	require.NoError(t, store.PutObject(ctx, keys[0], object))
+
+	_, err = mgr.ReadFile(ctx, filename, 0, uint64(len(data)))
+	require.Error(t, err, "Reading corrupted data should fail")
+	assert.True(t, errors.Is(err, types.ErrChecksumMismatch), "Expected ErrChecksumMismatch, got %v", err)
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	store := objectstore.NewMemory()
+	keys := map[string][]byte{
+		"a": bytes.Repeat([]byte{0xaa}, 32),
+		"b": bytes.Repeat([]byte{0xbb}, 32),
+	}
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithKeyring(keys, "a"))
+	defer cleanup()
+
+	filename := "testfile_key_rotation"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// v1 is encrypted with key a
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("written under key a"), 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	err = mgr.RotateKey(ctx, "missing")
+	require.Error(t, err, "Rotating to a key that isn't in the keyring should fail")
+
+	// v2 is encrypted with key b
+	require.NoError(t, mgr.RotateKey(ctx, "b"))
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("B"), 14))
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	content, err := mgr.ReadFile(ctx, filename, 0, 19)
+	require.NoError(t, err, "Failed to read v2")
+	assert.Equal(t, []byte("written under Bey a"), content)
+
+	require.NoError(t, mgr.SetHead(ctx, filename, "v1"))
+	content, err = mgr.ReadFile(ctx, filename, 0, 19)
+	require.NoError(t, err, "Failed to read v1")
+	assert.Equal(t, []byte("written under key a"), content)
+	require.NoError(t, mgr.DeleteHead(ctx, filename))
+
+	// A keyring without key a can read the data written under key b, but not under key a
+	onlyB, cleanupOnlyB := quackfstest.SetupStorageManagerWithStore(t, store,
+		storage.WithKeyring(map[string][]byte{"b": keys["b"]}, "b"))
+	defer cleanupOnlyB()
+
+	content, err = onlyB.ReadFile(ctx, filename, 14, 1)
+	require.NoError(t, err, "Data encrypted with key b should be readable")
+	assert.Equal(t, []byte("B"), content)
+
+	_, err = onlyB.ReadFile(ctx, filename, 0, 14)
+	require.Error(t, err, "Data encrypted with key a should not be readable without it")
+}
+
+func TestReadStats(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t, storage.WithReadCache(1<<20))
+	defer cleanup()
+
+	filename := "testfile_read_stats"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Three layers overlapping the same range: two flushed and the active one
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("aaaaaaaa"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("bbb"), 2))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("c"), 6))
+
+	var stats storage.ReadStats
+	content, err := sm.ReadFile(ctx, filename, 0, 8, storage.WithReadStats(&stats))
+	require.NoError(t, err, "Failed to read file")
+	assert.Equal(t, []byte("aabbbaca"), content)
+
+	assert.Equal(t, storage.ReadStats{
+		ChunksScanned: 3,
+		LayersTouched: 3,
+		BytesFetched:  11, // 8 bytes from v1 and 3 bytes from v2
+		CacheHits:     0,
+	}, stats)
+
+	// The flushed chunks are now in the read cache
+	content, err = sm.ReadFile(ctx, filename, 0, 8, storage.WithReadStats(&stats))
+	require.NoError(t, err, "Failed to read file")
+	assert.Equal(t, []byte("aabbbaca"), content)
+
+	assert.Equal(t, storage.ReadStats{
+		ChunksScanned: 3,
+		LayersTouched: 3,
+		BytesFetched:  0,
+		CacheHits:     2,
+	}, stats)
+
+	// Only the v1 chunk overlaps the first two bytes
+	_, err = sm.ReadFile(ctx, filename, 0, 2, storage.WithReadStats(&stats))
+	require.NoError(t, err, "Failed to read file")
+	assert.Equal(t, 1, stats.ChunksScanned)
+	assert.Equal(t, 1, stats.LayersTouched)
+}
+
+// BenchmarkCheckpointManyChunks checkpoints a layer made of 10k small writes, so its
+// chunks dominate the cost of the checkpoint. The writes go backwards, so that they aren't
+// coalesced into a single chunk.
+func BenchmarkCheckpointManyChunks(b *testing.B) {
+	sm, cleanup := quackfstest.SetupStorageManager(b)
+	defer cleanup()
+
+	filename := "testfile_checkpoint_many_chunks"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(b, err, "Failed to insert file")
+
+	const numChunks = 10000
+	data := []byte("0123456789abcdef")
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := range numChunks {
+			require.NoError(b, sm.WriteFile(ctx, filename, data, uint64((numChunks-1-j)*len(data))))
+		}
+		b.StartTimer()
+
+		_, err = sm.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i))
+
+		require.NoError(b, err)
+	}
+}
+
+func TestPublishSnapshot(t *testing.T) {
+	store := objectstore.NewMemory()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	filename := "testfile_publish_snapshot.duckdb"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("first version of the file"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("second"), 0))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte(", now longer"), 25))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	// Uncommitted writes aren't part of any version
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("uncommitted"), 0))
+
+	require.NoError(t, sm.PublishSnapshot(ctx, filename, "v1", "snapshots/v1.duckdb"))
+	require.NoError(t, sm.PublishSnapshot(ctx, filename, "v2", "snapshots/v2.duckdb"))
+
+	for tag, expected := range map[string]string{
+		"v1": "first version of the file",
+		"v2": "second version of the file, now longer",
+	} {
+		object, err := store.GetObject(ctx, "snapshots/"+tag+".duckdb", [2]uint64{0, uint64(len(expected)) - 1})
+		require.NoError(t, err, "Failed to get snapshot object for %s", tag)
+		assert.Equal(t, expected, string(object))
+
+		_, err = store.GetObject(ctx, "snapshots/"+tag+".duckdb", [2]uint64{0, uint64(len(expected))})
+		assert.Error(t, err, "Snapshot of %s should contain nothing past the end of the file", tag)
+	}
+
+	err = sm.PublishSnapshot(ctx, filename, "v1", "layers/v1.duckdb")
+	assert.Error(t, err, "Publishing a snapshot among layer objects should fail")
+
+	err = sm.PublishSnapshot(ctx, filename, "missing", "snapshots/missing.duckdb")
+	assert.Error(t, err, "Publishing a snapshot of a missing version should fail")
+}
+
+func TestLargeLayerRangesRoundTrip(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_large_layer_ranges"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Ranges past 255 and 65535 bytes, to catch values truncated to 8 or 16 bits
+	first := bytes.Repeat([]byte("a"), 300)
+	second := bytes.Repeat([]byte("b"), 70000)
+	require.NoError(t, sm.WriteFile(ctx, filename, first, 0))
+	require.NoError(t, sm.WriteFile(ctx, filename, second, 300))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	// Reload the chunks of the layer from the metadata store
+	delta, err := sm.GetVersionDelta(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to get version delta")
+	defer delta.Close()
+
+	header, _, err := storage.DecodeVersionDelta(delta)
+	require.NoError(t, err, "Failed to decode version delta")
+	assert.Equal(t, []storage.DeltaChunk{
+		{LayerRange: [2]uint64{0, 300}, FileRange: [2]uint64{0, 300}},
+		{LayerRange: [2]uint64{300, 70300}, FileRange: [2]uint64{300, 70300}},
+	}, header.Chunks)
+
+	content, err := sm.ReadFile(ctx, filename, 250, 100)
+	require.NoError(t, err, "Failed to read file")
+	assert.Equal(t, append(bytes.Repeat([]byte("a"), 50), bytes.Repeat([]byte("b"), 50)...), content)
+}
+
+func TestAppendAndOverwriteWrites(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_append_overwrite"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("aaaa"), 0))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("bbbb"), 4)) // append, extends the first chunk
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("XX"), 2))   // overwrite, new chunk
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("cccc"), 8)) // append, but not right after the previous chunk
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("dd"), 12))  // append, extends the previous chunk
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("ee"), 16))  // beyond the end, zero-filled gap
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("ff"), 18))  // append, extends the previous chunk
+
+	expected := []byte("aaXXbbbbccccdd\x00\x00eeff")
+
+	content, err := sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err, "Failed to read active layer")
+	assert.Equal(t, expected, content)
+
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+
+	require.NoError(t, err)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err, "Failed to read checkpointed layer")
+	assert.Equal(t, expected, content)
+
+	delta, err := sm.GetVersionDelta(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to get version delta")
+	defer delta.Close()
+
+	header, _, err := storage.DecodeVersionDelta(delta)
+	require.NoError(t, err, "Failed to decode version delta")
+	assert.Equal(t, []storage.DeltaChunk{
+		{LayerRange: [2]uint64{0, 8}, FileRange: [2]uint64{0, 8}},
+		{LayerRange: [2]uint64{8, 10}, FileRange: [2]uint64{2, 4}},
+		{LayerRange: [2]uint64{10, 16}, FileRange: [2]uint64{8, 14}},
+		{LayerRange: [2]uint64{16, 18}, FileRange: [2]uint64{14, 16}},
+		{LayerRange: [2]uint64{18, 22}, FileRange: [2]uint64{16, 20}},
+	}, header.Chunks)
+
+	// Appending after a checkpoint starts a new chunk in the new active layer
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("gg"), 20))
+	content, err = sm.ReadFile(ctx, filename, 18, 100)
+	require.NoError(t, err, "Failed to read file")
+	assert.Equal(t, []byte("ffgg"), content)
+}
+
+// BenchmarkReadManyLayers reads a file whose pages are spread across 64 layers from a store
+// with 5ms of latency per request, fetching chunks serially and concurrently.
+func BenchmarkReadManyLayers(b *testing.B) {
+	const layers = 64
+	const pageSize = 4096
+
+	for _, concurrency := range []int{1, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			store := &slowStore{ObjectStore: quackfstest.MemoryStore()}
+			mgr, cleanup := quackfstest.SetupStorageManagerWithStore(b, store, storage.WithFetchConcurrency(concurrency))
+			defer cleanup()
+
+			filename := "benchfile_read_many_layers"
+			ctx := context.Background()
+
+			_, err := mgr.InsertFile(ctx, filename)
+			require.NoError(b, err)
+
+			for i := range layers {
+				require.NoError(b, mgr.WriteFile(ctx, filename, bytes.Repeat([]byte{byte(i)}, pageSize), uint64(i*pageSize)))
+				_, err = mgr.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i+1))
+				require.NoError(b, err)
+			}
+
+			store.delay = 5 * time.Millisecond
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := mgr.ReadFile(ctx, filename, 0, layers*pageSize)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// BenchmarkAppendWrites writes a file sequentially in 4 KiB pages, the way DuckDB grows a database.
+func BenchmarkAppendWrites(b *testing.B) {
+	sm, cleanup := quackfstest.SetupStorageManager(b)
+	defer cleanup()
+
+	filename := "testfile_append_writes"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(b, err, "Failed to insert file")
+
+	page := bytes.Repeat([]byte("x"), 4096)
+	b.SetBytes(int64(len(page)))
+
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, sm.WriteFile(ctx, filename, page, uint64(i*len(page))))
+	}
+
+	b.StopTimer()
+	_, err = sm.ReadFile(ctx, filename, 0, uint64(b.N*len(page)))
+	require.NoError(b, err, "Failed to read file")
+}
+
+// TestReadAfterCheckpointSameManager tests reads right after Checkpoint moved the active layer
+// to committed storage, in the same Manager (the DuckDB checkpoint-then-query flow).
+func TestReadAfterCheckpointSameManager(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_read_after_checkpoint"
+	ctx := context.Background()
+
+	fileID, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	expected := []byte{}
+	write := func(data []byte, offset uint64) {
+		require.NoError(t, sm.WriteFile(ctx, filename, data, offset))
+		if end := offset + uint64(len(data)); end > uint64(len(expected)) {
+			expected = append(expected, make([]byte, end-uint64(len(expected)))...)
+		}
+		copy(expected[offset:], data)
+	}
+
+	assertContent := func(msg string) {
+		size, err := sm.SizeOf(ctx, filename)
+		require.NoError(t, err, "Failed to get file size")
+		assert.Equal(t, uint64(len(expected)), size, "%s: unexpected size", msg)
+
+		content, err := sm.ReadFile(ctx, filename, 0, uint64(len(expected)))
+		require.NoError(t, err, "Failed to read file")
+		assert.Equal(t, expected, content, "%s: unexpected content", msg)
+
+		// Reads of partial ranges, at the boundaries of the writes
+		for _, r := range [][2]uint64{{0, 1}, {3, 7}, {7, 9}, {uint64(len(expected)) - 1, 1}} {
+			content, err := sm.ReadFile(ctx, filename, r[0], r[1])
+			require.NoError(t, err, "Failed to read file")
+			assert.Equal(t, expected[r[0]:r[0]+r[1]], content, "%s: unexpected content at offset %d", msg, r[0])
+		}
+
+		// Reads that go past the end of the file are cut short
+		content, err = sm.ReadFile(ctx, filename, uint64(len(expected))-2, 10)
+		require.NoError(t, err, "Failed to read file")
+		assert.Equal(t, expected[len(expected)-2:], content, "%s: unexpected content at the end of the file", msg)
+	}
+
+	for i, version := range []string{"v1", "v2", "v3"} {
+		write([]byte("0123456789"), uint64(i*4))
+		write([]byte("ab"), uint64(i*3+1))
+
+		assertContent(version + " before checkpoint")
+
+		_, err = sm.Checkpoint(ctx, filename, version)
+
+		require.NoError(t, err)
+		assert.Empty(t, sm.GetActiveLayerData(ctx, fileID), "Active layer should be empty after checkpoint")
+
+		assertContent(version + " after checkpoint")
+	}
+}
+
+func TestStrictWriteBeyondFileSize(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_write_beyond_size"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("hello"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	// Strict mode rejects writes past the end of the file...
+	err = sm.WriteFile(ctx, filename, []byte("world"), 10, storage.WithZeroFill(false))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, types.ErrBeyondFileSize), "expected ErrBeyondFileSize, got %v", err)
+
+	// ...but not appends or overwrites
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte(" world"), 5, storage.WithZeroFill(false)))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("H"), 0, storage.WithZeroFill(false)))
+
+	size, err := sm.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), size, "Rejected write should not change the file size")
+
+	// By default the gap is zero-filled
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("!"), 13))
+
+	content, err := sm.ReadFile(ctx, filename, 0, 14)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("Hello world\x00\x00!"), content)
+}
+
+func TestVerify(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithCompression(storage.CompressionGzip))
+	defer cleanup()
+
+	ctx := context.Background()
+
+	filenames := []string{"testfile_verify_a", "testfile_verify_b"}
+	for _, filename := range filenames {
+		_, err := mgr.InsertFile(ctx, filename)
+		require.NoError(t, err, "Failed to insert file")
+		for _, tag := range []string{"v1", "v2"} {
+			require.NoError(t, mgr.WriteFile(ctx, filename, bytes.Repeat([]byte(tag), 100), 0))
+			_, err = mgr.Checkpoint(ctx, filename, tag)
+			require.NoError(t, err, "Failed to checkpoint")
+		}
+	}
+
+	issues, err := mgr.Verify(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+
+	versions, err := mgr.GetAllVersions(ctx)
+	require.NoError(t, err)
+	objectKey := func(filename, tag string) string {
+		for _, v := range versions {
+			if v.FileName == filename && v.Tag == tag {
+				return v.ObjectKey
+			}
+		}
+		t.Fatalf("No version %s of %s", tag, filename)
+		return ""
+	}
+
+	missing := objectKey(filenames[0], "v2")
+	require.NoError(t, store.DeleteObject(ctx, missing))
+
+	issues, err = mgr.Verify(ctx)
+	require.NoError(t, err)
+	require.Len(t, issues, 1, "Only the deleted object should be flagged")
+	assert.Equal(t, filenames[0], issues[0].FileName)
+	assert.Equal(t, "v2", issues[0].Version)
+	assert.Equal(t, missing, issues[0].ObjectKey)
+	assert.Equal(t, storage.IssueMissing, issues[0].Problem)
+	assert.NotZero(t, issues[0].ExpectedSize)
+
+	// A truncated object is flagged too
+	truncated := objectKey(filenames[1], "v1")
+	require.NoError(t, store.PutObject(ctx, truncated, []byte("x")))
+
+	issues, err = mgr.Verify(ctx)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	assert.Equal(t, truncated, issues[1].ObjectKey)
+	assert.Equal(t, storage.IssueSizeMismatch, issues[1].Problem)
+	assert.Equal(t, uint64(1), issues[1].ActualSize)
+}
+
+func TestObjectKeyFunc(t *testing.T) {
+	// Names can't contain slashes, but can contain characters that are awkward in S3 keys
+	filename := "my db?#%+.duckdb"
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name    string
+		opts    []storage.ManagerOpt
+		pattern string
+	}{
+		{"default", nil, `^layers/(\d+)/\d+$`},
+		{"file name", []storage.ManagerOpt{storage.WithObjectKeyFunc(storage.FileNameObjectKey)}, `^layers/my%20db%3F%23%25\+\.duckdb/(\d+)-\d+$`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := quackfstest.MemoryStore()
+			mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, tc.opts...)
+			defer cleanup()
+
+			fileID, err := mgr.InsertFile(ctx, filename)
+			require.NoError(t, err, "Failed to insert file")
+			require.NoError(t, mgr.WriteFile(ctx, filename, []byte("data"), 0))
+			_, err = mgr.Checkpoint(ctx, filename, "v1")
+			require.NoError(t, err, "Failed to checkpoint")
+
+			keys, err := store.ListObjects(ctx, "layers/")
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+			require.Regexp(t, tc.pattern, keys[0])
+			assert.Equal(t, fmt.Sprint(fileID), regexp.MustCompile(tc.pattern).FindStringSubmatch(keys[0])[1])
+
+			content, err := mgr.ReadFile(ctx, filename, 0, 4)
+			require.NoError(t, err)
+			assert.Equal(t, "data", string(content))
+		})
+	}
+}
+
+func TestObjectKeysSurviveRename(t *testing.T) {
+	store := objectstore.NewMemory()
+
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+
+	filename := "testfile_before_rename.duckdb"
+	ctx := context.Background()
+
+	fileID, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("version one"), 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("two"), 8))
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	for _, key := range keys {
+		assert.Regexp(t, fmt.Sprintf(`^layers/%d/\d+$`, fileID), key, "Object keys should not contain the filename")
+	}
+
+	newFilename := "testfile_after_rename.duckdb"
+	_, err = db.ExecContext(ctx, "UPDATE files SET name = $1 WHERE id = $2", newFilename, fileID)
+	require.NoError(t, err, "Failed to rename file")
+
+	content, err := mgr.ReadFile(ctx, newFilename, 0, 11)
+	require.NoError(t, err, "Failed to read renamed file")
+	assert.Equal(t, []byte("version two"), content)
+
+	// The objects are still referenced under the new name
+	deleted, err := mgr.GC(ctx)
+	require.NoError(t, err, "GC failed")
+	assert.Empty(t, deleted, "GC should not delete the objects of a renamed file")
+
+	require.NoError(t, mgr.WriteFile(ctx, newFilename, []byte("3"), 0))
+	_, err = mgr.Checkpoint(ctx, newFilename, "v3")
+	require.NoError(t, err)
+
+	content, err = mgr.ReadFile(ctx, newFilename, 0, 11)
+	require.NoError(t, err, "Failed to read renamed file")
+	assert.Equal(t, []byte("3ersion two"), content)
+}
+
+func TestSizeOfVersion(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_size_of_version"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("0123456789"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("abcdef"), 20))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("x"), 0)) // v3 doesn't grow the file
+	_, err = sm.Checkpoint(ctx, filename, "v3")
+	require.NoError(t, err)
+
+	// Uncommitted writes don't count
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("uncommitted"), 26))
+
+	for tag, expected := range map[string]uint64{"v1": 10, "v2": 26, "v3": 26} {
+		size, err := sm.SizeOfVersion(ctx, filename, tag)
+		require.NoError(t, err, "Failed to get size of %s", tag)
+		assert.Equal(t, expected, size, "Unexpected size for %s", tag)
+	}
+
+	// Reads of a version bounded by its size
+	size, err := sm.SizeOfVersion(ctx, filename, "v1")
+	require.NoError(t, err)
+	content, err := sm.ReadFile(ctx, filename, 0, size, storage.WithVersion("v1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789"), content)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 1, storage.WithVersion("v3"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), content)
+
+	_, err = sm.SizeOfVersion(ctx, filename, "missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "version tag not found")
+
+	_, err = sm.ReadFile(ctx, filename, 0, 1, storage.WithVersion("missing"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "version tag not found")
+}
+
+func TestReadArchivedHeadVersion(t *testing.T) {
+	store := &flakyStore{ObjectStore: objectstore.NewMemory()}
+
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	filename := "testfile_archived_head"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("cold data"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("hot"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	require.NoError(t, sm.ArchiveVersion(ctx, filename, "v1"))
+	require.NoError(t, sm.SetHead(ctx, filename, "v1"))
+	defer sm.DeleteHead(ctx, filename)
+
+	_, err = sm.ReadFile(ctx, filename, 0, 9)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, types.ErrObjectArchived), "expected ErrObjectArchived, got %v", err)
+	assert.Contains(t, err.Error(), "RestoreVersion")
+	assert.Zero(t, store.gets.Load(), "Reading an archived version should not fetch from the object store")
+
+	// Reads of the latest version need the archived layer too
+	require.NoError(t, sm.DeleteHead(ctx, filename))
+	_, err = sm.ReadFile(ctx, filename, 0, 9)
+	assert.True(t, errors.Is(err, types.ErrObjectArchived), "expected ErrObjectArchived, got %v", err)
+
+	// Once restored, the version is readable again
+	require.NoError(t, sm.RestoreVersion(ctx, filename, "v1"))
+	require.NoError(t, sm.SetHead(ctx, filename, "v1"))
+
+	content, err := sm.ReadFile(ctx, filename, 0, 9)
+	require.NoError(t, err, "Failed to read restored version")
+	assert.Equal(t, []byte("cold data"), content)
+}
+
+func TestDiffVersions(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_diff_versions"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// Each version overwrites the same offset, like in TestGetDataRangeWithVersion
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("***************"), 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("---------------"), 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("@@@@@@@@@@@@@@@"), 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v3")
+	require.NoError(t, err)
+
+	// v4 changes part of the file and grows it past a zero-filled gap
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("ab"), 5))
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("cd"), 20))
+	_, err = mgr.Checkpoint(ctx, filename, "v4")
+	require.NoError(t, err)
+
+	tests := []struct {
+		from, to string
+		expected []storage.DiffRange
+	}{
+		{"v1", "v2", []storage.DiffRange{{FileRange: [2]uint64{0, 15}, Version: "v2"}}},
+		{"v1", "v3", []storage.DiffRange{{FileRange: [2]uint64{0, 15}, Version: "v3"}}},
+		{"v2", "v2", []storage.DiffRange{}},
+		{"v3", "v4", []storage.DiffRange{
+			{FileRange: [2]uint64{5, 7}, Version: "v4"},
+			{FileRange: [2]uint64{15, 22}, Version: "v4"},
+		}},
+		{"v2", "v4", []storage.DiffRange{
+			{FileRange: [2]uint64{0, 5}, Version: "v3"},
+			{FileRange: [2]uint64{5, 7}, Version: "v4"},
+			{FileRange: [2]uint64{7, 15}, Version: "v3"},
+			{FileRange: [2]uint64{15, 22}, Version: "v4"},
+		}},
+	}
+
+	for _, tt := range tests {
+		ranges, err := mgr.Diff(ctx, filename, tt.from, tt.to)
+		require.NoError(t, err, "Failed to diff %s and %s", tt.from, tt.to)
+		assert.Equal(t, tt.expected, ranges, "Unexpected diff between %s and %s", tt.from, tt.to)
+	}
+
+	_, err = mgr.Diff(ctx, filename, "v3", "v1")
+	assert.Error(t, err, "Diffing from a newer version should fail")
+
+	_, err = mgr.Diff(ctx, filename, "v1", "missing")
+	assert.Error(t, err, "Diffing with a missing version should fail")
+}
+
+func TestWriteTracing(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t, storage.WithWriteTracing())
+	defer cleanup()
+
+	filename := "testfile_write_tracing"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("hello"), 0, storage.WithWriteOrigin(1, 100)))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte(" world"), 5, storage.WithWriteOrigin(2, 100)))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("untraced"), 11))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("!"), 30, storage.WithWriteOrigin(3, 200)))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	origins, err := sm.GetWriteOrigins(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to get write origins")
+	assert.Equal(t, []metadata.WriteOrigin{
+		{LayerRange: [2]uint64{0, 5}, RequestID: 1, PID: 100, Offset: 0, Size: 5},
+		{LayerRange: [2]uint64{5, 11}, RequestID: 2, PID: 100, Offset: 5, Size: 6},
+		// bytes 11-19 were written without an origin, and 19-30 are the zero-filled gap
+		{LayerRange: [2]uint64{30, 31}, RequestID: 3, PID: 200, Offset: 30, Size: 1},
+	}, origins)
+
+	// Nothing is recorded when tracing is disabled
+	untraced, cleanupUntraced := quackfstest.SetupStorageManager(t)
+	defer cleanupUntraced()
+
+	require.NoError(t, untraced.WriteFile(ctx, filename, []byte("bye"), 0, storage.WithWriteOrigin(4, 100)))
+	_, err = untraced.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	origins, err = untraced.GetWriteOrigins(ctx, filename, "v2")
+	require.NoError(t, err, "Failed to get write origins")
+	assert.Empty(t, origins)
+}
+
+func TestCheckpointMessageAndAuthor(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_checkpoint_metadata"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("data"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("more data"), 4))
+	_, err = sm.Checkpoint(ctx, filename, "v2",
+		storage.WithMessage("Load the sales table"), storage.WithAuthor("etl"))
+	require.NoError(t, err)
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err, "Failed to get file versions")
+	require.Len(t, versions, 2)
+
+	byTag := make(map[string]sqlc.Version)
+	for _, v := range versions {
+		byTag[v.Tag] = v
+	}
+
+	// Versions checkpointed without metadata have empty fields
+	assert.Empty(t, byTag["v1"].Message)
+	assert.Empty(t, byTag["v1"].Author)
+	assert.Equal(t, "Load the sales table", byTag["v2"].Message)
+	assert.Equal(t, "etl", byTag["v2"].Author)
+}
+
+func TestMultiManagerRoutingStability(t *testing.T) {
+	log := logger.New(os.Stderr)
+	newShards := func(names ...string) map[string]*storage.Manager {
+		shards := make(map[string]*storage.Manager)
+		for _, name := range names {
+			shards[name] = storage.NewManager(nil, objectstore.NewMemory(), log)
+		}
+		return shards
+	}
+
+	mm, err := storage.NewMultiManager(newShards("a", "b", "c"))
+	require.NoError(t, err)
+
+	filenames := make([]string, 1000)
+	routes := make(map[string]string)
+	counts := make(map[string]int)
+	for i := range filenames {
+		filenames[i] = fmt.Sprintf("db_%d.duckdb", i)
+		routes[filenames[i]] = mm.ShardFor(filenames[i])
+		counts[routes[filenames[i]]]++
+	}
+
+	for _, shard := range []string{"a", "b", "c"} {
+		assert.Greater(t, counts[shard], 200, "Files should be spread across shards, got %v", counts)
+	}
+
+	// Another MultiManager over the same shards routes the same way
+	again, err := storage.NewMultiManager(newShards("c", "b", "a"))
+	require.NoError(t, err)
+	for _, filename := range filenames {
+		assert.Equal(t, routes[filename], again.ShardFor(filename), "Route of %s changed", filename)
+	}
+
+	// Adding a shard only moves files to the new shard
+	grown, err := storage.NewMultiManager(newShards("a", "b", "c", "d"))
+	require.NoError(t, err)
+	moved := 0
+	for _, filename := range filenames {
+		if shard := grown.ShardFor(filename); shard != routes[filename] {
+			assert.Equal(t, "d", shard, "%s moved between existing shards", filename)
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 0, "Some files should move to the new shard")
+	assert.Less(t, moved, 500, "Most files should stay where they are")
+
+	_, err = storage.NewMultiManager(nil)
+	assert.Error(t, err, "A MultiManager needs at least one shard")
+}
+
+func TestMultiManagerReadWrite(t *testing.T) {
+	// The shards share the test database but each has its own object store
+	a, cleanupA := quackfstest.SetupStorageManagerWithStore(t, objectstore.NewMemory())
+	defer cleanupA()
+	b, cleanupB := quackfstest.SetupStorageManagerWithStore(t, objectstore.NewMemory())
+	defer cleanupB()
+
+	mm, err := storage.NewMultiManager(map[string]*storage.Manager{"a": a, "b": b})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// Pick files landing on both shards
+	var filenames []string
+	seen := make(map[string]bool)
+	for i := 0; len(seen) < 2 || len(filenames) < 4; i++ {
+		filename := fmt.Sprintf("testfile_multi_%d.duckdb", i)
+		seen[mm.ShardFor(filename)] = true
+		filenames = append(filenames, filename)
+	}
+
+	for _, filename := range filenames {
+		_, err := mm.InsertFile(ctx, filename)
+		require.NoError(t, err, "Failed to insert %s", filename)
+		require.NoError(t, mm.WriteFile(ctx, filename, []byte("data of "+filename), 0))
+		_, err = mm.Checkpoint(ctx, filename, "v1")
+		require.NoError(t, err)
+	}
+
+	for _, filename := range filenames {
+		content, err := mm.ReadFile(ctx, filename, 0, uint64(len("data of "+filename)))
+		require.NoError(t, err, "Failed to read %s", filename)
+		assert.Equal(t, "data of "+filename, string(content))
+
+		// The data is only in the object store of the file's shard
+		other := a
+		if mm.ShardFor(filename) == "a" {
+			other = b
+		}
+		_, err = other.ReadFile(ctx, filename, 0, 1)
+		assert.Error(t, err, "%s should not be readable from the other shard", filename)
+	}
+
+	files, err := mm.GetAllFiles(ctx)
+	require.NoError(t, err)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	slices.Sort(filenames)
+	assert.Equal(t, filenames, names, "Each file should be listed once")
+
+	// Files can't be renamed to a name on another shard
+	for _, filename := range filenames[1:] {
+		if mm.ShardFor(filename) != mm.ShardFor(filenames[0]) {
+			err = mm.RenameFile(ctx, filenames[0], filename)
+			assert.ErrorIs(t, err, types.ErrCrossShard)
+			break
+		}
+	}
+}
+
+// putHookStore wraps an object store and calls afterPut once each object is stored
+type putHookStore struct {
+	objectstore.ObjectStore
+	afterPut func()
+}
+
+func (s *putHookStore) PutObject(ctx context.Context, key string, data []byte) error {
+	if err := s.ObjectStore.PutObject(ctx, key, data); err != nil {
+		return err
+	}
+	if s.afterPut != nil {
+		s.afterPut()
+	}
+	return nil
+}
+
+func TestCheckpointFailureDeletesObject(t *testing.T) {
+	store := &putHookStore{ObjectStore: quackfstest.MemoryStore()}
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	filename := "testfile_checkpoint_orphan"
+
+	_, err := mgr.InsertFile(context.Background(), filename)
+	require.NoError(t, err, "Failed to insert file")
+	require.NoError(t, mgr.WriteFile(context.Background(), filename, []byte("data"), 0))
+
+	// Cancelling the checkpoint right after the upload makes its transaction fail
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.afterPut = cancel
+
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.Error(t, err)
+
+	keys, err := store.ListObjects(context.Background(), "layers/")
+	require.NoError(t, err)
+	assert.Empty(t, keys, "The object of the failed checkpoint should have been deleted")
+
+	// The writes are still there to be checkpointed again
+	store.afterPut = nil
+	_, err = mgr.Checkpoint(context.Background(), filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+
+	keys, err = store.ListObjects(context.Background(), "layers/")
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestCheckpointPlan(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_checkpoint_plan"
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	plan, err := mgr.CheckpointPlan(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, storage.CheckpointPlan{}, plan, "There should be nothing to checkpoint")
+
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("0123456789"), 0))
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("abc"), 2))
+
+	plan, err = mgr.CheckpointPlan(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", plan.Version)
+	assert.Equal(t, uint64(13), plan.Bytes)
+	assert.Equal(t, 2, plan.Chunks)
+
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	assert.Empty(t, keys, "Planning should not upload anything")
+
+	version, err := mgr.Checkpoint(ctx, filename, "")
+	require.NoError(t, err, "Failed to checkpoint")
+	assert.Equal(t, plan.Version, version)
+
+	infos, err := mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, infos, plan.Chunks)
+	var bytes uint64
+	for _, info := range infos {
+		bytes += info.LayerRange[1] - info.LayerRange[0]
+		assert.True(t, strings.HasPrefix(info.ObjectKey, plan.ObjectKeyPrefix), "%s should start with %s", info.ObjectKey, plan.ObjectKeyPrefix)
+	}
+	assert.Equal(t, plan.Bytes, bytes)
+
+	// Nothing is left to checkpoint, and a file with a head can't be checkpointed
+	plan, err = mgr.CheckpointPlan(ctx, filename)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Version)
+
+	require.NoError(t, mgr.SetHead(ctx, filename, "v1"))
+	_, err = mgr.CheckpointPlan(ctx, filename)
+	assert.ErrorContains(t, err, "read-only mode")
+}
+
+func TestCheckpointDuplicateVersion(t *testing.T) {
+	store := objectstore.NewMemory()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_duplicate_version.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("first"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	keys, err := store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("second"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.Error(t, err, "Checkpointing an existing version tag should fail")
+	assert.ErrorIs(t, err, types.ErrVersionExists)
+
+	keys, err = store.ListObjects(ctx, "layers/")
+	require.NoError(t, err)
+	assert.Len(t, keys, 1, "The rejected checkpoint should not upload an object")
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	// The writes are kept and can be checkpointed under another tag
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+	content, err := sm.ReadFile(ctx, filename, 0, 6, storage.WithVersion("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	// Other files can use the same tag
+	other := "testfile_duplicate_version_other.duckdb"
+	_, err = sm.InsertFile(ctx, other)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, other, []byte("other"), 0))
+	_, err = sm.Checkpoint(ctx, other, "v1")
+	assert.NoError(t, err)
+}
+
+func TestCheckpointGeneratedVersionTags(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_generated_tags.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	// Nothing to checkpoint, no version is created
+	version, err := sm.Checkpoint(ctx, filename, "")
+	require.NoError(t, err)
+	assert.Empty(t, version)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("one"), 0))
+	version, err = sm.Checkpoint(ctx, filename, "")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", version)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("two"), 0))
+	version, err = sm.Checkpoint(ctx, filename, "")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", version)
+
+	// Explicit tags are returned as is, and generated tags follow the highest numeric one
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("ten"), 0))
+	version, err = sm.Checkpoint(ctx, filename, "v10")
+	require.NoError(t, err)
+	assert.Equal(t, "v10", version)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("rel"), 0))
+	version, err = sm.Checkpoint(ctx, filename, "release-v99")
+	require.NoError(t, err)
+	assert.Equal(t, "release-v99", version)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("new"), 0))
+	version, err = sm.Checkpoint(ctx, filename, "")
+	require.NoError(t, err)
+	assert.Equal(t, "v11", version)
+
+	content, err := sm.ReadFile(ctx, filename, 0, 3, storage.WithVersion("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, "two", string(content))
+}
+
+func TestCompact(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_compact.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	// Each version adds a page and rewrites the header
+	const pageSize = 64
+	contents := map[string][]byte{}
+	for i := range 10 {
+		tag := fmt.Sprintf("v%d", i+1)
+		require.NoError(t, sm.WriteFile(ctx, filename, bytes.Repeat([]byte{byte('a' + i)}, pageSize), uint64((i+1)*pageSize)))
+		require.NoError(t, sm.WriteFile(ctx, filename, []byte(fmt.Sprintf("header %d", i)), 0))
+		_, err = sm.Checkpoint(ctx, filename, tag)
+		require.NoError(t, err)
+
+		size, err := sm.SizeOf(ctx, filename)
+		require.NoError(t, err)
+		contents[tag], err = sm.ReadFile(ctx, filename, 0, size)
+		require.NoError(t, err)
+	}
+
+	before, err := sm.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, before, 20)
+
+	require.NoError(t, sm.Compact(ctx, filename))
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, versions, 11)
+	compacted := versions[len(versions)-1].Tag
+	history, err := sm.GetAllVersions(ctx)
+	require.NoError(t, err)
+	for _, v := range history {
+		if v.FileName == filename && v.Tag == compacted {
+			assert.Equal(t, storage.OriginCompact, v.Origin)
+		}
+	}
+
+	after, err := sm.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, after, 1, "the compacted version should be read from a single chunk")
+	assert.Equal(t, compacted, after[0].Version)
+
+	size, err := sm.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	data, err := sm.ReadFile(ctx, filename, 0, size)
+	require.NoError(t, err)
+	assert.Equal(t, contents["v10"], data)
+
+	// Earlier versions are still read from their own layers
+	for tag, want := range contents {
+		data, err := sm.ReadFile(ctx, filename, 0, uint64(len(want)), storage.WithVersion(tag))
+		require.NoError(t, err)
+		assert.Equal(t, want, data, "content of %s", tag)
+	}
+	chunks, err := sm.ListChunks(ctx, filename, storage.WithVersion("v10"))
+	require.NoError(t, err)
+	assert.Len(t, chunks, 20)
+
+	// Compacting again has nothing to do
+	require.NoError(t, sm.Compact(ctx, filename))
+	versions, err = sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	assert.Len(t, versions, 11)
+
+	// Later versions build on the compacted one
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("HEADER"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v12")
+	require.NoError(t, err)
+	after, err = sm.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	assert.Len(t, after, 2)
+	data, err = sm.ReadFile(ctx, filename, 0, size)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("HEADER"), contents["v10"][6:]...), data)
+
+	// Pending writes would be mixed with the compacted content
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("pending"), 0))
+	assert.Error(t, sm.Compact(ctx, filename))
+}
+
+func TestCompactConcurrentWrite(t *testing.T) {
+	store := &slowStore{ObjectStore: quackfstest.MemoryStore()}
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_compact_concurrent_write.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, sm.WriteFile(ctx, filename, []byte(fmt.Sprintf("version %d", i+1)), uint64(i*16)))
+		_, err = sm.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i+1))
+		require.NoError(t, err)
+	}
+	size, err := sm.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	want, err := sm.ReadFile(ctx, filename, 0, size)
+	require.NoError(t, err)
+
+	// Write while the compaction reads the latest version back, slowly enough for the write
+	// to be waiting for it
+	var once sync.Once
+	started := make(chan struct{})
+	store.delay = 50 * time.Millisecond
+	store.onGet = func() {
+		once.Do(func() { close(started) })
+	}
+	writeErr := make(chan error, 1)
+	go func() {
+		<-started
+		writeErr <- sm.WriteFile(ctx, filename, []byte("concurrent"), 0)
+	}()
+
+	require.NoError(t, sm.Compact(ctx, filename))
+	assert.ErrorIs(t, <-writeErr, types.ErrFileBusy, "the write would be overwritten by the compaction")
+
+	data, err := sm.ReadFile(ctx, filename, 0, size)
+	require.NoError(t, err)
+	assert.Equal(t, want, data)
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, versions, 4)
+
+	// Once compacted, the file can be written again
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("after"), 0))
+	data, err = sm.ReadFile(ctx, filename, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "after", string(data))
+}
+
+func TestCompactConcurrentCheckpoint(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	other, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_compact_concurrent_checkpoint.duckdb"
+
+	_, err := other.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, other.WriteFile(ctx, filename, []byte(fmt.Sprintf("version %d", i+1)), uint64(i*16)))
+		_, err = other.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i+1))
+		require.NoError(t, err)
+	}
+
+	// Another node checkpoints while this one reads the latest version back, before the
+	// compaction takes ownership of the file
+	var once sync.Once
+	var otherErr error
+	compacting := &slowStore{ObjectStore: store, onGet: func() {
+		once.Do(func() {
+			otherErr = other.WriteFile(ctx, filename, []byte("version 4"), 0)
+			if otherErr == nil {
+				_, otherErr = other.Checkpoint(ctx, filename, "v4")
+			}
+		})
+	}}
+	sm, smCleanup := quackfstest.SetupStorageManagerWithStore(t, compacting)
+	defer smCleanup()
+
+	assert.Error(t, sm.Compact(ctx, filename), "the compaction would revert v4")
+	require.NoError(t, otherErr)
+
+	data, err := sm.ReadFile(ctx, filename, 0, 9)
+	require.NoError(t, err)
+	assert.Equal(t, "version 4", string(data))
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	assert.Len(t, versions, 4)
+
+	version, err := sm.Checkpoint(ctx, filename, "v5")
+	require.NoError(t, err)
+	assert.Empty(t, version, "the compacted content should be discarded")
+}
+
+func TestRevert(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_revert.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	dataV1 := []byte("version one")
+	require.NoError(t, sm.WriteFile(ctx, filename, dataV1, 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	dataV2 := []byte("VERSION TWO, which is longer")
+	require.NoError(t, sm.WriteFile(ctx, filename, dataV2, 0))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	// Reverting works while time traveling, and brings the file out of read-only mode
+	require.NoError(t, sm.SetHead(ctx, filename, "v2"))
+	require.NoError(t, sm.Revert(ctx, filename, "v1", "v3"))
+	defer sm.DeleteHead(ctx, filename)
+
+	head, err := sm.GetHead(ctx, filename)
+	require.NoError(t, err)
+	assert.Empty(t, head, "Revert should clear the head")
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, "v3", versions[0].Tag, "Versions are listed newest first")
+
+	// The file doesn't shrink, the bytes written after v1 are zeroed
+	size, err := sm.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(dataV2)), size)
+
+	content, err := sm.ReadFile(ctx, filename, 0, size)
+	require.NoError(t, err)
+	expected := append(slices.Clone(dataV1), make([]byte, len(dataV2)-len(dataV1))...)
+	assert.Equal(t, expected, content, "Latest version should have v1's content")
+
+	// Older versions are untouched
+	content, err = sm.ReadFile(ctx, filename, 0, uint64(len(dataV2)), storage.WithVersion("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, dataV2, content)
+
+	// Writes continue on top of the reverted content
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("VERSION"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v4")
+	require.NoError(t, err)
+
+	content, err = sm.ReadFile(ctx, filename, 0, uint64(len(dataV1)))
+	require.NoError(t, err)
+	assert.Equal(t, "VERSION one", string(content))
+
+	// A revert that can't create its version leaves the file as it was
+	err = sm.Revert(ctx, filename, "v1", "v2")
+	assert.ErrorIs(t, err, types.ErrVersionExists)
+	content, err = sm.ReadFile(ctx, filename, 0, uint64(len(dataV1)))
+	require.NoError(t, err)
+	assert.Equal(t, "VERSION one", string(content))
+
+	err = sm.Revert(ctx, filename, "missing", "v5")
+	assert.Error(t, err, "Reverting to a missing version should fail")
+}
+
+func TestRevertConcurrentWrite(t *testing.T) {
+	store := &slowStore{ObjectStore: quackfstest.MemoryStore()}
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_revert_concurrent.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("version one"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("VERSION TWO"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	// A revert that can't create its version puts the head back
+	require.NoError(t, sm.SetHead(ctx, filename, "v2"))
+	err = sm.Revert(ctx, filename, "v1", "v2")
+	assert.ErrorIs(t, err, types.ErrVersionExists)
+	head, err := sm.GetHead(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", head)
+	require.NoError(t, sm.DeleteHead(ctx, filename))
+
+	// Write while the revert reads the target version back, slowly enough for the write to
+	// be waiting for it
+	var once sync.Once
+	started := make(chan struct{})
+	store.delay = 50 * time.Millisecond
+	store.onGet = func() {
+		once.Do(func() { close(started) })
+	}
+	writeErr := make(chan error, 1)
+	go func() {
+		<-started
+		writeErr <- sm.WriteFile(ctx, filename, []byte("concurrent"), 0)
+	}()
+
+	require.NoError(t, sm.Revert(ctx, filename, "v1", "v3"))
+	assert.ErrorIs(t, <-writeErr, types.ErrFileBusy, "the write would be overwritten by the revert")
+
+	content, err := sm.ReadFile(ctx, filename, 0, 11)
+	require.NoError(t, err)
+	assert.Equal(t, "version one", string(content))
+
+	// Once reverted, the file can be written again
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("VERSION"), 0))
+	content, err = sm.ReadFile(ctx, filename, 0, 11)
+	require.NoError(t, err)
+	assert.Equal(t, "VERSION one", string(content))
+}
+
+func TestBranches(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_branches.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("first"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("again"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	branch, err := sm.GetCurrentBranch(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, "main", branch, "Files should start on the main branch")
+
+	require.NoError(t, sm.CreateBranch(ctx, filename, "old", "v1"))
+	defer sm.DeleteBranch(ctx, filename, "old")
+	assert.Error(t, sm.CreateBranch(ctx, filename, "old", "v2"), "Creating an existing branch should fail")
+	assert.Error(t, sm.CreateBranch(ctx, filename, "missing", "v3"), "Creating a branch of a missing version should fail")
+	assert.Error(t, sm.SwitchBranch(ctx, filename, "missing"), "Switching to a missing branch should fail")
+
+	// Creating a branch doesn't change what is read
+	content, err := sm.ReadFile(ctx, filename, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(content))
+
+	require.NoError(t, sm.SwitchBranch(ctx, filename, "old"))
+	branch, err = sm.GetCurrentBranch(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, "old", branch)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(content), "Reads should resolve against the current branch")
+
+	err = sm.WriteFile(ctx, filename, []byte("nope"), 0)
+	assert.Error(t, err, "Branches pointing to a version are read-only")
+	assert.Error(t, sm.DeleteBranch(ctx, filename, "old"), "The current branch can't be deleted")
+
+	// The single head API works with the main branch, whatever the current branch is
+	head, err := sm.GetHead(ctx, filename)
+	require.NoError(t, err)
+	assert.Empty(t, head)
+
+	require.NoError(t, sm.SetHead(ctx, filename, "v2"))
+	defer sm.DeleteHead(ctx, filename)
+	head, err = sm.GetHead(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", head)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(content), "Setting the main head should not affect other branches")
+
+	heads, err := sm.GetAllHeads(ctx)
+	require.NoError(t, err)
+	branches := map[string]string{}
+	for _, h := range heads {
+		if h.FileName == filename {
+			branches[h.Branch] = h.VersionTag
+		}
+	}
+	assert.Equal(t, map[string]string{"main": "v2", "old": "v1"}, branches)
+
+	// Back on main without a head, the latest version is read and written
+	require.NoError(t, sm.SwitchBranch(ctx, filename, "main"))
+	require.NoError(t, sm.DeleteHead(ctx, filename))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("third"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v3")
+	require.NoError(t, err)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "third", string(content))
+
+	require.NoError(t, sm.DeleteBranch(ctx, filename, "old"))
+	head, err = sm.GetBranchHead(ctx, filename, "old")
+	require.NoError(t, err)
+	assert.Empty(t, head)
+}
+
+func TestGetAllVersions(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	checkpoints := []struct{ filename, tag string }{
+		{"testfile_all_versions_a.duckdb", "v1"},
+		{"testfile_all_versions_b.duckdb", "v1"},
+		{"testfile_all_versions_a.duckdb", "v2"},
+	}
+
+	for _, file := range []string{checkpoints[0].filename, checkpoints[1].filename} {
+		_, err := sm.InsertFile(ctx, file)
+		require.NoError(t, err)
+	}
+
+	for _, c := range checkpoints {
+		require.NoError(t, sm.WriteFile(ctx, c.filename, []byte("data"), 0))
+		_, err := sm.Checkpoint(ctx, c.filename, c.tag)
+		require.NoError(t, err)
+	}
+
+	versions, err := sm.GetAllVersions(ctx)
+	require.NoError(t, err)
+	require.Len(t, versions, len(checkpoints))
+
+	for i, c := range checkpoints {
+		assert.Equal(t, c.filename, versions[i].FileName, "Versions should be in creation order")
+		assert.Equal(t, c.tag, versions[i].Tag)
+		assert.NotEmpty(t, versions[i].ObjectKey)
+		assert.False(t, versions[i].CreatedAt.IsZero())
+	}
+
+	// Walking stops at the first error
+	stop := errors.New("stop")
+	walked := 0
+	err = sm.WalkVersions(ctx, func(v storage.VersionInfo) error {
+		walked++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, walked)
+}
+
+func TestRenameFile(t *testing.T) {
+	store := objectstore.NewMemory()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	ctx := context.Background()
+	oldName := "testfile_rename_a.duckdb"
+	newName := "testfile_rename_b.duckdb"
+
+	// The file renamed over
+	replacedID, err := sm.InsertFile(ctx, newName)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, newName, []byte("replaced file"), 0))
+	_, err = sm.Checkpoint(ctx, newName, "v1")
+	require.NoError(t, err)
+
+	fileID, err := sm.InsertFile(ctx, oldName)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, oldName, []byte("file"), 0))
+	_, err = sm.Checkpoint(ctx, oldName, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, oldName, []byte(" and uncommitted data"), 4))
+
+	require.NoError(t, sm.RenameFile(ctx, oldName, newName))
+
+	_, err = sm.GetFileID(ctx, oldName)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	id, err := sm.GetFileID(ctx, newName)
+	require.NoError(t, err)
+	assert.Equal(t, fileID, id, "The renamed file should keep its ID")
+	assert.NotEqual(t, replacedID, id)
+
+	content, err := sm.ReadFile(ctx, newName, 0, 25)
+	require.NoError(t, err)
+	assert.Equal(t, "file and uncommitted data", string(content), "Uncommitted writes should be kept")
+
+	// Callers holding the ID of the replaced file, or of the old name, are told it's stale
+	_, err = sm.ReadFile(ctx, newName, 0, 4, storage.WithReadFileID(replacedID))
+	assert.ErrorIs(t, err, types.ErrStaleFile)
+	err = sm.WriteFile(ctx, newName, []byte("data"), 0, storage.WithWriteFileID(replacedID))
+	assert.ErrorIs(t, err, types.ErrStaleFile)
+	_, err = sm.ReadFile(ctx, oldName, 0, 4, storage.WithReadFileID(fileID))
+	assert.ErrorIs(t, err, types.ErrStaleFile)
+
+	content, err = sm.ReadFile(ctx, newName, 0, 4, storage.WithReadFileID(fileID))
+	require.NoError(t, err)
+	assert.Equal(t, "file", string(content))
+	require.NoError(t, sm.WriteFile(ctx, newName, []byte("FILE"), 0, storage.WithWriteFileID(fileID)))
+
+	versions, err := sm.GetFileVersions(ctx, newName)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1, "Only the versions of the renamed file should be left")
+
+	// The layer object of the replaced file is garbage
+	deleted, err := sm.GC(ctx)
+	require.NoError(t, err)
+	assert.Len(t, deleted, 1)
+
+	err = sm.RenameFile(ctx, "testfile_rename_missing.duckdb", oldName)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestRenameFileNoReplace(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	oldName := "testfile_rename_noreplace_a.duckdb"
+	newName := "testfile_rename_noreplace_b.duckdb"
+	otherName := "testfile_rename_noreplace_c.duckdb"
+
+	// A file whose data is all in the active layer
+	fileID, err := sm.InsertFile(ctx, oldName)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, oldName, []byte("uncommitted"), 0))
+
+	otherID, err := sm.InsertFile(ctx, otherName)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, otherName, []byte("other file"), 0))
+	_, err = sm.Checkpoint(ctx, otherName, "v1")
+	require.NoError(t, err)
+
+	// Taken names are left alone
+	err = sm.RenameFile(ctx, oldName, otherName, storage.WithNoReplace())
+	assert.ErrorIs(t, err, types.ErrFileExists)
+
+	id, err := sm.GetFileID(ctx, otherName)
+	require.NoError(t, err)
+	assert.Equal(t, otherID, id)
+
+	err = sm.RenameFile(ctx, oldName, "", storage.WithNoReplace())
+	assert.ErrorIs(t, err, types.ErrInvalidFilename)
+
+	require.NoError(t, sm.RenameFile(ctx, oldName, newName, storage.WithNoReplace()))
+
+	_, err = sm.GetFileID(ctx, oldName)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	id, err = sm.GetFileID(ctx, newName)
+	require.NoError(t, err)
+	assert.Equal(t, fileID, id)
+
+	content, err := sm.ReadFile(ctx, newName, 0, 11)
+	require.NoError(t, err)
+	assert.Equal(t, "uncommitted", string(content), "Uncommitted writes should follow the file")
+
+	// They are checkpointed under the new name
+	_, err = sm.Checkpoint(ctx, newName, "v1")
+	require.NoError(t, err)
+
+	content, err = sm.ReadFile(ctx, newName, 0, 11, storage.WithVersion("v1"))
+	require.NoError(t, err)
+	assert.Equal(t, "uncommitted", string(content))
+
+	// A file can be created with the old name again
+	_, err = sm.InsertFile(ctx, oldName)
+	require.NoError(t, err)
+}
+
+func TestFileAttr(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_attr.duckdb"
+
+	fileID, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	attr, err := sm.GetFileAttr(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, fileID, attr.ID)
+	assert.Equal(t, os.FileMode(0644), attr.Mode, "New files should get the default mode")
+	assert.False(t, attr.CreatedAt.IsZero())
+
+	require.NoError(t, sm.SetFileMode(ctx, filename, 0600))
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, sm.SetFileModTime(ctx, filename, modTime))
+
+	attr, err = sm.GetFileAttr(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), attr.Mode)
+	assert.True(t, modTime.Equal(attr.ModifiedAt), "expected %v, got %v", modTime, attr.ModifiedAt)
+
+	// Checkpoints update the modification time
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("data"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	attr, err = sm.GetFileAttr(ctx, filename)
+	require.NoError(t, err)
+	assert.True(t, attr.ModifiedAt.After(modTime), "Checkpoint should update the modification time")
+
+	_, err = sm.GetFileAttr(ctx, "testfile_attr_missing.duckdb")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestXattrs(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_xattrs.duckdb"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	names, err := sm.ListXattrs(ctx, filename)
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	_, err = sm.GetXattr(ctx, filename, "user.missing")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	require.NoError(t, sm.SetXattr(ctx, filename, "user.b", []byte("first")))
+	require.NoError(t, sm.SetXattr(ctx, filename, "user.b", []byte("second")), "Without a mode an existing attribute is replaced")
+	require.NoError(t, sm.SetXattr(ctx, filename, "user.a", []byte{}), "Empty values are allowed")
+
+	value, err := sm.GetXattr(ctx, filename, "user.b")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(value))
+
+	names, err = sm.ListXattrs(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.a", "user.b"}, names, "Names should be sorted")
+
+	// XATTR_CREATE and XATTR_REPLACE
+	err = sm.SetXattr(ctx, filename, "user.b", []byte("third"), storage.WithXattrCreate())
+	assert.ErrorIs(t, err, types.ErrXattrExists)
+	err = sm.SetXattr(ctx, filename, "user.c", []byte("third"), storage.WithXattrReplace())
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	value, err = sm.GetXattr(ctx, filename, "user.b")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(value), "A failed create should keep the value")
+	_, err = sm.GetXattr(ctx, filename, "user.c")
+	assert.ErrorIs(t, err, types.ErrNotFound, "A failed replace should not create the attribute")
+
+	require.NoError(t, sm.SetXattr(ctx, filename, "user.c", []byte("created"), storage.WithXattrCreate()))
+	require.NoError(t, sm.SetXattr(ctx, filename, "user.c", []byte("replaced"), storage.WithXattrReplace()))
+
+	value, err = sm.GetXattr(ctx, filename, "user.c")
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(value))
+
+	// Limits
+	err = sm.SetXattr(ctx, filename, "", []byte("value"))
+	assert.ErrorIs(t, err, types.ErrInvalidXattrName)
+	err = sm.SetXattr(ctx, filename, "user."+strings.Repeat("n", storage.MaxXattrNameLen-4), []byte("value"))
+	assert.ErrorIs(t, err, types.ErrInvalidXattrName)
+	require.NoError(t, sm.SetXattr(ctx, filename, "user."+strings.Repeat("n", storage.MaxXattrNameLen-5), []byte("value")))
+
+	err = sm.SetXattr(ctx, filename, "user.big", make([]byte, storage.MaxXattrValueSize+1))
+	assert.ErrorIs(t, err, types.ErrXattrTooLarge)
+	require.NoError(t, sm.SetXattr(ctx, filename, "user.big", make([]byte, storage.MaxXattrValueSize)))
+	require.NoError(t, sm.RemoveXattr(ctx, filename, "user.big"))
+	require.NoError(t, sm.RemoveXattr(ctx, filename, "user."+strings.Repeat("n", storage.MaxXattrNameLen-5)))
+
+	// Extended attributes aren't versioned
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("data"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	value, err = sm.GetXattr(ctx, filename, "user.b")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(value), "Attributes should survive checkpoints")
+
+	require.NoError(t, sm.RemoveXattr(ctx, filename, "user.a"))
+	err = sm.RemoveXattr(ctx, filename, "user.a")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	// Renaming over a file keeps the attributes of the renamed file only
+	newName := "testfile_xattrs_renamed.duckdb"
+	_, err = sm.InsertFile(ctx, newName)
+	require.NoError(t, err)
+	require.NoError(t, sm.SetXattr(ctx, newName, "user.replaced", []byte("gone")))
+
+	require.NoError(t, sm.RenameFile(ctx, filename, newName))
+
+	names, err = sm.ListXattrs(ctx, newName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.b", "user.c"}, names, "Attributes should survive renames")
+
+	value, err = sm.GetXattr(ctx, newName, "user.c")
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(value))
+
+	_, err = sm.ListXattrs(ctx, filename)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+	err = sm.SetXattr(ctx, filename, "user.b", []byte("value"))
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestStats(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, "testfile_stats_a")
+	require.NoError(t, err)
+	_, err = sm.InsertFile(ctx, "testfile_stats_b")
+	require.NoError(t, err)
+
+	require.NoError(t, sm.WriteFile(ctx, "testfile_stats_a", []byte("0123456789"), 0))
+	_, err = sm.Checkpoint(ctx, "testfile_stats_a", "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, "testfile_stats_a", []byte("abcde"), 10))
+	_, err = sm.Checkpoint(ctx, "testfile_stats_a", "v2")
+	require.NoError(t, err)
+
+	// Uncommitted writes are only in the memtable
+	require.NoError(t, sm.WriteFile(ctx, "testfile_stats_a", []byte("xyz"), 0))
+	require.NoError(t, sm.WriteFile(ctx, "testfile_stats_b", []byte("1234"), 0))
+
+	stats, err := sm.Stats(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, storage.ManagerStats{
+		Files:         2,
+		Versions:      2,
+		SealedLayers:  2,
+		MemtableBytes: 7,
+		ObjectBytes:   15,
+		PerFile: []storage.FileStats{
+			{Name: "testfile_stats_a", Versions: 2, SealedLayers: 2, MemtableBytes: 3, ObjectBytes: 15},
+			{Name: "testfile_stats_b", Versions: 0, SealedLayers: 0, MemtableBytes: 4, ObjectBytes: 0},
+		},
+	}, stats)
+}
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	sm, cleanup := quackfstest.SetupStorageManager(t, storage.WithMetrics(registry), storage.WithReadCache(1<<20))
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_metrics"
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("0123456789"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err = sm.ReadFile(ctx, filename, 0, 10)
+		require.NoError(t, err)
+	}
+
+	var out bytes.Buffer
+	_, err = registry.WriteTo(&out)
+	require.NoError(t, err)
+
+	body := out.String()
+	assert.Contains(t, body, "quackfs_write_bytes_total 10\n")
+	assert.Contains(t, body, "quackfs_read_bytes_total 20\n")
+	assert.Contains(t, body, "quackfs_chunk_cache_hits_total 1\n")
+	assert.Contains(t, body, "quackfs_chunk_cache_misses_total 1\n")
+	assert.Contains(t, body, "quackfs_checkpoint_duration_seconds_count 1\n")
+	assert.Contains(t, body, `quackfs_object_store_request_duration_seconds_count{operation="put",result="ok"} 1`+"\n")
+	assert.Contains(t, body, `quackfs_object_store_request_duration_seconds_count{operation="get",result="ok"} 1`+"\n")
+}
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("healthy", func(t *testing.T) {
+		sm, cleanup := quackfstest.SetupStorageManager(t)
+		defer cleanup()
+
+		require.NoError(t, sm.Health(ctx))
+	})
+
+	t.Run("failing object store", func(t *testing.T) {
+		store := &flakyStore{ObjectStore: objectstore.NewMemory()}
+		sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+		defer cleanup()
+
+		store.failGets.Store(true)
+
+		err := sm.Health(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "object store is unreachable")
+		assert.NotContains(t, err.Error(), "metadata store")
+	})
+
+	t.Run("unreachable database", func(t *testing.T) {
+		db, err := sql.Open("postgres", "host=localhost dbname=quackfs sslmode=disable")
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		sm := storage.NewManager(db, objectstore.NewMemory(), logger.New(os.Stderr))
+
+		err = sm.Health(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "metadata store is unreachable")
+		assert.NotContains(t, err.Error(), "object store")
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	// Migrate an empty schema of the test database
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+
+	migrateDB, cleanup := openTestSchema(t, db, "migrate_test")
+	defer cleanup()
+
+	sm := storage.NewManager(migrateDB, objectstore.NewMemory(), logger.New(os.Stderr))
+	require.NoError(t, sm.Migrate(ctx))
+
+	rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = 'migrate_test'")
+	require.NoError(t, err)
+	var tables []string
+	for rows.Next() {
+		var table string
+		require.NoError(t, rows.Scan(&table))
+		tables = append(tables, table)
+	}
+	require.NoError(t, rows.Err())
+	assert.ElementsMatch(t, []string{"files", "versions", "snapshot_layers", "chunks", "write_origins", "heads", "file_xattrs", "schema_migrations"}, tables)
+
+	migrations, err := filepath.Glob("../../db/migrations/*.sql")
+	require.NoError(t, err)
+	var applied int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM migrate_test.schema_migrations").Scan(&applied))
+	assert.Equal(t, len(migrations), applied, "the schema includes every migration")
+
+	_, err = sm.InsertFile(ctx, "testfile_migrate")
+	require.NoError(t, err, "the migrated schema should be usable")
+
+	require.NoError(t, sm.Migrate(ctx), "migrating an up to date schema should do nothing")
+
+	// Undo the last migration, as if the database was created by an older version
+	_, err = db.Exec("ALTER TABLE migrate_test.versions DROP COLUMN origin; DELETE FROM migrate_test.schema_migrations WHERE version = '0008_add_version_origin'")
+	require.NoError(t, err)
+
+	require.NoError(t, sm.Migrate(ctx))
+
+	var hasOrigin bool
+	require.NoError(t, db.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns WHERE table_schema = 'migrate_test' AND table_name = 'versions' AND column_name = 'origin'
+	)`).Scan(&hasOrigin))
+	assert.True(t, hasOrigin, "the missing migration should be applied")
+
+	// Upgrade a database created with the first schema, before any migration existed, holding
+	// a version written back then
+	baseline, err := os.ReadFile("testdata/baseline_schema.sql")
+	require.NoError(t, err)
+
+	baselineDB, cleanupBaseline := openTestSchema(t, db, "migrate_baseline_test")
+	defer cleanupBaseline()
+
+	_, err = baselineDB.Exec(string(baseline))
+	require.NoError(t, err)
+	_, err = baselineDB.Exec(`
+		INSERT INTO files (name) VALUES ('testfile_baseline');
+		INSERT INTO versions (tag) VALUES ('v1');
+		INSERT INTO snapshot_layers (file_id, active, version_id, object_key)
+			SELECT f.id, 0, v.id, 'layers/testfile_baseline/v1' FROM files f, versions v;
+		INSERT INTO chunks (snapshot_layer_id, layer_range, file_range)
+			SELECT id, '[0,8)', '[0,8)' FROM snapshot_layers`)
+	require.NoError(t, err)
+
+	store := objectstore.NewMemory()
+	require.NoError(t, store.PutObject(ctx, "layers/testfile_baseline/v1", []byte("baseline")))
+
+	sm = storage.NewManager(baselineDB, store, logger.New(os.Stderr))
+	require.NoError(t, sm.Migrate(ctx))
+
+	var objectRange string
+	require.NoError(t, baselineDB.QueryRow("SELECT object_range::text FROM chunks").Scan(&objectRange))
+	assert.Equal(t, "[0,8)", objectRange, "existing chunks are stored at their layer range")
+
+	filename := "testfile_baseline"
+	data, err := sm.ReadFile(ctx, filename, 0, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "baseline", string(data), "the version written before the upgrade should still be readable")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("upgraded"), 8))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	data, err = sm.ReadFile(ctx, filename, 0, 16)
+	require.NoError(t, err)
+	assert.Equal(t, "baselineupgraded", string(data), "the upgraded schema should be usable")
+}
+
+// openTestSchema (re)creates an empty schema in the test database and opens a connection
+// using it, falling back to public for btree_gist. The cleanup function closes the connection
+// and drops the schema.
+func openTestSchema(t *testing.T, db *sql.DB, schema string) (*sql.DB, func()) {
+	t.Helper()
+
+	_, err := db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %[1]s CASCADE; CREATE SCHEMA %[1]s", schema))
+	require.NoError(t, err)
+
+	connURL, err := url.Parse(quackfstest.GetTestConnectionString(t))
+	require.NoError(t, err, "POSTGRES_TEST_CONN should be a URL")
+	query := connURL.Query()
+	query.Set("search_path", schema+",public")
+	connURL.RawQuery = query.Encode()
+
+	schemaDB, err := sql.Open("postgres", connURL.String())
+	require.NoError(t, err)
+
+	return schemaDB, func() {
+		schemaDB.Close()
+		db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	}
+}
+
+func TestConnPoolConcurrentReads(t *testing.T) {
+	store := &slowStore{ObjectStore: quackfstest.MemoryStore(), delay: 20 * time.Millisecond}
+	// Far fewer connections than concurrent reads, each holding one during its slow fetch
+	mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithConnPool(2, 1, time.Minute))
+	defer cleanup()
+
+	filename := "testfile_conn_pool"
+	ctx := context.Background()
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("pooled data"), 0))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	const readers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := mgr.ReadFile(ctx, filename, 0, 11)
+			if err == nil && string(data) != "pooled data" {
+				err = fmt.Errorf("read %q", data)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err, "every read should complete even though they wait for a connection")
+	}
+	assert.EqualValues(t, readers, store.gets.Load())
+}
+
+func TestCachedFileSize(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	filename := "testfile_cached_size"
+
+	fileID, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err, "Failed to insert file")
+
+	// The size computed from every chunk of the file, committed or not
+	computedSize := func() uint64 {
+		infos, err := mgr.ListChunks(ctx, filename)
+		require.NoError(t, err)
+		var size uint64
+		for _, info := range infos {
+			size = max(size, info.FileRange[1])
+		}
+		return size
+	}
+	checkSize := func(step string) {
+		size, err := mgr.SizeOf(ctx, filename)
+		require.NoError(t, err)
+		assert.Equal(t, computedSize(), size, "size after %s", step)
+
+		var committed, cached uint64
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT COALESCE(MAX(UPPER(c.file_range)), 0) FROM chunks c
+			INNER JOIN snapshot_layers l ON c.snapshot_layer_id = l.id WHERE l.file_id = $1`, fileID).Scan(&committed))
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT current_size FROM files WHERE id = $1`, fileID).Scan(&cached))
+		assert.Equal(t, committed, cached, "cached size of the committed chunks after %s", step)
+	}
+
+	checkSize("insert")
+
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("hello"), 0))
+	checkSize("append")
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("world"), 100))
+	checkSize("write past the end")
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("HE"), 0, storage.WithInPlaceOverwrite()))
+	checkSize("in place overwrite")
+
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err, "Failed to checkpoint")
+	checkSize("checkpoint")
+
+	// Overwriting the start of the file in a new layer doesn't shrink it
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("short"), 0))
+	checkSize("overwrite")
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err, "Failed to checkpoint")
+	checkSize("checkpoint of a smaller layer")
+
+	require.NoError(t, mgr.WriteFileBatch(ctx, filename, []storage.WriteOp{
+		{Offset: 200, Data: []byte("batch")},
+		{Offset: 50, Data: []byte("middle")},
+	}))
+	checkSize("batch")
+	_, err = mgr.Checkpoint(ctx, filename, "v3")
+	require.NoError(t, err, "Failed to checkpoint")
+	checkSize("last checkpoint")
+
+	// A new manager, e.g. after a restart, gets the same size
+	mgr2, cleanup2 := quackfstest.SetupStorageManager(t)
+	defer cleanup2()
+	size, err := mgr2.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(205), size)
+}
+
+// BenchmarkSizeOf compares computing the size of a file with many chunks from its chunks, as
+// SizeOf (and so every FUSE Attr) used to, with reading the size cached on the file.
+func BenchmarkSizeOf(b *testing.B) {
+	sm, cleanup := quackfstest.SetupStorageManager(b)
+	defer cleanup()
+
+	db := quackfstest.SetupDB(b)
+	defer db.Close()
+
+	filename := "benchfile_size_of"
+	ctx := context.Background()
+
+	fileID, err := sm.InsertFile(ctx, filename)
+	require.NoError(b, err)
+
+	// Backward writes keep every page in a chunk of its own
+	const versions = 50
+	const pages = 100
+	for v := range versions {
+		for i := pages - 1; i >= 0; i-- {
+			require.NoError(b, sm.WriteFile(ctx, filename, []byte{byte(v)}, uint64(v*pages+i)*4096))
+		}
+		_, err = sm.Checkpoint(ctx, filename, fmt.Sprintf("v%d", v+1))
+		require.NoError(b, err)
+	}
+	require.NoError(b, sm.WriteFile(ctx, filename, []byte("uncommitted"), 0))
+
+	want, err := sm.SizeOf(ctx, filename)
+	require.NoError(b, err)
+
+	b.Run("computed from chunks", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var size uint64
+			require.NoError(b, db.QueryRowContext(ctx, `SELECT UPPER(e.file_range)::BIGINT FROM chunks e
+				INNER JOIN snapshot_layers l ON e.snapshot_layer_id = l.id WHERE l.file_id = $1
+				ORDER BY UPPER(e.file_range) DESC LIMIT 1`, fileID).Scan(&size))
+			require.Equal(b, want, size)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			size, err := sm.SizeOf(ctx, filename)
+			require.NoError(b, err)
+			require.Equal(b, want, size)
+		}
+	})
+}
+
+// BenchmarkLatestVersion compares finding the latest version of a file with many versions by
+// loading all of its layers, as ApplyVersionDelta used to, with the GetLatestVersion query.
+func BenchmarkLatestVersion(b *testing.B) {
+	sm, cleanup := quackfstest.SetupStorageManager(b)
+	defer cleanup()
+
+	filename := "benchfile_latest_version"
+	ctx := context.Background()
+
+	fileID, err := sm.InsertFile(ctx, filename)
+	require.NoError(b, err)
+
+	const versions = 200
+	for i := range versions {
+		require.NoError(b, sm.WriteFile(ctx, filename, []byte{byte(i)}, uint64(i)))
+		_, err = sm.Checkpoint(ctx, filename, fmt.Sprintf("v%d", i+1))
+		require.NoError(b, err)
+	}
+
+	b.Run("load all layers", func(b *testing.B) {
+		var rows int
+		for i := 0; i < b.N; i++ {
+			layers, err := sm.LoadLayersByFileID(ctx, fileID)
+			require.NoError(b, err)
+			require.Equal(b, fmt.Sprintf("v%d", versions), layers[len(layers)-1].Tag)
+			rows += len(layers)
+		}
+		b.ReportMetric(float64(rows)/float64(b.N), "rows/op")
+	})
+
+	b.Run("latest version query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tag, err := sm.GetLatestVersion(ctx, filename)
+			require.NoError(b, err)
+			require.Equal(b, fmt.Sprintf("v%d", versions), tag)
+		}
+		b.ReportMetric(1, "rows/op")
+	})
+}
+
+func TestReadActiveLayerBeyondSealedChunks(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_active_beyond_sealed"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("0123456789"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	// Uncommitted write overlapping the sealed chunk and extending well past it
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("abcdefghijklmnopqrstuvwxy"), 5))
+
+	data, err := sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "01234abcdefghijklmnopqrstuvwxy", string(data), "the active layer's trailing bytes should be read")
+
+	data, err = sm.ReadFile(ctx, filename, 8, 20)
+	require.NoError(t, err)
+	assert.Equal(t, "defghijklmnopqrstuvw", string(data))
+
+	data, err = sm.ReadFile(ctx, filename, 25, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "uvwxy", string(data))
+
+	data, err = sm.ReadFile(ctx, filename, 100, 10)
+	require.NoError(t, err, "reading past the end of the file should not fail")
+	assert.Empty(t, data)
+}
+
+// countingConnector opens PostgreSQL connections counting the transactions and statements
+// they run. database/sql prepares each statement since countingConn doesn't run queries itself.
+type countingConnector struct {
+	driver.Connector
+	statements atomic.Int64
+}
+
+func (c *countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, statements: &c.statements}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+	statements *atomic.Int64
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.statements.Add(1)
+	return c.Conn.Prepare(query)
+}
+
+func (c *countingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.statements.Add(1)
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+// hungConnector stands in for an unresponsive PostgreSQL: connecting blocks until the context is done.
+type hungConnector struct{}
+
+func (hungConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungConnector) Driver() driver.Driver {
+	return hungDriver{}
+}
+
+type hungDriver struct{}
+
+func (hungDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("hungDriver only connects through hungConnector")
+}
+
+func TestOperationTimeout(t *testing.T) {
+	db := sql.OpenDB(hungConnector{})
+	defer db.Close()
+
+	const timeout = 100 * time.Millisecond
+	sm := storage.NewManager(db, quackfstest.MemoryStore(), logger.New(os.Stderr), storage.WithOperationTimeout(timeout))
+
+	filename := "testfile_op_timeout"
+	operations := map[string]func(ctx context.Context) error{
+		"InsertFile": func(ctx context.Context) error {
+			_, err := sm.InsertFile(ctx, filename)
+			return err
+		},
+		"WriteFile": func(ctx context.Context) error {
+			return sm.WriteFile(ctx, filename, []byte("data"), 0)
+		},
+		"ReadFile": func(ctx context.Context) error {
+			_, err := sm.ReadFile(ctx, filename, 0, 4)
+			return err
+		},
+		"SizeOf": func(ctx context.Context) error {
+			_, err := sm.SizeOf(ctx, filename)
+			return err
+		},
+		"Checkpoint": func(ctx context.Context) error {
+			_, err := sm.Checkpoint(ctx, filename, "v1")
+			return err
+		},
+	}
+
+	for name, op := range operations {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			err := op(context.Background())
+			elapsed := time.Since(start)
+
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.GreaterOrEqual(t, elapsed, timeout, "the operation should run until the timeout")
+			assert.Less(t, elapsed, 10*timeout, "the operation should give up at the timeout")
+		})
+	}
+
+	// The deadline of the caller applies when it's earlier
+	ctx, cancel := context.WithTimeout(context.Background(), timeout/10)
+	defer cancel()
+
+	start := time.Now()
+	_, err := sm.SizeOf(ctx, filename)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), timeout, "the caller's deadline should take precedence")
+}
+
+func TestReadUncommittedFileFromMemory(t *testing.T) {
+	_, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	pqConnector, err := pq.NewConnector(quackfstest.GetTestConnectionString(t))
+	require.NoError(t, err)
+	connector := &countingConnector{Connector: pqConnector}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	sm := storage.NewManager(db, quackfstest.MemoryStore(), logger.New(os.Stderr))
+
+	filename := "testfile_uncommitted_read"
+	ctx := context.Background()
+
+	_, err = sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("0123456789"), 0))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("abc"), 4))
+
+	connector.statements.Store(0)
+
+	var stats storage.ReadStats
+	data, err := sm.ReadFile(ctx, filename, 2, 6, storage.WithReadStats(&stats))
+	require.NoError(t, err)
+	assert.Equal(t, "23abc7", string(data))
+	assert.Equal(t, 2, stats.ChunksScanned)
+
+	data, err = sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "0123abc789", string(data))
+
+	data, err = sm.ReadFile(ctx, filename, 50, 10)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	assert.Zero(t, connector.statements.Load(), "reads of a file without versions shouldn't query the metadata store")
+
+	// Once the file has a version, its chunks have to be queried
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("XY"), 10))
+
+	connector.statements.Store(0)
+
+	data, err = sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "0123abc789XY", string(data))
+	assert.NotZero(t, connector.statements.Load())
+}
+
+// TestOverlappingWritesBeforeCheckpoint writes the same offsets several times within a single
+// active layer, relying on its chunks staying in write order for the last write to win.
+func TestOverlappingWritesBeforeCheckpoint(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	for _, committed := range []bool{false, true} {
+		filename := fmt.Sprintf("testfile_overlapping_writes_%t", committed)
+
+		_, err := sm.InsertFile(ctx, filename)
+		require.NoError(t, err)
+
+		if committed {
+			// Reads then merge the chunks of the metadata store with those of the active layer
+			require.NoError(t, sm.WriteFile(ctx, filename, []byte("................"), 0))
+			_, err = sm.Checkpoint(ctx, filename, "v1")
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, sm.WriteFile(ctx, filename, []byte("first write"), 2))
+		require.NoError(t, sm.WriteFile(ctx, filename, []byte("SECOND"), 2))
+		require.NoError(t, sm.WriteFile(ctx, filename, []byte("third"), 6))
+
+		want := "..SECOthirdte..."
+		if !committed {
+			want = "\x00\x00SECOthirdte" // zero filled up to the first write
+		}
+
+		data, err := sm.ReadFile(ctx, filename, 0, 100)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data), "later writes should win (committed=%t)", committed)
+
+		_, err = sm.Checkpoint(ctx, filename, "overlapping")
+		require.NoError(t, err, "the active layer should pass the chunk ordering check")
+
+		data, err = sm.ReadFile(ctx, filename, 0, 100)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data), "the checkpointed version should read the same (committed=%t)", committed)
+	}
+}
+
+// TestPartialOverwriteAcrossSealedLayers overwrites the middle of a sealed chunk with a smaller
+// one in another sealed layer, so reads have to interleave the data of both layer objects.
+func TestPartialOverwriteAcrossSealedLayers(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_partial_overwrite_sealed"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	writeVersion := func(data string, offset uint64, version string) {
+		require.NoError(t, sm.WriteFile(ctx, filename, []byte(data), offset))
+		_, err := sm.Checkpoint(ctx, filename, version)
+		require.NoError(t, err)
+	}
+
+	writeVersion(strings.Repeat("a", 100), 0, "v1")
+	writeVersion(strings.Repeat("b", 10), 40, "v2") // in the middle of v1
+	writeVersion(strings.Repeat("c", 30), 90, "v3") // over the end of v1
+	writeVersion(strings.Repeat("d", 5), 45, "v4")  // in the middle of v2
+	want := strings.Repeat("a", 40) + "bbbbb" + "ddddd" + strings.Repeat("a", 40) + strings.Repeat("c", 30)
+
+	for _, r := range [][2]uint64{
+		{0, 120},  // everything
+		{42, 6},   // starts and ends inside chunks of v2 and v4, both after the start of v1's chunk
+		{47, 10},  // starts in v4's chunk, ends in v1's tail
+		{49, 1},   // last byte of v4's chunk
+		{50, 1},   // first byte of v1's tail
+		{89, 2},   // across the end of v1's last shown byte and v3
+		{95, 100}, // starts inside v3's chunk, past the end of the file
+	} {
+		var stats storage.ReadStats
+		data, err := sm.ReadFile(ctx, filename, r[0], r[1], storage.WithReadStats(&stats))
+		require.NoError(t, err)
+		end := min(r[0]+r[1], uint64(len(want)))
+		assert.Equal(t, want[r[0]:end], string(data), "read of %d bytes at %d", r[1], r[0])
+	}
+
+	var stats storage.ReadStats
+	_, err = sm.ReadFile(ctx, filename, 0, 120, storage.WithReadStats(&stats))
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.LayersTouched, "every layer object should contribute to the read")
+
+	// Reading v2 ignores the later layers
+	data, err := sm.ReadFile(ctx, filename, 35, 20, storage.WithVersion("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaabbbbbbbbbbaaaaa", string(data))
+}
+
+func TestReadWithVersionOverridesHead(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_version_over_head"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("version one"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("VERSION TWO"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	require.NoError(t, sm.SetHead(ctx, filename, "v1"))
+	defer sm.DeleteHead(ctx, filename)
+
+	data, err := sm.ReadFile(ctx, filename, 0, 11)
+	require.NoError(t, err)
+	assert.Equal(t, "version one", string(data), "without a version the head is read")
+
+	data, err = sm.ReadFile(ctx, filename, 0, 11, storage.WithVersion("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, "VERSION TWO", string(data), "an explicit version should win over the head")
+
+	head, err := sm.GetHead(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", head, "reading a version should not move the head")
+
+	_, err = sm.ReadFile(ctx, filename, 0, 11, storage.WithVersion("v9"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "version tag not found")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+// shortStore wraps an object store and drops the last byte of the first shortReads ranges it
+// returns
+type shortStore struct {
+	objectstore.ObjectStore
+	shortReads atomic.Int64
+	gets       atomic.Int64
+}
+
+func (s *shortStore) GetObject(ctx context.Context, key string, dataRange [2]uint64) ([]byte, error) {
+	s.gets.Add(1)
+	data, err := s.ObjectStore.GetObject(ctx, key, dataRange)
+	if err == nil && len(data) > 0 && s.shortReads.Add(-1) >= 0 {
+		data = data[:len(data)-1]
+	}
+	return data, err
+}
+
+func TestShortObjectStoreReads(t *testing.T) {
+	store := &shortStore{ObjectStore: objectstore.NewMemory()}
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store)
+	defer cleanup()
+
+	filename := "testfile_short_reads"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("0123456789"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	// A single short read is retried
+	store.shortReads.Store(1)
+	store.gets.Store(0)
+
+	data, err := sm.ReadFile(ctx, filename, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.EqualValues(t, 2, store.gets.Load())
+
+	// A second one fails the read, saying which bytes of which object were short
+	store.shortReads.Store(2)
+	store.gets.Store(0)
+
+	_, err = sm.ReadFile(ctx, filename, 0, 10)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, types.ErrShortRead)
+	assert.Contains(t, err.Error(), "sealed layer")
+	assert.Contains(t, err.Error(), "layers/")
+	assert.Contains(t, err.Error(), "bytes 0-9 (inclusive): got 9 bytes, expected 10")
+	assert.EqualValues(t, 2, store.gets.Load(), "a short read should be retried only once")
+}
+
+func TestJournalReplay(t *testing.T) {
+	store := quackfstest.MemoryStore()
+	dir := t.TempDir()
+	sm, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, storage.WithJournal(dir))
+	defer cleanup()
+
+	filename := "testfile_journal.duckdb"
+	ctx := context.Background()
+
+	fileID, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("checkpointed"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "Checkpointed writes should leave no journal behind")
+
+	// Uncommitted writes of every kind
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("CHECK"), 0))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("ch"), 0, storage.WithInPlaceOverwrite()))
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("gap"), 16))
+	require.NoError(t, sm.WriteFileBatch(ctx, filename, []storage.WriteOp{
+		{Offset: 5, Data: []byte("P")},
+		{Offset: 19, Data: []byte("!")},
+	}))
+
+	expected := []byte("chECKPointed\x00\x00\x00\x00gap!")
+	content, err := sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, expected, content)
+
+	// The process crashes in the middle of journaling a write
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	journalPath := filepath.Join(dir, entries[0].Name())
+	info, err := os.Stat(journalPath)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("torn write"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// A new manager replays the journal, without the torn write
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+	restarted := storage.NewManager(db, store, logger.New(os.Stderr), storage.WithJournal(dir))
+
+	content, err = restarted.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content, "Uncommitted writes should be recovered from the journal")
+
+	size, err := restarted.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(expected)), size)
+
+	truncated, err := os.Stat(journalPath)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), truncated.Size(), "The torn write should be dropped from the journal")
+
+	// Once checkpointed, the writes aren't replayed anymore
+	_, err = restarted.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	content, err = restarted.ReadFile(ctx, filename, 0, 100, storage.WithVersion("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	again := storage.NewManager(db, store, logger.New(os.Stderr), storage.WithJournal(dir))
+	assert.Empty(t, again.GetActiveLayerData(ctx, fileID))
+}
+
+func TestPunchHole(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_hole.duckdb"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("0123456789abcdef"), 0))
+	_, err = sm.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+
+	// The data of the earlier layer is hidden by the hole, past the end of the file is ignored
+	require.NoError(t, sm.PunchHole(ctx, filename, 4, 8))
+	require.NoError(t, sm.PunchHole(ctx, filename, 14, 100))
+
+	expected := []byte("0123\x00\x00\x00\x00\x00\x00\x00\x00cd\x00\x00")
+	content, err := sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content, "Punched ranges should read as zeroes before checkpoint")
+
+	content, err = sm.ReadFile(ctx, filename, 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("23\x00\x00"), content)
+
+	size, err := sm.SizeOf(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), size, "Punching holes should not change the size of the file")
+
+	_, err = sm.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content, "Holes should survive checkpoint")
+
+	chunks, err := sm.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	assert.True(t, chunks[1].Hole)
+	assert.True(t, chunks[2].Hole)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 100, storage.WithVersion("v1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef"), content, "Earlier versions should keep their data")
+
+	// Later writes win over the hole
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("XY"), 6))
+	expected = []byte("0123\x00\x00XY\x00\x00\x00\x00cd\x00\x00")
+	content, err = sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+
+	_, err = sm.Checkpoint(ctx, filename, "v3")
+	require.NoError(t, err)
+
+	content, err = sm.ReadFile(ctx, filename, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+}
+
+func TestFlush(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	filename := "testfile_flush"
+	ctx := context.Background()
+
+	_, err := sm.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	require.NoError(t, sm.Flush(ctx, filename), "flushing a file without writes should do nothing")
+
+	require.NoError(t, sm.WriteFile(ctx, filename, []byte("flushed data"), 0))
+	require.NoError(t, sm.Flush(ctx, filename))
+	require.NoError(t, sm.Flush(ctx, filename), "flushing twice should do nothing the second time")
+
+	versions, err := sm.GetFileVersions(ctx, filename)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, storage.OriginFlush, versions[0].Origin)
+
+	// Uncommitted writes are lost with their manager, flushed ones aren't
+	db := quackfstest.SetupDB(t)
+	defer db.Close()
+	other := storage.NewManager(db, quackfstest.MemoryStore(), logger.New(os.Stderr))
+
+	data, err := other.ReadFile(ctx, filename, 0, 12)
+	require.NoError(t, err)
+	assert.Equal(t, "flushed data", string(data))
+
+	assert.Error(t, sm.Flush(ctx, "testfile_flush_missing"))
+}
+
+func TestInsertFileNames(t *testing.T) {
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	fileID, err := sm.InsertFile(ctx, "testfile_insert.duckdb")
+	require.NoError(t, err)
+
+	_, err = sm.InsertFile(ctx, "testfile_insert.duckdb")
+	assert.ErrorIs(t, err, types.ErrFileExists)
+
+	got, err := sm.GetFileID(ctx, "testfile_insert.duckdb")
+	require.NoError(t, err)
+	assert.Equal(t, fileID, got, "the existing file should be left as is")
+
+	for _, name := range []string{"", ".", "..", "dir/testfile.duckdb", "/testfile.duckdb", "testfile\x00.duckdb"} {
+		_, err := sm.InsertFile(ctx, name)
+		assert.ErrorIs(t, err, types.ErrInvalidFilename, "name %q", name)
+	}
+
+	files, err := sm.GetAllFiles(ctx)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "rejected names should not leave rows behind")
+}
+
+// putCountingStore wraps an object store and records the size of each object put
+type putCountingStore struct {
+	objectstore.ObjectStore
+	mu   sync.Mutex
+	puts map[string]int
+}
+
+func (s *putCountingStore) PutObject(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	s.puts[key] = len(data)
+	s.mu.Unlock()
+	return s.ObjectStore.PutObject(ctx, key, data)
+}
+
+func (s *putCountingStore) lastPut(t *testing.T, mgr *storage.Manager, filename string, tag string) int {
+	versions, err := mgr.GetAllVersions(context.Background())
+	require.NoError(t, err)
+	for _, v := range versions {
+		if v.FileName == filename && v.Tag == tag {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.puts[v.ObjectKey]
+		}
+	}
+	t.Fatalf("version %s of %s not found", tag, filename)
+	return 0
+}
+
+func TestCheckpointDedup(t *testing.T) {
+	const blockSize = 1024
+
+	// Distinct blocks that compress well
+	block := func(b byte) []byte {
+		return bytes.Repeat([]byte{b, b + 1, b + 2, b + 3}, blockSize/4)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts []storage.ManagerOpt
+	}{
+		{"plain", nil},
+		{"compressed and encrypted", []storage.ManagerOpt{
+			storage.WithCompression(storage.CompressionGzip),
+			storage.WithEncryptionKey(bytes.Repeat([]byte{0x42}, 32)),
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &putCountingStore{ObjectStore: quackfstest.MemoryStore(), puts: map[string]int{}}
+			mgr, cleanup := quackfstest.SetupStorageManagerWithStore(t, store, append(tt.opts, storage.WithChunkDedup())...)
+			defer cleanup()
+
+			ctx := context.Background()
+			filename := "testfile_dedup"
+
+			_, err := mgr.InsertFile(ctx, filename)
+			require.NoError(t, err)
+
+			// Checkpoint 4 blocks, then rewrite them all (as DuckDB does) with only the third changed
+			writeBlocks := func(blocks ...[]byte) {
+				for i, b := range blocks {
+					require.NoError(t, mgr.WriteFile(ctx, filename, b, uint64(i*blockSize)))
+				}
+			}
+
+			v1 := [][]byte{block(10), block(20), block(30), block(40)}
+			writeBlocks(v1...)
+			_, err = mgr.Checkpoint(ctx, filename, "v1")
+			require.NoError(t, err)
+			v1Size := store.lastPut(t, mgr, filename, "v1")
+
+			v2 := [][]byte{block(10), block(20), block(50), block(40)}
+			writeBlocks(v2...)
+			_, err = mgr.Checkpoint(ctx, filename, "v2")
+			require.NoError(t, err)
+			v2Size := store.lastPut(t, mgr, filename, "v2")
+
+			if tt.opts == nil {
+				assert.Equal(t, 4*blockSize, v1Size)
+				assert.Equal(t, blockSize, v2Size, "only the changed block should be uploaded")
+			} else {
+				assert.Less(t, v2Size, v1Size/2, "only the changed block should be uploaded")
+			}
+
+			// Going back to the first content reuses the data of both versions
+			writeBlocks(v1[2])
+			_, err = mgr.Checkpoint(ctx, filename, "v3")
+			require.NoError(t, err)
+			assert.Zero(t, store.lastPut(t, mgr, filename, "v3"))
+
+			for tag, blocks := range map[string][][]byte{"v1": v1, "v2": v2, "v3": v1} {
+				got, err := mgr.ReadFile(ctx, filename, 0, 4*blockSize, storage.WithVersion(tag))
+				require.NoError(t, err)
+				assert.Equal(t, bytes.Join(blocks, nil), got, "version %s", tag)
+			}
+
+			stats, err := mgr.Stats(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(v1Size+v2Size), stats.ObjectBytes, "shared data should be counted once")
+
+			// Another manager (e.g. after a restart) reads them too
+			db := quackfstest.SetupDB(t)
+			defer db.Close()
+			other := storage.NewManager(db, store, logger.New(os.Stderr), tt.opts...)
+			got, err := other.ReadFile(ctx, filename, 0, 4*blockSize)
+			require.NoError(t, err)
+			assert.Equal(t, bytes.Join(v1, nil), got)
+		})
+	}
+}
+
+func TestListChunks(t *testing.T) {
+	mgr, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	filename := "testfile_list_chunks"
+
+	_, err := mgr.InsertFile(ctx, filename)
+	require.NoError(t, err)
+
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("aaaa"), 0))
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("bb"), 2))
+	_, err = mgr.Checkpoint(ctx, filename, "v1")
+	require.NoError(t, err)
+	require.NoError(t, mgr.WriteFile(ctx, filename, []byte("cc"), 1))
+
+	type chunk struct {
+		version    string
+		fileRange  [2]uint64
+		layerRange [2]uint64
+		active     bool
+	}
+	simplify := func(infos []storage.ChunkInfo) []chunk {
+		chunks := make([]chunk, len(infos))
+		for i, info := range infos {
+			chunks[i] = chunk{info.Version, info.FileRange, info.LayerRange, info.Active}
+		}
+		return chunks
+	}
+
+	v1Chunks := []chunk{
+		{"v1", [2]uint64{0, 4}, [2]uint64{0, 4}, false},
+		{"v1", [2]uint64{2, 4}, [2]uint64{4, 6}, false},
+	}
+
+	infos, err := mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, append(v1Chunks, chunk{"", [2]uint64{1, 3}, [2]uint64{0, 2}, true}), simplify(infos))
+
+	assert.NotZero(t, infos[0].LayerID)
+	assert.Equal(t, infos[0].LayerID, infos[1].LayerID)
+	assert.NotEmpty(t, infos[0].ObjectKey)
+	assert.Zero(t, infos[2].LayerID)
+	assert.Empty(t, infos[2].ObjectKey)
+
+	infos, err = mgr.ListChunks(ctx, filename, storage.WithVersion("v1"))
+	require.NoError(t, err)
+	assert.Equal(t, v1Chunks, simplify(infos))
+
+	_, err = mgr.Checkpoint(ctx, filename, "v2")
+	require.NoError(t, err)
+
+	infos, err = mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, append(v1Chunks, chunk{"v2", [2]uint64{1, 3}, [2]uint64{0, 2}, false}), simplify(infos))
+
+	// The head version is listed like it's read
+	require.NoError(t, mgr.SetHead(ctx, filename, "v1"))
+	infos, err = mgr.ListChunks(ctx, filename)
+	require.NoError(t, err)
+	assert.Equal(t, v1Chunks, simplify(infos))
+
+	_, err = mgr.ListChunks(ctx, "testfile_list_chunks_missing")
+	assert.Error(t, err)
+}
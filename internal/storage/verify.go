@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	objectstore "github.com/vinimdocarmo/quackfs/internal/storage/object"
+)
+
+// layersPageSize is the number of layers fetched at once by Verify
+const layersPageSize = 1000
+
+// Problems found by Verify
+const (
+	IssueMissing      = "missing"       // the layer object doesn't exist
+	IssueSizeMismatch = "size mismatch" // the layer object doesn't have the size its chunks require
+)
+
+// VerifyIssue describes a layer whose object can't be read back as recorded.
+type VerifyIssue struct {
+	LayerID      uint64 `json:"layerId"`
+	FileName     string `json:"fileName"`
+	Version      string `json:"version"`
+	ObjectKey    string `json:"objectKey"`
+	Problem      string `json:"problem"` // IssueMissing or IssueSizeMismatch
+	ExpectedSize uint64 `json:"expectedSize"`
+	ActualSize   uint64 `json:"actualSize"` // 0 when the object is missing
+}
+
+// Verify checks that the object of every layer exists in the primary object store with the size its
+// chunks were recorded with, e.g. to find objects removed by a lifecycle rule or by hand, and
+// returns the layers that fail the check. Only the size of each object is fetched, and layers
+// are loaded a page at a time, so the whole history is never loaded in memory.
+func (mgr *Manager) Verify(ctx context.Context) ([]VerifyIssue, error) {
+	issues := []VerifyIssue{}
+	var afterID uint64
+	checked := 0
+
+	for {
+		rows, err := mgr.metaStore.GetLayerObjectsPage(ctx, afterID, layersPageSize)
+		if err != nil {
+			mgr.log.Error("Failed to get layers", "after", afterID, "error", err)
+			return nil, fmt.Errorf("failed to get layers: %w", err)
+		}
+
+		for _, row := range rows {
+			issue := VerifyIssue{
+				LayerID:      row.ID,
+				FileName:     row.FileName,
+				Version:      row.Tag,
+				ObjectKey:    row.ObjectKey,
+				ExpectedSize: uint64(row.ObjectSize),
+			}
+
+			size, err := mgr.objectStore.ObjectSize(ctx, row.ObjectKey)
+			switch {
+			case errors.Is(err, objectstore.ErrNotFound):
+				issue.Problem = IssueMissing
+			case err != nil:
+				mgr.log.Error("Failed to get object size", "objectKey", row.ObjectKey, "error", err)
+				return nil, fmt.Errorf("failed to get size of object %s: %w", row.ObjectKey, err)
+			case size != issue.ExpectedSize:
+				issue.Problem = IssueSizeMismatch
+				issue.ActualSize = size
+			}
+
+			if issue.Problem != "" {
+				mgr.log.Warn("Layer object failed verification", "layerID", row.ID, "objectKey", row.ObjectKey, "problem", issue.Problem)
+				issues = append(issues, issue)
+			}
+		}
+		checked += len(rows)
+
+		if len(rows) < layersPageSize {
+			break
+		}
+		afterID = rows[len(rows)-1].ID
+	}
+
+	mgr.log.Info("Verification done", "layers", checked, "issues", len(issues))
+
+	return issues, nil
+}
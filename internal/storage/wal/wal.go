@@ -2,6 +2,7 @@ package wal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,15 +11,20 @@ import (
 	"time"
 
 	"github.com/charmbracelet/log"
-	"github.com/google/uuid"
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage"
 )
 
 // DBCheckpointer is an interface that defines the methods needed by WALManager
 // to checkpoint a database file
 type DBCheckpointer interface {
-	Checkpoint(ctx context.Context, filename string, version string) error
+	Checkpoint(ctx context.Context, filename string, version string, opts ...storage.CheckpointOpt) (string, error)
 }
 
+// ErrWALFull is returned by Write when the write would make the WAL file larger than the
+// maximum size (see WithMaxSize).
+var ErrWALFull = errors.New("WAL file size limit exceeded")
+
 // WALManager handles operations for DuckDB WAL (Write-Ahead Log) files.
 // It provides functionality to read, write, and manage WAL files on the filesystem.
 type WALManager struct {
@@ -26,17 +32,35 @@ type WALManager struct {
 	log     *log.Logger    // Logger for WAL operations
 	mgr     DBCheckpointer // Reference to the storage manager for checkpointing
 	mu      sync.RWMutex   // Mutex to protect concurrent operations
+	maxSize uint64         // Maximum size of each WAL file in bytes, 0 for no limit
+}
+
+// WALOpt configures a WALManager.
+type WALOpt func(*WALManager)
+
+// WithMaxSize limits the size of each WAL file to maxSize bytes, so a runaway WAL can't fill
+// the disk. Writes that would go past it fail with ErrWALFull. 0 means no limit.
+func WithMaxSize(maxSize uint64) WALOpt {
+	return func(wm *WALManager) {
+		wm.maxSize = maxSize
+	}
 }
 
-func NewWALManager(walPath string, mgr DBCheckpointer, logger *log.Logger) *WALManager {
+func NewWALManager(walPath string, mgr DBCheckpointer, logger *log.Logger, opts ...WALOpt) *WALManager {
 	walLog := logger.With()
 	walLog.SetPrefix("📝 WAL")
 
-	return &WALManager{
+	wm := &WALManager{
 		walPath: walPath,
 		log:     walLog,
 		mgr:     mgr,
 	}
+
+	for _, opt := range opts {
+		opt(wm)
+	}
+
+	return wm
 }
 
 func IsWALFile(filename string) bool {
@@ -106,6 +130,11 @@ func (wm *WALManager) Create(filename string) error {
 	}
 	defer file.Close()
 
+	// Make the new directory entry durable, so the file survives a crash
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+
 	wm.log.Debug("Created WAL file", "filename", filename)
 	return nil
 }
@@ -195,6 +224,12 @@ func (wm *WALManager) Write(filename string, data []byte, offset uint64) (int, e
 		return 0, fmt.Errorf("invalid WAL file name: %s", filename)
 	}
 
+	// Writes only ever grow the file up to their end, so checking it is enough
+	if wm.maxSize > 0 && offset+uint64(len(data)) > wm.maxSize {
+		wm.log.Error("WAL file would exceed its maximum size", "filename", filename, "offset", offset, "size", len(data), "maxSize", wm.maxSize)
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d to %s: %w", len(data), offset, filename, ErrWALFull)
+	}
+
 	filePath := wm.GetFilePath(filename)
 
 	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
@@ -217,7 +252,7 @@ func (wm *WALManager) Write(filename string, data []byte, offset uint64) (int, e
 	return n, nil
 }
 
-// Remove removes a WAL file and checkpoints the associated database
+// Remove removes a WAL file and checkpoints the associated database, see checkpointWAL
 func (wm *WALManager) Remove(ctx context.Context, filename string) error {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
@@ -226,12 +261,9 @@ func (wm *WALManager) Remove(ctx context.Context, filename string) error {
 		return fmt.Errorf("invalid WAL file name: %s", filename)
 	}
 
-	dbFilename := wm.GetDBFilename(filename)
-	checkpointID := uuid.New().String()
-
-	if err := wm.mgr.Checkpoint(ctx, dbFilename, checkpointID); err != nil {
-		wm.log.Error("Failed to checkpoint database", "dbFilename", dbFilename, "error", err)
-		return fmt.Errorf("failed to checkpoint database: %w", err)
+	// DuckDB has merged the WAL into the database file
+	if err := wm.checkpointWAL(ctx, filename); err != nil {
+		return err
 	}
 
 	if err := os.Remove(wm.GetFilePath(filename)); err != nil {
@@ -239,10 +271,97 @@ func (wm *WALManager) Remove(ctx context.Context, filename string) error {
 		return err
 	}
 
+	// Otherwise the WAL could come back after a crash and be replayed by DuckDB a second time
+	if err := syncDir(filepath.Dir(wm.GetFilePath(filename))); err != nil {
+		wm.log.Error("Failed to sync WAL directory", "filename", filename, "error", err)
+		return err
+	}
+
 	wm.log.Info("WAL file removed successfully", "filename", filename)
 	return nil
 }
 
+// Checkpoint checkpoints the database file of a WAL file without removing the WAL, e.g. to
+// create a version when driving quackfs programmatically. The new version gets a generated tag.
+func (wm *WALManager) Checkpoint(ctx context.Context, filename string) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if !IsWALFile(filename) {
+		return fmt.Errorf("invalid WAL file name: %s", filename)
+	}
+
+	return wm.checkpoint(ctx, filename, "")
+}
+
+// walTagLayout is the layout of the timestamp in the tags of versions created by WAL removals
+const walTagLayout = "20060102T150405"
+
+// maxWALTagSuffix is how many versions can be created by WAL removals within a second
+const maxWALTagSuffix = 100
+
+// checkpointWAL checkpoints the database file of a WAL file that DuckDB removed. The version is
+// tagged with the time (e.g. wal-20060102T150405), suffixed if that tag is already taken.
+func (wm *WALManager) checkpointWAL(ctx context.Context, filename string) error {
+	tag := "wal-" + time.Now().UTC().Format(walTagLayout)
+
+	for i := 1; ; i++ {
+		version := tag
+		if i > 1 {
+			version = fmt.Sprintf("%s-%d", tag, i)
+		}
+
+		err := wm.checkpoint(ctx, filename, version, storage.WithOrigin(storage.OriginWAL))
+		if errors.Is(err, types.ErrVersionExists) && i < maxWALTagSuffix {
+			continue
+		}
+		return err
+	}
+}
+
+func (wm *WALManager) checkpoint(ctx context.Context, filename string, version string, opts ...storage.CheckpointOpt) error {
+	dbFilename := wm.GetDBFilename(filename)
+	version, err := wm.mgr.Checkpoint(ctx, dbFilename, version, opts...)
+	if err != nil {
+		wm.log.Error("Failed to checkpoint database", "dbFilename", dbFilename, "error", err)
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	if version != "" {
+		wm.log.Info("Checkpointed database", "dbFilename", dbFilename, "version", version)
+	}
+
+	return nil
+}
+
+// Rename renames a WAL file, replacing the WAL file named newFilename if there is one.
+// Unlike Remove, it doesn't checkpoint the database.
+func (wm *WALManager) Rename(oldFilename string, newFilename string) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if !IsWALFile(oldFilename) {
+		return fmt.Errorf("invalid WAL file name: %s", oldFilename)
+	}
+	if !IsWALFile(newFilename) {
+		return fmt.Errorf("invalid WAL file name: %s", newFilename)
+	}
+
+	if err := os.Rename(wm.GetFilePath(oldFilename), wm.GetFilePath(newFilename)); err != nil {
+		wm.log.Error("Failed to rename WAL file", "filename", oldFilename, "newFilename", newFilename, "error", err)
+		return err
+	}
+
+	if err := syncDir(filepath.Dir(wm.GetFilePath(newFilename))); err != nil {
+		wm.log.Error("Failed to sync WAL directory", "filename", newFilename, "error", err)
+		return err
+	}
+
+	wm.log.Debug("Renamed WAL file", "filename", oldFilename, "newFilename", newFilename)
+	return nil
+}
+
+// Sync flushes the WAL file to stable storage (fsync). Writes go straight to the file without
+// any buffering in the process, but they may only be in the OS page cache until synced.
 func (wm *WALManager) Sync(filename string) error {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
@@ -266,3 +385,18 @@ func (wm *WALManager) Sync(filename string) error {
 	wm.log.Debug("Synced WAL file", "filename", filename)
 	return nil
 }
+
+// syncDir fsyncs a directory, making the entries created, removed or renamed in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL directory for syncing: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+
+	return nil
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vinimdocarmo/quackfs/internal/quackfstest"
+	"github.com/vinimdocarmo/quackfs/internal/storage"
 )
 
 // For testing purposes, we'll use a simple struct that just implements the methods we need
@@ -18,11 +20,11 @@ type mockStorageManager struct {
 	checkpointFn func(ctx context.Context, filename, version string) error
 }
 
-func (m *mockStorageManager) Checkpoint(ctx context.Context, filename string, version string) error {
+func (m *mockStorageManager) Checkpoint(ctx context.Context, filename string, version string, opts ...storage.CheckpointOpt) (string, error) {
 	if m.checkpointFn != nil {
-		return m.checkpointFn(ctx, filename, version)
+		return version, m.checkpointFn(ctx, filename, version)
 	}
-	return nil
+	return version, nil
 }
 
 func TestIsWALFile(t *testing.T) {
@@ -249,6 +251,43 @@ func TestWALManagerRemove(t *testing.T) {
 	})
 }
 
+func TestWALManagerRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := log.NewWithOptions(os.Stderr, log.Options{Level: log.FatalLevel})
+
+	checkpointCalled := false
+	mockSM := &mockStorageManager{
+		checkpointFn: func(ctx context.Context, filename, version string) error {
+			checkpointCalled = true
+			return nil
+		},
+	}
+
+	wm := NewWALManager(tmpDir, mockSM, logger)
+
+	require.NoError(t, wm.Create("a.duckdb.wal"))
+	_, err := wm.Write("a.duckdb.wal", []byte("wal data"), 0)
+	require.NoError(t, err)
+
+	require.NoError(t, wm.Create("b.duckdb.wal"))
+	_, err = wm.Write("b.duckdb.wal", []byte("replaced"), 0)
+	require.NoError(t, err)
+
+	require.NoError(t, wm.Rename("a.duckdb.wal", "b.duckdb.wal"))
+	assert.False(t, checkpointCalled, "Renaming should not checkpoint the database")
+
+	exists, err := wm.Exists("a.duckdb.wal")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	data, err := wm.Read("b.duckdb.wal", 0, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "wal data", string(data))
+
+	assert.Error(t, wm.Rename("b.duckdb.wal", "b.duckdb"), "WAL files can only be renamed to WAL files")
+	assert.Error(t, wm.Rename("missing.duckdb.wal", "c.duckdb.wal"))
+}
+
 func TestWALManagerEdgeCases(t *testing.T) {
 	// Create a temporary directory for testing
 	tmpDir, err := os.MkdirTemp("", "walmanager_edge_test_*")
@@ -331,3 +370,161 @@ func TestWALManagerEdgeCases(t *testing.T) {
 		assert.Greater(t, size, uint64(0))
 	})
 }
+
+func TestWALManagerMaxSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := log.NewWithOptions(os.Stderr, log.Options{Level: log.FatalLevel})
+	wm := NewWALManager(tmpDir, &mockStorageManager{}, logger, WithMaxSize(10))
+
+	walFile := "capped.duckdb.wal"
+	require.NoError(t, wm.Create(walFile))
+
+	n, err := wm.Write(walFile, []byte("0123456"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+
+	// Up to the cap is fine
+	_, err = wm.Write(walFile, []byte("789"), 7)
+	require.NoError(t, err)
+
+	_, err = wm.Write(walFile, []byte("x"), 10)
+	assert.ErrorIs(t, err, ErrWALFull)
+
+	// Overwriting within the cap still works
+	_, err = wm.Write(walFile, []byte("ab"), 0)
+	require.NoError(t, err)
+
+	_, err = wm.Write(walFile, []byte("abcdefghijk"), 0)
+	assert.ErrorIs(t, err, ErrWALFull, "a write straddling the cap should fail too")
+
+	size, err := wm.GetFileSize(walFile)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), size, "failed writes should not change the file")
+
+	data, err := wm.Read(walFile, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "ab23456789", string(data))
+
+	// The cap is per file
+	other := "other.duckdb.wal"
+	require.NoError(t, wm.Create(other))
+	_, err = wm.Write(other, []byte("0123456789"), 0)
+	require.NoError(t, err)
+}
+
+func TestWALManagerSyncDurability(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := log.NewWithOptions(os.Stderr, log.Options{Level: log.FatalLevel})
+
+	walFile := "durable.duckdb.wal"
+
+	wm := NewWALManager(tmpDir, &mockStorageManager{}, logger)
+	require.NoError(t, wm.Create(walFile))
+	_, err := wm.Write(walFile, []byte("committed"), 0)
+	require.NoError(t, err)
+	require.NoError(t, wm.Sync(walFile))
+
+	// Simulate a crash after the sync: a new manager (e.g. after a restart) starts from what
+	// is on disk, the old one is never used again
+	wm = NewWALManager(tmpDir, &mockStorageManager{}, logger)
+
+	exists, err := wm.Exists(walFile)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	data, err := wm.Read(walFile, 0, 9)
+	require.NoError(t, err)
+	assert.Equal(t, "committed", string(data))
+
+	assert.Error(t, wm.Sync("missing.duckdb.wal"), "Syncing a missing WAL file should fail")
+	assert.Error(t, wm.Sync("not-a-wal.duckdb"))
+}
+
+func TestWALManagerCheckpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := log.NewWithOptions(os.Stderr, log.Options{Level: log.FatalLevel})
+
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	dbFile := "explicit_checkpoint.duckdb"
+	walFile := dbFile + ".wal"
+
+	_, err := sm.InsertFile(ctx, dbFile)
+	require.NoError(t, err)
+	require.NoError(t, sm.WriteFile(ctx, dbFile, []byte("database data"), 0))
+
+	wm := NewWALManager(tmpDir, sm, logger)
+	require.NoError(t, wm.Create(walFile))
+	_, err = wm.Write(walFile, []byte("wal data"), 0)
+	require.NoError(t, err)
+
+	require.NoError(t, wm.Checkpoint(ctx, walFile))
+
+	versions, err := sm.GetFileVersions(ctx, dbFile)
+	require.NoError(t, err)
+	require.Len(t, versions, 1, "An explicit checkpoint should create a version")
+
+	exists, err := wm.Exists(walFile)
+	require.NoError(t, err)
+	assert.True(t, exists, "The WAL file should still exist after an explicit checkpoint")
+
+	data, err := wm.Read(walFile, 0, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "wal data", string(data))
+
+	// The manager level convenience does the same
+	require.NoError(t, sm.WriteFile(ctx, dbFile, []byte("more data"), 13))
+	version, err := sm.CheckpointWAL(ctx, walFile)
+	require.NoError(t, err)
+	assert.NotEmpty(t, version)
+
+	_, err = sm.CheckpointWAL(ctx, dbFile)
+	assert.Error(t, err, "Only WAL file names should be accepted")
+
+	assert.Error(t, wm.Checkpoint(ctx, dbFile))
+}
+
+func TestWALManagerRemoveTagsVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger := log.NewWithOptions(os.Stderr, log.Options{Level: log.FatalLevel})
+
+	sm, cleanup := quackfstest.SetupStorageManager(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	dbFile := "wal_tag.duckdb"
+	walFile := dbFile + ".wal"
+
+	_, err := sm.InsertFile(ctx, dbFile)
+	require.NoError(t, err)
+
+	// A manual checkpoint first, to tell both origins apart
+	require.NoError(t, sm.WriteFile(ctx, dbFile, []byte("manual"), 0))
+	_, err = sm.Checkpoint(ctx, dbFile, "")
+	require.NoError(t, err)
+
+	wm := NewWALManager(tmpDir, sm, logger)
+
+	// Two WAL removals within the same second shouldn't collide
+	for i := range 2 {
+		require.NoError(t, sm.WriteFile(ctx, dbFile, []byte("flushed"), uint64(6+7*i)))
+		require.NoError(t, wm.Create(walFile))
+		require.NoError(t, wm.Remove(ctx, walFile))
+	}
+
+	versions, err := sm.GetFileVersions(ctx, dbFile)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	// Newest first
+	assert.Regexp(t, `^wal-\d{8}T\d{6}(-\d+)?$`, versions[0].Tag)
+	assert.Regexp(t, `^wal-\d{8}T\d{6}(-\d+)?$`, versions[1].Tag)
+	assert.NotEqual(t, versions[0].Tag, versions[1].Tag)
+	assert.Equal(t, storage.OriginWAL, versions[0].Origin)
+	assert.Equal(t, storage.OriginWAL, versions[1].Origin)
+
+	assert.Equal(t, "v1", versions[2].Tag)
+	assert.Equal(t, storage.OriginManual, versions[2].Origin)
+}
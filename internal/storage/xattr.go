@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vinimdocarmo/quackfs/db/types"
+	"github.com/vinimdocarmo/quackfs/internal/storage/metadata"
+)
+
+const (
+	// MaxXattrNameLen is the longest name of an extended attribute, as on Linux (XATTR_NAME_MAX)
+	MaxXattrNameLen = 255
+	// MaxXattrValueSize is the largest value of an extended attribute, as on Linux
+	// (XATTR_SIZE_MAX). Values are stored in the metadata store, they are meant to be small.
+	MaxXattrValueSize = 64 << 10 // 64 KiB
+)
+
+type xattrOptions struct {
+	mode metadata.XattrSetMode
+}
+
+// XattrOpt configures a single SetXattr call.
+type XattrOpt func(*xattrOptions)
+
+// WithXattrCreate makes SetXattr fail with types.ErrXattrExists if the file already has the
+// attribute, like setxattr(2) with XATTR_CREATE.
+func WithXattrCreate() XattrOpt {
+	return func(o *xattrOptions) {
+		o.mode = metadata.XattrCreate
+	}
+}
+
+// WithXattrReplace makes SetXattr fail with types.ErrNotFound if the file doesn't have the
+// attribute yet, like setxattr(2) with XATTR_REPLACE.
+func WithXattrReplace() XattrOpt {
+	return func(o *xattrOptions) {
+		o.mode = metadata.XattrReplace
+	}
+}
+
+// GetXattr returns the value of an extended attribute of a file, or types.ErrNotFound if the
+// file doesn't have it. Extended attributes aren't versioned: they are the same whatever the
+// version read, and survive checkpoints and renames.
+func (mgr *Manager) GetXattr(ctx context.Context, filename string, name string) ([]byte, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := mgr.metaStore.GetFileXattr(ctx, fileID, name)
+	if err != nil {
+		if err != types.ErrNotFound {
+			mgr.log.Error("Failed to get extended attribute", "filename", filename, "name", name, "error", err)
+		}
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// ListXattrs returns the names of the extended attributes of a file, sorted.
+func (mgr *Manager) ListXattrs(ctx context.Context, filename string) ([]string, error) {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := mgr.metaStore.ListFileXattrs(ctx, fileID)
+	if err != nil {
+		mgr.log.Error("Failed to list extended attributes", "filename", filename, "error", err)
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// SetXattr sets an extended attribute of a file, creating it or replacing its value (see
+// WithXattrCreate and WithXattrReplace). It fails with types.ErrInvalidXattrName if the name
+// is empty or longer than MaxXattrNameLen, and with types.ErrXattrTooLarge if the value is
+// larger than MaxXattrValueSize.
+func (mgr *Manager) SetXattr(ctx context.Context, filename string, name string, value []byte, opts ...XattrOpt) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	var xattrOpts xattrOptions
+	for _, opt := range opts {
+		opt(&xattrOpts)
+	}
+
+	if name == "" || len(name) > MaxXattrNameLen {
+		return fmt.Errorf("cannot set extended attribute %q of %s: %w", name, filename, types.ErrInvalidXattrName)
+	}
+	if len(value) > MaxXattrValueSize {
+		return fmt.Errorf("cannot set extended attribute %q of %s: %w: %d bytes, at most %d", name, filename, types.ErrXattrTooLarge, len(value), MaxXattrValueSize)
+	}
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	err = mgr.metaStore.SetFileXattr(ctx, fileID, name, value, xattrOpts.mode)
+	if err != nil {
+		if err != types.ErrNotFound && err != types.ErrXattrExists {
+			mgr.log.Error("Failed to set extended attribute", "filename", filename, "name", name, "error", err)
+		}
+		return err
+	}
+
+	mgr.log.Debug("Extended attribute set", "filename", filename, "name", name, "size", len(value))
+	return nil
+}
+
+// RemoveXattr removes an extended attribute of a file, or returns types.ErrNotFound if the
+// file doesn't have it.
+func (mgr *Manager) RemoveXattr(ctx context.Context, filename string, name string) error {
+	ctx, cancel := mgr.withTimeout(ctx)
+	defer cancel()
+
+	fileID, err := mgr.metaStore.GetFileIDByName(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	err = mgr.metaStore.RemoveFileXattr(ctx, fileID, name)
+	if err != nil {
+		if err != types.ErrNotFound {
+			mgr.log.Error("Failed to remove extended attribute", "filename", filename, "name", name, "error", err)
+		}
+		return err
+	}
+
+	mgr.log.Debug("Extended attribute removed", "filename", filename, "name", name)
+	return nil
+}
@@ -1,28 +1,127 @@
 package logger
 
 import (
-	os "os"
+	"flag"
+	"fmt"
+	"io"
+	"os"
 	"time"
 
 	log "github.com/charmbracelet/log"
 )
 
-// New creates a new logger instance
-func New(output *os.File) *log.Logger {
-	// Set log level from environment variable
-	level := os.Getenv("LOG_LEVEL")
+// Format is the format of the log lines.
+type Format string
 
-	logger := log.NewWithOptions(os.Stderr, log.Options{
-		ReportCaller:    level == "debug",
+const (
+	// FormatText is the human readable format, the default
+	FormatText Format = "text"
+	// FormatJSON writes every log line as a JSON object, for log aggregators
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses a format name, either "text" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid log format %q, must be %q or %q", s, FormatText, FormatJSON)
+	}
+}
+
+type options struct {
+	level        log.Level
+	format       Format
+	reportCaller bool
+}
+
+// Opt configures a logger created by New.
+type Opt func(*options)
+
+// WithLevel sets the lowest level logged, info by default.
+func WithLevel(level log.Level) Opt {
+	return func(o *options) {
+		o.level = level
+		o.reportCaller = level == log.DebugLevel
+	}
+}
+
+// WithFormat sets the format of the log lines, FormatText by default.
+func WithFormat(format Format) Opt {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithReportCaller sets whether the file and line of the caller are logged, which is the
+// default at the debug level only. It must come after WithLevel, which resets it.
+func WithReportCaller(report bool) Opt {
+	return func(o *options) {
+		o.reportCaller = report
+	}
+}
+
+// New creates a new logger instance writing to output. The level and format default to the
+// LOG_LEVEL and LOG_FORMAT env vars (invalid values are ignored), then to info and text.
+func New(output io.Writer, opts ...Opt) *log.Logger {
+	o := options{level: log.InfoLevel, format: FormatText}
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		if level, err := log.ParseLevel(s); err == nil {
+			WithLevel(level)(&o)
+		}
+	}
+	if format, err := ParseFormat(os.Getenv("LOG_FORMAT")); err == nil {
+		o.format = format
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	logOpts := log.Options{
+		Level:           o.level,
+		ReportCaller:    o.reportCaller,
 		ReportTimestamp: true,
 		TimeFormat:      time.TimeOnly,
-	})
+	}
+	if o.format == FormatJSON {
+		// Full timestamps, as the lines are read by machines that may not be on the same day
+		logOpts.Formatter = log.JSONFormatter
+		logOpts.TimeFormat = time.RFC3339Nano
+	}
 
-	if level != "" {
-		level, err := log.ParseLevel(level)
-		if err == nil {
-			logger.SetLevel(level)
+	return log.NewWithOptions(output, logOpts)
+}
+
+// Config are the logging settings of the commands. Its flags default to the env vars
+// LOG_LEVEL and LOG_FORMAT, so a flag takes precedence over its env var.
+type Config struct {
+	Level  string
+	Format string
+}
+
+// RegisterFlags defines the -log-level and -log-format flags.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Level, "log-level", os.Getenv("LOG_LEVEL"), "Log level: debug, info, warn, error or fatal (env LOG_LEVEL, default info)")
+	fs.StringVar(&c.Format, "log-format", os.Getenv("LOG_FORMAT"), "Log format: text or json, one object per line (env LOG_FORMAT, default text)")
+}
+
+// Opts returns the options of New matching the config. Empty settings are left to New's defaults.
+func (c *Config) Opts() ([]Opt, error) {
+	var opts []Opt
+	if c.Level != "" {
+		level, err := log.ParseLevel(c.Level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level: %w", err)
+		}
+		opts = append(opts, WithLevel(level))
+	}
+	if c.Format != "" {
+		format, err := ParseFormat(c.Format)
+		if err != nil {
+			return nil, err
 		}
+		opts = append(opts, WithFormat(format))
 	}
-	return logger
+	return opts, nil
 }
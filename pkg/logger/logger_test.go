@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/charmbracelet/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONFormat(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "json")
+
+	var buf bytes.Buffer
+	l := New(&buf)
+	l.Debug("hidden")
+	l.Info("file opened", "name", "db.duckdb")
+	l.Warn("slow checkpoint", "ms", 1200)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2, "debug should be filtered out and each entry should be one line")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "file opened", entry["msg"])
+	assert.Equal(t, "db.duckdb", entry["name"])
+	assert.Contains(t, entry, "time")
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "warn", entry["level"])
+}
+
+func TestOptionsOverrideEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("LOG_FORMAT", "json")
+
+	var buf bytes.Buffer
+	l := New(&buf, WithLevel(log.DebugLevel), WithFormat(FormatText), WithReportCaller(false))
+	l.Debug("visible")
+
+	assert.Contains(t, buf.String(), "DEBU visible")
+	assert.False(t, json.Valid(buf.Bytes()))
+}
+
+func TestConfigOpts(t *testing.T) {
+	opts, err := (&Config{}).Opts()
+	require.NoError(t, err)
+	assert.Empty(t, opts)
+
+	opts, err = (&Config{Level: "warn", Format: "json"}).Opts()
+	require.NoError(t, err)
+	assert.Len(t, opts, 2)
+
+	_, err = (&Config{Level: "loud"}).Opts()
+	assert.Error(t, err)
+	_, err = (&Config{Format: "xml"}).Opts()
+	assert.Error(t, err)
+}
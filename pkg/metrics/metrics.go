@@ -0,0 +1,218 @@
+// Package metrics records what the storage manager does (bytes written and read, checkpoint
+// and object store latencies, read cache use) and serves it in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the measurements of the storage manager.
+type Metrics interface {
+	WriteBytes(n int)
+	ReadBytes(n int)
+	CheckpointDuration(d time.Duration)
+	// ObjectStoreRequest records a request to the object store, op being e.g. "get" or "put".
+	ObjectStoreRequest(op string, d time.Duration, err error)
+	CacheLookup(hit bool)
+}
+
+// Nop discards all measurements. It is what the storage manager uses unless told otherwise.
+type Nop struct{}
+
+func (Nop) WriteBytes(int)                                  {}
+func (Nop) ReadBytes(int)                                   {}
+func (Nop) CheckpointDuration(time.Duration)                {}
+func (Nop) ObjectStoreRequest(string, time.Duration, error) {}
+func (Nop) CacheLookup(bool)                                {}
+
+var _ Metrics = Nop{}
+var _ Metrics = (*Registry)(nil)
+
+// defaultBuckets are the upper bounds of the latency histograms, in seconds
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry keeps the measurements in memory and serves them to Prometheus, see Handler.
+type Registry struct {
+	writeBytes  atomic.Uint64
+	readBytes   atomic.Uint64
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	checkpoints *histogram
+
+	mu       sync.Mutex
+	requests map[requestLabels]*histogram
+}
+
+type requestLabels struct {
+	op     string
+	result string // "ok" or "error"
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		checkpoints: newHistogram(defaultBuckets),
+		requests:    make(map[requestLabels]*histogram),
+	}
+}
+
+func (r *Registry) WriteBytes(n int) {
+	r.writeBytes.Add(uint64(n))
+}
+
+func (r *Registry) ReadBytes(n int) {
+	r.readBytes.Add(uint64(n))
+}
+
+func (r *Registry) CheckpointDuration(d time.Duration) {
+	r.checkpoints.observe(d.Seconds())
+}
+
+func (r *Registry) ObjectStoreRequest(op string, d time.Duration, err error) {
+	labels := requestLabels{op: op, result: "ok"}
+	if err != nil {
+		labels.result = "error"
+	}
+
+	r.mu.Lock()
+	h, ok := r.requests[labels]
+	if !ok {
+		h = newHistogram(defaultBuckets)
+		r.requests[labels] = h
+	}
+	r.mu.Unlock()
+
+	h.observe(d.Seconds())
+}
+
+func (r *Registry) CacheLookup(hit bool) {
+	if hit {
+		r.cacheHits.Add(1)
+	} else {
+		r.cacheMisses.Add(1)
+	}
+}
+
+// Handler serves the metrics in the Prometheus text exposition format, e.g. on /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	writeCounter(cw, "quackfs_write_bytes_total", "Bytes written to files.", r.writeBytes.Load())
+	writeCounter(cw, "quackfs_read_bytes_total", "Bytes read from files.", r.readBytes.Load())
+	writeCounter(cw, "quackfs_chunk_cache_hits_total", "Chunks served from the read cache.", r.cacheHits.Load())
+	writeCounter(cw, "quackfs_chunk_cache_misses_total", "Chunks not found in the read cache.", r.cacheMisses.Load())
+
+	writeHeader(cw, "quackfs_checkpoint_duration_seconds", "Time taken by checkpoints that created a version.", "histogram")
+	r.checkpoints.writeTo(cw, "quackfs_checkpoint_duration_seconds", "")
+
+	r.mu.Lock()
+	labels := make([]requestLabels, 0, len(r.requests))
+	for l := range r.requests {
+		labels = append(labels, l)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].op != labels[j].op {
+			return labels[i].op < labels[j].op
+		}
+		return labels[i].result < labels[j].result
+	})
+
+	writeHeader(cw, "quackfs_object_store_request_duration_seconds", "Latency of object store requests.", "histogram")
+	for _, l := range labels {
+		r.mu.Lock()
+		h := r.requests[l]
+		r.mu.Unlock()
+		h.writeTo(cw, "quackfs_object_store_request_duration_seconds", fmt.Sprintf(`operation=%q,result=%q`, l.op, l.result))
+	}
+
+	return cw.n, cw.err
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounter(w io.Writer, name string, help string, value uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // upper bounds
+	counts  []uint64  // observations per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// writeTo writes the series of the histogram, labels being extra labels such as `op="get"`.
+func (h *histogram) writeTo(w io.Writer, name string, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=%q} %d\n", name, labels, sep, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	r.WriteBytes(10)
+	r.WriteBytes(5)
+	r.ReadBytes(7)
+	r.CacheLookup(true)
+	r.CacheLookup(false)
+	r.CacheLookup(false)
+	r.CheckpointDuration(20 * time.Millisecond)
+	r.ObjectStoreRequest("get", 3*time.Millisecond, nil)
+	r.ObjectStoreRequest("get", 2*time.Second, nil)
+	r.ObjectStoreRequest("put", time.Millisecond, errors.New("boom"))
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE quackfs_write_bytes_total counter",
+		"quackfs_write_bytes_total 15",
+		"quackfs_read_bytes_total 7",
+		"quackfs_chunk_cache_hits_total 1",
+		"quackfs_chunk_cache_misses_total 2",
+		"# TYPE quackfs_checkpoint_duration_seconds histogram",
+		`quackfs_checkpoint_duration_seconds_bucket{le="0.01"} 0`,
+		`quackfs_checkpoint_duration_seconds_bucket{le="0.025"} 1`,
+		`quackfs_checkpoint_duration_seconds_bucket{le="+Inf"} 1`,
+		"quackfs_checkpoint_duration_seconds_count 1",
+		`quackfs_object_store_request_duration_seconds_bucket{operation="get",result="ok",le="0.005"} 1`,
+		`quackfs_object_store_request_duration_seconds_bucket{operation="get",result="ok",le="2.5"} 2`,
+		`quackfs_object_store_request_duration_seconds_count{operation="get",result="ok"} 2`,
+		`quackfs_object_store_request_duration_seconds_count{operation="put",result="error"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	// get comes before put
+	assert.Less(t, strings.Index(body, `operation="get"`), strings.Index(body, `operation="put"`))
+}
+
+func TestNop(t *testing.T) {
+	var m Metrics = Nop{}
+	assert.NotPanics(t, func() {
+		m.WriteBytes(1)
+		m.ReadBytes(1)
+		m.CheckpointDuration(time.Second)
+		m.ObjectStoreRequest("get", time.Second, nil)
+		m.CacheLookup(true)
+	})
+}